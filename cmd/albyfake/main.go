@@ -0,0 +1,45 @@
+// Command albyfake runs a fake Alby Wallet API for local development.
+//
+// Point the server at it with ALBY_API_BASE and settle invoices with
+//
+//	curl -X POST http://127.0.0.1:8081/_fake/settle/<payment_hash>
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/payments/albyfake"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8081", "HTTP listen address")
+	token := flag.String("token", "dev-token", "Bearer token to accept")
+	secret := flag.String("webhook-secret", "", "Svix webhook secret (whsec_...); generated if empty")
+	webhookURL := flag.String("webhook-url", "http://127.0.0.1:8080/api/webhook/alby", "Where to deliver settlement webhooks")
+	flag.Parse()
+
+	srv, err := albyfake.New(albyfake.Config{
+		Addr:          *addr,
+		Token:         *token,
+		WebhookSecret: *secret,
+		WebhookURL:    *webhookURL,
+	})
+	if err != nil {
+		logging.Alby.Fatalf("failed to start fake Alby API: %v", err)
+	}
+	defer srv.Close()
+
+	logging.Alby.Printf("fake Alby API listening on %s", srv.URL)
+	fmt.Println("Run the server with:")
+	fmt.Printf("  ALBY_API_BASE=%s ALBY_TOKEN=%s ALBY_WEBHOOK_SECRET=%s go run ./cmd/server -dev\n",
+		srv.URL, srv.Token(), srv.WebhookSecret())
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+}
@@ -7,15 +7,22 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"satoshisend/internal/accounts"
 	"satoshisend/internal/api"
+	"satoshisend/internal/cashu"
 	"satoshisend/internal/files"
+	"satoshisend/internal/l402"
 	"satoshisend/internal/logging"
 	"satoshisend/internal/payments"
+	"satoshisend/internal/payouts"
+	"satoshisend/internal/pricing"
 	"satoshisend/internal/store"
+	"satoshisend/internal/vouchers"
 )
 
 func serveIndex(w http.ResponseWriter, r *http.Request) {
@@ -35,6 +42,29 @@ func formatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// parseDurations parses a comma-separated list of durations such as "24h,168h".
+func parseDurations(s string) ([]time.Duration, error) {
+	var durations []time.Duration
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("duration %s must be positive", part)
+		}
+		durations = append(durations, d)
+	}
+	if len(durations) == 0 {
+		return nil, fmt.Errorf("at least one duration is required")
+	}
+	return durations, nil
+}
+
 func printStats(st *store.SQLiteStore) {
 	ctx := context.Background()
 	stats, err := st.GetStats(ctx)
@@ -60,17 +90,62 @@ func printStats(st *store.SQLiteStore) {
 	} else {
 		fmt.Println("║  No files in database                    ║")
 	}
-	if len(stats.DailyStats) > 0 {
+	fmt.Println("╠══════════════════════════════════════════╣")
+	fmt.Printf("║  Payments:        %-22d║\n", stats.Payments)
+	fmt.Printf("║  Total Revenue:   %-22s║\n", fmt.Sprintf("%d sats", stats.RevenueSats))
+	fmt.Printf("║  Avg Price/File:  %-22s║\n", fmt.Sprintf("%d sats", stats.AvgPriceSats))
+	fmt.Printf("║  Avg Time to Pay: %-22s║\n", stats.AvgSettleTime)
+	if stats.Refunds > 0 {
+		fmt.Printf("║  Refunds Owed:    %-22s║\n", fmt.Sprintf("%d (%d sats)", stats.Refunds, stats.RefundSats))
+	}
+	var paidDays, revenueDays []store.DailyStat
+	for _, ds := range stats.DailyStats {
+		if ds.PaidFiles > 0 {
+			paidDays = append(paidDays, ds)
+		}
+		if ds.RevenueSats > 0 {
+			revenueDays = append(revenueDays, ds)
+		}
+	}
+	if len(paidDays) > 0 {
 		fmt.Println("╠══════════════════════════════════════════╣")
 		fmt.Println("║  Paid Files (last 14 days)               ║")
 		fmt.Println("║  ──────────────────────────────────────  ║")
-		for _, ds := range stats.DailyStats {
+		for _, ds := range paidDays {
 			fmt.Printf("║  %s:    %3d files  %12s  ║\n", ds.Date, ds.PaidFiles, formatBytes(ds.PaidBytes))
 		}
 	}
+	if len(revenueDays) > 0 {
+		fmt.Println("╠══════════════════════════════════════════╣")
+		fmt.Println("║  Revenue (last 14 days)                  ║")
+		fmt.Println("║  ──────────────────────────────────────  ║")
+		for _, ds := range revenueDays {
+			fmt.Printf("║  %s:    %12d sats        ║\n", ds.Date, ds.RevenueSats)
+		}
+	}
 	fmt.Println("╚══════════════════════════════════════════╝")
 }
 
+// multipartEnv reads the multipart upload settings for object storage from
+// <prefix>_PART_SIZE_MB and <prefix>_PART_CONCURRENCY.
+func multipartEnv(prefix string) (partSize int64, concurrency int) {
+	if v := os.Getenv(prefix + "_PART_SIZE_MB"); v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil {
+			logging.Internal.Fatalf("invalid %s_PART_SIZE_MB %q: %v", prefix, v, err)
+		}
+		partSize = int64(mb) << 20
+	}
+	if v := os.Getenv(prefix + "_PART_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			logging.Internal.Fatalf("invalid %s_PART_CONCURRENCY %q: %v", prefix, v, err)
+		}
+		concurrency = n
+	}
+	return partSize, concurrency
+}
+
 func main() {
 	addr := flag.String("addr", ":8080", "HTTP listen address")
 	dbPath := flag.String("db", "satoshisend.db", "SQLite database path")
@@ -78,6 +153,16 @@ func main() {
 	showStats := flag.Bool("stats", false, "Show database statistics and exit")
 	devMode := flag.Bool("dev", false, "Development mode: disables CORS restrictions and rate limiting")
 	corsOrigins := flag.String("cors-origins", "https://satoshisend.xyz", "Comma-separated list of allowed CORS origins")
+	pricingConfig := flag.String("pricing-config", "", "JSON rate card file (overrides the -price-* flags)")
+	priceGBDay := flag.Float64("price-gb-day", 0, "Sats per GB per day of hosting (0 = 1 sat per MB per 7 days)")
+	priceMinSats := flag.Int64("price-min-sats", 100, "Minimum invoice amount in sats")
+	durationsFlag := flag.String("durations", "24h,168h,720h,8760h", "Comma-separated hosting durations clients may choose from")
+	btcPrice := flag.Float64("btc-price", 0, "Fixed price of 1 BTC in the rate card's currency (0 = fetch from -rate-url)")
+	rateURL := flag.String("rate-url", "https://api.coinbase.com/v2/prices/BTC-{currency}/spot", "JSON exchange rate API for fiat pricing; {currency} is replaced")
+	rateField := flag.String("rate-field", "data.amount", "Dot-separated path to the BTC price in the -rate-url response")
+	rateTTL := flag.Duration("rate-ttl", 5*time.Minute, "How long to reuse a fetched exchange rate")
+	orphanGrace := flag.Duration("orphan-grace", files.DefaultOrphanGrace, "Delete stored blobs without metadata once this old (0 disables the sweeper)")
+	orphanDryRun := flag.Bool("orphan-dry-run", false, "Only log the orphaned blobs the sweeper would delete")
 	flag.Parse()
 
 	// Initialize store
@@ -93,26 +178,62 @@ func main() {
 		return
 	}
 
-	// Initialize file storage - use B2 if configured, otherwise local filesystem
+	// Run a subcommand such as "voucher create" and exit
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "voucher" {
+			logging.Internal.Fatalf("unknown command %q", args[0])
+		}
+		if err := runVoucherCommand(vouchers.NewService(st), args[1:]); err != nil {
+			logging.Internal.Fatalf("voucher: %v", err)
+		}
+		return
+	}
+
+	// Initialize file storage - use S3 or B2 if configured, otherwise local filesystem
 	var storage files.Storage
+	s3Bucket := os.Getenv("S3_BUCKET")
 	b2Bucket := os.Getenv("B2_BUCKET")
-	if b2Bucket != "" {
-		b2PublicURL := os.Getenv("B2_PUBLIC_URL")
-		b2Storage, err := files.NewB2Storage(files.B2Config{
-			KeyID:     os.Getenv("B2_KEY_ID"),
-			AppKey:    os.Getenv("B2_APP_KEY"),
-			Bucket:    b2Bucket,
-			Prefix:    os.Getenv("B2_PREFIX"),
-			PublicURL: b2PublicURL,
-		})
+	if s3Bucket != "" || b2Bucket != "" {
+		var cfg files.S3Config
+		name := "S3-compatible"
+		if s3Bucket != "" {
+			partSize, partConcurrency := multipartEnv("S3")
+			cfg = files.S3Config{
+				Endpoint:        os.Getenv("S3_ENDPOINT"),
+				Region:          os.Getenv("S3_REGION"),
+				AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+				SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+				Bucket:          s3Bucket,
+				Prefix:          os.Getenv("S3_PREFIX"),
+				DisableTLS:      os.Getenv("S3_DISABLE_TLS") == "true",
+				PathStyle:       os.Getenv("S3_PATH_STYLE") == "true",
+				PublicURL:       os.Getenv("S3_PUBLIC_URL"),
+				PartSize:        partSize,
+				PartConcurrency: partConcurrency,
+			}
+		} else {
+			partSize, partConcurrency := multipartEnv("B2")
+			cfg = files.B2Config{
+				KeyID:           os.Getenv("B2_KEY_ID"),
+				AppKey:          os.Getenv("B2_APP_KEY"),
+				Bucket:          b2Bucket,
+				Region:          os.Getenv("B2_REGION"),
+				Prefix:          os.Getenv("B2_PREFIX"),
+				PublicURL:       os.Getenv("B2_PUBLIC_URL"),
+				PartSize:        partSize,
+				PartConcurrency: partConcurrency,
+			}.S3Config()
+			name = "Backblaze B2"
+		}
+		s3Storage, err := files.NewS3Storage(cfg)
 		if err != nil {
-			logging.Internal.Fatalf("failed to initialize B2 storage: %v", err)
+			logging.Internal.Fatalf("failed to initialize %s storage: %v", name, err)
 		}
-		storage = b2Storage
-		if b2PublicURL != "" {
-			logging.Internal.Printf("using Backblaze B2 storage (bucket: %s, direct downloads enabled)", b2Bucket)
+		storage = s3Storage
+		if cfg.PublicURL != "" {
+			logging.Internal.Printf("using %s storage (bucket: %s, direct downloads enabled)", name, cfg.Bucket)
 		} else {
-			logging.Internal.Printf("using Backblaze B2 storage (bucket: %s)", b2Bucket)
+			logging.Internal.Printf("using %s storage (bucket: %s)", name, cfg.Bucket)
 		}
 	} else {
 		fsStorage, err := files.NewFSStorage(*storagePath)
@@ -125,17 +246,54 @@ func main() {
 
 	// Initialize services
 	filesSvc := files.NewService(storage, st)
+	filesSvc.SetPartialUploads(st)
 
-	// Initialize LND client - use Alby HTTP API if configured, otherwise mock
+	// Initialize Lightning backend - use lnd, CLN, NWC or Alby HTTP API if configured, otherwise mock
 	var lndClient payments.LNDClient
 	var albyClient *payments.AlbyHTTPClient
+	lndHost := os.Getenv("LND_REST_HOST")
+	clnURL := os.Getenv("CLN_REST_URL")
+	nwcURI := os.Getenv("NWC_URI")
 	albyToken := os.Getenv("ALBY_TOKEN")
 	albyWebhookSecret := os.Getenv("ALBY_WEBHOOK_SECRET")
-	if albyToken != "" && albyWebhookSecret != "" {
+	if lndHost != "" {
+		lndREST, err := payments.NewLNDRESTClient(payments.LNDConfig{
+			Host:         lndHost,
+			MacaroonHex:  os.Getenv("LND_MACAROON_HEX"),
+			MacaroonPath: os.Getenv("LND_MACAROON_PATH"),
+			TLSCertPath:  os.Getenv("LND_TLS_CERT_PATH"),
+		})
+		if err != nil {
+			logging.Internal.Fatalf("failed to connect to lnd: %v", err)
+		}
+		lndClient = lndREST
+		logging.Internal.Println("connected to Lightning node via lnd REST API")
+	} else if clnURL != "" {
+		clnREST, err := payments.NewCLNRESTClient(payments.CLNConfig{
+			URL:         clnURL,
+			Rune:        os.Getenv("CLN_RUNE"),
+			TLSCertPath: os.Getenv("CLN_TLS_CERT_PATH"),
+			State:       st,
+		})
+		if err != nil {
+			logging.Internal.Fatalf("failed to connect to Core Lightning: %v", err)
+		}
+		lndClient = clnREST
+		logging.Internal.Println("connected to Lightning node via Core Lightning REST API")
+	} else if nwcURI != "" {
+		nwcClient, err := payments.NewNWCClient(payments.NWCConfig{URI: nwcURI})
+		if err != nil {
+			logging.Internal.Fatalf("failed to connect to Nostr Wallet Connect: %v", err)
+		}
+		lndClient = nwcClient
+		logging.Internal.Println("connected to Lightning wallet via Nostr Wallet Connect")
+	} else if albyToken != "" && albyWebhookSecret != "" {
 		var err error
 		albyClient, err = payments.NewAlbyHTTPClient(payments.AlbyConfig{
 			AccessToken:   albyToken,
 			WebhookSecret: albyWebhookSecret,
+			BaseURL:       os.Getenv("ALBY_API_BASE"),
+			Inbox:         st,
 		})
 		if err != nil {
 			logging.Internal.Fatalf("failed to connect to Alby wallet: %v", err)
@@ -146,14 +304,18 @@ func main() {
 		logging.Internal.Fatalf("ALBY_TOKEN is set but ALBY_WEBHOOK_SECRET is missing (see README for webhook setup)")
 	} else {
 		lndClient = payments.NewMockLNDClient()
-		logging.Internal.Println("using mock LND client (set ALBY_TOKEN and ALBY_WEBHOOK_SECRET for real payments)")
+		logging.Internal.Println("using mock LND client (set LND_REST_HOST, CLN_REST_URL, NWC_URI or ALBY_TOKEN and ALBY_WEBHOOK_SECRET for real payments)")
 	}
 	paymentsSvc := payments.NewService(lndClient, st)
+	paymentsSvc.SetHistory(st)
 
 	// Load pending invoices from database (restart recovery)
 	if err := paymentsSvc.LoadPendingInvoices(context.Background()); err != nil {
 		logging.Internal.Printf("warning: failed to load pending invoices: %v", err)
 	}
+	if err := paymentsSvc.LoadOffers(context.Background()); err != nil {
+		logging.Internal.Printf("warning: failed to load offers: %v", err)
+	}
 
 	// Create pending file limiter (max 3 pending files per IP)
 	pendingLimiter := api.NewPendingFileLimiter(3)
@@ -161,6 +323,32 @@ func main() {
 	// Set payment callback to clear pending file tracking when payment is received
 	paymentsSvc.SetPaymentCallback(pendingLimiter.OnPaymentReceived)
 
+	// Accept Cashu ecash for uploads if a mint is configured
+	if mintURL := os.Getenv("CASHU_MINT_URL"); mintURL != "" {
+		wallet := cashu.NewWallet(mintURL, st)
+		// Received ecash the database fails to save goes next to it
+		wallet.SetFallbackFile(*dbPath + ".cashu-proofs")
+		paymentsSvc.SetCashuReceiver(wallet)
+		logging.Internal.Printf("accepting Cashu tokens from %s", mintURL)
+	}
+
+	// Enable L402 paywalled downloads; the macaroon root key persists in the database
+	rootKey, err := l402.LoadRootKey(context.Background(), st)
+	if err != nil {
+		logging.Internal.Fatalf("failed to load L402 root key: %v", err)
+	}
+	l402Svc := l402.NewService(rootKey, st, paymentsSvc)
+	l402Svc.SetLedger(st)
+
+	// Prepaid accounts let bulk uploaders skip per-file invoices
+	accountsSvc := accounts.NewService(st, paymentsSvc)
+
+	// Register settlement callbacks before the watcher starts, since it may
+	// replay payments received while the server was down
+	paymentsSvc.SetOfferPaymentCallback(l402Svc.RecordOfferPayment)
+	paymentsSvc.SetDownloadCallback(l402Svc.RecordDownloadPayment)
+	paymentsSvc.SetTopUpCallback(accountsSvc.OnTopUp)
+
 	// Start payment watcher
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -169,6 +357,28 @@ func main() {
 		logging.Internal.Fatalf("failed to start payment watcher: %v", err)
 	}
 
+	// Settle invoices that were paid while we weren't listening (e.g. during
+	// downtime), and drop unpaid ones that have expired
+	paymentsSvc.StartReconciler(ctx, 5*time.Minute)
+
+	// Delete accounts whose first top-up was never paid
+	accountsSvc.StartPruner(ctx, time.Hour)
+
+	// Poll Alby for settlements in case webhooks are delayed or misconfigured
+	if albyClient != nil {
+		pollInterval := time.Minute
+		if v := os.Getenv("ALBY_POLL_INTERVAL"); v != "" {
+			pollInterval, err = time.ParseDuration(v)
+			if err != nil {
+				logging.Internal.Fatalf("invalid ALBY_POLL_INTERVAL %q: %v", v, err)
+			}
+		}
+		if pollInterval > 0 {
+			albyClient.StartPoller(ctx, pollInterval, paymentsSvc.PendingPaymentHashes)
+			logging.Internal.Printf("polling Alby for settlements every %s", pollInterval)
+		}
+	}
+
 	// Start cleanup goroutine for expired files
 	go func() {
 		ticker := time.NewTicker(15 * time.Minute)
@@ -194,9 +404,74 @@ func main() {
 		}
 	}()
 
+	// Sweep storage for blobs whose upload was never completed
+	if *orphanGrace > 0 {
+		if _, ok := storage.(files.Lister); ok {
+			filesSvc.StartOrphanSweeper(ctx, time.Hour, *orphanGrace, *orphanDryRun)
+		} else {
+			logging.Internal.Printf("storage backend can't list files; orphaned blob sweeper disabled")
+		}
+	}
+
 	// Setup HTTP handler
 	handler := api.NewHandler(filesSvc, paymentsSvc, pendingLimiter)
 
+	// Configure pricing from a rate card file, or from flags
+	pricingCfg := pricing.DefaultConfig()
+	if *pricingConfig != "" {
+		pricingCfg, err = pricing.LoadConfig(*pricingConfig)
+		if err != nil {
+			logging.Internal.Fatalf("failed to load pricing config: %v", err)
+		}
+	} else {
+		if *priceGBDay > 0 {
+			pricingCfg.SatsPerGBDay = *priceGBDay
+		}
+		pricingCfg.MinimumSats = *priceMinSats
+	}
+	pricer, err := pricing.NewRateCard(pricingCfg)
+	if err != nil {
+		logging.Internal.Fatalf("invalid pricing config: %v", err)
+	}
+
+	// Fiat-priced rate cards convert to sats at the current exchange rate
+	if currency := pricer.Config().Currency; currency != "" {
+		if *btcPrice > 0 {
+			pricer.SetRateProvider(pricing.StaticRates{currency: *btcPrice})
+			logging.Internal.Printf("pricing in %s at a fixed rate of %.2f per BTC", currency, *btcPrice)
+		} else {
+			rateProvider, err := pricing.NewHTTPRateProvider(pricing.HTTPRateConfig{URL: *rateURL, Field: *rateField})
+			if err != nil {
+				logging.Internal.Fatalf("invalid exchange rate API: %v", err)
+			}
+			// Keep quoting from the last rate for up to an hour if the API is down
+			pricer.SetRateProvider(pricing.NewRateCache(rateProvider, *rateTTL, time.Hour))
+			logging.Internal.Printf("pricing in %s using exchange rates from %s", currency, *rateURL)
+		}
+	}
+	handler.SetPricer(pricer)
+
+	durations, err := parseDurations(*durationsFlag)
+	if err != nil {
+		logging.Internal.Fatalf("invalid -durations: %v", err)
+	}
+	handler.SetHostDurations(durations)
+
+	handler.SetL402(l402Svc)
+	handler.SetAccounts(accountsSvc)
+	handler.SetVouchers(vouchers.NewService(st))
+
+	// Let uploaders withdraw download earnings if the backend can pay invoices
+	var payoutsSvc *payouts.Service
+	if payer, ok := lndClient.(payments.InvoicePayer); ok {
+		payoutsSvc = payouts.NewService(st, payer)
+		handler.SetPayouts(payoutsSvc)
+		// Settle payouts whose outcome was unknown when they were sent
+		payoutsSvc.StartResolver(ctx, 5*time.Minute)
+	} else {
+		logging.Internal.Println("Lightning backend can't pay invoices; earnings withdrawal disabled")
+	}
+
 	// Wire up Alby webhook handler if configured
 	if albyClient != nil {
 		handler.SetWebhookHandler(albyClient)
@@ -207,6 +482,7 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.Handle("/api/", handler)
+	mux.Handle("/.well-known/lnurlp/", handler)
 
 	// SPA routes - serve index.html for client-side routing
 	mux.HandleFunc("/file/", serveIndex)
@@ -269,4 +545,9 @@ func main() {
 	if err := server.ListenAndServe(); err != http.ErrServerClosed {
 		logging.Internal.Fatalf("server error: %v", err)
 	}
+
+	// Let in-flight payouts finish so their ledger entries are settled
+	if payoutsSvc != nil {
+		payoutsSvc.Wait()
+	}
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"satoshisend/internal/store"
+	"satoshisend/internal/vouchers"
+)
+
+const voucherUsage = `usage: satoshisend [flags] voucher <command> [options]
+
+Commands:
+  create    Mint a voucher
+  list      List vouchers and how often they were used
+`
+
+// runVoucherCommand handles the "voucher" subcommand, which lets operators
+// manage discount codes in the database without running the server.
+func runVoucherCommand(svc *vouchers.Service, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, voucherUsage)
+		return fmt.Errorf("missing voucher command")
+	}
+
+	switch args[0] {
+	case "create":
+		return createVoucher(svc, args[1:])
+	case "list":
+		return listVouchers(svc)
+	default:
+		fmt.Fprint(os.Stderr, voucherUsage)
+		return fmt.Errorf("unknown voucher command %q", args[0])
+	}
+}
+
+func createVoucher(svc *vouchers.Service, args []string) error {
+	fs := flag.NewFlagSet("voucher create", flag.ContinueOnError)
+	code := fs.String("code", "", "Voucher code (random if empty)")
+	amountOff := fs.Int64("amount-off", 0, "Sats taken off the hosting fee")
+	percentOff := fs.Int("percent-off", 0, "Percentage taken off the hosting fee (100 = free)")
+	maxUses := fs.Int("max-uses", 1, "Number of uploads the voucher can be used for (0 = unlimited)")
+	validFor := fs.Duration("valid-for", 0, "How long the voucher can be redeemed, e.g. 72h (0 = no expiry)")
+	count := fs.Int("n", 1, "Number of vouchers to mint with these settings")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *count < 1 {
+		return fmt.Errorf("-n must be at least 1")
+	}
+	if *count > 1 && *code != "" {
+		return fmt.Errorf("-code can't be combined with -n")
+	}
+
+	var expiresAt time.Time
+	if *validFor > 0 {
+		expiresAt = time.Now().Add(*validFor)
+	}
+
+	for i := 0; i < *count; i++ {
+		v := &store.Voucher{
+			Code:          *code,
+			AmountOffSats: *amountOff,
+			PercentOff:    *percentOff,
+			MaxUses:       *maxUses,
+			ExpiresAt:     expiresAt,
+		}
+		if err := svc.Create(context.Background(), v); err != nil {
+			return err
+		}
+		fmt.Println(v.Code)
+	}
+	return nil
+}
+
+func listVouchers(svc *vouchers.Service) error {
+	list, err := svc.List(context.Background())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "CODE\tDISCOUNT\tUSES\tEXPIRES\tCREATED")
+	for _, v := range list {
+		discount := fmt.Sprintf("%d sats", v.AmountOffSats)
+		if v.PercentOff > 0 {
+			discount = fmt.Sprintf("%d%%", v.PercentOff)
+		}
+		uses := fmt.Sprintf("%d", v.Uses)
+		if v.MaxUses > 0 {
+			uses = fmt.Sprintf("%d/%d", v.Uses, v.MaxUses)
+		}
+		expires := "never"
+		if !v.ExpiresAt.IsZero() {
+			expires = v.ExpiresAt.Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", v.Code, discount, uses, expires, v.CreatedAt.Format("2006-01-02 15:04"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,208 @@
+// Package accounts manages prepaid credit accounts for bulk uploaders. An
+// account is topped up with Lightning invoices and authenticates with an API
+// key; uploads completed with the key are paid from its balance instead of
+// with a per-file invoice. Every credit and debit is an entry in the store's
+// account ledger.
+package accounts
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/payments"
+	"satoshisend/internal/store"
+)
+
+// APIKeyPrefix marks SatoshiSend API keys, so they are easy to recognize in
+// configs and secret scanners.
+const APIKeyPrefix = "ssk_"
+
+// UnfundedAccountTTL is how long an account that was never topped up is
+// kept. It outlasts the first top-up invoice, whatever expiry the backend
+// gives it.
+const UnfundedAccountTTL = payments.UnknownInvoiceExpiry
+
+var (
+	ErrInvalidKey          = errors.New("invalid API key")
+	ErrInsufficientBalance = errors.New("insufficient account balance")
+)
+
+// Service creates accounts, tops them up and charges uploads to them.
+type Service struct {
+	store    store.AccountStore
+	payments *payments.Service
+}
+
+// NewService creates an account service that issues top-up invoices through
+// paymentsSvc. Call payments.Service.SetTopUpCallback(svc.OnTopUp) so paid
+// top-ups are credited.
+func NewService(st store.AccountStore, paymentsSvc *payments.Service) *Service {
+	return &Service{store: st, payments: paymentsSvc}
+}
+
+// Create opens a new account with a zero balance and an invoice for its
+// first top-up of amountSats. The account is only stored once the invoice
+// has been issued, and is deleted by PruneUnfunded if the invoice is never
+// paid. The API key is returned only here; the store keeps just its hash.
+func (s *Service) Create(ctx context.Context, amountSats int64) (*store.Account, string, *payments.Invoice, error) {
+	id, err := randomHex(16)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	secret, err := randomHex(32)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	apiKey := APIKeyPrefix + secret
+
+	inv, err := s.TopUp(ctx, id, amountSats)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	account := &store.Account{
+		ID:         id,
+		APIKeyHash: hashAPIKey(apiKey),
+		CreatedAt:  time.Now(),
+	}
+	if err := s.store.CreateAccount(ctx, account); err != nil {
+		// Nobody has the invoice, so it can't be paid; reconciliation drops it
+		return nil, "", nil, err
+	}
+	logging.Internal.Printf("created account %s", id)
+	return account, apiKey, inv, nil
+}
+
+// PruneUnfunded deletes accounts older than UnfundedAccountTTL that were
+// never topped up, and returns how many it deleted.
+func (s *Service) PruneUnfunded(ctx context.Context) (int64, error) {
+	return s.store.DeleteUnfundedAccounts(ctx, time.Now().Add(-UnfundedAccountTTL))
+}
+
+// StartPruner runs PruneUnfunded immediately and then every interval until
+// ctx is cancelled.
+func (s *Service) StartPruner(ctx context.Context, interval time.Duration) {
+	prune := func() {
+		count, err := s.PruneUnfunded(ctx)
+		if err != nil && ctx.Err() == nil {
+			logging.Internal.Printf("account pruning error: %v", err)
+		}
+		if count > 0 {
+			logging.Internal.Printf("deleted %d accounts that were never topped up", count)
+		}
+	}
+
+	go func() {
+		prune()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				prune()
+			}
+		}
+	}()
+}
+
+// Authenticate returns the account an API key belongs to.
+func (s *Service) Authenticate(ctx context.Context, apiKey string) (*store.Account, error) {
+	if !strings.HasPrefix(apiKey, APIKeyPrefix) {
+		return nil, ErrInvalidKey
+	}
+	account, err := s.store.GetAccountByKeyHash(ctx, hashAPIKey(apiKey))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvalidKey
+	}
+	return account, err
+}
+
+// TopUp creates an invoice that credits amountSats to the account once paid.
+func (s *Service) TopUp(ctx context.Context, accountID string, amountSats int64) (*payments.Invoice, error) {
+	inv, err := s.payments.CreateTopUpInvoice(ctx, accountID, amountSats)
+	if err != nil {
+		return nil, err
+	}
+	logging.Internal.Printf("top-up requested: account=%s, amount=%d sats", accountID, amountSats)
+	return inv, nil
+}
+
+// OnTopUp credits a paid top-up invoice. It is safe to call more than once
+// for the same payment.
+func (s *Service) OnTopUp(ctx context.Context, accountID string, amountSats int64, paymentHash string) {
+	err := s.store.AddAccountEntry(ctx, &store.AccountEntry{
+		AccountID:  accountID,
+		Kind:       store.AccountTopUp,
+		AmountSats: amountSats,
+		Reference:  paymentHash,
+		CreatedAt:  time.Now(),
+	})
+	if errors.Is(err, store.ErrDuplicate) {
+		return
+	}
+	if err != nil {
+		logging.Internal.Printf("CRITICAL: failed to credit %d sats top-up %s to account %s: %v", amountSats, payments.ShortHash(paymentHash), accountID, err)
+		return
+	}
+	logging.Internal.Printf("credited %d sats to account %s", amountSats, accountID)
+}
+
+// ChargeUpload debits an upload's hosting fee from the account. Each file
+// can be charged only once.
+func (s *Service) ChargeUpload(ctx context.Context, accountID, fileID string, amountSats int64) error {
+	err := s.store.AddAccountEntry(ctx, &store.AccountEntry{
+		AccountID:  accountID,
+		Kind:       store.AccountUpload,
+		AmountSats: -amountSats,
+		Reference:  fileID,
+		CreatedAt:  time.Now(),
+	})
+	if errors.Is(err, store.ErrInsufficientBalance) {
+		return ErrInsufficientBalance
+	}
+	return err
+}
+
+// RefundUpload credits back an upload charge, e.g. when the file couldn't
+// be marked paid after the debit.
+func (s *Service) RefundUpload(ctx context.Context, accountID, fileID string, amountSats int64) error {
+	return s.store.AddAccountEntry(ctx, &store.AccountEntry{
+		AccountID:  accountID,
+		Kind:       store.AccountUploadReversal,
+		AmountSats: amountSats,
+		Reference:  fileID,
+		CreatedAt:  time.Now(),
+	})
+}
+
+// Balance returns the account's balance in sats.
+func (s *Service) Balance(ctx context.Context, accountID string) (int64, error) {
+	return s.store.AccountBalance(ctx, accountID)
+}
+
+// Entries returns the account's ledger, oldest first.
+func (s *Service) Entries(ctx context.Context, accountID string) ([]*store.AccountEntry, error) {
+	return s.store.ListAccountEntries(ctx, accountID)
+}
+
+func hashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
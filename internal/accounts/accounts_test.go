@@ -0,0 +1,155 @@
+package accounts
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"satoshisend/internal/payments"
+	"satoshisend/internal/store"
+)
+
+func newTestService(t *testing.T) (*Service, *payments.Service, *payments.MockLNDClient) {
+	t.Helper()
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+
+	lnd := payments.NewMockLNDClient()
+	paymentsSvc := payments.NewService(lnd, st)
+	svc := NewService(st, paymentsSvc)
+	paymentsSvc.SetTopUpCallback(svc.OnTopUp)
+	return svc, paymentsSvc, lnd
+}
+
+func TestService_Authenticate(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	ctx := context.Background()
+
+	account, apiKey, _, err := svc.Create(ctx, 1000)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !strings.HasPrefix(apiKey, APIKeyPrefix) {
+		t.Errorf("API key %q lacks prefix %q", apiKey, APIKeyPrefix)
+	}
+	if account.APIKeyHash == apiKey || strings.Contains(account.APIKeyHash, apiKey[len(APIKeyPrefix):]) {
+		t.Error("API key must not be stored in plain text")
+	}
+
+	got, err := svc.Authenticate(ctx, apiKey)
+	if err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+	if got.ID != account.ID {
+		t.Errorf("authenticated account %s, want %s", got.ID, account.ID)
+	}
+
+	for _, key := range []string{"", apiKey[len(APIKeyPrefix):], APIKeyPrefix + "0000"} {
+		if _, err := svc.Authenticate(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Authenticate(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestService_TopUpAndCharge(t *testing.T) {
+	svc, paymentsSvc, lnd := newTestService(t)
+	ctx := context.Background()
+
+	account, _, inv, err := svc.Create(ctx, 1000)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if inv.AmountSats != 1000 {
+		t.Errorf("first top-up = %d sats, want 1000", inv.AmountSats)
+	}
+	if balance, _ := svc.Balance(ctx, account.ID); balance != 0 {
+		t.Errorf("balance before payment = %d, want 0", balance)
+	}
+
+	lnd.MarkSettled(inv.PaymentHash)
+	if count, err := paymentsSvc.ReconcilePendingInvoices(ctx); err != nil || count != 1 {
+		t.Fatalf("expected 1 reconciled invoice, got %d (err=%v)", count, err)
+	}
+	// A replayed settlement must not credit twice
+	svc.OnTopUp(ctx, account.ID, 1000, inv.PaymentHash)
+
+	if balance, _ := svc.Balance(ctx, account.ID); balance != 1000 {
+		t.Fatalf("balance after top-up = %d, want 1000", balance)
+	}
+
+	if err := svc.ChargeUpload(ctx, account.ID, "file-big", 1500); !errors.Is(err, ErrInsufficientBalance) {
+		t.Errorf("expected ErrInsufficientBalance, got %v", err)
+	}
+	if err := svc.ChargeUpload(ctx, account.ID, "file-1", 400); err != nil {
+		t.Fatalf("ChargeUpload failed: %v", err)
+	}
+	if err := svc.ChargeUpload(ctx, account.ID, "file-1", 400); !errors.Is(err, store.ErrDuplicate) {
+		t.Errorf("expected ErrDuplicate when charging a file twice, got %v", err)
+	}
+	if err := svc.RefundUpload(ctx, account.ID, "file-1", 400); err != nil {
+		t.Fatalf("RefundUpload failed: %v", err)
+	}
+	if err := svc.ChargeUpload(ctx, account.ID, "file-2", 300); err != nil {
+		t.Fatalf("ChargeUpload failed: %v", err)
+	}
+
+	if balance, _ := svc.Balance(ctx, account.ID); balance != 700 {
+		t.Errorf("balance = %d, want 700", balance)
+	}
+	entries, err := svc.Entries(ctx, account.ID)
+	if err != nil {
+		t.Fatalf("Entries failed: %v", err)
+	}
+	wantKinds := []store.AccountEntryKind{store.AccountTopUp, store.AccountUpload, store.AccountUploadReversal, store.AccountUpload}
+	if len(entries) != len(wantKinds) {
+		t.Fatalf("expected %d entries, got %d", len(wantKinds), len(entries))
+	}
+	for i, kind := range wantKinds {
+		if entries[i].Kind != kind {
+			t.Errorf("entry %d kind = %s, want %s", i, entries[i].Kind, kind)
+		}
+	}
+	if entries[0].Reference != inv.PaymentHash {
+		t.Errorf("top-up entry reference = %s, want the payment hash", entries[0].Reference)
+	}
+}
+
+func TestService_PruneUnfunded(t *testing.T) {
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer st.Close()
+	svc := NewService(st, payments.NewService(payments.NewMockLNDClient(), st))
+	ctx := context.Background()
+
+	old := time.Now().Add(-UnfundedAccountTTL - time.Minute)
+	for _, id := range []string{"unfunded", "funded"} {
+		if err := st.CreateAccount(ctx, &store.Account{ID: id, APIKeyHash: "hash-" + id, CreatedAt: old}); err != nil {
+			t.Fatalf("CreateAccount failed: %v", err)
+		}
+	}
+	svc.OnTopUp(ctx, "funded", 1000, "topup-hash")
+	fresh, apiKey, _, err := svc.Create(ctx, 1000)
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	if count, err := svc.PruneUnfunded(ctx); err != nil || count != 1 {
+		t.Fatalf("expected 1 pruned account, got %d (err=%v)", count, err)
+	}
+	if _, err := st.GetAccountByKeyHash(ctx, "hash-unfunded"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected the unfunded account to be deleted, got %v", err)
+	}
+	if _, err := st.GetAccountByKeyHash(ctx, "hash-funded"); err != nil {
+		t.Errorf("expected the funded account to be kept, got %v", err)
+	}
+	if got, err := svc.Authenticate(ctx, apiKey); err != nil || got.ID != fresh.ID {
+		t.Errorf("expected the new account to be kept until its invoice expires, got %v", err)
+	}
+}
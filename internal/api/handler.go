@@ -2,20 +2,43 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"satoshisend/internal/accounts"
+	"satoshisend/internal/cashu"
 	"satoshisend/internal/files"
+	"satoshisend/internal/l402"
+	"satoshisend/internal/lnurl"
 	"satoshisend/internal/logging"
 	"satoshisend/internal/payments"
+	"satoshisend/internal/payouts"
+	"satoshisend/internal/pricing"
 	"satoshisend/internal/store"
+	"satoshisend/internal/vouchers"
 )
 
 var validFileIDPattern = regexp.MustCompile(`^[a-zA-Z0-9]+$`)
 
+// DefaultHostDuration is used when an upload doesn't request a duration.
+const DefaultHostDuration = 7 * 24 * time.Hour
+
+// DefaultHostDurations are the hosting durations clients may choose from
+// unless the operator configures others.
+var DefaultHostDurations = []time.Duration{
+	24 * time.Hour,
+	7 * 24 * time.Hour,
+	30 * 24 * time.Hour,
+	365 * 24 * time.Hour,
+}
+
 // WebhookHandler is an interface for handling webhook callbacks.
 type WebhookHandler interface {
 	HandleWebhook(body []byte, headers http.Header) error
@@ -27,6 +50,12 @@ type Handler struct {
 	payments       *payments.Service
 	webhookHandler WebhookHandler
 	pendingLimiter *PendingFileLimiter
+	pricer         pricing.Pricer
+	l402           *l402.Service     // nil disables paywalled downloads
+	payouts        *payouts.Service  // nil disables earnings withdrawal
+	accounts       *accounts.Service // nil disables prepaid accounts
+	vouchers       *vouchers.Service // nil disables voucher codes
+	durations      []time.Duration   // allowed hosting durations
 	mux            *http.ServeMux
 }
 
@@ -37,6 +66,8 @@ func NewHandler(files *files.Service, payments *payments.Service, pendingLimiter
 		files:          files,
 		payments:       payments,
 		pendingLimiter: pendingLimiter,
+		pricer:         pricing.Default(),
+		durations:      DefaultHostDurations,
 		mux:            http.NewServeMux(),
 	}
 	h.registerRoutes()
@@ -48,14 +79,96 @@ func (h *Handler) SetWebhookHandler(wh WebhookHandler) {
 	h.webhookHandler = wh
 }
 
+// SetPricer replaces the default pricing (1 sat per MB, minimum 100 sats).
+func (h *Handler) SetPricer(p pricing.Pricer) {
+	h.pricer = p
+}
+
+// SetL402 enables L402 paywalled downloads for files uploaded with a
+// download price.
+func (h *Handler) SetL402(svc *l402.Service) {
+	h.l402 = svc
+}
+
+// SetPayouts lets uploaders withdraw their download earnings via
+// LNURL-withdraw.
+func (h *Handler) SetPayouts(svc *payouts.Service) {
+	h.payouts = svc
+}
+
+// SetAccounts enables prepaid accounts: uploads completed with an account's
+// API key are paid from its balance.
+func (h *Handler) SetAccounts(svc *accounts.Service) {
+	h.accounts = svc
+}
+
+// SetVouchers enables voucher codes on upload completion.
+func (h *Handler) SetVouchers(svc *vouchers.Service) {
+	h.vouchers = svc
+}
+
+// SetHostDurations sets the hosting durations clients may choose from.
+// An empty list restores DefaultHostDurations.
+func (h *Handler) SetHostDurations(durations []time.Duration) {
+	if len(durations) == 0 {
+		durations = DefaultHostDurations
+	}
+	h.durations = durations
+}
+
+// defaultDuration returns DefaultHostDuration if it is allowed, otherwise
+// the first allowed duration.
+func (h *Handler) defaultDuration() time.Duration {
+	for _, d := range h.durations {
+		if d == DefaultHostDuration {
+			return d
+		}
+	}
+	return h.durations[0]
+}
+
+// resolveDuration maps a requested duration in seconds (0 for the default)
+// to an allowed hosting duration.
+func (h *Handler) resolveDuration(seconds int64) (time.Duration, error) {
+	if seconds == 0 {
+		return h.defaultDuration(), nil
+	}
+	// Out-of-range values would wrap when converted and could alias an allowed one
+	if seconds > 0 && seconds <= int64(math.MaxInt64/time.Second) {
+		requested := time.Duration(seconds) * time.Second
+		for _, d := range h.durations {
+			if d == requested {
+				return d, nil
+			}
+		}
+	}
+	allowed := make([]string, len(h.durations))
+	for i, d := range h.durations {
+		allowed[i] = strconv.FormatInt(int64(d/time.Second), 10)
+	}
+	return 0, fmt.Errorf("duration_seconds must be one of %s", strings.Join(allowed, ", "))
+}
+
 func (h *Handler) registerRoutes() {
 	h.mux.HandleFunc("POST /api/upload/init", h.handleUploadInit)
 	h.mux.HandleFunc("POST /api/upload/complete", h.handleUploadComplete)
 	h.mux.HandleFunc("PUT /api/upload/{id}", h.handleUploadStream)
+	h.registerTusRoutes()
 	h.mux.HandleFunc("GET /api/file/{id}", h.handleDownload)
 	h.mux.HandleFunc("HEAD /api/file/{id}", h.handleDownload)
 	h.mux.HandleFunc("GET /api/file/{id}/status", h.handleStatus)
 	h.mux.HandleFunc("GET /api/file/{id}/invoice", h.handleGetInvoice)
+	h.mux.HandleFunc("POST /api/file/{id}/extend", h.handleExtend)
+	h.mux.HandleFunc("POST /api/file/{id}/cashu", h.handlePayCashu)
+	h.mux.HandleFunc("GET /api/file/{id}/earnings", h.handleEarnings)
+	h.mux.HandleFunc("GET /api/lnurlw/{k1}", h.handleLNURLWithdraw)
+	h.mux.HandleFunc("GET /api/lnurlw/callback", h.handleLNURLWithdrawCallback)
+	h.mux.HandleFunc("GET /.well-known/lnurlp/{id}", h.handleLNURLPay)
+	h.mux.HandleFunc("GET /api/lnurlp/{id}/callback", h.handleLNURLPayCallback)
+	h.mux.HandleFunc("POST /api/accounts", h.handleCreateAccount)
+	h.mux.HandleFunc("GET /api/account", h.handleAccount)
+	h.mux.HandleFunc("POST /api/account/topup", h.handleAccountTopUp)
+	h.mux.HandleFunc("GET /api/pricing", h.handlePricing)
 	h.mux.HandleFunc("POST /api/webhook/alby", h.handleAlbyWebhook)
 }
 
@@ -75,21 +188,48 @@ type UploadInitRequest struct {
 // UploadInitResponse is returned when initiating an upload.
 type UploadInitResponse struct {
 	FileID string `json:"file_id"`
+
+	// Set if the client should upload straight to storage instead of to
+	// /api/upload/{file_id}: either a URL to PUT the whole file to, or a
+	// multipart upload whose part n (from 1) is PUT to PartURLs[n-1]
+	UploadURL string   `json:"upload_url,omitempty"`
+	UploadID  string   `json:"upload_id,omitempty"`
+	PartSize  int64    `json:"part_size,omitempty"`
+	PartURLs  []string `json:"part_urls,omitempty"`
 }
 
 // UploadCompleteRequest is the request body for completing an upload.
 type UploadCompleteRequest struct {
-	FileID string `json:"file_id"`
-	Size   int64  `json:"size"`
+	FileID          string `json:"file_id"`
+	Size            int64  `json:"size"`
+	DurationSeconds int64  `json:"duration_seconds,omitempty"` // Hosting duration; 0 for the default
+
+	// DownloadPriceSats, if set, makes recipients pay this much via L402 to download
+	DownloadPriceSats int64 `json:"download_price_sats,omitempty"`
+
+	// Voucher, if set, is a code that discounts or covers the hosting fee
+	Voucher string `json:"voucher,omitempty"`
+
+	// UploadID and PartETags finish a presigned multipart upload: the
+	// upload_id from /api/upload/init and the ETag returned for each part,
+	// in order
+	UploadID  string   `json:"upload_id,omitempty"`
+	PartETags []string `json:"part_etags,omitempty"`
 }
 
 // UploadCompleteResponse is the response after completing an upload.
 type UploadCompleteResponse struct {
-	FileID         string `json:"file_id"`
-	Size           int64  `json:"size"`
-	PaymentRequest string `json:"payment_request"`
-	PaymentHash    string `json:"payment_hash"`
-	AmountSats     int64  `json:"amount_sats"`
+	FileID          string    `json:"file_id"`
+	Size            int64     `json:"size"`
+	PaymentRequest  string    `json:"payment_request"`
+	PaymentHash     string    `json:"payment_hash"`
+	AmountSats      int64     `json:"amount_sats"`
+	DiscountSats    int64     `json:"discount_sats,omitempty"` // Taken off the fee by a voucher
+	DurationSeconds int64     `json:"duration_seconds"`
+	ExpiresAt       time.Time `json:"expires_at"`  // Expiry if paid now; fixed when the payment arrives
+	OwnerToken      string    `json:"owner_token"` // Secret for managing the file; shown only once
+	LNURL           string    `json:"lnurl"`       // Reusable LNURL-pay for the hosting fee
+	Paid            bool      `json:"paid"`        // Already paid by a voucher or account; no invoice is issued
 }
 
 // MaxUploadSize is the maximum allowed file size (5GB).
@@ -126,19 +266,24 @@ func (h *Handler) handleUploadInit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get presigned URL from file service
-	result, err := h.files.InitUpload(r.Context())
+	result, err := h.files.InitUpload(r.Context(), req.Size)
 	if err != nil {
 		logging.Internal.Printf("failed to init upload: %v", err)
 		http.Error(w, "failed to initialize upload", http.StatusInternalServerError)
 		return
 	}
 
-	logging.Internal.Printf("upload init: file_id=%s, size=%d", result.ID, req.Size)
+	logging.Internal.Printf("upload init: file_id=%s, size=%d, presigned=%t", result.ID, req.Size, result.Presigned != nil)
 
+	resp := UploadInitResponse{FileID: result.ID}
+	if p := result.Presigned; p != nil {
+		resp.UploadURL = p.URL
+		resp.UploadID = p.UploadID
+		resp.PartSize = p.PartSize
+		resp.PartURLs = p.PartURLs
+	}
 	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(UploadInitResponse{
-		FileID: result.ID,
-	}); err != nil {
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
 		logging.Internal.Printf("failed to encode response: %v", err)
 	}
 }
@@ -164,25 +309,165 @@ func (h *Handler) handleUploadComplete(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "size must be positive", http.StatusBadRequest)
 		return
 	}
+	if req.Size > MaxUploadSize {
+		http.Error(w, "file too large (max 5GB)", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	duration, err := h.resolveDuration(req.DurationSeconds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if req.DownloadPriceSats < 0 {
+		http.Error(w, "download_price_sats must not be negative", http.StatusBadRequest)
+		return
+	}
+	if req.DownloadPriceSats > 0 && h.l402 == nil {
+		http.Error(w, "paid downloads are not enabled on this server", http.StatusBadRequest)
+		return
+	}
+	// Anyone could fetch the blob from a public bucket, bypassing the paywall
+	if req.DownloadPriceSats > 0 && h.files.GetDirectURL(req.FileID) != "" {
+		http.Error(w, "paid downloads are not available when storage has a public URL", http.StatusBadRequest)
+		return
+	}
+
+	// Check a voucher before creating metadata, so a mistyped code can be
+	// corrected and retried
+	var voucher *store.Voucher
+	if req.Voucher != "" {
+		if h.vouchers == nil {
+			http.Error(w, "vouchers are not enabled on this server", http.StatusBadRequest)
+			return
+		}
+		voucher, err = h.vouchers.Check(r.Context(), req.Voucher)
+		switch {
+		case errors.Is(err, vouchers.ErrNotFound), errors.Is(err, vouchers.ErrExpired), errors.Is(err, vouchers.ErrUsedUp):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			logging.Internal.Printf("failed to check voucher: %v", err)
+			http.Error(w, "failed to check voucher", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Uploads with an account's API key are paid from its balance. Check it
+	// covers the fee before creating metadata, so the client can top up and
+	// retry.
+	account, ok := h.authenticateAccount(w, r)
+	if !ok {
+		return
+	}
+	if account != nil {
+		quote, err := h.pricer.Quote(r.Context(), req.Size, duration, "")
+		if err != nil {
+			logging.Internal.Printf("failed to price upload %s: %v", req.FileID, err)
+			http.Error(w, "failed to price upload", http.StatusInternalServerError)
+			return
+		}
+		fee := quote.AmountSats
+		if voucher != nil {
+			fee -= vouchers.Discount(voucher, fee)
+		}
+		balance, err := h.accounts.Balance(r.Context(), account.ID)
+		if err != nil {
+			logging.Internal.Printf("failed to get balance of account %s: %v", account.ID, err)
+			http.Error(w, "failed to get account balance", http.StatusInternalServerError)
+			return
+		}
+		if balance < fee {
+			http.Error(w, fmt.Sprintf("insufficient account balance: %d sats, upload costs %d", balance, fee), http.StatusPaymentRequired)
+			return
+		}
+	}
+
+	// Assemble a presigned multipart upload from its parts
+	if req.UploadID != "" {
+		if err := h.files.CompletePresignedUpload(r.Context(), req.FileID, req.UploadID, req.PartETags); err != nil {
+			logging.Internal.Printf("failed to complete multipart upload %s: %v", req.FileID, err)
+			http.Error(w, "failed to complete multipart upload", http.StatusBadRequest)
+			return
+		}
+	}
 
 	// Verify upload and create metadata
-	result, err := h.files.CompleteUpload(r.Context(), req.FileID, req.Size, 7*24*time.Hour)
+	result, err := h.files.CompleteUpload(r.Context(), req.FileID, req.Size, duration, req.DownloadPriceSats)
 	if err != nil {
 		logging.Internal.Printf("failed to complete upload: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	// Calculate price: 1 sat per MB, minimum 100 sats
-	amountSats := result.Size / (1024 * 1024)
-	if amountSats < 100 {
-		amountSats = 100
+	quote, err := h.pricer.Quote(r.Context(), result.Size, duration, "")
+	if err != nil {
+		logging.Internal.Printf("failed to price upload %s: %v", result.ID, err)
+		http.Error(w, "failed to price upload", http.StatusInternalServerError)
+		return
+	}
+	amountSats := quote.AmountSats
+
+	// The voucher's use only sticks once the file is paid or invoiced
+	var discount int64
+	release := func() {}
+	if voucher != nil {
+		discount, err = h.vouchers.Redeem(r.Context(), voucher.Code, result.ID, amountSats)
+		if err != nil {
+			// The voucher ran out since the check above; charge the full fee
+			logging.Internal.Printf("failed to redeem voucher %s for %s: %v", voucher.Code, result.ID, err)
+			discount = 0
+		} else {
+			release = func() {
+				if err := h.vouchers.Release(r.Context(), voucher.Code, result.ID); err != nil {
+					logging.Internal.Printf("failed to release voucher %s for %s: %v", voucher.Code, result.ID, err)
+				}
+			}
+		}
+		amountSats -= discount
+	}
+
+	// Files covered by a voucher or an account need no invoice
+	var paidBy string
+	if voucher != nil && amountSats == 0 {
+		if err := h.files.MarkPaid(r.Context(), result.ID); err != nil {
+			logging.Internal.Printf("CRITICAL: failed to mark %s paid with voucher %s: %v", result.ID, voucher.Code, err)
+			release()
+			http.Error(w, "failed to mark file paid", http.StatusInternalServerError)
+			return
+		}
+		paidBy = "voucher " + voucher.Code
+	} else if account != nil {
+		if h.chargeAccount(r, account, result.ID, amountSats) {
+			paidBy = "account " + account.ID
+		}
+		// Otherwise the balance ran out since the check above; fall back to
+		// an invoice
+	}
+	if paidBy != "" {
+		logging.Internal.Printf("upload complete: file_id=%s, size=%d, duration=%s, amount=%d sats, discount=%d sats, paid by %s", result.ID, result.Size, duration, amountSats, discount, paidBy)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(UploadCompleteResponse{
+			FileID:          result.ID,
+			Size:            result.Size,
+			AmountSats:      amountSats,
+			DiscountSats:    discount,
+			DurationSeconds: int64(duration / time.Second),
+			ExpiresAt:       time.Now().Add(duration),
+			OwnerToken:      result.OwnerToken,
+			Paid:            true,
+		}); err != nil {
+			logging.Internal.Printf("failed to encode response: %v", err)
+		}
+		return
 	}
 
 	// Create payment invoice
-	invoice, err := h.payments.CreateInvoiceForFile(r.Context(), result.ID, amountSats)
+	invoice, err := h.payments.CreateInvoiceForFile(r.Context(), result.ID, amountSats, quoteRate(quote))
 	if err != nil {
 		logging.Internal.Printf("failed to create invoice: %v", err)
+		release()
 		http.Error(w, "failed to create invoice", http.StatusInternalServerError)
 		return
 	}
@@ -192,15 +477,20 @@ func (h *Handler) handleUploadComplete(w http.ResponseWriter, r *http.Request) {
 		h.pendingLimiter.TrackPendingFile(ip, result.ID)
 	}
 
-	logging.Internal.Printf("upload complete: file_id=%s, size=%d, amount=%d sats", result.ID, result.Size, amountSats)
+	logging.Internal.Printf("upload complete: file_id=%s, size=%d, duration=%s, amount=%d sats, discount=%d sats", result.ID, result.Size, duration, amountSats, discount)
 
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(UploadCompleteResponse{
-		FileID:         result.ID,
-		Size:           result.Size,
-		PaymentRequest: invoice.PaymentRequest,
-		PaymentHash:    invoice.PaymentHash,
-		AmountSats:     invoice.AmountSats,
+		FileID:          result.ID,
+		Size:            result.Size,
+		PaymentRequest:  invoice.PaymentRequest,
+		PaymentHash:     invoice.PaymentHash,
+		AmountSats:      invoice.AmountSats,
+		DiscountSats:    discount,
+		DurationSeconds: int64(duration / time.Second),
+		ExpiresAt:       time.Now().Add(duration),
+		OwnerToken:      result.OwnerToken,
+		LNURL:           lnurlPay(r, result.ID),
 	}); err != nil {
 		logging.Internal.Printf("failed to encode response: %v", err)
 	}
@@ -270,10 +560,15 @@ func (h *Handler) handleDownload(w http.ResponseWriter, r *http.Request) {
 	defer reader.Close()
 
 	// Get metadata for modification time (used by ServeContent for caching)
-	meta, _ := h.files.GetMetadata(r.Context(), id)
-	modTime := time.Time{}
-	if meta != nil {
-		modTime = meta.CreatedAt
+	meta, err := h.files.GetMetadata(r.Context(), id)
+	if err != nil {
+		http.Error(w, "download failed", http.StatusInternalServerError)
+		return
+	}
+	modTime := meta.CreatedAt
+
+	if meta.DownloadPriceSats > 0 && !h.authorizeDownload(w, r, meta) {
+		return
 	}
 
 	// ServeContent handles Range requests, Content-Length, and HEAD automatically
@@ -281,12 +576,59 @@ func (h *Handler) handleDownload(w http.ResponseWriter, r *http.Request) {
 	http.ServeContent(w, r, "", modTime, reader)
 }
 
+// authorizeDownload enforces the L402 paywall on a priced file. If the request
+// doesn't carry a paid token it answers 402 with a challenge and returns
+// false. HEAD requests get a bare 402, without an invoice being created.
+func (h *Handler) authorizeDownload(w http.ResponseWriter, r *http.Request, meta *store.FileMeta) bool {
+	if h.l402 == nil {
+		http.Error(w, "paid downloads are not enabled on this server", http.StatusServiceUnavailable)
+		return false
+	}
+
+	err := h.l402.Authorize(r.Context(), meta.ID, r.Header.Get("Authorization"))
+	if err == nil {
+		return true
+	}
+	if err != l402.ErrMissingToken && err != l402.ErrInvalidToken {
+		logging.Internal.Printf("failed to verify L402 token for %s: %v", meta.ID, err)
+		http.Error(w, "download failed", http.StatusInternalServerError)
+		return false
+	}
+
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusPaymentRequired)
+		return false
+	}
+
+	challenge, err := h.l402.Challenge(r.Context(), meta.ID, meta.DownloadPriceSats, extractIP(r))
+	if err != nil {
+		logging.Internal.Printf("failed to issue L402 challenge for %s: %v", meta.ID, err)
+		http.Error(w, "failed to create invoice", http.StatusInternalServerError)
+		return false
+	}
+	// Offer a reusable BOLT12 alternative to the one-shot invoice
+	if h.payments.OffersSupported() {
+		offer, err := h.payments.CreateDownloadOffer(r.Context(), meta.ID, meta.DownloadPriceSats, meta.ExpiresAt)
+		if err != nil {
+			logging.Internal.Printf("failed to create download offer for %s: %v", meta.ID, err)
+		} else {
+			challenge += fmt.Sprintf(`, offer="%s"`, offer.Bolt12)
+		}
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, "payment required", http.StatusPaymentRequired)
+	return false
+}
+
 // StatusResponse is the response for file status check.
 type StatusResponse struct {
-	Paid      bool      `json:"paid"`
-	ExpiresAt time.Time `json:"expires_at"`
-	Size      int64     `json:"size"`
-	DirectURL string    `json:"direct_url,omitempty"` // Direct download URL (if available and paid)
+	Paid            bool      `json:"paid"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	Size            int64     `json:"size"`
+	DurationSeconds int64     `json:"duration_seconds"`     // Hosting duration bought (or to be bought) for the file
+	DirectURL       string    `json:"direct_url,omitempty"` // Direct download URL (if available, paid and free to download)
+
+	DownloadPriceSats int64 `json:"download_price_sats,omitempty"` // L402 price per download token
 }
 
 func (h *Handler) handleStatus(w http.ResponseWriter, r *http.Request) {
@@ -307,13 +649,17 @@ func (h *Handler) handleStatus(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := StatusResponse{
-		Paid:      meta.Paid,
-		ExpiresAt: meta.ExpiresAt,
-		Size:      meta.Size,
+		Paid:            meta.Paid,
+		ExpiresAt:       meta.ExpiresAt,
+		Size:            meta.Size,
+		DurationSeconds: int64(meta.HostDuration / time.Second),
+
+		DownloadPriceSats: meta.DownloadPriceSats,
 	}
 
-	// Include direct download URL if file is paid and direct access is available
-	if meta.Paid {
+	// Include direct download URL if file is paid and direct access is available.
+	// Paywalled files must go through the API so the token can be checked.
+	if meta.Paid && meta.DownloadPriceSats == 0 {
 		if directURL := h.files.GetDirectURL(id); directURL != "" {
 			resp.DirectURL = directURL
 		}
@@ -327,9 +673,13 @@ func (h *Handler) handleStatus(w http.ResponseWriter, r *http.Request) {
 
 // InvoiceResponse is the response for invoice retrieval.
 type InvoiceResponse struct {
-	PaymentRequest string `json:"payment_request"`
-	PaymentHash    string `json:"payment_hash"`
-	AmountSats     int64  `json:"amount_sats"`
+	PaymentRequest string    `json:"payment_request"`
+	PaymentHash    string    `json:"payment_hash"`
+	AmountSats     int64     `json:"amount_sats"`
+	ExpiresAt      time.Time `json:"expires_at,omitzero"`
+	LNURL          string    `json:"lnurl"`                // Reusable LNURL-pay that survives invoice expiry
+	CashuMint      string    `json:"cashu_mint,omitempty"` // Set if Cashu tokens from this mint are accepted
+	Paid           bool      `json:"paid,omitempty"`       // Set instead of an invoice once the file has been paid for
 }
 
 func (h *Handler) handleGetInvoice(w http.ResponseWriter, r *http.Request) {
@@ -339,11 +689,19 @@ func (h *Handler) handleGetInvoice(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	pending, err := h.payments.GetInvoiceForFile(id)
+	// Transparently replaces an invoice the wallet has already expired
+	pending, err := h.payments.RefreshInvoiceForFile(r.Context(), id)
 	if err == payments.ErrInvoiceNotFound {
 		http.Error(w, "invoice not found", http.StatusNotFound)
 		return
 	}
+	if err == payments.ErrInvoicePaid {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(InvoiceResponse{Paid: true}); err != nil {
+			logging.Internal.Printf("failed to encode response: %v", err)
+		}
+		return
+	}
 	if err != nil {
 		http.Error(w, "failed to get invoice", http.StatusInternalServerError)
 		return
@@ -353,6 +711,363 @@ func (h *Handler) handleGetInvoice(w http.ResponseWriter, r *http.Request) {
 		PaymentRequest: pending.Invoice.PaymentRequest,
 		PaymentHash:    pending.Invoice.PaymentHash,
 		AmountSats:     pending.Invoice.AmountSats,
+		ExpiresAt:      pending.Invoice.ExpiresAt,
+		LNURL:          lnurlPay(r, id),
+		CashuMint:      h.payments.CashuMint(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.Internal.Printf("failed to encode response: %v", err)
+	}
+}
+
+// lnurlPay returns the bech32 LNURL-pay link for a file's hosting fee.
+func lnurlPay(r *http.Request, fileID string) string {
+	return lnurl.Encode(baseURL(r) + "/.well-known/lnurlp/" + fileID)
+}
+
+// payableInvoice returns the upload invoice of a file that is still awaiting
+// payment, or a reason to show the payer's wallet if there is none.
+func (h *Handler) payableInvoice(r *http.Request, id string) (*payments.PendingInvoice, string) {
+	if !isValidFileID(id) {
+		return nil, "invalid file id"
+	}
+	meta, err := h.files.GetMetadata(r.Context(), id)
+	if err == store.ErrNotFound {
+		return nil, "file not found"
+	}
+	if err != nil {
+		logging.Internal.Printf("failed to get file %s for LNURL-pay: %v", id, err)
+		return nil, "failed to get file"
+	}
+	if meta.Paid {
+		return nil, "file has already been paid for"
+	}
+	if time.Now().After(meta.ExpiresAt) {
+		return nil, "file expired"
+	}
+
+	pending, err := h.payments.GetInvoiceForFile(id)
+	if err != nil {
+		return nil, "invoice not found"
+	}
+	return pending, ""
+}
+
+// CashuPaymentRequest is the body of POST /api/file/{id}/cashu.
+type CashuPaymentRequest struct {
+	Token string `json:"token"` // Serialized Cashu token ("cashuA...")
+}
+
+// CashuPaymentResponse confirms a Cashu payment.
+type CashuPaymentResponse struct {
+	Paid       bool  `json:"paid"`
+	AmountSats int64 `json:"amount_sats"` // Amount received after mint fees
+}
+
+// maxCashuTokenSize bounds the request body of a Cashu payment.
+const maxCashuTokenSize = 64 << 10
+
+// handlePayCashu pays for a pending upload with a Cashu token instead of a
+// Lightning invoice.
+func (h *Handler) handlePayCashu(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !isValidFileID(id) {
+		http.Error(w, "invalid file id", http.StatusBadRequest)
+		return
+	}
+	if h.payments.CashuMint() == "" {
+		http.Error(w, "cashu payments are not enabled on this server", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req CashuPaymentRequest
+	r.Body = http.MaxBytesReader(w, r.Body, maxCashuTokenSize)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	meta, err := h.files.GetMetadata(r.Context(), id)
+	if err == store.ErrNotFound {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to get file", http.StatusInternalServerError)
+		return
+	}
+	if meta.Paid {
+		http.Error(w, "file has already been paid for", http.StatusConflict)
+		return
+	}
+	if time.Now().After(meta.ExpiresAt) {
+		http.Error(w, "file expired", http.StatusGone)
+		return
+	}
+
+	received, err := h.payments.PayWithCashu(r.Context(), id, req.Token)
+	var mintErr *cashu.MintError
+	switch {
+	case err == nil:
+	case errors.Is(err, payments.ErrInvoiceNotFound):
+		http.Error(w, "invoice not found", http.StatusNotFound)
+		return
+	case errors.Is(err, payments.ErrInvoicePaid):
+		http.Error(w, "file has already been paid for", http.StatusConflict)
+		return
+	case errors.Is(err, cashu.ErrInvalidToken), errors.Is(err, cashu.ErrUnsupportedToken),
+		errors.Is(err, cashu.ErrWrongMint), errors.Is(err, cashu.ErrInsufficientAmount):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case errors.As(err, &mintErr):
+		http.Error(w, "token rejected by mint: "+mintErr.Detail, http.StatusBadRequest)
+		return
+	default:
+		logging.Internal.Printf("failed to redeem cashu token for %s: %v", id, err)
+		http.Error(w, "failed to redeem token", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(CashuPaymentResponse{Paid: true, AmountSats: received}); err != nil {
+		logging.Internal.Printf("failed to encode response: %v", err)
+	}
+}
+
+// handleLNURLPay answers the first LNURL-pay request for a pending file with
+// the exact hosting fee.
+func (h *Handler) handleLNURLPay(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	pending, reason := h.payableInvoice(r, id)
+	if pending == nil {
+		writeLNURL(w, lnurl.Error(reason))
+		return
+	}
+
+	writeLNURL(w, lnurlPayRequest(r, id, pending.Invoice.AmountSats))
+}
+
+// lnurlPayRequest builds the pay request for a file. The callback must build
+// the same one, since its invoice commits to the metadata's hash.
+func lnurlPayRequest(r *http.Request, id string, amountSats int64) lnurl.PayRequest {
+	return lnurl.NewPayRequest(
+		baseURL(r)+"/api/lnurlp/"+id+"/callback",
+		"SatoshiSend file hosting: "+id[:min(8, len(id))],
+		amountSats,
+		amountSats,
+	)
+}
+
+// handleLNURLPayCallback hands the wallet an invoice whose description hash
+// is the SHA-256 of the pay request's metadata (LUD-06). The file's current
+// invoice is reused while it qualifies; otherwise it is replaced and
+// cancelled, so repeated callbacks don't pile up payable invoices.
+func (h *Handler) handleLNURLPayCallback(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	pending, reason := h.payableInvoice(r, id)
+	if pending == nil {
+		writeLNURL(w, lnurl.Error(reason))
+		return
+	}
+
+	amountSats := pending.Invoice.AmountSats
+	amountMsat, err := strconv.ParseInt(r.URL.Query().Get("amount"), 10, 64)
+	if err != nil || amountMsat != amountSats*1000 {
+		writeLNURL(w, lnurl.Error(fmt.Sprintf("amount must be exactly %d msat", amountSats*1000)))
+		return
+	}
+
+	metadata := lnurlPayRequest(r, id, amountSats).Metadata
+	invoice, err := h.payments.InvoiceForLNURLPay(r.Context(), id, metadata)
+	if errors.Is(err, payments.ErrInvoicePaid) {
+		writeLNURL(w, lnurl.Error("file has already been paid for"))
+		return
+	}
+	if err != nil {
+		logging.Internal.Printf("failed to create LNURL-pay invoice for %s: %v", id, err)
+		writeLNURL(w, lnurl.Error("failed to create invoice"))
+		return
+	}
+
+	writeLNURL(w, lnurl.NewPayResponse(invoice.Invoice.PaymentRequest))
+}
+
+// ExtendRequest is the request body for extending a paid file's hosting.
+type ExtendRequest struct {
+	DurationSeconds int64 `json:"duration_seconds,omitempty"` // Additional hosting; 0 for the default
+}
+
+// ExtendResponse carries the invoice that pays for an extension.
+type ExtendResponse struct {
+	PaymentRequest  string    `json:"payment_request"`
+	PaymentHash     string    `json:"payment_hash"`
+	AmountSats      int64     `json:"amount_sats"`
+	DurationSeconds int64     `json:"duration_seconds"`
+	ExpiresAt       time.Time `json:"expires_at"`      // File expiry once the extension is paid, if paid now
+	Offer           string    `json:"offer,omitempty"` // BOLT12 offer that extends by the same duration each time it's paid
+}
+
+// handleExtend issues an invoice that, once paid, extends an already-paid
+// file's expiry by the requested duration from its current expiry.
+func (h *Handler) handleExtend(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !isValidFileID(id) {
+		http.Error(w, "invalid file id", http.StatusBadRequest)
+		return
+	}
+
+	var req ExtendRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	duration, err := h.resolveDuration(req.DurationSeconds)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	meta, err := h.files.GetMetadata(r.Context(), id)
+	if err == store.ErrNotFound {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to get file", http.StatusInternalServerError)
+		return
+	}
+	if !meta.Paid {
+		http.Error(w, "file has not been paid for yet", http.StatusConflict)
+		return
+	}
+	now := time.Now()
+	if now.After(meta.ExpiresAt) {
+		http.Error(w, "file expired", http.StatusGone)
+		return
+	}
+
+	quote, err := h.pricer.Quote(r.Context(), meta.Size, duration, "")
+	if err != nil {
+		logging.Internal.Printf("failed to price extension for %s: %v", id, err)
+		http.Error(w, "failed to price extension", http.StatusInternalServerError)
+		return
+	}
+
+	invoice, err := h.payments.CreateExtensionInvoice(r.Context(), id, quote.AmountSats, duration, meta.ExpiresAt, quoteRate(quote))
+	if errors.Is(err, payments.ErrExpiresTooSoon) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		logging.Internal.Printf("failed to create extension invoice: %v", err)
+		http.Error(w, "failed to create invoice", http.StatusInternalServerError)
+		return
+	}
+
+	logging.Internal.Printf("extension requested: file_id=%s, duration=%s, amount=%d sats", id, duration, invoice.AmountSats)
+
+	resp := ExtendResponse{
+		PaymentRequest:  invoice.PaymentRequest,
+		PaymentHash:     invoice.PaymentHash,
+		AmountSats:      invoice.AmountSats,
+		DurationSeconds: int64(duration / time.Second),
+		ExpiresAt:       meta.ExpiresAt.Add(duration),
+	}
+	// The offer can only be paid until the file's current expiry, so it
+	// never extends a file that has already been deleted
+	if h.payments.OffersSupported() {
+		offer, err := h.payments.CreateExtensionOffer(r.Context(), id, quote.AmountSats, duration, meta.ExpiresAt)
+		if err != nil {
+			logging.Internal.Printf("failed to create extension offer for %s: %v", id, err)
+		} else {
+			resp.Offer = offer.Bolt12
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.Internal.Printf("failed to encode response: %v", err)
+	}
+}
+
+// LedgerEntryResponse is one credit or payout in a file's earnings history.
+type LedgerEntryResponse struct {
+	Kind       store.LedgerKind `json:"kind"`
+	AmountSats int64            `json:"amount_sats"` // Positive for credits, negative for payouts
+	CreatedAt  time.Time        `json:"created_at"`
+}
+
+// EarningsResponse reports a file's download earnings to its owner.
+type EarningsResponse struct {
+	BalanceSats int64                 `json:"balance_sats"`
+	Entries     []LedgerEntryResponse `json:"entries"`
+	LNURL       string                `json:"lnurl,omitempty"`           // LNURL-withdraw for the balance, if any
+	LNURLExpiry time.Time             `json:"lnurl_expires_at,omitzero"` // The LNURL can be used once before this
+}
+
+// handleEarnings shows the owner (authenticated by "Authorization: Bearer
+// <owner token>") what their file has earned, with a fresh LNURL-withdraw
+// link for the balance.
+func (h *Handler) handleEarnings(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !isValidFileID(id) {
+		http.Error(w, "invalid file id", http.StatusBadRequest)
+		return
+	}
+	if h.payouts == nil {
+		http.Error(w, "payouts are not enabled on this server", http.StatusServiceUnavailable)
+		return
+	}
+
+	token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	_, err := h.files.VerifyOwner(r.Context(), id, strings.TrimSpace(token))
+	if err == store.ErrNotFound || err == files.ErrNotOwner {
+		// Don't reveal whether the file exists to callers without the token
+		http.Error(w, "invalid owner token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, "failed to get earnings", http.StatusInternalServerError)
+		return
+	}
+
+	balance, err := h.payouts.Balance(r.Context(), id)
+	if err != nil {
+		logging.Internal.Printf("failed to get balance for %s: %v", id, err)
+		http.Error(w, "failed to get earnings", http.StatusInternalServerError)
+		return
+	}
+	entries, err := h.payouts.Entries(r.Context(), id)
+	if err != nil {
+		logging.Internal.Printf("failed to list ledger for %s: %v", id, err)
+		http.Error(w, "failed to get earnings", http.StatusInternalServerError)
+		return
+	}
+
+	resp := EarningsResponse{
+		BalanceSats: balance,
+		Entries:     make([]LedgerEntryResponse, 0, len(entries)),
+	}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, LedgerEntryResponse{
+			Kind:       e.Kind,
+			AmountSats: e.AmountSats,
+			CreatedAt:  e.CreatedAt,
+		})
+	}
+
+	if balance > 0 {
+		k1, err := h.payouts.NewWithdrawal(id)
+		if err != nil {
+			logging.Internal.Printf("failed to create withdrawal for %s: %v", id, err)
+			http.Error(w, "failed to get earnings", http.StatusInternalServerError)
+			return
+		}
+		resp.LNURL = lnurl.Encode(baseURL(r) + "/api/lnurlw/" + k1)
+		resp.LNURLExpiry = time.Now().Add(payouts.WithdrawalTTL)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -361,6 +1076,371 @@ func (h *Handler) handleGetInvoice(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// handleLNURLWithdraw answers the first LNURL-withdraw request from the
+// owner's wallet with the amount it may pull.
+func (h *Handler) handleLNURLWithdraw(w http.ResponseWriter, r *http.Request) {
+	if h.payouts == nil {
+		writeLNURL(w, lnurl.Error("payouts are not enabled on this server"))
+		return
+	}
+
+	k1 := r.PathValue("k1")
+	fileID, balance, err := h.payouts.Withdrawable(r.Context(), k1)
+	if err == payouts.ErrUnknownWithdrawal {
+		writeLNURL(w, lnurl.Error("withdrawal link expired or already used"))
+		return
+	}
+	if err != nil {
+		logging.Internal.Printf("failed to look up withdrawal: %v", err)
+		writeLNURL(w, lnurl.Error("failed to look up withdrawal"))
+		return
+	}
+	if balance <= 0 {
+		writeLNURL(w, lnurl.Error("nothing to withdraw"))
+		return
+	}
+
+	writeLNURL(w, lnurl.NewWithdrawRequest(
+		baseURL(r)+"/api/lnurlw/callback",
+		k1,
+		"SatoshiSend earnings: "+fileID[:8],
+		1,
+		balance,
+	))
+}
+
+// handleLNURLWithdrawCallback receives the invoice the wallet wants paid.
+func (h *Handler) handleLNURLWithdrawCallback(w http.ResponseWriter, r *http.Request) {
+	if h.payouts == nil {
+		writeLNURL(w, lnurl.Error("payouts are not enabled on this server"))
+		return
+	}
+
+	query := r.URL.Query()
+	err := h.payouts.Withdraw(r.Context(), query.Get("k1"), query.Get("pr"))
+	switch err {
+	case nil:
+		writeLNURL(w, lnurl.OK())
+	case payouts.ErrUnknownWithdrawal, payouts.ErrInsufficientBalance, payouts.ErrInvalidInvoice:
+		writeLNURL(w, lnurl.Error(err.Error()))
+	default:
+		logging.Internal.Printf("failed to start payout: %v", err)
+		writeLNURL(w, lnurl.Error("withdrawal failed"))
+	}
+}
+
+func writeLNURL(w http.ResponseWriter, resp any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.Internal.Printf("failed to encode response: %v", err)
+	}
+}
+
+// baseURL reconstructs the public origin the request was made to, honoring
+// X-Forwarded-Proto from a TLS-terminating reverse proxy.
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
+
+// PricingResponse describes the available hosting options.
+type PricingResponse struct {
+	DurationsSeconds       []int64         `json:"durations_seconds"`
+	DefaultDurationSeconds int64           `json:"default_duration_seconds"`
+	RateCard               *pricing.Config `json:"rate_card,omitempty"` // Present if the pricer exposes one
+}
+
+// handlePricing quotes the price for an upload so the frontend can show the
+// same amount the server will charge. Without a size it returns the allowed
+// durations and the rate card.
+func (h *Handler) handlePricing(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	if query.Get("size") == "" {
+		resp := PricingResponse{
+			DefaultDurationSeconds: int64(h.defaultDuration() / time.Second),
+		}
+		for _, d := range h.durations {
+			resp.DurationsSeconds = append(resp.DurationsSeconds, int64(d/time.Second))
+		}
+		if card, ok := h.pricer.(interface{ Config() pricing.Config }); ok {
+			cfg := card.Config()
+			resp.RateCard = &cfg
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			logging.Internal.Printf("failed to encode response: %v", err)
+		}
+		return
+	}
+
+	size, err := strconv.ParseInt(query.Get("size"), 10, 64)
+	if err != nil || size <= 0 {
+		http.Error(w, "size must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	if size > MaxUploadSize {
+		http.Error(w, "file too large (max 5GB)", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	var secs int64
+	if v := query.Get("duration_seconds"); v != "" {
+		secs, err = strconv.ParseInt(v, 10, 64)
+		if err != nil || secs <= 0 {
+			http.Error(w, "duration_seconds must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	duration, err := h.resolveDuration(secs)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	quote, err := h.pricer.Quote(r.Context(), size, duration, query.Get("tier"))
+	if errors.Is(err, pricing.ErrUnknownTier) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, pricing.ErrRateUnavailable) {
+		logging.Internal.Printf("failed to compute quote: %v", err)
+		http.Error(w, "exchange rate unavailable, try again later", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		logging.Internal.Printf("failed to compute quote: %v", err)
+		http.Error(w, "failed to compute quote", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(quote); err != nil {
+		logging.Internal.Printf("failed to encode response: %v", err)
+	}
+}
+
+// quoteRate returns the exchange rate a quote was converted at, to be
+// recorded with its invoice.
+func quoteRate(q *pricing.Quote) payments.ExchangeRate {
+	return payments.ExchangeRate{Currency: q.Currency, BTCPrice: q.BTCPrice}
+}
+
+// authenticateAccount resolves the account whose API key is presented as
+// "Authorization: Bearer <key>". It returns a nil account if no key is
+// presented, and false after writing an error response.
+func (h *Handler) authenticateAccount(w http.ResponseWriter, r *http.Request) (*store.Account, bool) {
+	apiKey, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return nil, true
+	}
+	if h.accounts == nil {
+		http.Error(w, "accounts are not enabled on this server", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	account, err := h.accounts.Authenticate(r.Context(), strings.TrimSpace(apiKey))
+	if err == accounts.ErrInvalidKey {
+		http.Error(w, "invalid API key", http.StatusUnauthorized)
+		return nil, false
+	}
+	if err != nil {
+		logging.Internal.Printf("failed to authenticate account: %v", err)
+		http.Error(w, "failed to authenticate", http.StatusInternalServerError)
+		return nil, false
+	}
+	return account, true
+}
+
+// chargeAccount pays for an upload from the account's balance and marks the
+// file paid. It reports whether the file was paid.
+func (h *Handler) chargeAccount(r *http.Request, account *store.Account, fileID string, amountSats int64) bool {
+	if err := h.accounts.ChargeUpload(r.Context(), account.ID, fileID, amountSats); err != nil {
+		logging.Internal.Printf("failed to charge %d sats for %s to account %s: %v", amountSats, fileID, account.ID, err)
+		return false
+	}
+	if err := h.files.MarkPaid(r.Context(), fileID); err != nil {
+		logging.Internal.Printf("failed to mark %s paid from account %s: %v", fileID, account.ID, err)
+		if err := h.accounts.RefundUpload(r.Context(), account.ID, fileID, amountSats); err != nil {
+			logging.Internal.Printf("CRITICAL: failed to refund %d sats for %s to account %s: %v", amountSats, fileID, account.ID, err)
+		}
+		return false
+	}
+	return true
+}
+
+// TopUpRequest is the body of POST /api/accounts and POST /api/account/topup.
+type TopUpRequest struct {
+	AmountSats int64 `json:"amount_sats"`
+}
+
+// TopUpResponse is an invoice that credits an account once paid.
+type TopUpResponse struct {
+	PaymentRequest string    `json:"payment_request"`
+	PaymentHash    string    `json:"payment_hash"`
+	AmountSats     int64     `json:"amount_sats"`
+	ExpiresAt      time.Time `json:"expires_at,omitzero"`
+}
+
+// CreateAccountResponse is returned when opening an account.
+type CreateAccountResponse struct {
+	AccountID string        `json:"account_id"`
+	APIKey    string        `json:"api_key"` // Shown only once
+	TopUp     TopUpResponse `json:"top_up"`
+}
+
+// AccountEntryResponse is one line of an account's ledger.
+type AccountEntryResponse struct {
+	Kind       store.AccountEntryKind `json:"kind"`
+	AmountSats int64                  `json:"amount_sats"` // Positive for credits, negative for uploads
+	Reference  string                 `json:"reference"`   // Payment hash or file ID
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// AccountResponse reports an account's balance and ledger.
+type AccountResponse struct {
+	AccountID   string                 `json:"account_id"`
+	BalanceSats int64                  `json:"balance_sats"`
+	Entries     []AccountEntryResponse `json:"entries"`
+}
+
+// MaxTopUpSats bounds a single account top-up.
+const MaxTopUpSats = 10_000_000
+
+// decodeTopUp reads a TopUpRequest, writing an error response and returning
+// 0 if it is invalid.
+func decodeTopUp(w http.ResponseWriter, r *http.Request) int64 {
+	var req TopUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return 0
+	}
+	if req.AmountSats <= 0 || req.AmountSats > MaxTopUpSats {
+		http.Error(w, fmt.Sprintf("amount_sats must be between 1 and %d", MaxTopUpSats), http.StatusBadRequest)
+		return 0
+	}
+	return req.AmountSats
+}
+
+// handleCreateAccount opens a prepaid account and returns its API key with an
+// invoice for the first top-up.
+func (h *Handler) handleCreateAccount(w http.ResponseWriter, r *http.Request) {
+	if h.accounts == nil {
+		http.Error(w, "accounts are not enabled on this server", http.StatusServiceUnavailable)
+		return
+	}
+	amountSats := decodeTopUp(w, r)
+	if amountSats == 0 {
+		return
+	}
+
+	account, apiKey, inv, err := h.accounts.Create(r.Context(), amountSats)
+	if err != nil {
+		logging.Internal.Printf("failed to create account: %v", err)
+		http.Error(w, "failed to create account", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(CreateAccountResponse{
+		AccountID: account.ID,
+		APIKey:    apiKey,
+		TopUp:     topUpResponse(inv),
+	}); err != nil {
+		logging.Internal.Printf("failed to encode response: %v", err)
+	}
+}
+
+// handleAccountTopUp issues an invoice that adds to the authenticated
+// account's balance.
+func (h *Handler) handleAccountTopUp(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.requireAccount(w, r)
+	if !ok {
+		return
+	}
+	amountSats := decodeTopUp(w, r)
+	if amountSats == 0 {
+		return
+	}
+
+	inv, err := h.accounts.TopUp(r.Context(), account.ID, amountSats)
+	if err != nil {
+		logging.Internal.Printf("failed to create top-up invoice: %v", err)
+		http.Error(w, "failed to create invoice", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(topUpResponse(inv)); err != nil {
+		logging.Internal.Printf("failed to encode response: %v", err)
+	}
+}
+
+// handleAccount shows the authenticated account's balance and ledger.
+func (h *Handler) handleAccount(w http.ResponseWriter, r *http.Request) {
+	account, ok := h.requireAccount(w, r)
+	if !ok {
+		return
+	}
+
+	balance, err := h.accounts.Balance(r.Context(), account.ID)
+	if err != nil {
+		logging.Internal.Printf("failed to get balance of account %s: %v", account.ID, err)
+		http.Error(w, "failed to get account", http.StatusInternalServerError)
+		return
+	}
+	entries, err := h.accounts.Entries(r.Context(), account.ID)
+	if err != nil {
+		logging.Internal.Printf("failed to list ledger of account %s: %v", account.ID, err)
+		http.Error(w, "failed to get account", http.StatusInternalServerError)
+		return
+	}
+
+	resp := AccountResponse{
+		AccountID:   account.ID,
+		BalanceSats: balance,
+		Entries:     make([]AccountEntryResponse, 0, len(entries)),
+	}
+	for _, e := range entries {
+		resp.Entries = append(resp.Entries, AccountEntryResponse{
+			Kind:       e.Kind,
+			AmountSats: e.AmountSats,
+			Reference:  e.Reference,
+			CreatedAt:  e.CreatedAt,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		logging.Internal.Printf("failed to encode response: %v", err)
+	}
+}
+
+// requireAccount is authenticateAccount for endpoints that need an API key.
+func (h *Handler) requireAccount(w http.ResponseWriter, r *http.Request) (*store.Account, bool) {
+	if h.accounts == nil {
+		http.Error(w, "accounts are not enabled on this server", http.StatusServiceUnavailable)
+		return nil, false
+	}
+	account, ok := h.authenticateAccount(w, r)
+	if ok && account == nil {
+		http.Error(w, "API key required", http.StatusUnauthorized)
+		return nil, false
+	}
+	return account, ok
+}
+
+func topUpResponse(inv *payments.Invoice) TopUpResponse {
+	return TopUpResponse{
+		PaymentRequest: inv.PaymentRequest,
+		PaymentHash:    inv.PaymentHash,
+		AmountSats:     inv.AmountSats,
+		ExpiresAt:      inv.ExpiresAt,
+	}
+}
+
 func (h *Handler) handleAlbyWebhook(w http.ResponseWriter, r *http.Request) {
 	if h.webhookHandler == nil {
 		http.Error(w, "webhook handler not configured", http.StatusServiceUnavailable)
@@ -376,6 +1456,12 @@ func (h *Handler) handleAlbyWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := h.webhookHandler.HandleWebhook(body, r.Header); err != nil {
+		if errors.Is(err, payments.ErrDuplicateWebhook) {
+			// Already stored; acknowledge so the sender stops retrying
+			logging.Internal.Printf("webhook: ignoring duplicate message %s", r.Header.Get("svix-id"))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
 		logging.Internal.Printf("webhook: failed to process: %v", err)
 		http.Error(w, "webhook processing failed", http.StatusBadRequest)
 		return
@@ -3,16 +3,30 @@ package api
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"satoshisend/internal/accounts"
+	"satoshisend/internal/cashu"
+	"satoshisend/internal/cashu/cashufake"
 	"satoshisend/internal/files"
+	"satoshisend/internal/l402"
+	"satoshisend/internal/lnurl"
 	"satoshisend/internal/payments"
+	"satoshisend/internal/payouts"
+	"satoshisend/internal/pricing"
 	"satoshisend/internal/store"
+	"satoshisend/internal/vouchers"
 )
 
 // Test mocks
@@ -43,9 +57,16 @@ func (m *mockStorage) Load(ctx context.Context, id string) (io.ReadCloser, error
 	if !ok {
 		return nil, files.ErrNotFound
 	}
-	return io.NopCloser(bytes.NewReader(data)), nil
+	return seekableReader{bytes.NewReader(data)}, nil
 }
 
+// seekableReader lets downloads of mock files be served with http.ServeContent.
+type seekableReader struct {
+	*bytes.Reader
+}
+
+func (seekableReader) Close() error { return nil }
+
 func (m *mockStorage) Delete(ctx context.Context, id string) error {
 	delete(m.files, id)
 	return nil
@@ -62,12 +83,15 @@ func (m *mockStorage) Stat(ctx context.Context, id string) (int64, error) {
 type mockStore struct {
 	files    map[string]*store.FileMeta
 	invoices map[string]*store.PendingInvoice
+	offers   map[string]*store.Offer
+	failPaid bool // UpdatePaymentStatus fails if set
 }
 
 func newMockStore() *mockStore {
 	return &mockStore{
 		files:    make(map[string]*store.FileMeta),
 		invoices: make(map[string]*store.PendingInvoice),
+		offers:   make(map[string]*store.Offer),
 	}
 }
 
@@ -85,12 +109,28 @@ func (m *mockStore) GetFileMetadata(ctx context.Context, id string) (*store.File
 }
 
 func (m *mockStore) UpdatePaymentStatus(ctx context.Context, fileID string, paid bool) error {
+	if m.failPaid {
+		return errors.New("database is locked")
+	}
 	if meta, ok := m.files[fileID]; ok {
 		meta.Paid = paid
 	}
 	return nil
 }
 
+func (m *mockStore) ExtendExpiry(ctx context.Context, fileID string, d time.Duration) error {
+	meta, ok := m.files[fileID]
+	if !ok || !meta.Paid {
+		return store.ErrNotFound
+	}
+	base := time.Now()
+	if meta.ExpiresAt.After(base) {
+		base = meta.ExpiresAt
+	}
+	meta.ExpiresAt = base.Add(d)
+	return nil
+}
+
 func (m *mockStore) DeleteFileMetadata(ctx context.Context, id string) error {
 	delete(m.files, id)
 	return nil
@@ -126,6 +166,21 @@ func (m *mockStore) ListPendingInvoices(ctx context.Context) ([]*store.PendingIn
 	return result, nil
 }
 
+func (m *mockStore) SaveOffer(ctx context.Context, offer *store.Offer) error {
+	m.offers[offer.OfferID] = offer
+	return nil
+}
+
+func (m *mockStore) ListOffers(ctx context.Context) ([]*store.Offer, error) {
+	var result []*store.Offer
+	for _, offer := range m.offers {
+		if offer.ExpiresAt.After(time.Now()) {
+			result = append(result, offer)
+		}
+	}
+	return result, nil
+}
+
 func setupTestHandler() (*Handler, *mockStorage, *mockStore) {
 	storage := newMockStorage()
 	st := newMockStore()
@@ -234,6 +289,184 @@ func TestHandler_UploadComplete(t *testing.T) {
 	}
 }
 
+func TestHandler_UploadCompleteSizeMismatch(t *testing.T) {
+	handler, storage, _ := setupTestHandler()
+	fileID := "0123456789abcdef0123456789abcdef"
+
+	complete := func(size int64) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(`{"file_id": %q, "size": %d}`, fileID, size)
+		req := httptest.NewRequest("POST", "/api/upload/complete", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	storage.files[fileID] = make([]byte, 2048)
+	if rec := complete(MaxUploadSize + 1); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an oversized declaration, got %d", rec.Code)
+	}
+	if rec := complete(1); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a size mismatch, got %d", rec.Code)
+	}
+	if _, exists := storage.files[fileID]; exists {
+		t.Error("expected the mismatched blob to be deleted")
+	}
+}
+
+// presigningStorage is a mockStorage that hands out presigned uploads, with
+// parts of 512 bytes. Completing an upload stores a file of the part count
+// times the part size.
+type presigningStorage struct {
+	*mockStorage
+	completed []string // ETags of the last completed upload
+}
+
+func (p *presigningStorage) PresignUpload(ctx context.Context, id string, size int64, expires time.Duration) (*files.PresignedUpload, error) {
+	if size <= 512 {
+		return &files.PresignedUpload{URL: "https://bucket.example.com/" + id}, nil
+	}
+	var urls []string
+	for n := int64(1); (n-1)*512 < size; n++ {
+		urls = append(urls, fmt.Sprintf("https://bucket.example.com/%s?partNumber=%d", id, n))
+	}
+	return &files.PresignedUpload{UploadID: "upload-" + id, PartSize: 512, PartURLs: urls}, nil
+}
+
+func (p *presigningStorage) CompletePresignedUpload(ctx context.Context, id, uploadID string, etags []string) error {
+	if uploadID != "upload-"+id {
+		return errors.New("no such upload")
+	}
+	p.completed = etags
+	p.files[id] = make([]byte, 512*len(etags))
+	return nil
+}
+
+func TestHandler_UploadPresigned(t *testing.T) {
+	storage := &presigningStorage{mockStorage: newMockStorage()}
+	filesSvc := files.NewService(storage, newMockStore())
+	paymentsSvc := payments.NewService(payments.NewMockLNDClient(), newMockStore())
+	handler := NewHandler(filesSvc, paymentsSvc, nil)
+
+	initUpload := func(size int) UploadInitResponse {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/api/upload/init", strings.NewReader(fmt.Sprintf(`{"size": %d}`, size)))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("init: expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp UploadInitResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		return resp
+	}
+	complete := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/api/upload/complete", strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("single URL", func(t *testing.T) {
+		resp := initUpload(100)
+		if resp.UploadURL != "https://bucket.example.com/"+resp.FileID {
+			t.Errorf("upload_url = %q", resp.UploadURL)
+		}
+		if resp.UploadID != "" || len(resp.PartURLs) != 0 {
+			t.Errorf("expected no multipart upload, got %+v", resp)
+		}
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		resp := initUpload(1200)
+		if resp.UploadURL != "" || resp.UploadID == "" || resp.PartSize != 512 || len(resp.PartURLs) != 3 {
+			t.Fatalf("unexpected init response: %+v", resp)
+		}
+
+		rec := complete(fmt.Sprintf(`{"file_id": %q, "size": 1536, "upload_id": "wrong", "part_etags": ["a", "b", "c"]}`, resp.FileID))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("wrong upload ID: expected 400, got %d", rec.Code)
+		}
+
+		body := fmt.Sprintf(`{"file_id": %q, "size": 1536, "upload_id": %q, "part_etags": ["a", "b", "c"]}`, resp.FileID, resp.UploadID)
+		rec = complete(body)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if got := strings.Join(storage.completed, ","); got != "a,b,c" {
+			t.Errorf("completed with ETags %q, want a,b,c", got)
+		}
+	})
+}
+
+func TestHandler_UploadComplete_Duration(t *testing.T) {
+	handler, storage, st := setupTestHandler()
+	handler.SetHostDurations([]time.Duration{24 * time.Hour, 30 * 24 * time.Hour})
+
+	tests := []struct {
+		name         string
+		seconds      int64
+		wantStatus   int
+		wantDuration time.Duration
+	}{
+		{"default not offered falls back to first", 0, http.StatusOK, 24 * time.Hour},
+		{"chosen duration", 30 * 24 * 3600, http.StatusOK, 30 * 24 * time.Hour},
+		{"duration not offered", 7 * 24 * 3600, http.StatusBadRequest, 0},
+		{"negative duration", -24 * 3600, http.StatusBadRequest, 0},
+		{"overflow aliasing an offered duration", 24*3600 + 1<<55, http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			initReq := httptest.NewRequest("POST", "/api/upload/init", bytes.NewReader([]byte(`{"size": 1024}`)))
+			initReq.Header.Set("Content-Type", "application/json")
+			initRec := httptest.NewRecorder()
+			handler.ServeHTTP(initRec, initReq)
+
+			var initResp UploadInitResponse
+			json.NewDecoder(initRec.Body).Decode(&initResp)
+			storage.files[initResp.FileID] = make([]byte, 1024)
+
+			body := fmt.Sprintf(`{"file_id": %q, "size": 1024, "duration_seconds": %d}`, initResp.FileID, tc.seconds)
+			req := httptest.NewRequest("POST", "/api/upload/complete", bytes.NewReader([]byte(body)))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp UploadCompleteResponse
+			json.NewDecoder(rec.Body).Decode(&resp)
+			if resp.DurationSeconds != int64(tc.wantDuration/time.Second) {
+				t.Errorf("expected duration %d, got %d", int64(tc.wantDuration/time.Second), resp.DurationSeconds)
+			}
+
+			meta, err := st.GetFileMetadata(context.Background(), initResp.FileID)
+			if err != nil {
+				t.Fatalf("GetFileMetadata failed: %v", err)
+			}
+			if meta.HostDuration != tc.wantDuration {
+				t.Errorf("expected stored duration %v, got %v", tc.wantDuration, meta.HostDuration)
+			}
+
+			statusReq := httptest.NewRequest("GET", "/api/file/"+initResp.FileID+"/status", nil)
+			statusRec := httptest.NewRecorder()
+			handler.ServeHTTP(statusRec, statusReq)
+
+			var status StatusResponse
+			json.NewDecoder(statusRec.Body).Decode(&status)
+			if status.DurationSeconds != resp.DurationSeconds {
+				t.Errorf("expected status duration %d, got %d", resp.DurationSeconds, status.DurationSeconds)
+			}
+		})
+	}
+}
+
 func TestHandler_UploadComplete_FileNotFound(t *testing.T) {
 	handler, _, _ := setupTestHandler()
 
@@ -393,6 +626,23 @@ func TestCORS_RestrictedOrigins(t *testing.T) {
 			t.Errorf("expected 200 for preflight, got %d", rec.Code)
 		}
 	})
+
+	t.Run("tus discovery reaches the handler", func(t *testing.T) {
+		tusHandler := CORS(CORSConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+		req := httptest.NewRequest("OPTIONS", "/api/tus", nil)
+		rec := httptest.NewRecorder()
+
+		tusHandler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNoContent {
+			t.Errorf("expected the tus handler to answer OPTIONS, got %d", rec.Code)
+		}
+		if !strings.Contains(rec.Header().Get("Access-Control-Allow-Headers"), "Upload-Offset") {
+			t.Errorf("expected tus headers to be allowed, got %q", rec.Header().Get("Access-Control-Allow-Headers"))
+		}
+	})
 }
 
 func TestRateLimit(t *testing.T) {
@@ -508,7 +758,7 @@ func TestHandler_GetInvoice(t *testing.T) {
 		CreatedAt: time.Now(),
 	})
 
-	_, err := paymentsSvc.CreateInvoiceForFile(ctx, "invoicetest12345", 100)
+	_, err := paymentsSvc.CreateInvoiceForFile(ctx, "invoicetest12345", 100, payments.ExchangeRate{})
 	if err != nil {
 		t.Fatalf("failed to create invoice: %v", err)
 	}
@@ -536,6 +786,50 @@ func TestHandler_GetInvoice(t *testing.T) {
 		}
 	})
 
+	t.Run("expired invoice is re-issued", func(t *testing.T) {
+		st.SaveFileMetadata(ctx, &store.FileMeta{
+			ID:        "staleinvoice1234",
+			Size:      1024,
+			ExpiresAt: time.Now().Add(10 * time.Minute),
+			CreatedAt: time.Now(),
+		})
+		st.SavePendingInvoice(ctx, &store.PendingInvoice{
+			PaymentHash:    "stalehash0000000",
+			FileID:         "staleinvoice1234",
+			PaymentRequest: "lnbcstale",
+			AmountSats:     250,
+			CreatedAt:      time.Now().Add(-2 * time.Hour),
+			ExpiresAt:      time.Now().Add(-time.Hour),
+		})
+		if err := paymentsSvc.LoadPendingInvoices(ctx); err != nil {
+			t.Fatalf("LoadPendingInvoices failed: %v", err)
+		}
+
+		req := httptest.NewRequest("GET", "/api/file/staleinvoice1234/invoice", nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp InvoiceResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+
+		if resp.PaymentHash == "stalehash0000000" || resp.PaymentRequest == "lnbcstale" {
+			t.Error("expected a fresh invoice")
+		}
+		if resp.AmountSats != 250 {
+			t.Errorf("expected re-issued invoice for 250 sats, got %d", resp.AmountSats)
+		}
+		if !resp.ExpiresAt.After(time.Now()) {
+			t.Errorf("expected future expiry, got %v", resp.ExpiresAt)
+		}
+		if _, ok := st.invoices["stalehash0000000"]; ok {
+			t.Error("expected superseded invoice to be removed from store")
+		}
+	})
+
 	t.Run("get nonexistent invoice", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/api/file/noinvoice123456/invoice", nil)
 		rec := httptest.NewRecorder()
@@ -561,6 +855,248 @@ func (m *mockWebhookHandler) HandleWebhook(body []byte, headers http.Header) err
 	return m.returnError
 }
 
+func TestHandler_Extend(t *testing.T) {
+	handler, _, st := setupTestHandler()
+	ctx := context.Background()
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: "extendpaid123456", Size: 1024, ExpiresAt: expiresAt, Paid: true, CreatedAt: time.Now()})
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: "extendunpaid1234", Size: 1024, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: "extendexpired123", Size: 1024, ExpiresAt: time.Now().Add(-time.Hour), Paid: true, CreatedAt: time.Now()})
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: "extendexpiring12", Size: 1024, ExpiresAt: time.Now().Add(time.Minute), Paid: true, CreatedAt: time.Now()})
+
+	tests := []struct {
+		name       string
+		fileID     string
+		body       string
+		wantStatus int
+	}{
+		{"paid file", "extendpaid123456", `{"duration_seconds": 2592000}`, http.StatusOK},
+		{"default duration", "extendpaid123456", ``, http.StatusOK},
+		{"duration not offered", "extendpaid123456", `{"duration_seconds": 3600}`, http.StatusBadRequest},
+		{"unpaid file", "extendunpaid1234", `{}`, http.StatusConflict},
+		{"expired file", "extendexpired123", `{}`, http.StatusGone},
+		{"expires too soon", "extendexpiring12", `{}`, http.StatusConflict},
+		{"unknown file", "extendmissing123", `{}`, http.StatusNotFound},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/api/file/"+tc.fileID+"/extend", bytes.NewReader([]byte(tc.body)))
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+
+			var resp ExtendResponse
+			if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if resp.PaymentRequest == "" || resp.AmountSats <= 0 {
+				t.Errorf("expected an invoice, got %+v", resp)
+			}
+			if !strings.HasPrefix(resp.Offer, "lno1") {
+				t.Errorf("expected a BOLT12 offer, got %q", resp.Offer)
+			}
+			want := expiresAt.Add(time.Duration(resp.DurationSeconds) * time.Second)
+			if !resp.ExpiresAt.Equal(want) {
+				t.Errorf("expected expiry %v, got %v", want, resp.ExpiresAt)
+			}
+		})
+	}
+}
+
+// publicStorage is a mockStorage served from a public bucket URL.
+type publicStorage struct {
+	*mockStorage
+}
+
+func (p *publicStorage) GetPublicURL(id string) string {
+	return "https://bucket.example.com/" + id
+}
+
+func TestHandler_UploadComplete_DownloadPriceWithPublicURL(t *testing.T) {
+	storage := &publicStorage{newMockStorage()}
+	st := newMockStore()
+	paymentsSvc := payments.NewService(payments.NewMockLNDClient(), st)
+	handler := NewHandler(files.NewService(storage, st), paymentsSvc, nil)
+	handler.SetL402(l402.NewService([]byte("test-root-key"), memTokens{}, paymentsSvc))
+
+	fileID := "0123456789abcdef0123456789abcdef"
+	storage.files[fileID] = make([]byte, 1024)
+	body := fmt.Sprintf(`{"file_id": %q, "size": 1024, "download_price_sats": 100}`, fileID)
+	req := httptest.NewRequest("POST", "/api/upload/complete", bytes.NewReader([]byte(body)))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "public URL") {
+		t.Fatalf("expected 400 for a public URL, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := st.GetFileMetadata(context.Background(), fileID); err != store.ErrNotFound {
+		t.Errorf("expected no metadata to be stored, got %v", err)
+	}
+}
+
+// memTokens implements store.L402TokenStore for testing.
+type memTokens map[string]*store.L402Token
+
+func (m memTokens) SaveL402Token(ctx context.Context, token *store.L402Token) error {
+	m[token.PaymentHash] = token
+	return nil
+}
+
+func (m memTokens) GetL402Token(ctx context.Context, paymentHash string) (*store.L402Token, error) {
+	token, ok := m[paymentHash]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return token, nil
+}
+
+func TestHandler_PaywalledDownload(t *testing.T) {
+	storage := newMockStorage()
+	st := newMockStore()
+	lnd := payments.NewMockLNDClient()
+	paymentsSvc := payments.NewService(lnd, st)
+	handler := NewHandler(files.NewService(storage, st), paymentsSvc, nil)
+	l402Svc := l402.NewService([]byte("test-root-key"), memTokens{}, paymentsSvc)
+	handler.SetL402(l402Svc)
+
+	ctx := context.Background()
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:                "paywalled1234567",
+		Size:              5,
+		ExpiresAt:         time.Now().Add(24 * time.Hour),
+		Paid:              true,
+		CreatedAt:         time.Now(),
+		DownloadPriceSats: 250,
+	})
+	storage.files["paywalled1234567"] = []byte("hello")
+
+	// Without a token the download is refused with a challenge
+	req := httptest.NewRequest("GET", "/api/file/paywalled1234567", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402, got %d: %s", rec.Code, rec.Body.String())
+	}
+	challenge := rec.Header().Get("WWW-Authenticate")
+	macaroon, _, ok := strings.Cut(strings.TrimPrefix(challenge, `L402 macaroon="`), `"`)
+	if !ok || !strings.Contains(challenge, `invoice="lnbc`) {
+		t.Fatalf("unexpected challenge %q", challenge)
+	}
+
+	mac, err := l402.DecodeMacaroon(macaroon)
+	if err != nil {
+		t.Fatalf("failed to decode macaroon: %v", err)
+	}
+	preimage, ok := lnd.Preimage(hex.EncodeToString(mac.ID[2:34]))
+	if !ok {
+		t.Fatal("challenge invoice not found")
+	}
+
+	t.Run("repeated requests reuse the challenge", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/file/paywalled1234567", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("WWW-Authenticate"); got != challenge {
+			t.Errorf("expected the same challenge, got %q", got)
+		}
+	})
+
+	t.Run("HEAD creates no invoice", func(t *testing.T) {
+		req := httptest.NewRequest("HEAD", "/api/file/paywalled1234567", nil)
+		req.RemoteAddr = "192.0.2.7:1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusPaymentRequired {
+			t.Fatalf("expected 402, got %d", rec.Code)
+		}
+		if got := rec.Header().Get("WWW-Authenticate"); got != "" {
+			t.Errorf("expected no challenge for HEAD, got %q", got)
+		}
+	})
+
+	t.Run("paid token downloads", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/file/paywalled1234567", nil)
+		req.Header.Set("Authorization", "L402 "+macaroon+":"+preimage)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		if rec.Body.String() != "hello" {
+			t.Errorf("unexpected body %q", rec.Body.String())
+		}
+	})
+
+	t.Run("wrong preimage is challenged again", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/file/paywalled1234567", nil)
+		req.Header.Set("Authorization", "L402 "+macaroon+":"+strings.Repeat("ab", 32))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusPaymentRequired {
+			t.Fatalf("expected 402, got %d", rec.Code)
+		}
+		if rec.Header().Get("WWW-Authenticate") == "" {
+			t.Error("expected a fresh challenge")
+		}
+	})
+
+	t.Run("paying the offer downloads", func(t *testing.T) {
+		_, after, ok := strings.Cut(challenge, `offer="`)
+		if !ok || !strings.HasPrefix(after, "lno1") {
+			t.Fatalf("expected an offer in challenge %q", challenge)
+		}
+		offers, _ := st.ListOffers(ctx)
+		if len(offers) != 1 || offers[0].Kind != store.InvoiceKindDownload {
+			t.Fatalf("expected one download offer, got %+v", offers)
+		}
+		offerHash, offerPreimage, err := lnd.PayOffer(offers[0].OfferID)
+		if err != nil {
+			t.Fatalf("PayOffer failed: %v", err)
+		}
+		// The payment watcher does this on settlement
+		l402Svc.RecordOfferPayment(ctx, offers[0], offerHash)
+
+		req := httptest.NewRequest("GET", "/api/file/paywalled1234567", nil)
+		req.Header.Set("Authorization", "L402 "+macaroon+":"+offerPreimage)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 after paying the offer, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+
+	t.Run("status hides direct URL and shows price", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/file/paywalled1234567/status", nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		var resp StatusResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if resp.DownloadPriceSats != 250 {
+			t.Errorf("expected price 250, got %d", resp.DownloadPriceSats)
+		}
+		if resp.DirectURL != "" {
+			t.Errorf("expected no direct URL for paywalled file, got %q", resp.DirectURL)
+		}
+	})
+}
+
 func TestHandler_AlbyWebhook(t *testing.T) {
 	handler, _, _ := setupTestHandler()
 
@@ -610,13 +1146,153 @@ func TestHandler_AlbyWebhook(t *testing.T) {
 			t.Errorf("expected 400, got %d", rec.Code)
 		}
 	})
+
+	t.Run("duplicate webhook acknowledged", func(t *testing.T) {
+		mockWH := &mockWebhookHandler{
+			returnError: fmt.Errorf("wrapped: %w", payments.ErrDuplicateWebhook),
+		}
+		handler.SetWebhookHandler(mockWH)
+
+		req := httptest.NewRequest("POST", "/api/webhook/alby", bytes.NewReader([]byte(`{}`)))
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Errorf("expected 200, got %d", rec.Code)
+		}
+	})
 }
 
-func TestDefaultRateLimitConfig(t *testing.T) {
-	cfg := DefaultRateLimitConfig()
+func TestHandler_Pricing(t *testing.T) {
+	handler, _, _ := setupTestHandler()
 
-	if cfg.RequestsPerSecond <= 0 {
-		t.Error("RequestsPerSecond should be positive")
+	pricer, err := pricing.NewRateCard(pricing.Config{
+		SatsPerGBDay: 100,
+		MinimumSats:  10,
+		Tiers:        map[string]float64{"priority": 2},
+	})
+	if err != nil {
+		t.Fatalf("NewRateCard failed: %v", err)
+	}
+	handler.SetPricer(pricer)
+
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantSats   int64
+	}{
+		{"default duration", "size=1073741824", http.StatusOK, 700},
+		{"custom duration", "size=1073741824&duration_seconds=86400", http.StatusOK, 100},
+		{"tier", "size=1073741824&duration_seconds=86400&tier=priority", http.StatusOK, 200},
+		{"minimum", "size=1024", http.StatusOK, 10},
+		{"unknown tier", "size=1024&tier=gold", http.StatusBadRequest, 0},
+		{"invalid size", "size=abc", http.StatusBadRequest, 0},
+		{"too large", "size=10737418240", http.StatusRequestEntityTooLarge, 0},
+		{"invalid duration", "size=1024&duration_seconds=-5", http.StatusBadRequest, 0},
+		{"duration not offered", "size=1024&duration_seconds=3600", http.StatusBadRequest, 0},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/api/pricing?"+tc.query, nil)
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tc.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var quote pricing.Quote
+			if err := json.NewDecoder(rec.Body).Decode(&quote); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if quote.AmountSats != tc.wantSats {
+				t.Errorf("expected %d sats, got %d", tc.wantSats, quote.AmountSats)
+			}
+		})
+	}
+
+	t.Run("rate card", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/api/pricing", nil)
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected 200, got %d", rec.Code)
+		}
+		var resp PricingResponse
+		json.NewDecoder(rec.Body).Decode(&resp)
+		if resp.RateCard == nil || resp.RateCard.SatsPerGBDay != 100 || resp.RateCard.MinimumSats != 10 {
+			t.Errorf("unexpected rate card %+v", resp.RateCard)
+		}
+		if len(resp.DurationsSeconds) != len(DefaultHostDurations) {
+			t.Errorf("expected %d durations, got %v", len(DefaultHostDurations), resp.DurationsSeconds)
+		}
+		if resp.DefaultDurationSeconds != 7*24*3600 {
+			t.Errorf("expected default duration of 7 days, got %d", resp.DefaultDurationSeconds)
+		}
+	})
+}
+
+func TestHandler_FiatPricing(t *testing.T) {
+	handler, storage, _ := setupTestHandler()
+
+	pricer, err := pricing.NewRateCard(pricing.Config{Currency: "USD", PricePerGBDay: 1024})
+	if err != nil {
+		t.Fatalf("NewRateCard failed: %v", err)
+	}
+	handler.SetPricer(pricer)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/pricing?size=1048576&duration_seconds=86400", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without an exchange rate, got %d", rec.Code)
+	}
+
+	// $1 per MB-day at $50,000 per BTC is 2000 sats
+	pricer.SetRateProvider(pricing.StaticRates{"USD": 50000})
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/pricing?size=1048576&duration_seconds=86400", nil))
+	var quote pricing.Quote
+	json.NewDecoder(rec.Body).Decode(&quote)
+	if quote.AmountSats != 2000 || quote.Currency != "USD" || quote.FiatAmount != 1 {
+		t.Errorf("unexpected quote %+v", quote)
+	}
+
+	storage.files["fiatfile12345678"] = make([]byte, 1<<20)
+	req := httptest.NewRequest("POST", "/api/upload/complete", strings.NewReader(`{"file_id": "fiatfile12345678", "size": 1048576, "duration_seconds": 86400}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var upload UploadCompleteResponse
+	json.NewDecoder(rec.Body).Decode(&upload)
+	if upload.AmountSats != 2000 {
+		t.Errorf("expected 2000 sats, got %d", upload.AmountSats)
+	}
+
+	// The rate is kept with the invoice for accounting
+	pending, err := handler.payments.GetInvoiceForFile("fiatfile12345678")
+	if err != nil {
+		t.Fatalf("GetInvoiceForFile failed: %v", err)
+	}
+	if want := (payments.ExchangeRate{Currency: "USD", BTCPrice: 50000}); pending.Rate != want {
+		t.Errorf("invoice rate = %+v, want %+v", pending.Rate, want)
+	}
+}
+
+func TestDefaultRateLimitConfig(t *testing.T) {
+	cfg := DefaultRateLimitConfig()
+
+	if cfg.RequestsPerSecond <= 0 {
+		t.Error("RequestsPerSecond should be positive")
 	}
 	if cfg.BurstSize <= 0 {
 		t.Error("BurstSize should be positive")
@@ -753,6 +1429,134 @@ func TestHandler_UploadStream(t *testing.T) {
 	}
 }
 
+func TestHandler_Tus(t *testing.T) {
+	handler, storage, _ := setupTestHandler()
+
+	tus := func(method, path string, body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Tus-Resumable", "1.0.0")
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	patch := func(location string, offset int, body []byte) *httptest.ResponseRecorder {
+		return tus("PATCH", location, body, map[string]string{
+			"Content-Type":  "application/offset+octet-stream",
+			"Upload-Offset": strconv.Itoa(offset),
+		})
+	}
+
+	if rec := tus("POST", "/api/tus", nil, map[string]string{"Upload-Length": "1024"}); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 with resumable uploads disabled, got %d", rec.Code)
+	}
+	partials, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer partials.Close()
+	handler.files.SetPartialUploads(partials)
+
+	rec := tus("OPTIONS", "/api/tus", nil, nil)
+	if rec.Code != http.StatusNoContent || rec.Header().Get("Tus-Version") != "1.0.0" || !strings.Contains(rec.Header().Get("Tus-Extension"), "creation") {
+		t.Errorf("unexpected OPTIONS response %d %v", rec.Code, rec.Header())
+	}
+
+	req := httptest.NewRequest("POST", "/api/tus", nil)
+	req.Header.Set("Upload-Length", "1024")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Errorf("expected 412 without Tus-Resumable, got %d", rec.Code)
+	}
+	if rec := tus("POST", "/api/tus", nil, map[string]string{"Upload-Length": "0"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a zero length, got %d", rec.Code)
+	}
+	if rec := tus("POST", "/api/tus", nil, map[string]string{"Upload-Length": strconv.Itoa(MaxUploadSize + 1)}); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an oversized upload, got %d", rec.Code)
+	}
+
+	rec = tus("POST", "/api/tus", nil, map[string]string{"Upload-Length": "1024", "Upload-Metadata": "filetype YXBwbGljYXRpb24vb2N0ZXQtc3RyZWFt"})
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	location := rec.Header().Get("Location")
+	fileID := strings.TrimPrefix(location, "/api/tus/")
+	if !isValidFileID(fileID) || rec.Header().Get("Upload-Expires") == "" {
+		t.Fatalf("unexpected creation headers %v", rec.Header())
+	}
+
+	data := make([]byte, 1024)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if rec := patch(location, 0, data[:400]); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "400" {
+		t.Fatalf("first PATCH: %d offset=%q %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Body.String())
+	}
+
+	// The connection drops; the client asks where to resume
+	rec = tus("HEAD", location, nil, nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Upload-Offset") != "400" || rec.Header().Get("Upload-Length") != "1024" {
+		t.Errorf("unexpected HEAD response %d %v", rec.Code, rec.Header())
+	}
+	if rec.Header().Get("Upload-Metadata") != "filetype YXBwbGljYXRpb24vb2N0ZXQtc3RyZWFt" || rec.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("unexpected HEAD headers %v", rec.Header())
+	}
+
+	if rec := patch(location, 0, data[:400]); rec.Code != http.StatusConflict {
+		t.Errorf("expected 409 for a wrong offset, got %d", rec.Code)
+	}
+	rec = tus("PATCH", location, data[400:], map[string]string{"Upload-Offset": "400"})
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 without the tus content type, got %d", rec.Code)
+	}
+	if rec := patch(location, 400, make([]byte, 1000)); rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for a chunk past the upload length, got %d", rec.Code)
+	}
+
+	// Completing before all bytes arrived is refused
+	completeBody := fmt.Sprintf(`{"file_id": %q, "size": 1024}`, fileID)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/upload/complete", strings.NewReader(completeBody)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 completing an unfinished upload, got %d", rec.Code)
+	}
+
+	if rec := patch(location, 400, data[400:]); rec.Code != http.StatusNoContent || rec.Header().Get("Upload-Offset") != "1024" {
+		t.Fatalf("last PATCH: %d offset=%q %s", rec.Code, rec.Header().Get("Upload-Offset"), rec.Body.String())
+	}
+	if !bytes.Equal(storage.files[fileID], data) {
+		t.Fatal("stored file doesn't match the uploaded data")
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/upload/complete", strings.NewReader(completeBody)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 completing the upload, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp UploadCompleteResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.Size != 1024 || resp.PaymentRequest == "" {
+		t.Errorf("unexpected complete response %+v", resp)
+	}
+	if rec := tus("HEAD", location, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a completed upload, got %d", rec.Code)
+	}
+
+	// Termination discards an upload
+	rec = tus("POST", "/api/tus", nil, map[string]string{"Upload-Length": "10"})
+	other := rec.Header().Get("Location")
+	patch(other, 0, []byte("abc"))
+	if rec := tus("DELETE", other, nil, nil); rec.Code != http.StatusNoContent {
+		t.Errorf("expected 204 terminating an upload, got %d", rec.Code)
+	}
+	if rec := tus("HEAD", other, nil, nil); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 after termination, got %d", rec.Code)
+	}
+}
+
 func TestHandler_UploadStream_InvalidID(t *testing.T) {
 	handler, _, _ := setupTestHandler()
 
@@ -796,3 +1600,507 @@ func TestHandler_UploadStream_TooLarge(t *testing.T) {
 		t.Errorf("expected 413, got %d", rec.Code)
 	}
 }
+
+func TestHandler_EarningsWithdraw(t *testing.T) {
+	storage := newMockStorage()
+	st := newMockStore()
+	lnd := payments.NewMockLNDClient()
+	handler := NewHandler(files.NewService(storage, st), payments.NewService(lnd, st), nil)
+
+	ledger, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create ledger: %v", err)
+	}
+	defer ledger.Close()
+	payoutsSvc := payouts.NewService(ledger, lnd)
+	handler.SetPayouts(payoutsSvc)
+
+	// Upload a file to get its owner token
+	storage.files["earner1234567890"] = make([]byte, 1024)
+	req := httptest.NewRequest("POST", "/api/upload/complete", strings.NewReader(`{"file_id": "earner1234567890", "size": 1024}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var upload UploadCompleteResponse
+	json.NewDecoder(rec.Body).Decode(&upload)
+	if upload.OwnerToken == "" {
+		t.Fatalf("expected an owner token, got %s", rec.Body.String())
+	}
+
+	ctx := context.Background()
+	ledger.AddLedgerEntry(ctx, &store.LedgerEntry{
+		FileID:     "earner1234567890",
+		Kind:       store.LedgerDownload,
+		AmountSats: 700,
+		Reference:  "download1",
+		CreatedAt:  time.Now(),
+	})
+
+	earnings := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/file/earner1234567890/earnings", nil)
+		req.Header.Set("X-Forwarded-Proto", "https")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("requires owner token", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			if rec := earnings(token); rec.Code != http.StatusUnauthorized {
+				t.Errorf("token %q: expected 401, got %d", token, rec.Code)
+			}
+		}
+	})
+
+	rec = earnings(upload.OwnerToken)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp EarningsResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if resp.BalanceSats != 700 || len(resp.Entries) != 1 {
+		t.Fatalf("unexpected earnings %+v", resp)
+	}
+
+	withdrawURL, err := lnurl.Decode(resp.LNURL)
+	if err != nil {
+		t.Fatalf("invalid LNURL %q: %v", resp.LNURL, err)
+	}
+	if !strings.HasPrefix(withdrawURL, "https://example.com/api/lnurlw/") {
+		t.Fatalf("unexpected withdraw URL %q", withdrawURL)
+	}
+
+	// The wallet fetches the withdraw request...
+	req = httptest.NewRequest("GET", withdrawURL, nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var withdraw lnurl.WithdrawRequest
+	json.NewDecoder(rec.Body).Decode(&withdraw)
+	if withdraw.Tag != "withdrawRequest" || withdraw.MaxWithdrawable != 700_000 {
+		t.Fatalf("unexpected withdraw request %s", rec.Body.String())
+	}
+	if withdraw.Callback != "https://example.com/api/lnurlw/callback" {
+		t.Errorf("unexpected callback %q", withdraw.Callback)
+	}
+
+	// ...and submits an invoice for part of the balance
+	pr, _ := payments.MockPaymentRequest("5u")
+	callback := withdraw.Callback + "?k1=" + withdraw.K1 + "&pr=" + pr
+	req = httptest.NewRequest("GET", callback, nil)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var status lnurl.StatusResponse
+	json.NewDecoder(rec.Body).Decode(&status)
+	if status.Status != "OK" {
+		t.Fatalf("expected OK, got %+v", status)
+	}
+	payoutsSvc.Wait()
+
+	if paid := lnd.Payments(); len(paid) != 1 || paid[0].AmountSats != 500 {
+		t.Errorf("expected a 500 sat payout, got %+v", paid)
+	}
+	if balance, _ := payoutsSvc.Balance(ctx, "earner1234567890"); balance != 200 {
+		t.Errorf("expected 200 sats left, got %d", balance)
+	}
+
+	t.Run("link is single use", func(t *testing.T) {
+		req := httptest.NewRequest("GET", callback, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var status lnurl.StatusResponse
+		json.NewDecoder(rec.Body).Decode(&status)
+		if status.Status != "ERROR" {
+			t.Errorf("expected ERROR on reuse, got %+v", status)
+		}
+	})
+}
+
+func TestHandler_LNURLPay(t *testing.T) {
+	storage := newMockStorage()
+	st := newMockStore()
+	lnd := payments.NewMockLNDClient()
+	handler := NewHandler(files.NewService(storage, st), payments.NewService(lnd, st), nil)
+
+	storage.files["lnurlpay12345678"] = make([]byte, 1024)
+	req := httptest.NewRequest("POST", "/api/upload/complete", strings.NewReader(`{"file_id": "lnurlpay12345678", "size": 1024}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var upload UploadCompleteResponse
+	json.NewDecoder(rec.Body).Decode(&upload)
+
+	payURL, err := lnurl.Decode(upload.LNURL)
+	if err != nil {
+		t.Fatalf("invalid LNURL %q: %v", upload.LNURL, err)
+	}
+	if payURL != "http://example.com/.well-known/lnurlp/lnurlpay12345678" {
+		t.Fatalf("unexpected LNURL-pay URL %q", payURL)
+	}
+
+	get := func(url string, v any) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", url, nil))
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s: invalid JSON response: %v", url, err)
+		}
+	}
+
+	var payReq lnurl.PayRequest
+	get(payURL, &payReq)
+	wantMsat := upload.AmountSats * 1000
+	if payReq.Tag != "payRequest" || payReq.MinSendable != wantMsat || payReq.MaxSendable != wantMsat {
+		t.Fatalf("unexpected pay request %+v", payReq)
+	}
+
+	t.Run("wrong amount", func(t *testing.T) {
+		var status lnurl.StatusResponse
+		get(fmt.Sprintf("%s?amount=%d", payReq.Callback, wantMsat-1000), &status)
+		if status.Status != "ERROR" {
+			t.Errorf("expected ERROR, got %+v", status)
+		}
+	})
+
+	var payResp lnurl.PayResponse
+	get(fmt.Sprintf("%s?amount=%d", payReq.Callback, wantMsat), &payResp)
+	if !strings.HasPrefix(payResp.PR, "lnbc") || payResp.PR == upload.PaymentRequest {
+		t.Fatalf("expected a fresh invoice, got %q", payResp.PR)
+	}
+	pending, err := handler.payments.GetInvoiceForFile("lnurlpay12345678")
+	if err != nil || pending.Invoice.PaymentRequest != payResp.PR {
+		t.Fatalf("expected the new invoice to be tracked for the file")
+	}
+	sum := sha256.Sum256([]byte(payReq.Metadata))
+	if got := lnd.DescriptionHash(pending.PaymentHash); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the invoice to commit to the metadata hash, got %q", got)
+	}
+	if !lnd.Canceled(upload.PaymentHash) {
+		t.Errorf("expected the superseded upload invoice to be cancelled")
+	}
+
+	t.Run("repeated callback reuses the invoice", func(t *testing.T) {
+		var again lnurl.PayResponse
+		get(fmt.Sprintf("%s?amount=%d", payReq.Callback, wantMsat), &again)
+		if again.PR != payResp.PR {
+			t.Errorf("expected the same invoice, got %q", again.PR)
+		}
+	})
+
+	t.Run("paid file", func(t *testing.T) {
+		st.UpdatePaymentStatus(context.Background(), "lnurlpay12345678", true)
+		var status lnurl.StatusResponse
+		get(payURL, &status)
+		if status.Status != "ERROR" {
+			t.Errorf("expected ERROR for a paid file, got %+v", status)
+		}
+	})
+
+	t.Run("unknown file", func(t *testing.T) {
+		var status lnurl.StatusResponse
+		get("/.well-known/lnurlp/doesnotexist", &status)
+		if status.Status != "ERROR" {
+			t.Errorf("expected ERROR, got %+v", status)
+		}
+	})
+}
+
+func TestHandler_PayCashu(t *testing.T) {
+	handler, storage, st := setupTestHandler()
+	ctx := context.Background()
+
+	post := func(id, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/file/"+id+"/cashu", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := post("cashupay12345678", `{"token": "cashuA"}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without a mint, got %d", rec.Code)
+	}
+
+	mint, err := cashufake.New(cashufake.Config{})
+	if err != nil {
+		t.Fatalf("failed to start fake mint: %v", err)
+	}
+	defer mint.Close()
+	proofs, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer proofs.Close()
+	handler.payments.SetCashuReceiver(cashu.NewWallet(mint.URL, proofs))
+
+	storage.files["cashupay12345678"] = make([]byte, 1024)
+	req := httptest.NewRequest("POST", "/api/upload/complete", strings.NewReader(`{"file_id": "cashupay12345678", "size": 1024}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	var upload UploadCompleteResponse
+	json.NewDecoder(rec.Body).Decode(&upload)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/api/file/cashupay12345678/invoice", nil))
+	var invoice InvoiceResponse
+	json.NewDecoder(rec.Body).Decode(&invoice)
+	if invoice.CashuMint != mint.URL {
+		t.Errorf("expected invoice to advertise mint %s, got %q", mint.URL, invoice.CashuMint)
+	}
+
+	tooLittle := mint.Issue(upload.AmountSats - 1)
+	tests := []struct {
+		name       string
+		fileID     string
+		body       string
+		wantStatus int
+	}{
+		{"malformed body", "cashupay12345678", `{`, http.StatusBadRequest},
+		{"not a token", "cashupay12345678", `{"token": "lnbc1"}`, http.StatusBadRequest},
+		{"too little", "cashupay12345678", fmt.Sprintf(`{"token": %q}`, tooLittle.Encode()), http.StatusBadRequest},
+		{"unknown file", "cashumissing1234", fmt.Sprintf(`{"token": %q}`, mint.Issue(1000).Encode()), http.StatusNotFound},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if rec := post(tc.fileID, tc.body); rec.Code != tc.wantStatus {
+				t.Errorf("expected %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+	if mint.Swaps() != 0 {
+		t.Fatal("expected refused tokens to be left unspent")
+	}
+
+	token := mint.Issue(upload.AmountSats)
+	rec = post("cashupay12345678", fmt.Sprintf(`{"token": %q}`, token.Encode()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var resp CashuPaymentResponse
+	json.NewDecoder(rec.Body).Decode(&resp)
+	if !resp.Paid || resp.AmountSats != upload.AmountSats {
+		t.Errorf("unexpected response %+v", resp)
+	}
+	if meta, _ := st.GetFileMetadata(ctx, "cashupay12345678"); !meta.Paid {
+		t.Error("expected file to be marked paid")
+	}
+	if saved, _ := proofs.ListCashuProofs(ctx); len(saved) == 0 {
+		t.Error("expected the received ecash to be stored")
+	}
+
+	t.Run("already paid", func(t *testing.T) {
+		rec := post("cashupay12345678", fmt.Sprintf(`{"token": %q}`, mint.Issue(upload.AmountSats).Encode()))
+		if rec.Code != http.StatusConflict {
+			t.Errorf("expected 409, got %d", rec.Code)
+		}
+	})
+}
+
+func TestHandler_Accounts(t *testing.T) {
+	storage := newMockStorage()
+	st := newMockStore()
+	lnd := payments.NewMockLNDClient()
+	paymentsSvc := payments.NewService(lnd, st)
+	handler := NewHandler(files.NewService(storage, st), paymentsSvc, nil)
+	ctx := context.Background()
+
+	do := func(method, path, apiKey, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		if apiKey != "" {
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do("POST", "/api/accounts", "", `{"amount_sats": 1000}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 without accounts, got %d", rec.Code)
+	}
+	if rec := do("POST", "/api/upload/complete", "ssk_abc", `{"file_id": "acctfile12345678", "size": 1024}`); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 for an API key without accounts, got %d", rec.Code)
+	}
+
+	ledger, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer ledger.Close()
+	accountsSvc := accounts.NewService(ledger, paymentsSvc)
+	paymentsSvc.SetTopUpCallback(accountsSvc.OnTopUp)
+	handler.SetAccounts(accountsSvc)
+
+	if rec := do("POST", "/api/accounts", "", `{"amount_sats": 0}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a zero top-up, got %d", rec.Code)
+	}
+
+	rec := do("POST", "/api/accounts", "", `{"amount_sats": 1000}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var created CreateAccountResponse
+	json.NewDecoder(rec.Body).Decode(&created)
+	if !strings.HasPrefix(created.APIKey, accounts.APIKeyPrefix) || created.TopUp.PaymentRequest == "" || created.TopUp.AmountSats != 1000 {
+		t.Fatalf("unexpected response %+v", created)
+	}
+	apiKey := created.APIKey
+
+	// Nothing is credited until the top-up is paid
+	storage.files["acctfile12345678"] = make([]byte, 1024)
+	if rec := do("POST", "/api/upload/complete", apiKey, `{"file_id": "acctfile12345678", "size": 1024}`); rec.Code != http.StatusPaymentRequired {
+		t.Fatalf("expected 402 with an empty balance, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := st.GetFileMetadata(ctx, "acctfile12345678"); err == nil {
+		t.Error("expected no metadata for an upload the account couldn't pay")
+	}
+
+	lnd.MarkSettled(created.TopUp.PaymentHash)
+	if count, err := paymentsSvc.ReconcilePendingInvoices(ctx); err != nil || count != 1 {
+		t.Fatalf("expected 1 reconciled invoice, got %d (err=%v)", count, err)
+	}
+
+	rec = do("POST", "/api/upload/complete", apiKey, `{"file_id": "acctfile12345678", "size": 1024}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var upload UploadCompleteResponse
+	json.NewDecoder(rec.Body).Decode(&upload)
+	if !upload.Paid || upload.PaymentRequest != "" || upload.AmountSats <= 0 {
+		t.Errorf("expected upload paid from the account without an invoice, got %+v", upload)
+	}
+	if meta, _ := st.GetFileMetadata(ctx, "acctfile12345678"); meta == nil || !meta.Paid {
+		t.Error("expected file to be marked paid")
+	}
+
+	rec = do("GET", "/api/account", apiKey, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var account AccountResponse
+	json.NewDecoder(rec.Body).Decode(&account)
+	if account.AccountID != created.AccountID || account.BalanceSats != 1000-upload.AmountSats {
+		t.Errorf("unexpected account %+v", account)
+	}
+	if len(account.Entries) != 2 || account.Entries[0].Kind != store.AccountTopUp ||
+		account.Entries[1].Kind != store.AccountUpload || account.Entries[1].Reference != "acctfile12345678" {
+		t.Errorf("unexpected ledger %+v", account.Entries)
+	}
+
+	rec = do("POST", "/api/account/topup", apiKey, `{"amount_sats": 500}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var topUp TopUpResponse
+	json.NewDecoder(rec.Body).Decode(&topUp)
+	if topUp.AmountSats != 500 || topUp.PaymentHash == "" {
+		t.Errorf("unexpected top-up %+v", topUp)
+	}
+
+	t.Run("invalid key", func(t *testing.T) {
+		if rec := do("POST", "/api/upload/complete", "ssk_wrong", `{"file_id": "acctfile87654321", "size": 1024}`); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401, got %d", rec.Code)
+		}
+		if rec := do("GET", "/api/account", "ssk_wrong", ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401, got %d", rec.Code)
+		}
+	})
+
+	t.Run("missing key", func(t *testing.T) {
+		if rec := do("GET", "/api/account", "", ""); rec.Code != http.StatusUnauthorized {
+			t.Errorf("expected 401, got %d", rec.Code)
+		}
+	})
+}
+
+func TestHandler_UploadComplete_Voucher(t *testing.T) {
+	handler, storage, st := setupTestHandler()
+	ctx := context.Background()
+
+	complete := func(fileID, voucher string) *httptest.ResponseRecorder {
+		storage.files[fileID] = make([]byte, 1024)
+		body := fmt.Sprintf(`{"file_id": %q, "size": 1024, "voucher": %q}`, fileID, voucher)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("POST", "/api/upload/complete", strings.NewReader(body)))
+		return rec
+	}
+
+	if rec := complete("voucher000000001", "FREE"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 without vouchers enabled, got %d", rec.Code)
+	}
+
+	vouchersStore, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer vouchersStore.Close()
+	vouchersSvc := vouchers.NewService(vouchersStore)
+	vouchersSvc.Create(ctx, &store.Voucher{Code: "FREE", PercentOff: 100, MaxUses: 1})
+	vouchersSvc.Create(ctx, &store.Voucher{Code: "HALF", PercentOff: 50})
+	handler.SetVouchers(vouchersSvc)
+
+	if rec := complete("voucher000000002", "WRONG"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an unknown code, got %d", rec.Code)
+	}
+	if _, err := st.GetFileMetadata(ctx, "voucher000000002"); err == nil {
+		t.Error("expected no metadata for an upload with an unknown code")
+	}
+
+	// Full price, for comparison
+	rec := complete("voucher000000003", "")
+	var full UploadCompleteResponse
+	json.NewDecoder(rec.Body).Decode(&full)
+	if full.AmountSats <= 0 {
+		t.Fatalf("unexpected response %s", rec.Body.String())
+	}
+
+	rec = complete("voucher000000004", "free")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var free UploadCompleteResponse
+	json.NewDecoder(rec.Body).Decode(&free)
+	if !free.Paid || free.PaymentRequest != "" || free.AmountSats != 0 || free.DiscountSats != full.AmountSats {
+		t.Errorf("expected free upload without an invoice, got %+v", free)
+	}
+	if meta, _ := st.GetFileMetadata(ctx, "voucher000000004"); meta == nil || !meta.Paid {
+		t.Error("expected file to be marked paid")
+	}
+	if _, err := handler.payments.GetInvoiceForFile("voucher000000004"); err == nil {
+		t.Error("expected no invoice for a free upload")
+	}
+
+	if rec := complete("voucher000000005", "FREE"); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a used-up voucher, got %d", rec.Code)
+	}
+
+	rec = complete("voucher000000006", "HALF")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var half UploadCompleteResponse
+	json.NewDecoder(rec.Body).Decode(&half)
+	if half.Paid || half.PaymentRequest == "" {
+		t.Errorf("expected an invoice for a partial discount, got %+v", half)
+	}
+	if half.DiscountSats != full.AmountSats/2 || half.AmountSats != full.AmountSats-half.DiscountSats {
+		t.Errorf("expected %d sats off %d, got %+v", full.AmountSats/2, full.AmountSats, half)
+	}
+	if inv, err := handler.payments.GetInvoiceForFile("voucher000000006"); err != nil || inv.Invoice.AmountSats != half.AmountSats {
+		t.Errorf("expected the file's invoice to be for the discounted amount")
+	}
+
+	t.Run("failed upload releases the use", func(t *testing.T) {
+		vouchersSvc.Create(ctx, &store.Voucher{Code: "ONCE", PercentOff: 100, MaxUses: 1})
+		st.failPaid = true
+		rec := complete("voucher000000007", "ONCE")
+		st.failPaid = false
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("expected 500 when the file can't be marked paid, got %d", rec.Code)
+		}
+		if v, _ := vouchersSvc.Check(ctx, "ONCE"); v == nil || v.Uses != 0 {
+			t.Fatalf("expected the voucher's use to be given back, got %+v", v)
+		}
+		if rec := complete("voucher000000008", "ONCE"); rec.Code != http.StatusOK {
+			t.Errorf("expected the voucher to still be redeemable, got %d: %s", rec.Code, rec.Body.String())
+		}
+	})
+}
@@ -58,10 +58,13 @@ func CORS(cfg CORSConfig) func(http.Handler) http.Handler {
 				w.Header().Set("Vary", "Origin")
 			}
 
-			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, HEAD, OPTIONS")
-			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, HEAD, OPTIONS, PATCH, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Tus-Resumable, Upload-Length, Upload-Offset, Upload-Metadata")
+			w.Header().Set("Access-Control-Expose-Headers", "WWW-Authenticate, Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Upload-Offset, Upload-Length, Upload-Metadata, Upload-Expires")
 
-			if r.Method == "OPTIONS" {
+			// The tus endpoints answer OPTIONS themselves, advertising the
+			// protocol version and extensions
+			if r.Method == "OPTIONS" && !strings.HasPrefix(r.URL.Path, "/api/tus") {
 				w.WriteHeader(http.StatusOK)
 				return
 			}
@@ -91,6 +94,10 @@ type RateLimitConfig struct {
 	UploadRequestsPerMinute float64
 	// UploadBurstSize is the maximum burst for uploads
 	UploadBurstSize int
+	// AccountCreationsPerHour is the rate limit for opening prepaid accounts per IP
+	AccountCreationsPerHour float64
+	// AccountBurstSize is the maximum burst for opening accounts
+	AccountBurstSize int
 }
 
 // DefaultRateLimitConfig returns sensible defaults for rate limiting.
@@ -100,6 +107,8 @@ func DefaultRateLimitConfig() RateLimitConfig {
 		BurstSize:               20,  // Allow bursts up to 20
 		UploadRequestsPerMinute: 10,  // 10 uploads per minute
 		UploadBurstSize:         3,   // Allow burst of 3 uploads
+		AccountCreationsPerHour: 5,   // 5 new accounts per hour
+		AccountBurstSize:        2,   // Allow burst of 2 accounts
 	}
 }
 
@@ -203,6 +212,7 @@ func (rl *ipRateLimiter) Stop() {
 type RateLimiterMiddleware struct {
 	generalLimiter *ipRateLimiter
 	uploadLimiter  *ipRateLimiter
+	accountLimiter *ipRateLimiter
 }
 
 // Middleware returns the HTTP middleware function.
@@ -214,6 +224,9 @@ func (rlm *RateLimiterMiddleware) Middleware(next http.Handler) http.Handler {
 		var limiter *rate.Limiter
 		if r.Method == "POST" && r.URL.Path == "/api/upload" {
 			limiter = rlm.uploadLimiter.getLimiter(ip)
+		} else if r.Method == "POST" && r.URL.Path == "/api/accounts" {
+			// Each account is a database row and an invoice
+			limiter = rlm.accountLimiter.getLimiter(ip)
 		} else {
 			limiter = rlm.generalLimiter.getLimiter(ip)
 		}
@@ -232,6 +245,7 @@ func (rlm *RateLimiterMiddleware) Middleware(next http.Handler) http.Handler {
 func (rlm *RateLimiterMiddleware) Stop() {
 	rlm.generalLimiter.Stop()
 	rlm.uploadLimiter.Stop()
+	rlm.accountLimiter.Stop()
 }
 
 // NewRateLimiter creates a rate limiting middleware with automatic cleanup.
@@ -240,6 +254,8 @@ func NewRateLimiter(cfg RateLimitConfig) *RateLimiterMiddleware {
 	return &RateLimiterMiddleware{
 		generalLimiter: newIPRateLimiter(cfg.RequestsPerSecond, cfg.BurstSize),
 		uploadLimiter:  newIPRateLimiter(cfg.UploadRequestsPerMinute/60, cfg.UploadBurstSize),
+		// Idle account limiters must outlive a refill, or they'd reset early
+		accountLimiter: newIPRateLimiterWithTTL(cfg.AccountCreationsPerHour/3600, cfg.AccountBurstSize, 2*time.Hour),
 	}
 }
 
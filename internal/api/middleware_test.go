@@ -0,0 +1,37 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimiter_AccountCreation(t *testing.T) {
+	cfg := DefaultRateLimitConfig()
+	rl := NewRateLimiter(cfg)
+	defer rl.Stop()
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(method, path, ip string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	for i := 0; i < cfg.AccountBurstSize; i++ {
+		if code := do("POST", "/api/accounts", "192.0.2.1"); code != http.StatusOK {
+			t.Fatalf("account %d: expected 200, got %d", i+1, code)
+		}
+	}
+	if code := do("POST", "/api/accounts", "192.0.2.1"); code != http.StatusTooManyRequests {
+		t.Errorf("expected 429 once the burst is spent, got %d", code)
+	}
+	if code := do("POST", "/api/accounts", "192.0.2.2"); code != http.StatusOK {
+		t.Errorf("expected another IP to be unaffected, got %d", code)
+	}
+	if code := do("GET", "/api/account", "192.0.2.1"); code != http.StatusOK {
+		t.Errorf("expected other endpoints to be unaffected, got %d", code)
+	}
+}
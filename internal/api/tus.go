@@ -0,0 +1,222 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"satoshisend/internal/files"
+	"satoshisend/internal/logging"
+	"satoshisend/internal/store"
+)
+
+// Resumable uploads implement the core tus 1.0 protocol
+// (https://tus.io/protocols/resumable-upload) with the creation, termination
+// and expiration extensions. A client creates an upload with POST /api/tus,
+// sends the data with one or more PATCH requests, asks for the offset with
+// HEAD after an interruption, and then calls /api/upload/complete with the ID
+// from the Location header as usual.
+
+const tusVersion = "1.0.0"
+
+// maxUploadMetadata caps the Upload-Metadata header kept for each upload.
+const maxUploadMetadata = 4096
+
+func (h *Handler) registerTusRoutes() {
+	h.mux.HandleFunc("OPTIONS /api/tus", h.handleTusOptions)
+	h.mux.HandleFunc("OPTIONS /api/tus/{id}", h.handleTusOptions)
+	h.mux.HandleFunc("POST /api/tus", h.handleTusCreate)
+	h.mux.HandleFunc("HEAD /api/tus/{id}", h.handleTusHead)
+	h.mux.HandleFunc("PATCH /api/tus/{id}", h.handleTusPatch)
+	h.mux.HandleFunc("DELETE /api/tus/{id}", h.handleTusDelete)
+}
+
+// checkTus rejects requests when resumable uploads are disabled or the client
+// speaks another protocol version.
+func (h *Handler) checkTus(w http.ResponseWriter, r *http.Request) bool {
+	if !h.files.ResumableUploads() {
+		http.Error(w, "resumable uploads are not enabled on this server", http.StatusNotFound)
+		return false
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// tusUploadError writes the response for an error looking up or writing a
+// partial upload.
+func tusUploadError(w http.ResponseWriter, id string, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		http.Error(w, "upload not found", http.StatusNotFound)
+	case errors.Is(err, files.ErrExpired):
+		http.Error(w, "upload expired", http.StatusGone)
+	case errors.Is(err, files.ErrOffsetMismatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, files.ErrUploadBusy):
+		http.Error(w, err.Error(), http.StatusLocked)
+	default:
+		logging.Internal.Printf("resumable upload %s failed: %v", id, err)
+		http.Error(w, "upload failed", http.StatusInternalServerError)
+	}
+}
+
+func setUploadExpires(w http.ResponseWriter, u *store.PartialUpload) {
+	w.Header().Set("Upload-Expires", u.ExpiresAt.UTC().Format(http.TimeFormat))
+}
+
+func (h *Handler) handleTusOptions(w http.ResponseWriter, r *http.Request) {
+	if !h.files.ResumableUploads() {
+		http.Error(w, "resumable uploads are not enabled on this server", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+	w.Header().Set("Tus-Version", tusVersion)
+	w.Header().Set("Tus-Extension", "creation,termination,expiration")
+	w.Header().Set("Tus-Max-Size", strconv.FormatInt(MaxUploadSize, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleTusCreate(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTus(w, r) {
+		return
+	}
+
+	ip := extractIP(r)
+	if h.pendingLimiter != nil && !h.pendingLimiter.CanUpload(ip) {
+		msg := fmt.Sprintf("pending file limit reached: you have %d unpaid file(s) (max %d). "+
+			"Please pay for or wait for existing files to expire before uploading more.", h.pendingLimiter.PendingCount(ip), h.pendingLimiter.MaxPending())
+		http.Error(w, msg, http.StatusTooManyRequests)
+		return
+	}
+
+	if r.Header.Get("Upload-Defer-Length") != "" {
+		http.Error(w, "deferred upload length is not supported", http.StatusBadRequest)
+		return
+	}
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length <= 0 {
+		http.Error(w, "Upload-Length must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	if length > MaxUploadSize {
+		http.Error(w, "file too large (max 5GB)", http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata := r.Header.Get("Upload-Metadata")
+	if len(metadata) > maxUploadMetadata {
+		http.Error(w, "Upload-Metadata too large", http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.files.CreatePartialUpload(r.Context(), length, metadata)
+	if err != nil {
+		logging.Internal.Printf("failed to create resumable upload: %v", err)
+		http.Error(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	logging.Internal.Printf("resumable upload created: file_id=%s, size=%d", u.ID, length)
+
+	w.Header().Set("Location", "/api/tus/"+u.ID)
+	setUploadExpires(w, u)
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (h *Handler) handleTusHead(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTus(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	if !isValidFileID(id) {
+		http.Error(w, "invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.files.GetPartialUpload(r.Context(), id)
+	if err != nil {
+		tusUploadError(w, id, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	if u.Metadata != "" {
+		w.Header().Set("Upload-Metadata", u.Metadata)
+	}
+	setUploadExpires(w, u)
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *Handler) handleTusPatch(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTus(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	if !isValidFileID(id) {
+		http.Error(w, "invalid file ID", http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/offset+octet-stream") {
+		http.Error(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Upload-Offset must be a non-negative integer", http.StatusBadRequest)
+		return
+	}
+
+	u, err := h.files.GetPartialUpload(r.Context(), id)
+	if err != nil {
+		tusUploadError(w, id, err)
+		return
+	}
+	if r.ContentLength > u.Length-u.Offset {
+		http.Error(w, "request body exceeds the upload length", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	newOffset, err := h.files.AppendPartialUpload(r.Context(), id, offset, r.Body, r.ContentLength)
+	if err != nil {
+		tusUploadError(w, id, err)
+		return
+	}
+
+	if newOffset == u.Length {
+		logging.Internal.Printf("resumable upload finished: file_id=%s, size=%d", id, u.Length)
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	if newOffset > offset {
+		// Receiving data pushed the expiry back
+		if cur, err := h.files.GetPartialUpload(r.Context(), id); err == nil {
+			u = cur
+		}
+	}
+	setUploadExpires(w, u)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) handleTusDelete(w http.ResponseWriter, r *http.Request) {
+	if !h.checkTus(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	if !isValidFileID(id) {
+		http.Error(w, "invalid file ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.files.TerminatePartialUpload(r.Context(), id); err != nil {
+		tusUploadError(w, id, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
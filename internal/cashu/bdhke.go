@@ -0,0 +1,98 @@
+package cashu
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+// domainSeparator is prefixed to messages before hashing them to the curve (NUT-00).
+const domainSeparator = "Secp256k1_HashToCurve_Cashu_"
+
+// HashToCurve deterministically maps a message (a proof's secret) to a point
+// on secp256k1 whose discrete logarithm is unknown.
+func HashToCurve(message []byte) (*btcec.PublicKey, error) {
+	msgHash := sha256.Sum256(append([]byte(domainSeparator), message...))
+	var counter [4]byte
+	for i := uint32(0); i < 1<<16; i++ {
+		binary.LittleEndian.PutUint32(counter[:], i)
+		h := sha256.New()
+		h.Write(msgHash[:])
+		h.Write(counter[:])
+		if point, err := btcec.ParsePubKey(append([]byte{0x02}, h.Sum(nil)...)); err == nil {
+			return point, nil
+		}
+	}
+	return nil, errors.New("cashu: no curve point found for message")
+}
+
+// Blind returns the blinded message B_ = Y + rG for a secret, where
+// Y = HashToCurve(secret), together with the blinding factor r.
+func Blind(secret string) (blinded *btcec.PublicKey, r *btcec.PrivateKey, err error) {
+	y, err := HashToCurve([]byte(secret))
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err = btcec.NewPrivateKey()
+	if err != nil {
+		return nil, nil, err
+	}
+	return addPoints(y, r.PubKey()), r, nil
+}
+
+// Unblind removes the blinding factor from the mint's blind signature C_,
+// giving the proof's signature C = C_ - rK for the mint's amount key K.
+func Unblind(blindSig *btcec.PublicKey, r *btcec.PrivateKey, mintKey *btcec.PublicKey) *btcec.PublicKey {
+	return subPoints(blindSig, mulPoint(&r.Key, mintKey))
+}
+
+// SignBlinded is the mint's side of the exchange: C_ = kB_.
+func SignBlinded(k *btcec.PrivateKey, blinded *btcec.PublicKey) *btcec.PublicKey {
+	return mulPoint(&k.Key, blinded)
+}
+
+// Verify checks a proof's signature with the mint's private key k: C == kY.
+func Verify(k *btcec.PrivateKey, secret string, c *btcec.PublicKey) bool {
+	y, err := HashToCurve([]byte(secret))
+	if err != nil {
+		return false
+	}
+	return mulPoint(&k.Key, y).IsEqual(c)
+}
+
+// parsePoint decodes a hex compressed public key.
+func parsePoint(hexPoint string) (*btcec.PublicKey, error) {
+	raw, err := hex.DecodeString(hexPoint)
+	if err != nil {
+		return nil, err
+	}
+	return btcec.ParsePubKey(raw)
+}
+
+func addPoints(a, b *btcec.PublicKey) *btcec.PublicKey {
+	var ja, jb, sum btcec.JacobianPoint
+	a.AsJacobian(&ja)
+	b.AsJacobian(&jb)
+	btcec.AddNonConst(&ja, &jb, &sum)
+	sum.ToAffine()
+	return btcec.NewPublicKey(&sum.X, &sum.Y)
+}
+
+func subPoints(a, b *btcec.PublicKey) *btcec.PublicKey {
+	var jb btcec.JacobianPoint
+	b.AsJacobian(&jb)
+	jb.Y.Negate(1)
+	jb.Y.Normalize()
+	return addPoints(a, btcec.NewPublicKey(&jb.X, &jb.Y))
+}
+
+func mulPoint(k *btcec.ModNScalar, p *btcec.PublicKey) *btcec.PublicKey {
+	var jp, product btcec.JacobianPoint
+	p.AsJacobian(&jp)
+	btcec.ScalarMultNonConst(k, &jp, &product)
+	product.ToAffine()
+	return btcec.NewPublicKey(&product.X, &product.Y)
+}
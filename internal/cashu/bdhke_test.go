@@ -0,0 +1,84 @@
+package cashu
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+func TestHashToCurve(t *testing.T) {
+	// Test vectors from NUT-00
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"0000000000000000000000000000000000000000000000000000000000000000", "024cce997d3b518f739663b757deaec95bcd9473c30a14ac2fd04023a739d1a725"},
+		{"0000000000000000000000000000000000000000000000000000000000000001", "022e7158e11c9506f1aa4248bf531298daa7febd6194f003edcd9b93ade6253acf"},
+		{"0000000000000000000000000000000000000000000000000000000000000002", "026cdbe15362df59cd1dd3c9c11de8aedac2106eca69236ecd9fbe117af897be4f"},
+	}
+	for _, tc := range tests {
+		message, _ := hex.DecodeString(tc.message)
+		point, err := HashToCurve(message)
+		if err != nil {
+			t.Fatalf("HashToCurve(%s) failed: %v", tc.message, err)
+		}
+		if got := hex.EncodeToString(point.SerializeCompressed()); got != tc.want {
+			t.Errorf("HashToCurve(%s) = %s, want %s", tc.message, got, tc.want)
+		}
+	}
+}
+
+func TestBlindSignUnblind(t *testing.T) {
+	mintKey, _ := btcec.NewPrivateKey()
+
+	blinded, r, err := Blind("test_message")
+	if err != nil {
+		t.Fatalf("Blind failed: %v", err)
+	}
+	c := Unblind(SignBlinded(mintKey, blinded), r, mintKey.PubKey())
+
+	if !Verify(mintKey, "test_message", c) {
+		t.Error("expected the unblinded signature to verify")
+	}
+	if Verify(mintKey, "other_message", c) {
+		t.Error("expected the signature not to verify for another secret")
+	}
+}
+
+func TestDecodeToken(t *testing.T) {
+	token := &Token{
+		Mint: "https://mint.example",
+		Unit: "sat",
+		Memo: "thanks",
+		Proofs: []Proof{
+			{Amount: 2, ID: "009a1f293253e41e", Secret: "secret1", C: "02aa"},
+			{Amount: 8, ID: "009a1f293253e41e", Secret: "secret2", C: "02bb"},
+		},
+	}
+	encoded := token.Encode()
+	if !strings.HasPrefix(encoded, "cashuA") {
+		t.Fatalf("unexpected encoding %q", encoded)
+	}
+
+	for _, input := range []string{encoded, "cashu:" + encoded, " " + encoded + "\n", encoded + "=="} {
+		got, err := DecodeToken(input)
+		if err != nil {
+			t.Fatalf("DecodeToken(%q) failed: %v", input, err)
+		}
+		if got.Mint != token.Mint || got.Memo != token.Memo || got.Amount() != 10 || len(got.Proofs) != 2 {
+			t.Errorf("DecodeToken(%q) = %+v", input, got)
+		}
+	}
+
+	if _, err := DecodeToken("cashuBo2F0gaJhaUgA"); !errors.Is(err, ErrUnsupportedToken) {
+		t.Errorf("expected ErrUnsupportedToken for V4 tokens, got %v", err)
+	}
+	for _, input := range []string{"", "lnbc1", "cashuA!!!", "cashuA" + "e30"} {
+		if _, err := DecodeToken(input); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("DecodeToken(%q): expected ErrInvalidToken, got %v", input, err)
+		}
+	}
+}
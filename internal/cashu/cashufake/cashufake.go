@@ -0,0 +1,282 @@
+// Package cashufake provides an in-memory stand-in for a Cashu mint.
+//
+// It implements the endpoints cashu.Wallet uses (GET /v1/keysets, GET
+// /v1/keys/{id}, POST /v1/swap) with real blind signatures and double-spend
+// checks, and can issue tokens directly, so Cashu payments can be exercised
+// end to end in tests without a real mint.
+package cashufake
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+
+	"satoshisend/internal/cashu"
+)
+
+// maxOrder is the number of power-of-two denominations the mint signs
+// (1 sat up to 2^(maxOrder-1) sats).
+const maxOrder = 24
+
+// Error codes from NUT-00 the fake reports.
+const (
+	CodeTokenSpent       = 11001
+	CodeUnbalanced       = 11002
+	CodeInvalidProof     = 10003
+	CodeUnknownKeyset    = 12001
+	CodeOutputsDuplicate = 11004
+)
+
+// Config configures a fake mint. All fields are optional.
+type Config struct {
+	InputFeePPK       int64 // Fee per input in parts per thousand of a sat
+	InvalidSignatures bool  // Answer swaps with signatures that aren't curve points
+}
+
+// Mint is a running fake Cashu mint with a single active sat keyset.
+type Mint struct {
+	URL string
+
+	srv         *httptest.Server
+	keysetID    string
+	privKeys    map[int64]*btcec.PrivateKey
+	inputFeePPK int64
+	invalidSigs bool
+
+	mu    sync.Mutex
+	spent map[string]bool // by secret
+	swaps int
+}
+
+// New starts a fake mint.
+func New(cfg Config) (*Mint, error) {
+	m := &Mint{
+		privKeys:    make(map[int64]*btcec.PrivateKey, maxOrder),
+		inputFeePPK: cfg.InputFeePPK,
+		invalidSigs: cfg.InvalidSignatures,
+		spent:       make(map[string]bool),
+	}
+
+	amounts := make([]int64, 0, maxOrder)
+	for i := 0; i < maxOrder; i++ {
+		key, err := btcec.NewPrivateKey()
+		if err != nil {
+			return nil, err
+		}
+		m.privKeys[1<<i] = key
+		amounts = append(amounts, 1<<i)
+	}
+
+	// Keyset ID per NUT-02: version byte and a hash of the keys sorted by amount
+	h := sha256.New()
+	for _, a := range amounts {
+		h.Write(m.privKeys[a].PubKey().SerializeCompressed())
+	}
+	m.keysetID = "00" + hex.EncodeToString(h.Sum(nil))[:14]
+
+	m.srv = httptest.NewServer(m.routes())
+	m.URL = m.srv.URL
+	return m, nil
+}
+
+// Close shuts the mint down.
+func (m *Mint) Close() {
+	m.srv.Close()
+}
+
+// KeysetID returns the ID of the mint's keyset.
+func (m *Mint) KeysetID() string {
+	return m.keysetID
+}
+
+// Issue returns a token worth the given amount, as if a wallet had minted it.
+func (m *Mint) Issue(amount int64) *cashu.Token {
+	token := &cashu.Token{Mint: m.URL, Unit: "sat"}
+	for bit := int64(1); amount > 0; bit <<= 1 {
+		if amount&bit == 0 {
+			continue
+		}
+		amount &^= bit
+
+		secretBytes := make([]byte, 32)
+		rand.Read(secretBytes)
+		secret := hex.EncodeToString(secretBytes)
+
+		y, _ := cashu.HashToCurve([]byte(secret))
+		token.Proofs = append(token.Proofs, cashu.Proof{
+			Amount: bit,
+			ID:     m.keysetID,
+			Secret: secret,
+			C:      hex.EncodeToString(cashu.SignBlinded(m.privKeys[bit], y).SerializeCompressed()),
+		})
+	}
+	return token
+}
+
+// Spent reports whether a proof's secret has been spent.
+func (m *Mint) Spent(secret string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.spent[secret]
+}
+
+// Swaps returns how many swaps the mint has performed.
+func (m *Mint) Swaps() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.swaps
+}
+
+// Valid reports whether a proof carries a valid signature from the mint,
+// e.g. to check proofs a wallet received from a swap.
+func (m *Mint) Valid(amount int64, secret, c string) bool {
+	key, ok := m.privKeys[amount]
+	if !ok {
+		return false
+	}
+	raw, err := hex.DecodeString(c)
+	if err != nil {
+		return false
+	}
+	point, err := btcec.ParsePubKey(raw)
+	if err != nil {
+		return false
+	}
+	return cashu.Verify(key, secret, point)
+}
+
+func (m *Mint) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/keysets", m.handleKeysets)
+	mux.HandleFunc("GET /v1/keys/{id}", m.handleKeys)
+	mux.HandleFunc("POST /v1/swap", m.handleSwap)
+	return mux
+}
+
+func (m *Mint) handleKeysets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keysets": []map[string]any{{
+			"id":            m.keysetID,
+			"unit":          "sat",
+			"active":        true,
+			"input_fee_ppk": m.inputFeePPK,
+		}},
+	})
+}
+
+func (m *Mint) handleKeys(w http.ResponseWriter, r *http.Request) {
+	if r.PathValue("id") != m.keysetID {
+		writeError(w, CodeUnknownKeyset, "keyset not found")
+		return
+	}
+	keys := make(map[string]string, len(m.privKeys))
+	for amount, key := range m.privKeys {
+		keys[strconv.FormatInt(amount, 10)] = hex.EncodeToString(key.PubKey().SerializeCompressed())
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keysets": []map[string]any{{"id": m.keysetID, "unit": "sat", "keys": keys}},
+	})
+}
+
+func (m *Mint) handleSwap(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Inputs  []cashu.Proof `json:"inputs"`
+		Outputs []struct {
+			Amount int64  `json:"amount"`
+			ID     string `json:"id"`
+			B      string `json:"B_"`
+		} `json:"outputs"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var inputTotal int64
+	seen := make(map[string]bool)
+	for _, p := range req.Inputs {
+		if p.ID != m.keysetID {
+			writeError(w, CodeUnknownKeyset, "keyset not found")
+			return
+		}
+		if m.spent[p.Secret] || seen[p.Secret] {
+			writeError(w, CodeTokenSpent, "Token already spent.")
+			return
+		}
+		if !m.Valid(p.Amount, p.Secret, p.C) {
+			writeError(w, CodeInvalidProof, "Proof could not be verified")
+			return
+		}
+		seen[p.Secret] = true
+		inputTotal += p.Amount
+	}
+
+	var outputTotal int64
+	signatures := make([]map[string]any, 0, len(req.Outputs))
+	seenOutputs := make(map[string]bool)
+	for _, out := range req.Outputs {
+		key, ok := m.privKeys[out.Amount]
+		if out.ID != m.keysetID || !ok {
+			writeError(w, CodeUnknownKeyset, "keyset not found")
+			return
+		}
+		if seenOutputs[out.B] {
+			writeError(w, CodeOutputsDuplicate, "Duplicate outputs provided")
+			return
+		}
+		seenOutputs[out.B] = true
+		raw, err := hex.DecodeString(out.B)
+		if err != nil {
+			writeError(w, CodeInvalidProof, "invalid blinded message")
+			return
+		}
+		blinded, err := btcec.ParsePubKey(raw)
+		if err != nil {
+			writeError(w, CodeInvalidProof, "invalid blinded message")
+			return
+		}
+		outputTotal += out.Amount
+		sig := hex.EncodeToString(cashu.SignBlinded(key, blinded).SerializeCompressed())
+		if m.invalidSigs {
+			sig = "not-a-point"
+		}
+		signatures = append(signatures, map[string]any{
+			"amount": out.Amount,
+			"id":     m.keysetID,
+			"C_":     sig,
+		})
+	}
+
+	fee := (int64(len(req.Inputs))*m.inputFeePPK + 999) / 1000
+	if inputTotal-fee != outputTotal {
+		writeError(w, CodeUnbalanced, fmt.Sprintf("Inputs (%d) - fees (%d) vs outputs (%d) are not balanced.", inputTotal, fee, outputTotal))
+		return
+	}
+
+	for secret := range seen {
+		m.spent[secret] = true
+	}
+	m.swaps++
+	writeJSON(w, http.StatusOK, map[string]any{"signatures": signatures})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, detail string) {
+	writeJSON(w, http.StatusBadRequest, map[string]any{"code": code, "detail": detail})
+}
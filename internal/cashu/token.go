@@ -0,0 +1,108 @@
+package cashu
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrInvalidToken is returned for tokens that can't be decoded or redeemed.
+	ErrInvalidToken = errors.New("invalid cashu token")
+	// ErrUnsupportedToken is returned for token versions other than V3 ("cashuA").
+	ErrUnsupportedToken = errors.New("unsupported cashu token version")
+)
+
+// Proof is a single ecash note: a secret and the mint's signature on it.
+type Proof struct {
+	Amount int64  `json:"amount"`
+	ID     string `json:"id"` // Keyset ID
+	Secret string `json:"secret"`
+	C      string `json:"C"`
+}
+
+// Token is a decoded Cashu token holding proofs from a single mint.
+type Token struct {
+	Mint   string
+	Unit   string // Empty means "sat"
+	Memo   string
+	Proofs []Proof
+}
+
+// Amount returns the total value of the token's proofs.
+func (t *Token) Amount() int64 {
+	var total int64
+	for _, p := range t.Proofs {
+		total += p.Amount
+	}
+	return total
+}
+
+// tokenV3 is the JSON serialization of a V3 token.
+type tokenV3 struct {
+	Token []tokenV3Entry `json:"token"`
+	Unit  string         `json:"unit,omitempty"`
+	Memo  string         `json:"memo,omitempty"`
+}
+
+type tokenV3Entry struct {
+	Mint   string  `json:"mint"`
+	Proofs []Proof `json:"proofs"`
+}
+
+// DecodeToken parses a serialized V3 token ("cashuA..."), optionally with
+// a "cashu:" URI prefix. Tokens spanning several mints are rejected.
+func DecodeToken(s string) (*Token, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "cashu:")
+	if strings.HasPrefix(s, "cashuB") {
+		return nil, ErrUnsupportedToken
+	}
+	payload, ok := strings.CutPrefix(s, "cashuA")
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	// Wallets disagree on padding and alphabet, so accept either
+	payload = strings.NewReplacer("+", "-", "/", "_").Replace(strings.TrimRight(payload, "="))
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	var v3 tokenV3
+	if err := json.Unmarshal(raw, &v3); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if len(v3.Token) != 1 {
+		return nil, fmt.Errorf("%w: token must contain proofs from exactly one mint", ErrInvalidToken)
+	}
+
+	token := &Token{
+		Mint:   v3.Token[0].Mint,
+		Unit:   v3.Unit,
+		Memo:   v3.Memo,
+		Proofs: v3.Token[0].Proofs,
+	}
+	if len(token.Proofs) == 0 {
+		return nil, fmt.Errorf("%w: token has no proofs", ErrInvalidToken)
+	}
+	for _, p := range token.Proofs {
+		if p.Amount <= 0 || p.Secret == "" || p.C == "" || p.ID == "" {
+			return nil, fmt.Errorf("%w: malformed proof", ErrInvalidToken)
+		}
+	}
+	return token, nil
+}
+
+// Encode serializes the token in the V3 format.
+func (t *Token) Encode() string {
+	raw, _ := json.Marshal(tokenV3{
+		Token: []tokenV3Entry{{Mint: t.Mint, Proofs: t.Proofs}},
+		Unit:  t.Unit,
+		Memo:  t.Memo,
+	})
+	return "cashuA" + base64.RawURLEncoding.EncodeToString(raw)
+}
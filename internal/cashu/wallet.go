@@ -0,0 +1,392 @@
+// Package cashu implements the parts of the Cashu ecash protocol
+// (https://github.com/cashubtc/nuts) needed to accept tokens as payment:
+// V3 token decoding (NUT-00), blind signatures (NUT-00), keysets and fees
+// (NUT-01, NUT-02) and swapping proofs at the mint (NUT-03).
+package cashu
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/store"
+)
+
+var (
+	// ErrWrongMint is returned for tokens issued by a mint other than the
+	// wallet's.
+	ErrWrongMint = errors.New("token is from an untrusted mint")
+	// ErrInsufficientAmount is returned when a token is worth less than
+	// required after mint fees. The token is left unspent.
+	ErrInsufficientAmount = errors.New("token amount too low")
+)
+
+// MintError is an error reported by the mint, e.g. code 11001 for proofs
+// that were already spent.
+type MintError struct {
+	Code   int    `json:"code"`
+	Detail string `json:"detail"`
+}
+
+func (e *MintError) Error() string {
+	return fmt.Sprintf("mint error %d: %s", e.Code, e.Detail)
+}
+
+// Wallet receives ecash from a single trusted mint. Received tokens are
+// swapped for fresh proofs, so the sender can no longer spend them, and the
+// new proofs are kept in the store.
+type Wallet struct {
+	mintURL    string
+	httpClient *http.Client
+	proofs     store.CashuProofStore
+	fallback   string // file for proofs the store fails to save; optional
+
+	mu   sync.Mutex
+	keys map[string]map[int64]*btcec.PublicKey // amount keys by keyset ID
+}
+
+// NewWallet creates a wallet for the mint at mintURL.
+func NewWallet(mintURL string, proofs store.CashuProofStore) *Wallet {
+	return &Wallet{
+		mintURL:    strings.TrimRight(mintURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		proofs:     proofs,
+		keys:       make(map[string]map[int64]*btcec.PublicKey),
+	}
+}
+
+// SetFallbackFile sets a file that received proofs are appended to, readable
+// only by the server's user, when the store fails to save them. Without one
+// such a failure makes Receive return an error.
+func (w *Wallet) SetFallbackFile(path string) {
+	w.fallback = path
+}
+
+// MintURL returns the mint the wallet accepts tokens from.
+func (w *Wallet) MintURL() string {
+	return w.mintURL
+}
+
+// keyset is an entry of GET /v1/keysets.
+type keyset struct {
+	ID          string `json:"id"`
+	Unit        string `json:"unit"`
+	Active      bool   `json:"active"`
+	InputFeePPK int64  `json:"input_fee_ppk"`
+}
+
+type blindedMessage struct {
+	Amount int64  `json:"amount"`
+	ID     string `json:"id"`
+	B      string `json:"B_"`
+}
+
+type blindSignature struct {
+	Amount int64  `json:"amount"`
+	ID     string `json:"id"`
+	C      string `json:"C_"`
+}
+
+type swapRequest struct {
+	Inputs  []Proof          `json:"inputs"`
+	Outputs []blindedMessage `json:"outputs"`
+}
+
+type swapResponse struct {
+	Signatures []blindSignature `json:"signatures"`
+}
+
+// Receive redeems a serialized token worth at least minSats after the
+// mint's input fees, and returns the amount received. The token's proofs
+// are swapped at the mint, which fails if they were already spent.
+func (w *Wallet) Receive(ctx context.Context, serialized string, minSats int64) (int64, error) {
+	token, err := DecodeToken(serialized)
+	if err != nil {
+		return 0, err
+	}
+	if strings.TrimRight(token.Mint, "/") != w.mintURL {
+		return 0, ErrWrongMint
+	}
+	if token.Unit != "" && token.Unit != "sat" {
+		return 0, fmt.Errorf("%w: unit %q is not supported", ErrInvalidToken, token.Unit)
+	}
+
+	keysets, err := w.keysets(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var feePPK int64
+	for _, p := range token.Proofs {
+		ks, ok := keysets[p.ID]
+		if !ok || ks.Unit != "sat" {
+			return 0, fmt.Errorf("%w: unknown keyset %s", ErrInvalidToken, p.ID)
+		}
+		feePPK += ks.InputFeePPK
+	}
+	amount := token.Amount() - (feePPK+999)/1000
+	if amount <= 0 || amount < minSats {
+		return 0, fmt.Errorf("%w: worth %d sats after fees, need %d", ErrInsufficientAmount, amount, minSats)
+	}
+
+	var active *keyset
+	for _, ks := range keysets {
+		if ks.Active && ks.Unit == "sat" {
+			active = ks
+			break
+		}
+	}
+	if active == nil {
+		return 0, errors.New("mint has no active sat keyset")
+	}
+	keys, err := w.keysetKeys(ctx, active.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Blind fresh secrets for the received amount, one output per power of two
+	var outputs []blindedMessage
+	var secrets []string
+	var factors []*btcec.PrivateKey
+	for _, a := range splitAmount(amount) {
+		if keys[a] == nil {
+			return 0, fmt.Errorf("mint has no key for amount %d", a)
+		}
+		secret, err := newSecret()
+		if err != nil {
+			return 0, err
+		}
+		blinded, r, err := Blind(secret)
+		if err != nil {
+			return 0, err
+		}
+		outputs = append(outputs, blindedMessage{
+			Amount: a,
+			ID:     active.ID,
+			B:      hex.EncodeToString(blinded.SerializeCompressed()),
+		})
+		secrets = append(secrets, secret)
+		factors = append(factors, r)
+	}
+
+	// Once the mint accepts the swap the sender's proofs are spent, so a
+	// client hanging up must not abandon the swap or the saving of its
+	// outputs. The HTTP client's timeout still bounds the call.
+	ctx = context.WithoutCancel(ctx)
+
+	var swapResp swapResponse
+	if err := w.call(ctx, "POST", "/v1/swap", swapRequest{Inputs: token.Proofs, Outputs: outputs}, &swapResp); err != nil {
+		return 0, err
+	}
+	if len(swapResp.Signatures) != len(outputs) {
+		return 0, fmt.Errorf("mint returned %d signatures for %d outputs", len(swapResp.Signatures), len(outputs))
+	}
+
+	// The sender's proofs are spent now, so from here on the ecash is ours
+	// and must not be lost
+	now := time.Now()
+	received := make([]*store.CashuProof, 0, len(outputs))
+	var invalid int64
+	for i, sig := range swapResp.Signatures {
+		blindSig, err := parsePoint(sig.C)
+		if err != nil {
+			invalid += outputs[i].Amount
+			continue
+		}
+		received = append(received, &store.CashuProof{
+			Secret:     secrets[i],
+			Mint:       w.mintURL,
+			KeysetID:   outputs[i].ID,
+			AmountSats: outputs[i].Amount,
+			C:          hex.EncodeToString(Unblind(blindSig, factors[i], keys[outputs[i].Amount]).SerializeCompressed()),
+			CreatedAt:  now,
+		})
+	}
+	if err := w.keep(ctx, received); err != nil {
+		return 0, err
+	}
+	if invalid > 0 {
+		logging.Cashu.Printf("CRITICAL: mint returned invalid signatures for %d of %d sats; the token is spent", invalid, amount)
+		return 0, fmt.Errorf("mint returned invalid signatures for %d sats", invalid)
+	}
+
+	logging.Cashu.Printf("received %d sats (%d proofs in, %d fee)", amount, len(token.Proofs), token.Amount()-amount)
+	return amount, nil
+}
+
+// keep saves received proofs to the store, or to the fallback file if the
+// store fails. The proofs are bearer money, so they are never logged.
+func (w *Wallet) keep(ctx context.Context, proofs []*store.CashuProof) error {
+	if len(proofs) == 0 {
+		return nil
+	}
+	err := w.proofs.SaveCashuProofs(ctx, proofs)
+	if err == nil {
+		return nil
+	}
+	var total int64
+	for _, p := range proofs {
+		total += p.AmountSats
+	}
+	if w.fallback == "" {
+		logging.Cashu.Printf("CRITICAL: failed to save %d sats of received ecash, which is lost: %v", total, err)
+		return fmt.Errorf("failed to save received ecash: %w", err)
+	}
+	if ferr := appendProofs(w.fallback, proofs); ferr != nil {
+		logging.Cashu.Printf("CRITICAL: failed to save %d sats of received ecash, which is lost: %v; fallback file: %v", total, err, ferr)
+		return fmt.Errorf("failed to save received ecash: %w", err)
+	}
+	logging.Cashu.Printf("CRITICAL: failed to save %d sats of received ecash: %v; wrote the proofs to %s instead", total, err, w.fallback)
+	return nil
+}
+
+// appendProofs appends proofs to path as JSON lines, creating the file
+// readable only by its owner.
+func appendProofs(path string, proofs []*store.CashuProof) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, p := range proofs {
+		if err := enc.Encode(p); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// keysets returns the mint's keysets by ID.
+func (w *Wallet) keysets(ctx context.Context) (map[string]*keyset, error) {
+	var resp struct {
+		Keysets []*keyset `json:"keysets"`
+	}
+	if err := w.call(ctx, "GET", "/v1/keysets", nil, &resp); err != nil {
+		return nil, err
+	}
+	keysets := make(map[string]*keyset, len(resp.Keysets))
+	for _, ks := range resp.Keysets {
+		keysets[ks.ID] = ks
+	}
+	return keysets, nil
+}
+
+// keysetKeys returns a keyset's public keys by amount. A keyset ID commits
+// to its keys, so they are fetched once and cached.
+func (w *Wallet) keysetKeys(ctx context.Context, id string) (map[int64]*btcec.PublicKey, error) {
+	w.mu.Lock()
+	keys, ok := w.keys[id]
+	w.mu.Unlock()
+	if ok {
+		return keys, nil
+	}
+
+	var resp struct {
+		Keysets []struct {
+			ID   string            `json:"id"`
+			Keys map[string]string `json:"keys"`
+		} `json:"keysets"`
+	}
+	if err := w.call(ctx, "GET", "/v1/keys/"+id, nil, &resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Keysets) != 1 || resp.Keysets[0].ID != id {
+		return nil, fmt.Errorf("mint returned no keys for keyset %s", id)
+	}
+
+	keys = make(map[int64]*btcec.PublicKey, len(resp.Keysets[0].Keys))
+	for amountStr, keyHex := range resp.Keysets[0].Keys {
+		amount, err := strconv.ParseInt(amountStr, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid amount %q in keyset %s", amountStr, id)
+		}
+		key, err := parsePoint(keyHex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for amount %d in keyset %s: %w", amount, id, err)
+		}
+		keys[amount] = key
+	}
+
+	w.mu.Lock()
+	w.keys[id] = keys
+	w.mu.Unlock()
+	return keys, nil
+}
+
+// call makes a request to the mint and decodes the JSON response into out.
+func (w *Wallet) call(ctx context.Context, method, path string, body any, out any) error {
+	var reqBody io.Reader
+	if body != nil {
+		jsonBody, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reqBody = bytes.NewReader(jsonBody)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, w.mintURL+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("mint request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		var mintErr MintError
+		if json.Unmarshal(respBody, &mintErr) == nil && mintErr.Detail != "" {
+			return &mintErr
+		}
+		return fmt.Errorf("mint returned status %d: %s", resp.StatusCode, string(respBody))
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode mint response: %w", err)
+	}
+	return nil
+}
+
+// splitAmount decomposes an amount into powers of two, smallest first.
+func splitAmount(amount int64) []int64 {
+	var parts []int64
+	for bit := int64(1); amount > 0; bit <<= 1 {
+		if amount&bit != 0 {
+			parts = append(parts, bit)
+			amount &^= bit
+		}
+	}
+	return parts
+}
+
+func newSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,183 @@
+package cashu_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"satoshisend/internal/cashu"
+	"satoshisend/internal/cashu/cashufake"
+	"satoshisend/internal/store"
+)
+
+// memProofs implements store.CashuProofStore for testing.
+type memProofs struct {
+	mu     sync.Mutex
+	proofs []*store.CashuProof
+	err    error // returned by SaveCashuProofs if set
+}
+
+func (m *memProofs) SaveCashuProofs(ctx context.Context, proofs []*store.CashuProof) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.proofs = append(m.proofs, proofs...)
+	return nil
+}
+
+func (m *memProofs) ListCashuProofs(ctx context.Context) ([]*store.CashuProof, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*store.CashuProof(nil), m.proofs...), nil
+}
+
+func TestWallet_Receive(t *testing.T) {
+	mint, err := cashufake.New(cashufake.Config{InputFeePPK: 100})
+	if err != nil {
+		t.Fatalf("failed to start fake mint: %v", err)
+	}
+	defer mint.Close()
+
+	proofs := &memProofs{}
+	wallet := cashu.NewWallet(mint.URL+"/", proofs)
+	ctx := context.Background()
+
+	// 300 sats as 4 proofs (256+32+8+4), so the fee is ceil(4*100/1000) = 1 sat
+	token := mint.Issue(300)
+
+	t.Run("too little after fees", func(t *testing.T) {
+		_, err := wallet.Receive(ctx, token.Encode(), 300)
+		if !errors.Is(err, cashu.ErrInsufficientAmount) {
+			t.Fatalf("expected ErrInsufficientAmount, got %v", err)
+		}
+		if mint.Swaps() != 0 {
+			t.Error("expected the token to be left unspent")
+		}
+	})
+
+	t.Run("receives", func(t *testing.T) {
+		amount, err := wallet.Receive(ctx, token.Encode(), 299)
+		if err != nil {
+			t.Fatalf("Receive failed: %v", err)
+		}
+		if amount != 299 {
+			t.Errorf("expected 299 sats after fees, got %d", amount)
+		}
+		for _, p := range token.Proofs {
+			if !mint.Spent(p.Secret) {
+				t.Errorf("expected input proof %s to be spent", p.Secret[:8])
+			}
+		}
+
+		saved, _ := proofs.ListCashuProofs(ctx)
+		var total int64
+		for _, p := range saved {
+			if !mint.Valid(p.AmountSats, p.Secret, p.C) {
+				t.Errorf("received proof for %d sats is not valid", p.AmountSats)
+			}
+			if p.KeysetID != mint.KeysetID() || p.Mint != mint.URL {
+				t.Errorf("unexpected proof %+v", p)
+			}
+			total += p.AmountSats
+		}
+		if total != 299 {
+			t.Errorf("expected 299 sats of proofs saved, got %d", total)
+		}
+	})
+
+	t.Run("double spend", func(t *testing.T) {
+		_, err := wallet.Receive(ctx, token.Encode(), 1)
+		var mintErr *cashu.MintError
+		if !errors.As(err, &mintErr) || mintErr.Code != cashufake.CodeTokenSpent {
+			t.Fatalf("expected a token spent error, got %v", err)
+		}
+	})
+
+	t.Run("wrong mint", func(t *testing.T) {
+		other := mint.Issue(100)
+		other.Mint = "https://other.mint.example"
+		if _, err := wallet.Receive(ctx, other.Encode(), 1); !errors.Is(err, cashu.ErrWrongMint) {
+			t.Fatalf("expected ErrWrongMint, got %v", err)
+		}
+	})
+
+	t.Run("forged proof", func(t *testing.T) {
+		forged := mint.Issue(64)
+		forged.Proofs[0].Secret = "not-the-signed-secret"
+		var mintErr *cashu.MintError
+		if _, err := wallet.Receive(ctx, forged.Encode(), 1); !errors.As(err, &mintErr) {
+			t.Fatalf("expected the mint to reject the proof, got %v", err)
+		}
+	})
+}
+
+func TestWallet_ReceiveSaveFailure(t *testing.T) {
+	mint, err := cashufake.New(cashufake.Config{})
+	if err != nil {
+		t.Fatalf("failed to start fake mint: %v", err)
+	}
+	defer mint.Close()
+	ctx := context.Background()
+	proofs := &memProofs{err: errors.New("disk full")}
+
+	t.Run("without a fallback file", func(t *testing.T) {
+		wallet := cashu.NewWallet(mint.URL, proofs)
+		if _, err := wallet.Receive(ctx, mint.Issue(100).Encode(), 100); err == nil {
+			t.Fatal("expected Receive to fail when the proofs can't be saved")
+		}
+	})
+
+	t.Run("with a fallback file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "unsaved.jsonl")
+		wallet := cashu.NewWallet(mint.URL, proofs)
+		wallet.SetFallbackFile(path)
+
+		amount, err := wallet.Receive(ctx, mint.Issue(100).Encode(), 100)
+		if err != nil || amount != 100 {
+			t.Fatalf("expected 100 sats received, got %d, %v", amount, err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("expected the fallback file to be written: %v", err)
+		}
+		if perm := info.Mode().Perm(); perm != 0o600 {
+			t.Errorf("expected mode 0600, got %o", perm)
+		}
+
+		data, _ := os.ReadFile(path)
+		var total int64
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var p store.CashuProof
+			if err := json.Unmarshal([]byte(line), &p); err != nil {
+				t.Fatalf("invalid fallback line %q: %v", line, err)
+			}
+			if !mint.Valid(p.AmountSats, p.Secret, p.C) {
+				t.Errorf("saved proof for %d sats is not valid", p.AmountSats)
+			}
+			total += p.AmountSats
+		}
+		if total != 100 {
+			t.Errorf("expected 100 sats of proofs in the fallback file, got %d", total)
+		}
+	})
+}
+
+func TestWallet_ReceiveInvalidSignatures(t *testing.T) {
+	mint, err := cashufake.New(cashufake.Config{InvalidSignatures: true})
+	if err != nil {
+		t.Fatalf("failed to start fake mint: %v", err)
+	}
+	defer mint.Close()
+
+	wallet := cashu.NewWallet(mint.URL, &memProofs{})
+	if _, err := wallet.Receive(context.Background(), mint.Issue(100).Encode(), 100); err == nil {
+		t.Fatal("expected an error for signatures that can't be unblinded")
+	}
+}
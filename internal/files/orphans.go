@@ -0,0 +1,209 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/store"
+)
+
+// DefaultOrphanGrace is how old a blob without metadata must be before the
+// sweeper deletes it. It leaves clients ample time to finish an upload and
+// call CompleteUpload.
+const DefaultOrphanGrace = 24 * time.Hour
+
+// OrphanReport summarizes a sweep for orphaned blobs.
+type OrphanReport struct {
+	Scanned int        // Blobs looked at
+	Orphans []BlobInfo // Blobs without metadata older than the grace period
+	Bytes   int64      // Total size of the orphans
+	Deleted int        // Orphans removed; zero for a dry run
+
+	Uploads []MultipartUpload // Stale unfinished multipart uploads
+	Aborted int               // Stale uploads aborted; zero for a dry run
+}
+
+// SweepOrphans finds blobs in storage that have no metadata and are older
+// than grace, such as uploads that were never completed, and deletes them
+// unless dryRun is set. Data of resumable uploads still in progress is kept,
+// and so is anything whose name isn't a file or chunk ID, in case the
+// storage is shared. Multipart uploads that were never completed are aborted
+// once older than grace, or PresignExpiry if that is longer, since until then
+// a client may still be sending parts.
+func (s *Service) SweepOrphans(ctx context.Context, grace time.Duration, dryRun bool) (*OrphanReport, error) {
+	lister, ok := s.storage.(Lister)
+	if !ok {
+		return nil, errors.New("storage backend does not support listing")
+	}
+
+	report := &OrphanReport{}
+	cutoff := time.Now().Add(-grace)
+	err := lister.List(ctx, func(blob BlobInfo) error {
+		report.Scanned++
+		if blob.ModTime.After(cutoff) {
+			return nil
+		}
+		orphan, err := s.isOrphan(ctx, blob.ID)
+		if err != nil {
+			// Keep the blob; the next sweep checks it again
+			logging.Internal.Printf("failed to check blob %s for metadata: %v", blob.ID, err)
+			return nil
+		}
+		if !orphan {
+			return nil
+		}
+
+		report.Orphans = append(report.Orphans, blob)
+		report.Bytes += blob.Size
+		if dryRun {
+			return nil
+		}
+		if err := s.storage.Delete(ctx, blob.ID); err != nil && err != ErrNotFound {
+			logging.Internal.Printf("failed to delete orphaned blob %s: %v", blob.ID, err)
+			return nil
+		}
+		report.Deleted++
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if multipart, ok := s.storage.(MultipartLister); ok {
+		err = s.abortStaleUploads(ctx, multipart, max(grace, PresignExpiry), dryRun, report)
+	}
+	return report, err
+}
+
+// abortStaleUploads aborts the multipart uploads of file and chunk IDs that
+// were started longer than grace ago, adding them to report.
+func (s *Service) abortStaleUploads(ctx context.Context, multipart MultipartLister, grace time.Duration, dryRun bool, report *OrphanReport) error {
+	cutoff := time.Now().Add(-grace)
+	return multipart.ListMultipartUploads(ctx, func(upload MultipartUpload) error {
+		if upload.Initiated.After(cutoff) {
+			return nil
+		}
+		if fileID, _ := parseChunkID(upload.ID); !isFileID(fileID) {
+			return nil
+		}
+
+		report.Uploads = append(report.Uploads, upload)
+		if dryRun {
+			return nil
+		}
+		if err := multipart.AbortMultipartUpload(ctx, upload.ID, upload.UploadID); err != nil {
+			logging.Internal.Printf("failed to abort stale multipart upload of %s: %v", upload.ID, err)
+			return nil
+		}
+		report.Aborted++
+		return nil
+	})
+}
+
+// isOrphan reports whether a blob belongs to no file or resumable upload.
+func (s *Service) isOrphan(ctx context.Context, id string) (bool, error) {
+	fileID, isChunk := parseChunkID(id)
+	if !isFileID(fileID) {
+		return false, nil
+	}
+
+	if s.partials != nil {
+		_, err := s.partials.store.GetPartialUpload(ctx, fileID)
+		if err == nil {
+			return false, nil
+		}
+		if !errors.Is(err, store.ErrNotFound) {
+			return false, err
+		}
+	}
+	if isChunk {
+		// Chunks outlive their upload only if deleting them failed
+		return true, nil
+	}
+
+	_, err := s.store.GetFileMetadata(ctx, fileID)
+	if errors.Is(err, store.ErrNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+// parseChunkID returns the file ID a resumable upload chunk belongs to (see
+// chunkID), or id itself if it isn't a chunk.
+func parseChunkID(id string) (fileID string, isChunk bool) {
+	i := strings.Index(id, "part")
+	if i < 0 {
+		return id, false
+	}
+	if _, err := strconv.Atoi(id[i+len("part"):]); err != nil {
+		return id, false
+	}
+	return id[:i], true
+}
+
+// isFileID reports whether id has the form of a generated file ID.
+func isFileID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// StartOrphanSweeper sweeps for orphaned blobs immediately and then every
+// interval until ctx is cancelled, logging what it finds.
+func (s *Service) StartOrphanSweeper(ctx context.Context, interval, grace time.Duration, dryRun bool) {
+	sweep := func() {
+		report, err := s.SweepOrphans(ctx, grace, dryRun)
+		if err != nil && ctx.Err() == nil {
+			logging.Internal.Printf("orphan sweep error: %v", err)
+		}
+		if report == nil {
+			return
+		}
+		if len(report.Uploads) > 0 {
+			if dryRun {
+				for _, upload := range report.Uploads {
+					logging.Internal.Printf("stale multipart upload of %s, started %s", upload.ID, upload.Initiated.Format(time.RFC3339))
+				}
+				logging.Internal.Printf("dry run: would abort %d stale multipart uploads", len(report.Uploads))
+			} else {
+				logging.Internal.Printf("aborted %d of %d stale multipart uploads", report.Aborted, len(report.Uploads))
+			}
+		}
+		if len(report.Orphans) == 0 {
+			return
+		}
+		if dryRun {
+			for _, blob := range report.Orphans {
+				logging.Internal.Printf("orphaned blob %s: %d bytes, last modified %s", blob.ID, blob.Size, blob.ModTime.Format(time.RFC3339))
+			}
+			logging.Internal.Printf("dry run: would delete %d orphaned blobs (%d bytes) of %d scanned", len(report.Orphans), report.Bytes, report.Scanned)
+			return
+		}
+		logging.Internal.Printf("deleted %d of %d orphaned blobs (%d bytes) of %d scanned", report.Deleted, len(report.Orphans), report.Bytes, report.Scanned)
+	}
+
+	go func() {
+		sweep()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				sweep()
+			}
+		}
+	}()
+}
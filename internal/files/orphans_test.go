@@ -0,0 +1,159 @@
+package files
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+
+	"satoshisend/internal/store"
+)
+
+func TestService_SweepOrphans(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFSStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFSStorage failed: %v", err)
+	}
+	st := newMockStore()
+	svc := NewService(storage, st)
+	svc.SetPartialUploads(newPartialStore(t))
+	ctx := context.Background()
+
+	partial, err := svc.CreatePartialUpload(ctx, 100, "")
+	if err != nil {
+		t.Fatalf("CreatePartialUpload failed: %v", err)
+	}
+
+	completed := strings.Repeat("a", 32)
+	orphan := strings.Repeat("b", 32)
+	fresh := strings.Repeat("c", 32)
+	staleChunk := chunkID(strings.Repeat("d", 32), 1)
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: completed})
+
+	old := time.Now().Add(-2 * DefaultOrphanGrace)
+	blobs := map[string]bool{ // ID: whether it is an orphan
+		completed:              false,
+		orphan:                 true,
+		fresh:                  false,
+		partial.ID:             false,
+		chunkID(partial.ID, 0): false,
+		staleChunk:             true,
+		"notes":                false, // Not a file ID
+	}
+	for id := range blobs {
+		if _, err := storage.Save(ctx, id, strings.NewReader("data")); err != nil {
+			t.Fatalf("failed to save %s: %v", id, err)
+		}
+		if id != fresh {
+			os.Chtimes(filepath.Join(dir, id), old, old)
+		}
+	}
+
+	exists := func(id string) bool {
+		_, err := os.Stat(filepath.Join(dir, id))
+		return err == nil
+	}
+	checkReport := func(report *OrphanReport, deleted int) {
+		t.Helper()
+		if report.Scanned != len(blobs) {
+			t.Errorf("scanned %d blobs, want %d", report.Scanned, len(blobs))
+		}
+		found := make(map[string]bool)
+		for _, blob := range report.Orphans {
+			found[blob.ID] = true
+		}
+		for id, isOrphan := range blobs {
+			if found[id] != isOrphan {
+				t.Errorf("%s reported as orphan: %t, want %t", id, found[id], isOrphan)
+			}
+		}
+		if report.Bytes != 8 || report.Deleted != deleted {
+			t.Errorf("report has %d bytes, %d deleted; want 8 bytes, %d deleted", report.Bytes, report.Deleted, deleted)
+		}
+	}
+
+	report, err := svc.SweepOrphans(ctx, DefaultOrphanGrace, true)
+	if err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	checkReport(report, 0)
+	for id := range blobs {
+		if !exists(id) {
+			t.Errorf("dry run deleted %s", id)
+		}
+	}
+
+	report, err = svc.SweepOrphans(ctx, DefaultOrphanGrace, false)
+	if err != nil {
+		t.Fatalf("sweep failed: %v", err)
+	}
+	checkReport(report, 2)
+	for id, isOrphan := range blobs {
+		if exists(id) == isOrphan {
+			t.Errorf("%s exists: %t after the sweep", id, !isOrphan)
+		}
+	}
+}
+
+func TestService_SweepOrphansAbortsStaleUploads(t *testing.T) {
+	stale := strings.Repeat("a", 32)
+	started := strings.Repeat("b", 32)
+	mock := &mockS3Client{
+		uploads: []minio.ObjectMultipartInfo{
+			{Key: "uploads/" + stale, UploadID: "stale-upload", Initiated: time.Now().Add(-2 * PresignExpiry)},
+			// Within PresignExpiry, even though past the grace period
+			{Key: "uploads/" + started, UploadID: "started-upload", Initiated: time.Now().Add(-2 * time.Hour)},
+			{Key: "uploads/notes", UploadID: "other-upload", Initiated: time.Now().Add(-2 * PresignExpiry)},
+		},
+	}
+	svc := NewService(NewS3StorageWithClient(mock, "bucket", "uploads", ""), newMockStore())
+	ctx := context.Background()
+
+	report, err := svc.SweepOrphans(ctx, time.Hour, true)
+	if err != nil {
+		t.Fatalf("SweepOrphans failed: %v", err)
+	}
+	if len(report.Uploads) != 1 || report.Uploads[0].ID != stale || report.Aborted != 0 {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+	if len(mock.abortCalls) != 0 {
+		t.Fatalf("dry run aborted %v", mock.abortCalls)
+	}
+
+	report, err = svc.SweepOrphans(ctx, time.Hour, false)
+	if err != nil {
+		t.Fatalf("SweepOrphans failed: %v", err)
+	}
+	if report.Aborted != 1 {
+		t.Errorf("expected 1 aborted upload, got %d", report.Aborted)
+	}
+	if len(mock.abortCalls) != 1 || mock.abortCalls[0] != "stale-upload" {
+		t.Errorf("expected only the stale upload to be aborted, got %v", mock.abortCalls)
+	}
+}
+
+func TestParseChunkID(t *testing.T) {
+	id := strings.Repeat("a", 32)
+	tests := []struct {
+		in      string
+		fileID  string
+		isChunk bool
+	}{
+		{id, id, false},
+		{chunkID(id, 0), id, true},
+		{chunkID(id, 12), id, true},
+		{id + "part", id + "part", false},
+		{id + "partx", id + "partx", false},
+	}
+	for _, tc := range tests {
+		fileID, isChunk := parseChunkID(tc.in)
+		if fileID != tc.fileID || isChunk != tc.isChunk {
+			t.Errorf("parseChunkID(%q) = %q, %t; want %q, %t", tc.in, fileID, isChunk, tc.fileID, tc.isChunk)
+		}
+	}
+}
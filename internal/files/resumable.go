@@ -0,0 +1,344 @@
+package files
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/store"
+)
+
+// ResumableUploadTTL is how long an unfinished resumable upload can sit
+// without receiving data before it is discarded.
+const ResumableUploadTTL = 24 * time.Hour
+
+var (
+	ErrResumableDisabled = errors.New("resumable uploads are not enabled")
+	ErrOffsetMismatch    = errors.New("upload offset does not match")
+	ErrUploadBusy        = errors.New("upload is locked by another request")
+	ErrUploadUnfinished  = errors.New("upload has not finished")
+)
+
+// resumable tracks which partial uploads are being written, so two requests
+// can't append to the same upload at once.
+type resumable struct {
+	store store.PartialUploadStore
+
+	mu      sync.Mutex
+	writing map[string]bool
+}
+
+// SetPartialUploads enables resumable uploads, persisting their progress in
+// ps.
+func (s *Service) SetPartialUploads(ps store.PartialUploadStore) {
+	s.partials = &resumable{store: ps, writing: make(map[string]bool)}
+}
+
+// ResumableUploads reports whether resumable uploads are enabled.
+func (s *Service) ResumableUploads() bool {
+	return s.partials != nil
+}
+
+// CreatePartialUpload starts a resumable upload of length bytes. metadata is
+// stored as-is for the client.
+func (s *Service) CreatePartialUpload(ctx context.Context, length int64, metadata string) (*store.PartialUpload, error) {
+	if s.partials == nil {
+		return nil, ErrResumableDisabled
+	}
+	id, err := generateID()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	u := &store.PartialUpload{
+		ID:        id,
+		Length:    length,
+		Metadata:  metadata,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ResumableUploadTTL),
+	}
+	if err := s.partials.store.SavePartialUpload(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetPartialUpload returns a resumable upload's progress. It returns
+// store.ErrNotFound for unknown uploads and ErrExpired for ones that can no
+// longer be resumed.
+func (s *Service) GetPartialUpload(ctx context.Context, id string) (*store.PartialUpload, error) {
+	if s.partials == nil {
+		return nil, ErrResumableDisabled
+	}
+	u, err := s.partials.store.GetPartialUpload(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if time.Now().After(u.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	if err := s.syncOffset(ctx, u); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// syncOffset corrects the recorded offset of an upload written in place from
+// the size of its file, in case the server stopped between appending data
+// and recording it.
+func (s *Service) syncOffset(ctx context.Context, u *store.PartialUpload) error {
+	if _, ok := s.storage.(Appender); !ok {
+		return nil
+	}
+	statProvider, ok := s.storage.(StatProvider)
+	if !ok {
+		return nil
+	}
+	size, err := statProvider.Stat(ctx, u.ID)
+	if err == ErrNotFound {
+		size = 0
+	} else if err != nil {
+		return err
+	}
+	if size == u.Offset {
+		return nil
+	}
+	logging.Internal.Printf("upload %s has %d bytes stored but %d recorded; resuming from %d", u.ID, size, u.Offset, size)
+	u.Offset = size
+	return s.partials.store.SavePartialUpload(ctx, u)
+}
+
+// lock marks an upload as being written, or returns ErrUploadBusy.
+func (r *resumable) lock(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.writing[id] {
+		return ErrUploadBusy
+	}
+	r.writing[id] = true
+	return nil
+}
+
+func (r *resumable) unlock(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.writing, id)
+}
+
+// chunkID names the n-th chunk of a resumable upload on storage that can't
+// append. File IDs are hex, so chunk IDs never collide with them.
+func chunkID(id string, n int) string {
+	return fmt.Sprintf("%spart%d", id, n)
+}
+
+// AppendPartialUpload adds data to a resumable upload, which must currently
+// have received exactly offset bytes. size is the length of data, or -1 if
+// unknown. It returns the upload's new offset, which may have advanced even
+// if an error is returned. Once all bytes have arrived the file is in
+// storage under the upload's ID, ready for CompleteUpload.
+func (s *Service) AppendPartialUpload(ctx context.Context, id string, offset int64, data io.Reader, size int64) (int64, error) {
+	if s.partials == nil {
+		return 0, ErrResumableDisabled
+	}
+	if err := s.partials.lock(id); err != nil {
+		return 0, err
+	}
+	defer s.partials.unlock(id)
+
+	u, err := s.GetPartialUpload(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if offset != u.Offset {
+		return u.Offset, ErrOffsetMismatch
+	}
+	remaining := u.Length - u.Offset
+	if size > remaining {
+		return u.Offset, fmt.Errorf("chunk of %d bytes exceeds the %d bytes remaining", size, remaining)
+	}
+	data = io.LimitReader(data, remaining)
+
+	if appender, ok := s.storage.(Appender); ok {
+		n, err := appender.Append(ctx, id, data)
+		if n > 0 {
+			u.Offset += n
+			u.ExpiresAt = time.Now().Add(ResumableUploadTTL)
+			if saveErr := s.partials.store.SavePartialUpload(ctx, u); saveErr != nil && err == nil {
+				// The next request resyncs the offset from the file size
+				err = saveErr
+			}
+		}
+		return u.Offset, err
+	}
+
+	// Storage without append gets each request's data as a separate chunk,
+	// joined into the final file once the last one arrives
+	chunk := chunkID(id, u.Chunks)
+	n, err := s.storage.SaveWithProgress(ctx, chunk, data, size, nil)
+	if err != nil || n == 0 {
+		s.storage.Delete(ctx, chunk)
+		return u.Offset, err
+	}
+	if u.Offset+n == u.Length {
+		if err := s.joinChunks(ctx, id, u.Chunks+1, u.Length); err != nil {
+			// Have the client send the last chunk again
+			s.storage.Delete(ctx, chunk)
+			return u.Offset, err
+		}
+	}
+	u.Chunks++
+	u.Offset += n
+	u.ExpiresAt = time.Now().Add(ResumableUploadTTL)
+	if err := s.partials.store.SavePartialUpload(ctx, u); err != nil {
+		return u.Offset - n, err
+	}
+	if u.Offset == u.Length {
+		for i := 0; i < u.Chunks; i++ {
+			if err := s.storage.Delete(ctx, chunkID(id, i)); err != nil && err != ErrNotFound {
+				logging.Internal.Printf("failed to delete chunk %d of upload %s: %v", i, id, err)
+			}
+		}
+	}
+	return u.Offset, nil
+}
+
+// joinChunks concatenates an upload's chunks into the final file.
+func (s *Service) joinChunks(ctx context.Context, id string, chunks int, length int64) error {
+	readers := make([]io.Reader, chunks)
+	for i := range readers {
+		readers[i] = &lazyChunk{ctx: ctx, storage: s.storage, id: chunkID(id, i)}
+	}
+	defer func() {
+		for _, r := range readers {
+			r.(*lazyChunk).Close()
+		}
+	}()
+
+	n, err := s.storage.SaveWithProgress(ctx, id, io.MultiReader(readers...), length, nil)
+	if err != nil {
+		return err
+	}
+	if n != length {
+		s.storage.Delete(ctx, id)
+		return fmt.Errorf("joined upload is %d bytes, expected %d", n, length)
+	}
+	return nil
+}
+
+// lazyChunk opens a chunk on first read, so joining an upload holds only one
+// chunk open at a time.
+type lazyChunk struct {
+	ctx     context.Context
+	storage Storage
+	id      string
+	rc      io.ReadCloser
+}
+
+func (c *lazyChunk) Read(p []byte) (int, error) {
+	if c.rc == nil {
+		rc, err := c.storage.Load(c.ctx, c.id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to load chunk %s: %w", c.id, err)
+		}
+		c.rc = rc
+	}
+	n, err := c.rc.Read(p)
+	if err == io.EOF {
+		c.Close()
+	}
+	return n, err
+}
+
+func (c *lazyChunk) Close() error {
+	if c.rc == nil {
+		return nil
+	}
+	err := c.rc.Close()
+	c.rc = nil
+	return err
+}
+
+// TerminatePartialUpload discards a resumable upload and the data received
+// for it.
+func (s *Service) TerminatePartialUpload(ctx context.Context, id string) error {
+	if s.partials == nil {
+		return ErrResumableDisabled
+	}
+	if err := s.partials.lock(id); err != nil {
+		return err
+	}
+	defer s.partials.unlock(id)
+
+	u, err := s.partials.store.GetPartialUpload(ctx, id)
+	if err != nil {
+		return err
+	}
+	s.deletePartialData(ctx, u)
+	return s.partials.store.DeletePartialUpload(ctx, id)
+}
+
+// deletePartialData removes whatever has been stored for a partial upload.
+func (s *Service) deletePartialData(ctx context.Context, u *store.PartialUpload) {
+	for i := 0; i < u.Chunks; i++ {
+		if err := s.storage.Delete(ctx, chunkID(u.ID, i)); err != nil && err != ErrNotFound {
+			logging.Internal.Printf("failed to delete chunk %d of upload %s: %v", i, u.ID, err)
+		}
+	}
+	if err := s.storage.Delete(ctx, u.ID); err != nil && err != ErrNotFound {
+		logging.Internal.Printf("failed to delete partial upload %s: %v", u.ID, err)
+	}
+}
+
+// finishPartialUpload checks that a resumable upload, if id is one, has
+// received all its data, and forgets it so CompleteUpload can take over.
+func (s *Service) finishPartialUpload(ctx context.Context, id string) error {
+	if s.partials == nil {
+		return nil
+	}
+	u, err := s.partials.store.GetPartialUpload(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if u.Offset < u.Length {
+		return fmt.Errorf("%w: received %d of %d bytes", ErrUploadUnfinished, u.Offset, u.Length)
+	}
+	return s.partials.store.DeletePartialUpload(ctx, id)
+}
+
+// cleanupPartialUploads discards resumable uploads that were abandoned
+// before being completed. It returns how many were removed.
+func (s *Service) cleanupPartialUploads(ctx context.Context) (int, error) {
+	if s.partials == nil {
+		return 0, nil
+	}
+	expired, err := s.partials.store.ListExpiredPartialUploads(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, u := range expired {
+		if err := s.partials.lock(u.ID); err != nil {
+			continue
+		}
+		// A completed upload whose record wasn't removed keeps its data
+		if _, err := s.store.GetFileMetadata(ctx, u.ID); errors.Is(err, store.ErrNotFound) {
+			s.deletePartialData(ctx, u)
+		}
+		err := s.partials.store.DeletePartialUpload(ctx, u.ID)
+		s.partials.unlock(u.ID)
+		if err != nil {
+			logging.Internal.Printf("failed to delete partial upload record %s: %v", u.ID, err)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
@@ -0,0 +1,185 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"satoshisend/internal/store"
+)
+
+func newPartialStore(t *testing.T) *store.SQLiteStore {
+	t.Helper()
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return st
+}
+
+func TestService_ResumableUpload_Chunks(t *testing.T) {
+	storage := newMockStorage()
+	svc := NewService(storage, newMockStore())
+	svc.SetPartialUploads(newPartialStore(t))
+	ctx := context.Background()
+
+	content := []byte("hello resumable world")
+	u, err := svc.CreatePartialUpload(ctx, int64(len(content)), "filename aGVsbG8=")
+	if err != nil {
+		t.Fatalf("CreatePartialUpload failed: %v", err)
+	}
+
+	offset, err := svc.AppendPartialUpload(ctx, u.ID, 0, bytes.NewReader(content[:6]), 6)
+	if err != nil || offset != 6 {
+		t.Fatalf("first append: offset=%d err=%v", offset, err)
+	}
+	if _, err := svc.AppendPartialUpload(ctx, u.ID, 0, bytes.NewReader(content[:6]), 6); !errors.Is(err, ErrOffsetMismatch) {
+		t.Errorf("expected ErrOffsetMismatch for a stale offset, got %v", err)
+	}
+	if _, err := svc.AppendPartialUpload(ctx, u.ID, 6, bytes.NewReader(content[6:]), int64(len(content))); err == nil {
+		t.Error("expected an error for a chunk longer than the rest of the upload")
+	}
+	if _, err := svc.CompleteUpload(ctx, u.ID, 0, time.Hour, 0); !errors.Is(err, ErrUploadUnfinished) {
+		t.Errorf("expected ErrUploadUnfinished, got %v", err)
+	}
+
+	got, err := svc.GetPartialUpload(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetPartialUpload failed: %v", err)
+	}
+	if got.Offset != 6 || got.Metadata != "filename aGVsbG8=" {
+		t.Errorf("unexpected progress %+v", got)
+	}
+
+	offset, err = svc.AppendPartialUpload(ctx, u.ID, 6, bytes.NewReader(content[6:]), int64(len(content)-6))
+	if err != nil || offset != int64(len(content)) {
+		t.Fatalf("last append: offset=%d err=%v", offset, err)
+	}
+	if !bytes.Equal(storage.files[u.ID], content) {
+		t.Errorf("joined file = %q, want %q", storage.files[u.ID], content)
+	}
+	if len(storage.files) != 1 {
+		t.Errorf("expected chunks to be deleted, storage has %d files", len(storage.files))
+	}
+
+	result, err := svc.CompleteUpload(ctx, u.ID, int64(len(content)), time.Hour, 0)
+	if err != nil {
+		t.Fatalf("CompleteUpload failed: %v", err)
+	}
+	if result.Size != int64(len(content)) {
+		t.Errorf("expected size %d, got %d", len(content), result.Size)
+	}
+	if _, err := svc.GetPartialUpload(ctx, u.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected the partial upload to be forgotten, got %v", err)
+	}
+}
+
+func TestService_ResumableUpload_Append(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFSStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFSStorage failed: %v", err)
+	}
+	svc := NewService(storage, newMockStore())
+	svc.SetPartialUploads(newPartialStore(t))
+	ctx := context.Background()
+
+	u, _ := svc.CreatePartialUpload(ctx, 10, "")
+	if offset, err := svc.AppendPartialUpload(ctx, u.ID, 0, strings.NewReader("01234"), -1); err != nil || offset != 5 {
+		t.Fatalf("first append: offset=%d err=%v", offset, err)
+	}
+
+	// Bytes written without being recorded (e.g. a crash) are picked up
+	f, _ := os.OpenFile(filepath.Join(dir, u.ID), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString("56")
+	f.Close()
+	got, err := svc.GetPartialUpload(ctx, u.ID)
+	if err != nil || got.Offset != 7 {
+		t.Fatalf("expected offset 7 after resync, got %+v (err=%v)", got, err)
+	}
+
+	// Data past the declared length is ignored
+	if offset, err := svc.AppendPartialUpload(ctx, u.ID, 7, strings.NewReader("789extra"), -1); err != nil || offset != 10 {
+		t.Fatalf("last append: offset=%d err=%v", offset, err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, u.ID))
+	if string(data) != "0123456789" {
+		t.Errorf("file = %q", data)
+	}
+	if _, err := svc.CompleteUpload(ctx, u.ID, 10, time.Hour, 0); err != nil {
+		t.Errorf("CompleteUpload failed: %v", err)
+	}
+}
+
+func TestService_ResumableUpload_AppendExtendsExpiry(t *testing.T) {
+	partials := newPartialStore(t)
+	svc := NewService(newMockStorage(), newMockStore())
+	svc.SetPartialUploads(partials)
+	ctx := context.Background()
+
+	u, _ := svc.CreatePartialUpload(ctx, 10, "")
+	u.ExpiresAt = time.Now().Add(time.Minute)
+	if err := partials.SavePartialUpload(ctx, u); err != nil {
+		t.Fatalf("SavePartialUpload failed: %v", err)
+	}
+
+	if _, err := svc.AppendPartialUpload(ctx, u.ID, 0, strings.NewReader("01234"), 5); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+	got, err := svc.GetPartialUpload(ctx, u.ID)
+	if err != nil {
+		t.Fatalf("GetPartialUpload failed: %v", err)
+	}
+	if time.Until(got.ExpiresAt) < ResumableUploadTTL-time.Minute {
+		t.Errorf("expected the expiry to be pushed back, got %v", got.ExpiresAt)
+	}
+}
+
+func TestService_ResumableUpload_TerminateAndCleanup(t *testing.T) {
+	storage := newMockStorage()
+	partials := newPartialStore(t)
+	svc := NewService(storage, newMockStore())
+	ctx := context.Background()
+
+	if _, err := svc.CreatePartialUpload(ctx, 10, ""); !errors.Is(err, ErrResumableDisabled) {
+		t.Fatalf("expected ErrResumableDisabled, got %v", err)
+	}
+	svc.SetPartialUploads(partials)
+
+	terminated, _ := svc.CreatePartialUpload(ctx, 10, "")
+	svc.AppendPartialUpload(ctx, terminated.ID, 0, strings.NewReader("abc"), 3)
+	if err := svc.TerminatePartialUpload(ctx, terminated.ID); err != nil {
+		t.Fatalf("TerminatePartialUpload failed: %v", err)
+	}
+	if len(storage.files) != 0 {
+		t.Errorf("expected terminated upload's chunks to be deleted, have %d files", len(storage.files))
+	}
+	if _, err := svc.GetPartialUpload(ctx, terminated.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected terminated upload to be gone, got %v", err)
+	}
+
+	abandoned, _ := svc.CreatePartialUpload(ctx, 10, "")
+	svc.AppendPartialUpload(ctx, abandoned.ID, 0, strings.NewReader("abc"), 3)
+	abandoned.Offset, abandoned.Chunks = 3, 1
+	abandoned.ExpiresAt = time.Now().Add(-time.Minute)
+	partials.SavePartialUpload(ctx, abandoned)
+	if _, err := svc.GetPartialUpload(ctx, abandoned.ID); !errors.Is(err, ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+
+	if _, err := svc.CleanupExpired(ctx); err != nil {
+		t.Fatalf("CleanupExpired failed: %v", err)
+	}
+	if len(storage.files) != 0 {
+		t.Errorf("expected abandoned upload's chunks to be deleted, have %d files", len(storage.files))
+	}
+	if _, err := partials.GetPartialUpload(ctx, abandoned.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected abandoned upload record to be deleted, got %v", err)
+	}
+}
@@ -3,6 +3,8 @@ package files
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/hex"
 	"errors"
 	"io"
@@ -15,10 +17,16 @@ import (
 // PendingTimeout is how long an unpaid file remains before cleanup.
 const PendingTimeout = 15 * time.Minute
 
+// PresignExpiry is how long presigned upload URLs stay valid. It is generous
+// because each part of a large multipart upload is started only after the
+// previous ones finish.
+const PresignExpiry = 12 * time.Hour
+
 // Service handles file operations.
 type Service struct {
-	storage Storage
-	store   store.Store
+	storage  Storage
+	store    store.Store
+	partials *resumable // nil disables resumable uploads
 }
 
 // NewService creates a new file service.
@@ -33,6 +41,10 @@ func NewService(storage Storage, st store.Store) *Service {
 type UploadResult struct {
 	ID   string
 	Size int64
+	// OwnerToken authenticates the uploader for managing the file (e.g.
+	// withdrawing download earnings). Only its hash is stored, so it can't
+	// be recovered later.
+	OwnerToken string
 }
 
 // Upload stores an encrypted file and creates metadata.
@@ -88,28 +100,71 @@ func (s *Service) UploadWithID(ctx context.Context, id string, data io.Reader, s
 // UploadInitResult contains the result of initiating an upload.
 type UploadInitResult struct {
 	ID string
+	// Presigned is set if the client can upload straight to storage. If nil,
+	// the data must be sent through the server with UploadWithID.
+	Presigned *PresignedUpload
 }
 
-// InitUpload generates a file ID for a new upload.
-func (s *Service) InitUpload(ctx context.Context) (*UploadInitResult, error) {
+// InitUpload generates a file ID for a new upload of size bytes, and presigned
+// upload URLs if the storage backend supports them.
+func (s *Service) InitUpload(ctx context.Context, size int64) (*UploadInitResult, error) {
 	id, err := generateID()
 	if err != nil {
 		return nil, err
 	}
 
-	return &UploadInitResult{
-		ID: id,
-	}, nil
+	result := &UploadInitResult{ID: id}
+	if uploader, ok := s.storage.(PresignedUploader); ok {
+		presigned, err := uploader.PresignUpload(ctx, id, size, PresignExpiry)
+		if err != nil {
+			// The client can still upload through the server
+			logging.Internal.Printf("failed to presign upload %s, falling back to proxy upload: %v", id, err)
+		} else {
+			result.Presigned = presigned
+		}
+	}
+	return result, nil
+}
+
+// CompletePresignedUpload assembles a presigned multipart upload from the ETags
+// of its parts, so CompleteUpload can find the file. A retry after the
+// upload was already assembled succeeds.
+func (s *Service) CompletePresignedUpload(ctx context.Context, id, uploadID string, etags []string) error {
+	uploader, ok := s.storage.(PresignedUploader)
+	if !ok {
+		return errors.New("storage backend does not support presigned uploads")
+	}
+	if len(etags) == 0 {
+		return errors.New("no part ETags given for multipart upload")
+	}
+
+	err := uploader.CompletePresignedUpload(ctx, id, uploadID, etags)
+	if err == nil {
+		return nil
+	}
+	if statProvider, ok := s.storage.(StatProvider); ok {
+		if _, statErr := statProvider.Stat(ctx, id); statErr == nil {
+			return nil
+		}
+	}
+	return err
 }
 
 // CompleteUpload verifies the file was uploaded to storage and creates metadata.
-// Returns an error if the file doesn't exist or is the wrong size.
-func (s *Service) CompleteUpload(ctx context.Context, id string, expectedSize int64, hostDuration time.Duration) (*UploadResult, error) {
+// A non-zero downloadPriceSats puts downloads behind an L402 paywall.
+// Returns an error if the file doesn't exist, and ErrSizeMismatch (after
+// deleting the upload) if it isn't expectedSize bytes.
+func (s *Service) CompleteUpload(ctx context.Context, id string, expectedSize int64, hostDuration time.Duration, downloadPriceSats int64) (*UploadResult, error) {
 	statProvider, ok := s.storage.(StatProvider)
 	if !ok {
 		return nil, errors.New("storage backend does not support stat")
 	}
 
+	// A resumable upload must have received all its data
+	if err := s.finishPartialUpload(ctx, id); err != nil {
+		return nil, err
+	}
+
 	// Verify the file exists and check size
 	actualSize, err := statProvider.Stat(ctx, id)
 	if err != nil {
@@ -119,26 +174,56 @@ func (s *Service) CompleteUpload(ctx context.Context, id string, expectedSize in
 		return nil, err
 	}
 
-	// Verify size matches (with some tolerance for chunked encoding overhead)
-	if expectedSize > 0 && actualSize != expectedSize {
+	// The client sends the size of the encrypted data, which it was quoted
+	// and checked against the upload limit for. Presigned URLs don't bind the
+	// size, so a blob of any other size is discarded.
+	if actualSize != expectedSize {
 		logging.Internal.Printf("size mismatch for %s: expected %d, got %d", id, expectedSize, actualSize)
-		// Don't fail on size mismatch - client-side encryption may add overhead
+		// A completed file with this ID isn't ours to delete
+		if _, err := s.store.GetFileMetadata(ctx, id); errors.Is(err, store.ErrNotFound) {
+			if err := s.storage.Delete(ctx, id); err != nil && err != ErrNotFound {
+				logging.Internal.Printf("failed to delete mismatched upload %s: %v", id, err)
+			}
+		}
+		return nil, ErrSizeMismatch
+	}
+
+	ownerToken, err := generateOwnerToken()
+	if err != nil {
+		return nil, err
 	}
 
 	meta := &store.FileMeta{
-		ID:           id,
-		Size:         actualSize,
-		ExpiresAt:    time.Now().Add(PendingTimeout),
-		HostDuration: hostDuration,
-		Paid:         false,
-		CreatedAt:    time.Now(),
+		ID:                id,
+		Size:              actualSize,
+		ExpiresAt:         time.Now().Add(PendingTimeout),
+		HostDuration:      hostDuration,
+		Paid:              false,
+		CreatedAt:         time.Now(),
+		DownloadPriceSats: downloadPriceSats,
+		OwnerTokenHash:    hashOwnerToken(ownerToken),
 	}
 
 	if err := s.store.SaveFileMetadata(ctx, meta); err != nil {
 		return nil, err
 	}
 
-	return &UploadResult{ID: id, Size: actualSize}, nil
+	return &UploadResult{ID: id, Size: actualSize, OwnerToken: ownerToken}, nil
+}
+
+// VerifyOwner checks that token is the owner token issued for the file.
+// It returns ErrNotOwner if it isn't.
+func (s *Service) VerifyOwner(ctx context.Context, id, token string) (*store.FileMeta, error) {
+	meta, err := s.store.GetFileMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if token == "" || meta.OwnerTokenHash == "" ||
+		subtle.ConstantTimeCompare([]byte(hashOwnerToken(token)), []byte(meta.OwnerTokenHash)) != 1 {
+		return nil, ErrNotOwner
+	}
+	return meta, nil
 }
 
 // Download retrieves a file if it exists and is paid for.
@@ -255,6 +340,12 @@ func (s *Service) CleanupExpired(ctx context.Context) (int, error) {
 		logging.Internal.Printf("cleanup completed with errors: %d storage failures, %d metadata failures", storageErrors, metadataErrors)
 	}
 
+	if abandoned, err := s.cleanupPartialUploads(ctx); err != nil {
+		logging.Internal.Printf("failed to clean up abandoned resumable uploads: %v", err)
+	} else if abandoned > 0 {
+		logging.Internal.Printf("removed %d abandoned resumable uploads", abandoned)
+	}
+
 	return count, nil
 }
 
@@ -266,7 +357,22 @@ func generateID() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
+func generateOwnerToken() (string, error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(bytes), nil
+}
+
+func hashOwnerToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 var (
-	ErrNotPaid = errors.New("file not paid for")
-	ErrExpired = errors.New("file has expired")
+	ErrNotPaid      = errors.New("file not paid for")
+	ErrExpired      = errors.New("file has expired")
+	ErrNotOwner     = errors.New("invalid owner token")
+	ErrSizeMismatch = errors.New("uploaded file size does not match the declared size")
 )
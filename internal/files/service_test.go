@@ -3,6 +3,7 @@ package files
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
 	"testing"
 	"time"
@@ -51,16 +52,26 @@ func (m *mockStorage) Delete(ctx context.Context, id string) error {
 	return nil
 }
 
+func (m *mockStorage) Stat(ctx context.Context, id string) (int64, error) {
+	data, ok := m.files[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return int64(len(data)), nil
+}
+
 // mockStore implements store.Store for testing.
 type mockStore struct {
 	files    map[string]*store.FileMeta
 	invoices map[string]*store.PendingInvoice
+	offers   map[string]*store.Offer
 }
 
 func newMockStore() *mockStore {
 	return &mockStore{
 		files:    make(map[string]*store.FileMeta),
 		invoices: make(map[string]*store.PendingInvoice),
+		offers:   make(map[string]*store.Offer),
 	}
 }
 
@@ -90,6 +101,19 @@ func (m *mockStore) UpdatePaymentStatus(ctx context.Context, fileID string, paid
 	return nil
 }
 
+func (m *mockStore) ExtendExpiry(ctx context.Context, fileID string, d time.Duration) error {
+	meta, ok := m.files[fileID]
+	if !ok || !meta.Paid {
+		return store.ErrNotFound
+	}
+	base := time.Now()
+	if meta.ExpiresAt.After(base) {
+		base = meta.ExpiresAt
+	}
+	meta.ExpiresAt = base.Add(d)
+	return nil
+}
+
 func (m *mockStore) DeleteFileMetadata(ctx context.Context, id string) error {
 	if _, ok := m.files[id]; !ok {
 		return store.ErrNotFound
@@ -135,6 +159,21 @@ func (m *mockStore) ListPendingInvoices(ctx context.Context) ([]*store.PendingIn
 	return result, nil
 }
 
+func (m *mockStore) SaveOffer(ctx context.Context, offer *store.Offer) error {
+	m.offers[offer.OfferID] = offer
+	return nil
+}
+
+func (m *mockStore) ListOffers(ctx context.Context) ([]*store.Offer, error) {
+	var result []*store.Offer
+	for _, offer := range m.offers {
+		if offer.ExpiresAt.After(time.Now()) {
+			result = append(result, offer)
+		}
+	}
+	return result, nil
+}
+
 func TestService_Upload(t *testing.T) {
 	storage := newMockStorage()
 	st := newMockStore()
@@ -355,3 +394,69 @@ func TestService_CleanupExpired_UnpaidPendingFiles(t *testing.T) {
 		t.Error("non-expired file blob should still exist in storage")
 	}
 }
+
+func TestService_CompleteUploadSizeMismatch(t *testing.T) {
+	storage := newMockStorage()
+	st := newMockStore()
+	svc := NewService(storage, st)
+	ctx := context.Background()
+
+	// A client that declared 1 byte but uploaded more
+	storage.files["oversized"] = []byte("far more than one byte")
+	if _, err := svc.CompleteUpload(ctx, "oversized", 1, 24*time.Hour, 0); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected ErrSizeMismatch, got %v", err)
+	}
+	if _, exists := storage.files["oversized"]; exists {
+		t.Error("expected the mismatched upload to be deleted")
+	}
+	if _, err := st.GetFileMetadata(ctx, "oversized"); !errors.Is(err, store.ErrNotFound) {
+		t.Errorf("expected no metadata, got %v", err)
+	}
+
+	// Completing an existing file again with a wrong size leaves it alone
+	storage.files["existing"] = []byte("data")
+	if _, err := svc.CompleteUpload(ctx, "existing", 4, 24*time.Hour, 0); err != nil {
+		t.Fatalf("CompleteUpload failed: %v", err)
+	}
+	if _, err := svc.CompleteUpload(ctx, "existing", 5, 24*time.Hour, 0); !errors.Is(err, ErrSizeMismatch) {
+		t.Fatalf("expected ErrSizeMismatch, got %v", err)
+	}
+	if _, exists := storage.files["existing"]; !exists {
+		t.Error("a completed file should not be deleted")
+	}
+}
+
+func TestService_CompleteUploadOwnerToken(t *testing.T) {
+	storage := newMockStorage()
+	st := newMockStore()
+	svc := NewService(storage, st)
+
+	ctx := context.Background()
+	storage.files["owned123"] = []byte("encrypted file content")
+
+	result, err := svc.CompleteUpload(ctx, "owned123", 22, 24*time.Hour, 0)
+	if err != nil {
+		t.Fatalf("CompleteUpload failed: %v", err)
+	}
+	if len(result.OwnerToken) != 64 {
+		t.Fatalf("expected a 32 byte hex owner token, got %q", result.OwnerToken)
+	}
+
+	meta, _ := svc.GetMetadata(ctx, "owned123")
+	if meta.OwnerTokenHash == "" || meta.OwnerTokenHash == result.OwnerToken {
+		t.Error("expected only a hash of the owner token to be stored")
+	}
+
+	if _, err := svc.VerifyOwner(ctx, "owned123", result.OwnerToken); err != nil {
+		t.Errorf("VerifyOwner rejected the issued token: %v", err)
+	}
+	if _, err := svc.VerifyOwner(ctx, "owned123", "wrong"); err != ErrNotOwner {
+		t.Errorf("expected ErrNotOwner for a wrong token, got %v", err)
+	}
+	if _, err := svc.VerifyOwner(ctx, "owned123", ""); err != ErrNotOwner {
+		t.Errorf("expected ErrNotOwner for an empty token, got %v", err)
+	}
+	if _, err := svc.VerifyOwner(ctx, "missing", result.OwnerToken); err != store.ErrNotFound {
+		t.Errorf("expected ErrNotFound for an unknown file, got %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package files
 import (
 	"context"
 	"io"
+	"time"
 )
 
 // ProgressFunc is called during upload with bytes written and total size.
@@ -24,9 +25,76 @@ type PublicURLProvider interface {
 	GetPublicURL(id string) string
 }
 
+// Appender is an optional interface for storage backends that can add data to
+// the end of a file, creating it if needed. Resumable uploads are written in
+// place on such backends instead of as separate chunks.
+type Appender interface {
+	// Append writes data to the end of the file and returns the number of
+	// bytes written, which may be non-zero even if an error is returned.
+	Append(ctx context.Context, id string, data io.Reader) (int64, error)
+}
+
+// PresignedUpload tells a client how to upload a file straight to the storage
+// backend. Either URL is set for a single PUT of the whole file, or UploadID
+// and PartURLs for a multipart upload: part n (from 1) is bytes
+// [(n-1)*PartSize, n*PartSize) of the file, PUT to PartURLs[n-1].
+type PresignedUpload struct {
+	URL       string
+	UploadID  string
+	PartSize  int64
+	PartURLs  []string
+	ExpiresAt time.Time
+}
+
+// PresignedUploader is an optional interface for storage backends that accept
+// uploads straight from clients via presigned URLs, so the data doesn't pass
+// through the server.
+type PresignedUploader interface {
+	// PresignUpload returns URLs for uploading size bytes as the file id,
+	// valid until expires from now.
+	PresignUpload(ctx context.Context, id string, size int64, expires time.Duration) (*PresignedUpload, error)
+	// CompletePresignedUpload assembles a multipart upload from the ETags the
+	// backend returned for each part, in order.
+	CompletePresignedUpload(ctx context.Context, id, uploadID string, etags []string) error
+}
+
 // StatProvider is an optional interface for storage backends that support
 // checking if a file exists and getting its size.
 type StatProvider interface {
 	// Stat returns the size of a file, or ErrNotFound if it doesn't exist.
 	Stat(ctx context.Context, id string) (size int64, err error)
 }
+
+// BlobInfo describes a file in storage.
+type BlobInfo struct {
+	ID      string
+	Size    int64
+	ModTime time.Time
+}
+
+// Lister is an optional interface for storage backends that can enumerate the
+// files they hold, which is needed to find orphaned blobs.
+type Lister interface {
+	// List calls fn for every file in storage, stopping at the first error
+	// fn returns.
+	List(ctx context.Context, fn func(BlobInfo) error) error
+}
+
+// MultipartUpload describes a multipart upload that was started but not yet
+// completed or aborted.
+type MultipartUpload struct {
+	ID        string
+	UploadID  string
+	Initiated time.Time
+}
+
+// MultipartLister is an optional interface for storage backends that keep
+// the parts of unfinished multipart uploads. Those parts take up space but
+// are not returned by List, so they have to be found and aborted separately.
+type MultipartLister interface {
+	// ListMultipartUploads calls fn for every unfinished multipart upload,
+	// stopping at the first error fn returns.
+	ListMultipartUploads(ctx context.Context, fn func(MultipartUpload) error) error
+	// AbortMultipartUpload discards the parts uploaded so far.
+	AbortMultipartUpload(ctx context.Context, id, uploadID string) error
+}
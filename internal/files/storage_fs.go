@@ -66,6 +66,21 @@ func (s *FSStorage) SaveWithProgress(ctx context.Context, id string, data io.Rea
 	return io.Copy(f, reader)
 }
 
+func (s *FSStorage) Append(ctx context.Context, id string, data io.Reader) (int64, error) {
+	if err := s.validateID(id); err != nil {
+		return 0, err
+	}
+	f, err := os.OpenFile(s.path(id), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
 func (s *FSStorage) Load(ctx context.Context, id string) (io.ReadCloser, error) {
 	if err := s.validateID(id); err != nil {
 		return nil, err
@@ -101,3 +116,31 @@ func (s *FSStorage) Delete(ctx context.Context, id string) error {
 	}
 	return err
 }
+
+// List calls fn for each file in the storage directory. Entries that aren't
+// regular files with valid IDs are skipped.
+func (s *FSStorage) List(ctx context.Context, fn func(BlobInfo) error) error {
+	entries, err := os.ReadDir(s.basePath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() || s.validateID(entry.Name()) != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if errors.Is(err, os.ErrNotExist) {
+			continue // Deleted since the directory was read
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(BlobInfo{ID: entry.Name(), Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -225,3 +225,43 @@ func TestFSStorage_Path(t *testing.T) {
 		t.Errorf("path() = %q, want %q", path, expected)
 	}
 }
+
+func TestFSStorage_List(t *testing.T) {
+	dir := t.TempDir()
+	storage, err := NewFSStorage(dir)
+	if err != nil {
+		t.Fatalf("NewFSStorage failed: %v", err)
+	}
+	ctx := context.Background()
+
+	storage.Save(ctx, "file1", strings.NewReader("hello"))
+	storage.Save(ctx, "file2", strings.NewReader("hello, world"))
+	// Neither of these is a stored file
+	os.Mkdir(filepath.Join(dir, "subdir"), 0755)
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644)
+
+	got := make(map[string]int64)
+	err = storage.List(ctx, func(blob BlobInfo) error {
+		if blob.ModTime.IsZero() {
+			t.Errorf("%s has no modification time", blob.ID)
+		}
+		got[blob.ID] = blob.Size
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(got) != 2 || got["file1"] != 5 || got["file2"] != 12 {
+		t.Errorf("List found %v, want file1 (5 bytes) and file2 (12 bytes)", got)
+	}
+
+	stop := io.ErrShortWrite
+	calls := 0
+	err = storage.List(ctx, func(BlobInfo) error {
+		calls++
+		return stop
+	})
+	if err != stop || calls != 1 {
+		t.Errorf("List returned %v after %d calls, want the callback's error after 1", err, calls)
+	}
+}
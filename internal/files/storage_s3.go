@@ -0,0 +1,673 @@
+package files
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"satoshisend/internal/logging"
+)
+
+// b2DefaultRegion is the B2 region used when none is configured.
+const b2DefaultRegion = "us-east-005"
+
+// Multipart upload settings for files saved through the server. Each part in
+// flight is buffered in memory, so uploads use up to part size times
+// concurrency bytes.
+const (
+	DefaultPartSize        = 16 << 20
+	MinPartSize            = 5 << 20 // S3 minimum for all but the last part
+	DefaultPartConcurrency = 4
+	maxPartAttempts        = 3
+)
+
+// partRetryDelay is the wait before retrying a failed part, multiplied by the
+// attempt number.
+var partRetryDelay = time.Second
+
+// presignPartSize is the part size of presigned multipart uploads. Files up to
+// this size are uploaded with a single presigned PUT.
+const presignPartSize = 64 << 20
+
+// S3Object wraps the operations needed from an S3 object.
+// This abstraction allows for mocking in tests.
+type S3Object interface {
+	io.ReadCloser
+	Stat() (minio.ObjectInfo, error)
+}
+
+// S3Client defines the interface for S3-compatible storage operations.
+// This abstraction allows for mocking in tests.
+type S3Client interface {
+	PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (S3Object, error)
+	RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error
+	StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+	ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo
+	ListIncompleteUploads(ctx context.Context, bucketName, objectPrefix string, recursive bool) <-chan minio.ObjectMultipartInfo
+
+	// Presigned and multipart uploads
+	PresignedPutObject(ctx context.Context, bucketName, objectName string, expires time.Duration) (*url.URL, error)
+	Presign(ctx context.Context, method, bucketName, objectName string, expires time.Duration, reqParams url.Values) (*url.URL, error)
+	NewMultipartUpload(ctx context.Context, bucketName, objectName string, opts minio.PutObjectOptions) (string, error)
+	PutObjectPart(ctx context.Context, bucketName, objectName, uploadID string, partID int, data io.Reader, size int64, opts minio.PutObjectPartOptions) (minio.ObjectPart, error)
+	CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) (minio.UploadInfo, error)
+	AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error
+}
+
+// minioClientWrapper wraps *minio.Client to implement S3Client.
+type minioClientWrapper struct {
+	client *minio.Client
+}
+
+func (w *minioClientWrapper) PutObject(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	return w.client.PutObject(ctx, bucketName, objectName, reader, objectSize, opts)
+}
+
+func (w *minioClientWrapper) GetObject(ctx context.Context, bucketName, objectName string, opts minio.GetObjectOptions) (S3Object, error) {
+	return w.client.GetObject(ctx, bucketName, objectName, opts)
+}
+
+func (w *minioClientWrapper) RemoveObject(ctx context.Context, bucketName, objectName string, opts minio.RemoveObjectOptions) error {
+	return w.client.RemoveObject(ctx, bucketName, objectName, opts)
+}
+
+func (w *minioClientWrapper) StatObject(ctx context.Context, bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	return w.client.StatObject(ctx, bucketName, objectName, opts)
+}
+
+func (w *minioClientWrapper) ListObjects(ctx context.Context, bucketName string, opts minio.ListObjectsOptions) <-chan minio.ObjectInfo {
+	return w.client.ListObjects(ctx, bucketName, opts)
+}
+
+func (w *minioClientWrapper) ListIncompleteUploads(ctx context.Context, bucketName, objectPrefix string, recursive bool) <-chan minio.ObjectMultipartInfo {
+	return w.client.ListIncompleteUploads(ctx, bucketName, objectPrefix, recursive)
+}
+
+func (w *minioClientWrapper) PresignedPutObject(ctx context.Context, bucketName, objectName string, expires time.Duration) (*url.URL, error) {
+	return w.client.PresignedPutObject(ctx, bucketName, objectName, expires)
+}
+
+func (w *minioClientWrapper) Presign(ctx context.Context, method, bucketName, objectName string, expires time.Duration, reqParams url.Values) (*url.URL, error) {
+	return w.client.Presign(ctx, method, bucketName, objectName, expires, reqParams)
+}
+
+func (w *minioClientWrapper) NewMultipartUpload(ctx context.Context, bucketName, objectName string, opts minio.PutObjectOptions) (string, error) {
+	return minio.Core{Client: w.client}.NewMultipartUpload(ctx, bucketName, objectName, opts)
+}
+
+func (w *minioClientWrapper) PutObjectPart(ctx context.Context, bucketName, objectName, uploadID string, partID int, data io.Reader, size int64, opts minio.PutObjectPartOptions) (minio.ObjectPart, error) {
+	return minio.Core{Client: w.client}.PutObjectPart(ctx, bucketName, objectName, uploadID, partID, data, size, opts)
+}
+
+func (w *minioClientWrapper) CompleteMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string, parts []minio.CompletePart, opts minio.PutObjectOptions) (minio.UploadInfo, error) {
+	return minio.Core{Client: w.client}.CompleteMultipartUpload(ctx, bucketName, objectName, uploadID, parts, opts)
+}
+
+func (w *minioClientWrapper) AbortMultipartUpload(ctx context.Context, bucketName, objectName, uploadID string) error {
+	return minio.Core{Client: w.client}.AbortMultipartUpload(ctx, bucketName, objectName, uploadID)
+}
+
+// S3Storage implements Storage on an S3-compatible object store, such as
+// Backblaze B2, AWS S3, Cloudflare R2, Wasabi or MinIO.
+type S3Storage struct {
+	client    S3Client
+	bucket    string
+	prefix    string
+	publicURL string // Public URL template or base URL (e.g., "https://f005.backblazeb2.com/file/mybucket")
+
+	partSize        int64 // Files larger than this are uploaded in parts
+	partConcurrency int   // Parts uploaded at once
+}
+
+// S3Config holds configuration for S3-compatible storage.
+type S3Config struct {
+	Endpoint        string // S3_ENDPOINT - host[:port] of the S3 API, e.g. "s3.us-west-2.amazonaws.com"
+	Region          string // S3_REGION - looked up from the bucket if empty
+	AccessKeyID     string // S3_ACCESS_KEY_ID
+	SecretAccessKey string // S3_SECRET_ACCESS_KEY
+	Bucket          string // S3_BUCKET
+	Prefix          string // S3_PREFIX - optional folder prefix for all objects
+	DisableTLS      bool   // S3_DISABLE_TLS - use plain HTTP, e.g. for a local MinIO
+	PathStyle       bool   // S3_PATH_STYLE - address buckets as endpoint/bucket rather than bucket.endpoint
+
+	// PublicURL (S3_PUBLIC_URL) enables direct downloads. It is a template
+	// where {bucket} and {key} are replaced, e.g.
+	// "https://{bucket}.s3.amazonaws.com/{key}", or a base URL the object key
+	// is appended to.
+	PublicURL string
+
+	PartSize        int64 // S3_PART_SIZE_MB - multipart upload part size; 0 for DefaultPartSize
+	PartConcurrency int   // S3_PART_CONCURRENCY - parts uploaded at once; 0 for DefaultPartConcurrency
+}
+
+// NewS3Storage creates a new S3-backed storage.
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	logging.S3.Printf("initializing storage (bucket=%s, prefix=%s, endpoint=%s)", cfg.Bucket, cfg.Prefix, cfg.Endpoint)
+
+	if cfg.Endpoint == "" {
+		return nil, errors.New("endpoint is required")
+	}
+	if cfg.Bucket == "" {
+		return nil, errors.New("bucket is required")
+	}
+	if cfg.PartSize != 0 && cfg.PartSize < MinPartSize {
+		return nil, fmt.Errorf("part size must be at least %d bytes", MinPartSize)
+	}
+	if cfg.PartConcurrency < 0 {
+		return nil, fmt.Errorf("part concurrency must not be negative")
+	}
+
+	lookup := minio.BucketLookupAuto
+	if cfg.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+	client, err := minio.New(cfg.Endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(cfg.AccessKeyID, cfg.SecretAccessKey, ""),
+		Secure:       !cfg.DisableTLS,
+		Region:       cfg.Region, // If set, presigning needs no lookup
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		logging.S3.Printf("failed to create client: %v", err)
+		return nil, err
+	}
+
+	if cfg.PublicURL != "" {
+		logging.S3.Printf("public URL configured: %s", cfg.PublicURL)
+	}
+
+	logging.S3.Printf("storage initialized successfully")
+	s := NewS3StorageWithClient(&minioClientWrapper{client: client}, cfg.Bucket, cfg.Prefix, cfg.PublicURL)
+	s.SetMultipart(cfg.PartSize, cfg.PartConcurrency)
+	return s, nil
+}
+
+// B2Config holds configuration for Backblaze B2, a preset of S3Config.
+type B2Config struct {
+	KeyID     string // B2_KEY_ID
+	AppKey    string // B2_APP_KEY
+	Bucket    string // B2_BUCKET
+	Region    string // B2_REGION - the bucket's region; defaults to "us-east-005"
+	Prefix    string // B2_PREFIX - optional folder prefix for all objects
+	PublicURL string // B2_PUBLIC_URL - base URL for public access (enables direct downloads)
+
+	PartSize        int64 // B2_PART_SIZE_MB - multipart upload part size; 0 for DefaultPartSize
+	PartConcurrency int   // B2_PART_CONCURRENCY - parts uploaded at once; 0 for DefaultPartConcurrency
+}
+
+// S3Config returns the S3 configuration for the B2 bucket.
+func (cfg B2Config) S3Config() S3Config {
+	region := cfg.Region
+	if region == "" {
+		region = b2DefaultRegion
+	}
+	return S3Config{
+		Endpoint:        "s3." + region + ".backblazeb2.com",
+		Region:          region,
+		AccessKeyID:     cfg.KeyID,
+		SecretAccessKey: cfg.AppKey,
+		Bucket:          cfg.Bucket,
+		Prefix:          cfg.Prefix,
+		PublicURL:       cfg.PublicURL,
+		PartSize:        cfg.PartSize,
+		PartConcurrency: cfg.PartConcurrency,
+	}
+}
+
+// NewB2Storage creates a new B2-backed storage.
+func NewB2Storage(cfg B2Config) (*S3Storage, error) {
+	return NewS3Storage(cfg.S3Config())
+}
+
+// NewS3StorageWithClient creates an S3Storage with a custom client.
+// This is primarily useful for testing with mock clients.
+func NewS3StorageWithClient(client S3Client, bucket, prefix, publicURL string) *S3Storage {
+	return &S3Storage{
+		client:          client,
+		bucket:          bucket,
+		prefix:          prefix,
+		publicURL:       publicURL,
+		partSize:        DefaultPartSize,
+		partConcurrency: DefaultPartConcurrency,
+	}
+}
+
+// SetMultipart sets the part size and number of parallel part uploads for
+// files saved in parts. Zero values keep the current settings.
+func (s *S3Storage) SetMultipart(partSize int64, concurrency int) {
+	if partSize > 0 {
+		s.partSize = partSize
+	}
+	if concurrency > 0 {
+		s.partConcurrency = concurrency
+	}
+}
+
+func (s *S3Storage) key(id string) string {
+	if s.prefix == "" {
+		return id
+	}
+	return path.Join(s.prefix, id)
+}
+
+func (s *S3Storage) Save(ctx context.Context, id string, data io.Reader) (int64, error) {
+	return s.SaveWithProgress(ctx, id, data, -1, nil)
+}
+
+// SaveWithProgress uploads files of up to one part size with a single PUT,
+// and larger ones (or ones of unknown size that turn out larger) in parts.
+func (s *S3Storage) SaveWithProgress(ctx context.Context, id string, data io.Reader, size int64, onProgress ProgressFunc) (int64, error) {
+	key := s.key(id)
+
+	if size >= 0 && size <= s.partSize {
+		return s.put(ctx, key, data, size, onProgress)
+	}
+
+	// Read the first part to find out whether the file needs more than one
+	var first bytes.Buffer
+	n, err := io.CopyN(&first, data, s.partSize)
+	if err == io.EOF {
+		return s.put(ctx, key, &first, n, onProgress)
+	}
+	if err != nil {
+		logging.S3.Printf("upload failed for %s: %v", key, err)
+		return 0, err
+	}
+	return s.putMultipart(ctx, key, first.Bytes(), data, size, onProgress)
+}
+
+// put uploads a file with a single PutObject.
+func (s *S3Storage) put(ctx context.Context, key string, data io.Reader, size int64, onProgress ProgressFunc) (int64, error) {
+	// Wrap reader with progress tracking if callback provided
+	var reader io.Reader = data
+	if onProgress != nil {
+		reader = &progressReader{
+			reader:     data,
+			total:      size,
+			onProgress: onProgress,
+		}
+	}
+
+	info, err := s.client.PutObject(ctx, s.bucket, key, reader, size, minio.PutObjectOptions{})
+	if err != nil {
+		logging.S3.Printf("upload failed for %s: %v", key, err)
+		return 0, err
+	}
+
+	return info.Size, nil
+}
+
+// putMultipart uploads a file in parts, partConcurrency at a time, starting
+// with first, which holds a full part already read from data. onProgress is
+// called as parts finish. If any part fails for good the upload is aborted,
+// so no parts are left behind.
+func (s *S3Storage) putMultipart(ctx context.Context, key string, first []byte, data io.Reader, size int64, onProgress ProgressFunc) (int64, error) {
+	uploadID, err := s.client.NewMultipartUpload(ctx, s.bucket, key, minio.PutObjectOptions{})
+	if err != nil {
+		logging.S3.Printf("failed to start multipart upload of %s: %v", key, err)
+		return 0, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type partJob struct {
+		number int
+		data   []byte
+	}
+	jobs := make(chan partJob)
+	// Buffers are recycled, so at most partConcurrency parts plus the one
+	// being read are held in memory. All of them can be handed back once the
+	// reader stops, so the channel has room for each and workers never block.
+	buffers := make(chan []byte, s.partConcurrency+1)
+
+	var (
+		mu       sync.Mutex
+		parts    []minio.CompletePart
+		uploaded int64
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.partConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				part, err := s.putPart(ctx, key, uploadID, job.number, job.data)
+				if err != nil {
+					fail(fmt.Errorf("part %d: %w", job.number, err))
+				} else {
+					mu.Lock()
+					parts = append(parts, part)
+					uploaded += int64(len(job.data))
+					if onProgress != nil {
+						onProgress(uploaded, size)
+					}
+					mu.Unlock()
+				}
+				buffers <- job.data[:cap(job.data)]
+			}
+		}()
+	}
+
+	buf := first
+	for number := 1; ; number++ {
+		if number > 1 {
+			select {
+			case buf = <-buffers:
+				buf = buf[:s.partSize]
+			default:
+				buf = make([]byte, s.partSize)
+			}
+			n, err := io.ReadFull(data, buf)
+			if err == io.EOF {
+				break
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				fail(err)
+				break
+			}
+			buf = buf[:n]
+		}
+
+		select {
+		case jobs <- partJob{number: number, data: buf}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil || int64(len(buf)) < s.partSize {
+			break
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
+	if firstErr != nil {
+		logging.S3.Printf("multipart upload of %s failed: %v", key, firstErr)
+		s.abortMultipart(key, uploadID)
+		return 0, firstErr
+	}
+
+	sort.Slice(parts, func(i, j int) bool { return parts[i].PartNumber < parts[j].PartNumber })
+	if _, err := s.client.CompleteMultipartUpload(ctx, s.bucket, key, uploadID, parts, minio.PutObjectOptions{}); err != nil {
+		logging.S3.Printf("failed to complete multipart upload of %s: %v", key, err)
+		s.abortMultipart(key, uploadID)
+		return 0, err
+	}
+
+	return uploaded, nil
+}
+
+// putPart uploads one part of a multipart upload, retrying failures.
+func (s *S3Storage) putPart(ctx context.Context, key, uploadID string, number int, data []byte) (minio.CompletePart, error) {
+	var err error
+	for attempt := 1; attempt <= maxPartAttempts; attempt++ {
+		var part minio.ObjectPart
+		part, err = s.client.PutObjectPart(ctx, s.bucket, key, uploadID, number, bytes.NewReader(data), int64(len(data)), minio.PutObjectPartOptions{})
+		if err == nil {
+			return minio.CompletePart{PartNumber: number, ETag: part.ETag}, nil
+		}
+		if ctx.Err() != nil {
+			return minio.CompletePart{}, err
+		}
+		logging.S3.Printf("part %d of %s failed (attempt %d of %d): %v", number, key, attempt, maxPartAttempts, err)
+		if attempt < maxPartAttempts {
+			select {
+			case <-time.After(partRetryDelay * time.Duration(attempt)):
+			case <-ctx.Done():
+				return minio.CompletePart{}, ctx.Err()
+			}
+		}
+	}
+	return minio.CompletePart{}, err
+}
+
+// abortMultipart discards the parts of a failed multipart upload. It runs
+// even if the upload's context was canceled.
+func (s *S3Storage) abortMultipart(key, uploadID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.client.AbortMultipartUpload(ctx, s.bucket, key, uploadID); err != nil {
+		logging.S3.Printf("failed to abort multipart upload of %s: %v", key, err)
+	}
+}
+
+// progressReader wraps an io.Reader and reports progress as data is read.
+type progressReader struct {
+	reader     io.Reader
+	total      int64
+	read       int64
+	onProgress ProgressFunc
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.reader.Read(p)
+	if n > 0 {
+		pr.read += int64(n)
+		if pr.onProgress != nil {
+			pr.onProgress(pr.read, pr.total)
+		}
+	}
+	return n, err
+}
+
+func (s *S3Storage) Load(ctx context.Context, id string) (io.ReadCloser, error) {
+	key := s.key(id)
+
+	obj, err := s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		logging.S3.Printf("failed to get object %s: %v", key, err)
+		return nil, err
+	}
+
+	// Check if object exists by attempting to stat it
+	_, err = obj.Stat()
+	if err != nil {
+		obj.Close()
+		errResp := minio.ToErrorResponse(err)
+		if errResp.Code == "NoSuchKey" {
+			return nil, ErrNotFound
+		}
+		logging.S3.Printf("failed to stat object %s: %v", key, err)
+		return nil, err
+	}
+
+	return obj, nil
+}
+
+func (s *S3Storage) Delete(ctx context.Context, id string) error {
+	key := s.key(id)
+
+	err := s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+	if err != nil {
+		errResp := minio.ToErrorResponse(err)
+		if errResp.Code == "NoSuchKey" {
+			return ErrNotFound
+		}
+		logging.S3.Printf("failed to delete %s: %v", key, err)
+		return err
+	}
+
+	return nil
+}
+
+// GetPublicURL returns the public URL for a file if public access is configured.
+// Returns empty string if public URL is not configured.
+func (s *S3Storage) GetPublicURL(id string) string {
+	if s.publicURL == "" {
+		return ""
+	}
+	key := s.key(id)
+	if strings.Contains(s.publicURL, "{key}") {
+		return strings.NewReplacer("{bucket}", s.bucket, "{key}", key).Replace(s.publicURL)
+	}
+	// Ensure no double slashes
+	if s.publicURL[len(s.publicURL)-1] == '/' {
+		return s.publicURL + key
+	}
+	return s.publicURL + "/" + key
+}
+
+// Stat returns the size of a file in the bucket.
+func (s *S3Storage) Stat(ctx context.Context, id string) (int64, error) {
+	key := s.key(id)
+
+	info, err := s.client.StatObject(ctx, s.bucket, key, minio.StatObjectOptions{})
+	if err != nil {
+		errResp := minio.ToErrorResponse(err)
+		if errResp.Code == "NoSuchKey" {
+			return 0, ErrNotFound
+		}
+		logging.S3.Printf("failed to stat %s: %v", key, err)
+		return 0, err
+	}
+
+	return info.Size, nil
+}
+
+// List calls fn for each object under the storage prefix. Objects in nested
+// folders are skipped, since files are never stored in them.
+func (s *S3Storage) List(ctx context.Context, fn func(BlobInfo) error) error {
+	// Stop the listing if fn returns early
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefix := ""
+	if s.prefix != "" {
+		prefix = strings.TrimSuffix(s.prefix, "/") + "/"
+	}
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			logging.S3.Printf("failed to list %s: %v", prefix, obj.Err)
+			return obj.Err
+		}
+		id := strings.TrimPrefix(obj.Key, prefix)
+		if id == "" || strings.Contains(id, "/") {
+			continue
+		}
+		if err := fn(BlobInfo{ID: id, Size: obj.Size, ModTime: obj.LastModified}); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// ListMultipartUploads calls fn for each unfinished multipart upload under the
+// storage prefix, skipping nested folders like List.
+func (s *S3Storage) ListMultipartUploads(ctx context.Context, fn func(MultipartUpload) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	prefix := ""
+	if s.prefix != "" {
+		prefix = strings.TrimSuffix(s.prefix, "/") + "/"
+	}
+	for upload := range s.client.ListIncompleteUploads(ctx, s.bucket, prefix, true) {
+		if upload.Err != nil {
+			logging.S3.Printf("failed to list multipart uploads in %s: %v", prefix, upload.Err)
+			return upload.Err
+		}
+		id := strings.TrimPrefix(upload.Key, prefix)
+		if id == "" || strings.Contains(id, "/") {
+			continue
+		}
+		if err := fn(MultipartUpload{ID: id, UploadID: upload.UploadID, Initiated: upload.Initiated}); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// AbortMultipartUpload discards an unfinished multipart upload of the file.
+func (s *S3Storage) AbortMultipartUpload(ctx context.Context, id, uploadID string) error {
+	key := s.key(id)
+	if err := s.client.AbortMultipartUpload(ctx, s.bucket, key, uploadID); err != nil {
+		logging.S3.Printf("failed to abort multipart upload of %s: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+// PresignUpload returns a presigned PUT URL for the file, or presigned part
+// URLs of a new multipart upload if it is larger than presignPartSize.
+func (s *S3Storage) PresignUpload(ctx context.Context, id string, size int64, expires time.Duration) (*PresignedUpload, error) {
+	key := s.key(id)
+	expiresAt := time.Now().Add(expires)
+
+	if size <= presignPartSize {
+		u, err := s.client.PresignedPutObject(ctx, s.bucket, key, expires)
+		if err != nil {
+			logging.S3.Printf("failed to presign upload of %s: %v", key, err)
+			return nil, err
+		}
+		return &PresignedUpload{URL: u.String(), ExpiresAt: expiresAt}, nil
+	}
+
+	uploadID, err := s.client.NewMultipartUpload(ctx, s.bucket, key, minio.PutObjectOptions{})
+	if err != nil {
+		logging.S3.Printf("failed to start multipart upload of %s: %v", key, err)
+		return nil, err
+	}
+	parts := int((size + presignPartSize - 1) / presignPartSize)
+	urls := make([]string, parts)
+	for i := range urls {
+		params := url.Values{}
+		params.Set("partNumber", strconv.Itoa(i+1))
+		params.Set("uploadId", uploadID)
+		u, err := s.client.Presign(ctx, http.MethodPut, s.bucket, key, expires, params)
+		if err != nil {
+			logging.S3.Printf("failed to presign part %d of %s: %v", i+1, key, err)
+			if abortErr := s.client.AbortMultipartUpload(ctx, s.bucket, key, uploadID); abortErr != nil {
+				logging.S3.Printf("failed to abort multipart upload of %s: %v", key, abortErr)
+			}
+			return nil, err
+		}
+		urls[i] = u.String()
+	}
+
+	return &PresignedUpload{
+		UploadID:  uploadID,
+		PartSize:  presignPartSize,
+		PartURLs:  urls,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// CompletePresignedUpload assembles a presigned multipart upload.
+func (s *S3Storage) CompletePresignedUpload(ctx context.Context, id, uploadID string, etags []string) error {
+	key := s.key(id)
+
+	parts := make([]minio.CompletePart, len(etags))
+	for i, etag := range etags {
+		parts[i] = minio.CompletePart{PartNumber: i + 1, ETag: etag}
+	}
+	if _, err := s.client.CompleteMultipartUpload(ctx, s.bucket, key, uploadID, parts, minio.PutObjectOptions{}); err != nil {
+		logging.S3.Printf("failed to complete multipart upload of %s: %v", key, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+// Package l402 implements the L402 HTTP payment protocol for paywalled
+// downloads. A request without a valid token is answered with 402 Payment
+// Required and a challenge carrying a macaroon and a Lightning invoice; once
+// the invoice is paid the client retries with
+// "Authorization: L402 <macaroon>:<preimage>".
+//
+// The challenge may also carry a BOLT12 offer for the file. The preimage of
+// any payment of that offer is accepted in place of the invoice's, with the
+// same macaroon.
+package l402
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/payments"
+	"satoshisend/internal/store"
+)
+
+var (
+	ErrMissingToken = errors.New("l402: missing token")
+	ErrInvalidToken = errors.New("l402: invalid token")
+)
+
+// rootKeySetting is the settings key under which the macaroon root key is
+// persisted, so issued tokens survive restarts.
+const rootKeySetting = "l402_root_key"
+
+// identifierVersion tags the macaroon identifier layout:
+// version (2 bytes) || payment hash (32 bytes) || token ID (32 bytes).
+const identifierVersion = 0
+
+const identifierLen = 2 + sha256.Size + 32
+
+// InvoiceCreator issues the invoices that pay for download tokens.
+// payments.Service implements it.
+type InvoiceCreator interface {
+	CreateDownloadInvoice(ctx context.Context, fileID string, amountSats int64) (*payments.Invoice, error)
+}
+
+// ChallengeReuse is how long a challenge is handed out again to the same
+// client for the same file, rather than creating a new invoice every time.
+const ChallengeReuse = 10 * time.Minute
+
+// issuedChallenge is a challenge remembered for reuse.
+type issuedChallenge struct {
+	header     string
+	amountSats int64
+	reuseUntil time.Time
+}
+
+// Service issues and verifies L402 download tokens.
+type Service struct {
+	rootKey  []byte
+	tokens   store.L402TokenStore
+	invoices InvoiceCreator
+	ledger   store.Ledger // optional; credits uploaders for paid downloads
+
+	mu     sync.Mutex
+	issued map[string]*issuedChallenge // keyed by file ID and client
+}
+
+// NewService creates an L402 service that signs macaroons with rootKey.
+func NewService(rootKey []byte, tokens store.L402TokenStore, invoices InvoiceCreator) *Service {
+	return &Service{
+		rootKey:  rootKey,
+		tokens:   tokens,
+		invoices: invoices,
+		issued:   make(map[string]*issuedChallenge),
+	}
+}
+
+// SetLedger credits each file's earnings with the price of every token
+// paid for it, so uploaders can withdraw what their downloads earned.
+func (s *Service) SetLedger(ledger store.Ledger) {
+	s.ledger = ledger
+}
+
+// LoadRootKey returns the persisted macaroon root key, generating and storing
+// one on first use.
+func LoadRootKey(ctx context.Context, settings store.SettingsStore) ([]byte, error) {
+	value, err := settings.GetSetting(ctx, rootKeySetting)
+	if err == nil {
+		return hex.DecodeString(value)
+	}
+	if !errors.Is(err, store.ErrNotFound) {
+		return nil, err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := settings.SetSetting(ctx, rootKeySetting, hex.EncodeToString(key)); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Challenge issues a download token for fileID priced at amountSats and
+// returns the WWW-Authenticate header value to send with a 402 response.
+// client identifies the requester (e.g. its IP address): asking again within
+// ChallengeReuse returns the same challenge while its invoice is payable.
+func (s *Service) Challenge(ctx context.Context, fileID string, amountSats int64, client string) (string, error) {
+	key := fileID + "|" + client
+	now := time.Now()
+	s.mu.Lock()
+	for k, c := range s.issued {
+		if !now.Before(c.reuseUntil) {
+			delete(s.issued, k)
+		}
+	}
+	if c, ok := s.issued[key]; ok && c.amountSats == amountSats {
+		s.mu.Unlock()
+		return c.header, nil
+	}
+	s.mu.Unlock()
+
+	inv, err := s.invoices.CreateDownloadInvoice(ctx, fileID, amountSats)
+	if err != nil {
+		return "", err
+	}
+
+	paymentHash, err := hex.DecodeString(inv.PaymentHash)
+	if err != nil || len(paymentHash) != sha256.Size {
+		return "", fmt.Errorf("invalid payment hash %q", inv.PaymentHash)
+	}
+	tokenID := make([]byte, 32)
+	if _, err := rand.Read(tokenID); err != nil {
+		return "", err
+	}
+
+	id := binary.BigEndian.AppendUint16(nil, identifierVersion)
+	id = append(id, paymentHash...)
+	id = append(id, tokenID...)
+	mac := NewMacaroon(s.rootKey, id, fileCaveat(fileID))
+
+	if err := s.tokens.SaveL402Token(ctx, &store.L402Token{
+		PaymentHash: inv.PaymentHash,
+		FileID:      fileID,
+		AmountSats:  inv.AmountSats,
+		CreatedAt:   time.Now(),
+	}); err != nil {
+		return "", err
+	}
+
+	header := fmt.Sprintf(`L402 macaroon="%s", invoice="%s"`, mac.Encode(), inv.PaymentRequest)
+
+	reuseUntil := now.Add(ChallengeReuse)
+	if !inv.ExpiresAt.IsZero() && inv.ExpiresAt.Add(-time.Minute).Before(reuseUntil) {
+		// Leave the payer time to pay before the invoice expires
+		reuseUntil = inv.ExpiresAt.Add(-time.Minute)
+	}
+	s.mu.Lock()
+	s.issued[key] = &issuedChallenge{header: header, amountSats: amountSats, reuseUntil: reuseUntil}
+	s.mu.Unlock()
+
+	return header, nil
+}
+
+// Authorize checks that the Authorization header carries a paid token for
+// fileID. It returns ErrMissingToken if there is no L402 credential and
+// ErrInvalidToken if the credential doesn't grant access.
+func (s *Service) Authorize(ctx context.Context, fileID, authorization string) error {
+	mac, preimage, err := ParseAuthorization(authorization)
+	if err != nil {
+		return err
+	}
+
+	if !mac.Verify(s.rootKey) || len(mac.Caveats) == 0 {
+		return ErrInvalidToken
+	}
+	// Every caveat must hold; the only condition we issue is the file ID
+	for _, caveat := range mac.Caveats {
+		if caveat != fileCaveat(fileID) {
+			return ErrInvalidToken
+		}
+	}
+
+	if len(mac.ID) != identifierLen || binary.BigEndian.Uint16(mac.ID) != identifierVersion {
+		return ErrInvalidToken
+	}
+	// The preimage must pay the challenge's invoice, or one of the file's offers
+	paymentHash := sha256.Sum256(preimage)
+	boundToInvoice := bytes.Equal(paymentHash[:], mac.ID[2:2+sha256.Size])
+
+	token, err := s.tokens.GetL402Token(ctx, hex.EncodeToString(paymentHash[:]))
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrInvalidToken
+	}
+	if err != nil {
+		return err
+	}
+	if token.FileID != fileID || (!boundToInvoice && token.OfferID == "") {
+		return ErrInvalidToken
+	}
+
+	// Normally credited when the payment settled; this covers a settlement
+	// that was missed
+	s.credit(ctx, token.FileID, token.AmountSats, token.PaymentHash)
+	return nil
+}
+
+// credit records a paid token's price as earnings for its file. A payment is
+// credited once however many times it is reported or its token presented.
+func (s *Service) credit(ctx context.Context, fileID string, amountSats int64, paymentHash string) {
+	if s.ledger == nil {
+		return
+	}
+	err := s.ledger.AddLedgerEntry(ctx, &store.LedgerEntry{
+		FileID:     fileID,
+		Kind:       store.LedgerDownload,
+		AmountSats: amountSats,
+		Reference:  paymentHash,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil && !errors.Is(err, store.ErrDuplicate) {
+		logging.Internal.Printf("failed to credit download of %s: %v", fileID, err)
+	}
+}
+
+// RecordDownloadPayment credits the uploader for a paid download invoice. It
+// has the signature of payments.DownloadCallback.
+func (s *Service) RecordDownloadPayment(ctx context.Context, fileID string, amountSats int64, paymentHash string) {
+	s.credit(ctx, fileID, amountSats, paymentHash)
+}
+
+// RecordOfferPayment records the download token bought by paying a file's
+// BOLT12 offer and credits the uploader for it. It has the signature of
+// payments.OfferPaymentCallback.
+func (s *Service) RecordOfferPayment(ctx context.Context, offer *store.Offer, paymentHash string) {
+	err := s.tokens.SaveL402Token(ctx, &store.L402Token{
+		PaymentHash: paymentHash,
+		FileID:      offer.FileID,
+		AmountSats:  offer.AmountSats,
+		CreatedAt:   time.Now(),
+		OfferID:     offer.OfferID,
+	})
+	if err != nil {
+		logging.Internal.Printf("CRITICAL: failed to record offer payment %s for %s: %v", payments.ShortHash(paymentHash), offer.FileID, err)
+	}
+	s.credit(ctx, offer.FileID, offer.AmountSats, paymentHash)
+}
+
+func fileCaveat(fileID string) string {
+	return "file_id=" + fileID
+}
+
+// ParseAuthorization extracts the macaroon and preimage from an
+// "L402 <macaroon>:<preimage>" Authorization header. The legacy "LSAT" scheme
+// is also accepted.
+func ParseAuthorization(header string) (*Macaroon, []byte, error) {
+	scheme, credentials, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || (!strings.EqualFold(scheme, "L402") && !strings.EqualFold(scheme, "LSAT")) {
+		return nil, nil, ErrMissingToken
+	}
+
+	encoded, preimageHex, ok := strings.Cut(strings.TrimSpace(credentials), ":")
+	if !ok {
+		return nil, nil, ErrInvalidToken
+	}
+	mac, err := DecodeMacaroon(encoded)
+	if err != nil {
+		return nil, nil, ErrInvalidToken
+	}
+	preimage, err := hex.DecodeString(preimageHex)
+	if err != nil || len(preimage) != 32 {
+		return nil, nil, ErrInvalidToken
+	}
+	return mac, preimage, nil
+}
@@ -0,0 +1,291 @@
+package l402
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"satoshisend/internal/payments"
+	"satoshisend/internal/store"
+)
+
+func TestMacaroon_EncodeDecode(t *testing.T) {
+	rootKey := []byte("root-key")
+	mac := NewMacaroon(rootKey, []byte("identifier"), "file_id=abc", "expires=123")
+
+	decoded, err := DecodeMacaroon(mac.Encode())
+	if err != nil {
+		t.Fatalf("DecodeMacaroon failed: %v", err)
+	}
+	if string(decoded.ID) != "identifier" || len(decoded.Caveats) != 2 || decoded.Caveats[1] != "expires=123" {
+		t.Errorf("unexpected decoded macaroon %+v", decoded)
+	}
+	if !decoded.Verify(rootKey) {
+		t.Error("expected decoded macaroon to verify")
+	}
+	if decoded.Verify([]byte("other-key")) {
+		t.Error("expected verification with the wrong root key to fail")
+	}
+
+	// Dropping or altering a caveat invalidates the signature
+	decoded.Caveats = decoded.Caveats[:1]
+	if decoded.Verify(rootKey) {
+		t.Error("expected macaroon with a removed caveat to fail verification")
+	}
+
+	if _, err := DecodeMacaroon("not base64!"); err == nil {
+		t.Error("expected error for invalid encoding")
+	}
+	if _, err := DecodeMacaroon(mac.Encode()[:10]); err == nil {
+		t.Error("expected error for truncated macaroon")
+	}
+}
+
+// parseChallenge extracts the macaroon and invoice from a WWW-Authenticate value.
+func parseChallenge(t *testing.T, challenge string) (macaroon, invoice string) {
+	t.Helper()
+	rest, ok := strings.CutPrefix(challenge, `L402 macaroon="`)
+	if !ok {
+		t.Fatalf("unexpected challenge %q", challenge)
+	}
+	macaroon, rest, _ = strings.Cut(rest, `", invoice="`)
+	invoice = strings.TrimSuffix(rest, `"`)
+	return macaroon, invoice
+}
+
+func TestService_ChallengeAndAuthorize(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer st.Close()
+
+	lnd := payments.NewMockLNDClient()
+	rootKey, err := LoadRootKey(ctx, st)
+	if err != nil {
+		t.Fatalf("LoadRootKey failed: %v", err)
+	}
+	again, _ := LoadRootKey(ctx, st)
+	if hex.EncodeToString(again) != hex.EncodeToString(rootKey) {
+		t.Error("expected the persisted root key to be reused")
+	}
+
+	svc := NewService(rootKey, st, payments.NewService(lnd, st))
+
+	challenge, err := svc.Challenge(ctx, "file1234abcd", 250, "")
+	if err != nil {
+		t.Fatalf("Challenge failed: %v", err)
+	}
+	macaroon, invoice := parseChallenge(t, challenge)
+	if !strings.HasPrefix(invoice, "lnbc") {
+		t.Errorf("expected an invoice in the challenge, got %q", invoice)
+	}
+
+	mac, _ := DecodeMacaroon(macaroon)
+	paymentHash := hex.EncodeToString(mac.ID[2:34])
+	preimage, ok := lnd.Preimage(paymentHash)
+	if !ok {
+		t.Fatal("challenge invoice not found in mock")
+	}
+	token, err := st.GetL402Token(ctx, paymentHash)
+	if err != nil {
+		t.Fatalf("expected token to be recorded: %v", err)
+	}
+	if token.FileID != "file1234abcd" || token.AmountSats != 250 {
+		t.Errorf("unexpected token %+v", token)
+	}
+
+	wrongPreimage := strings.Repeat("00", 32)
+	forged := NewMacaroon([]byte("attacker"), mac.ID, mac.Caveats...).Encode()
+
+	tests := []struct {
+		name    string
+		fileID  string
+		header  string
+		wantErr error
+	}{
+		{"paid token", "file1234abcd", "L402 " + macaroon + ":" + preimage, nil},
+		{"legacy scheme", "file1234abcd", "LSAT " + macaroon + ":" + preimage, nil},
+		{"no header", "file1234abcd", "", ErrMissingToken},
+		{"other scheme", "file1234abcd", "Bearer abc", ErrMissingToken},
+		{"wrong preimage", "file1234abcd", "L402 " + macaroon + ":" + wrongPreimage, ErrInvalidToken},
+		{"other file", "otherfile123", "L402 " + macaroon + ":" + preimage, ErrInvalidToken},
+		{"forged macaroon", "file1234abcd", "L402 " + forged + ":" + preimage, ErrInvalidToken},
+		{"malformed", "file1234abcd", "L402 garbage", ErrInvalidToken},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := svc.Authorize(ctx, tc.fileID, tc.header)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	// A macaroon signed with our key but never recorded is rejected
+	t.Run("unrecorded token", func(t *testing.T) {
+		inv, _ := lnd.CreateInvoice(ctx, 250, "unrecorded")
+		hash, _ := hex.DecodeString(inv.PaymentHash)
+		id := append([]byte{0, 0}, hash...)
+		id = append(id, make([]byte, 32)...)
+		unrecorded := NewMacaroon(rootKey, id, "file_id=file1234abcd").Encode()
+		preimage, _ := lnd.Preimage(inv.PaymentHash)
+
+		err := svc.Authorize(ctx, "file1234abcd", "L402 "+unrecorded+":"+preimage)
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("expected ErrInvalidToken, got %v", err)
+		}
+	})
+}
+
+func TestService_ChallengeReuse(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer st.Close()
+
+	svc := NewService([]byte("root"), st, payments.NewService(payments.NewMockLNDClient(), st))
+
+	first, err := svc.Challenge(ctx, "file1234abcd", 250, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Challenge failed: %v", err)
+	}
+	if again, _ := svc.Challenge(ctx, "file1234abcd", 250, "192.0.2.1"); again != first {
+		t.Error("expected the same client to get its challenge back")
+	}
+	if other, _ := svc.Challenge(ctx, "file1234abcd", 250, "192.0.2.2"); other == first {
+		t.Error("expected another client to get its own challenge")
+	}
+	if repriced, _ := svc.Challenge(ctx, "file1234abcd", 300, "192.0.2.1"); repriced == first {
+		t.Error("expected a new challenge after the price changed")
+	}
+
+	// Once the reuse window has passed a new invoice is issued
+	svc.mu.Lock()
+	for _, c := range svc.issued {
+		c.reuseUntil = time.Now().Add(-time.Second)
+	}
+	svc.mu.Unlock()
+	if fresh, _ := svc.Challenge(ctx, "file1234abcd", 250, "192.0.2.1"); fresh == first {
+		t.Error("expected a new challenge after the reuse window")
+	}
+}
+
+func TestService_CreditsLedger(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer st.Close()
+
+	lnd := payments.NewMockLNDClient()
+	svc := NewService([]byte("root"), st, payments.NewService(lnd, st))
+	svc.SetLedger(st)
+
+	challenge, err := svc.Challenge(ctx, "file1234abcd", 250, "")
+	if err != nil {
+		t.Fatalf("Challenge failed: %v", err)
+	}
+	macaroon, _ := parseChallenge(t, challenge)
+	mac, _ := DecodeMacaroon(macaroon)
+	preimage, _ := lnd.Preimage(hex.EncodeToString(mac.ID[2:34]))
+	header := "L402 " + macaroon + ":" + preimage
+
+	// Redeeming the same token repeatedly credits the uploader once
+	for range 3 {
+		if err := svc.Authorize(ctx, "file1234abcd", header); err != nil {
+			t.Fatalf("Authorize failed: %v", err)
+		}
+	}
+
+	balance, err := st.LedgerBalance(ctx, "file1234abcd")
+	if err != nil {
+		t.Fatalf("LedgerBalance failed: %v", err)
+	}
+	if balance != 250 {
+		t.Errorf("expected 250 sats credited, got %d", balance)
+	}
+}
+
+func TestService_CreditsLedgerOnSettlement(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer st.Close()
+
+	lnd := payments.NewMockLNDClient()
+	paymentsSvc := payments.NewService(lnd, st)
+	svc := NewService([]byte("root"), st, paymentsSvc)
+	svc.SetLedger(st)
+	paymentsSvc.SetDownloadCallback(svc.RecordDownloadPayment)
+
+	challenge, err := svc.Challenge(ctx, "file1234abcd", 250, "")
+	if err != nil {
+		t.Fatalf("Challenge failed: %v", err)
+	}
+	macaroon, _ := parseChallenge(t, challenge)
+	mac, _ := DecodeMacaroon(macaroon)
+	hash := hex.EncodeToString(mac.ID[2:34])
+
+	// Paid but never fetched
+	lnd.MarkSettled(hash)
+	if count, err := paymentsSvc.ReconcilePendingInvoices(ctx); err != nil || count != 1 {
+		t.Fatalf("expected 1 reconciled invoice, got %d (err=%v)", count, err)
+	}
+	if balance, _ := st.LedgerBalance(ctx, "file1234abcd"); balance != 250 {
+		t.Errorf("expected 250 sats credited on settlement, got %d", balance)
+	}
+
+	// Presenting the token afterwards doesn't credit it again
+	preimage, _ := lnd.Preimage(hash)
+	if err := svc.Authorize(ctx, "file1234abcd", "L402 "+macaroon+":"+preimage); err != nil {
+		t.Fatalf("Authorize failed: %v", err)
+	}
+	if balance, _ := st.LedgerBalance(ctx, "file1234abcd"); balance != 250 {
+		t.Errorf("expected the download to be credited once, got %d", balance)
+	}
+}
+
+func TestService_OfferPayment(t *testing.T) {
+	ctx := context.Background()
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer st.Close()
+
+	lnd := payments.NewMockLNDClient()
+	svc := NewService([]byte("root"), st, payments.NewService(lnd, st))
+
+	challenge, err := svc.Challenge(ctx, "file1234abcd", 250, "")
+	if err != nil {
+		t.Fatalf("Challenge failed: %v", err)
+	}
+	macaroon, _ := parseChallenge(t, challenge)
+
+	offer, _ := lnd.CreateOffer(ctx, 250, "download", time.Now().Add(time.Hour))
+	hash, preimage, _ := lnd.PayOffer(offer.OfferID)
+	header := "L402 " + macaroon + ":" + preimage
+
+	if err := svc.Authorize(ctx, "file1234abcd", header); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected an unrecorded offer payment to be rejected, got %v", err)
+	}
+
+	svc.RecordOfferPayment(ctx, &store.Offer{OfferID: offer.OfferID, FileID: "file1234abcd", AmountSats: 250}, hash)
+	if err := svc.Authorize(ctx, "file1234abcd", header); err != nil {
+		t.Errorf("expected the offer payment to unlock the download, got %v", err)
+	}
+	if err := svc.Authorize(ctx, "otherfile123", header); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected the offer payment to be bound to its file, got %v", err)
+	}
+}
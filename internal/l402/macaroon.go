@@ -0,0 +1,105 @@
+package l402
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Macaroon is a bearer credential whose signature chains an HMAC over its
+// identifier and every caveat, so caveats can be checked but not removed or
+// altered without the root key.
+type Macaroon struct {
+	ID        []byte
+	Caveats   []string // "condition=value" restrictions
+	Signature []byte
+}
+
+// NewMacaroon mints a macaroon signed with rootKey.
+func NewMacaroon(rootKey, id []byte, caveats ...string) *Macaroon {
+	m := &Macaroon{
+		ID:      id,
+		Caveats: caveats,
+	}
+	m.Signature = m.sign(rootKey)
+	return m
+}
+
+func (m *Macaroon) sign(rootKey []byte) []byte {
+	sig := hmacSHA256(rootKey, m.ID)
+	for _, caveat := range m.Caveats {
+		sig = hmacSHA256(sig, []byte(caveat))
+	}
+	return sig
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// Verify reports whether the macaroon was minted with rootKey and has not been
+// tampered with.
+func (m *Macaroon) Verify(rootKey []byte) bool {
+	return hmac.Equal(m.sign(rootKey), m.Signature)
+}
+
+// Encode serializes the macaroon as base64 for use in HTTP headers.
+func (m *Macaroon) Encode() string {
+	var buf []byte
+	buf = appendField(buf, m.ID)
+	buf = binary.AppendUvarint(buf, uint64(len(m.Caveats)))
+	for _, caveat := range m.Caveats {
+		buf = appendField(buf, []byte(caveat))
+	}
+	buf = append(buf, m.Signature...)
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+func appendField(buf, field []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(field)))
+	return append(buf, field...)
+}
+
+// DecodeMacaroon parses a macaroon produced by Encode.
+func DecodeMacaroon(s string) (*Macaroon, error) {
+	buf, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid macaroon encoding: %w", err)
+	}
+
+	m := &Macaroon{}
+	if m.ID, buf, err = readField(buf); err != nil {
+		return nil, err
+	}
+	count, n := binary.Uvarint(buf)
+	if n <= 0 || count > uint64(len(buf)) {
+		return nil, errors.New("invalid macaroon caveat count")
+	}
+	buf = buf[n:]
+	for range count {
+		var caveat []byte
+		if caveat, buf, err = readField(buf); err != nil {
+			return nil, err
+		}
+		m.Caveats = append(m.Caveats, string(caveat))
+	}
+	if len(buf) != sha256.Size {
+		return nil, errors.New("invalid macaroon signature")
+	}
+	m.Signature = buf
+	return m, nil
+}
+
+func readField(buf []byte) (field, rest []byte, err error) {
+	size, n := binary.Uvarint(buf)
+	if n <= 0 || size > uint64(len(buf)-n) {
+		return nil, nil, errors.New("truncated macaroon")
+	}
+	buf = buf[n:]
+	return buf[:size], buf[size:], nil
+}
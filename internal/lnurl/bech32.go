@@ -0,0 +1,115 @@
+package lnurl
+
+import (
+	"errors"
+	"strings"
+)
+
+const charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+var generator = [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+
+// Encode returns the bech32-encoded LNURL for url, upper-cased as wallets
+// expect in QR codes. LNURLs ignore bech32's 90 character limit.
+func Encode(url string) string {
+	data := convertBits([]byte(url), 8, 5, true)
+	return strings.ToUpper(bech32Encode("lnurl", data))
+}
+
+// Decode returns the URL encoded in an LNURL string.
+func Decode(lnurl string) (string, error) {
+	s := strings.ToLower(strings.TrimPrefix(strings.ToLower(lnurl), "lightning:"))
+	sep := strings.LastIndexByte(s, '1')
+	if sep < 1 || sep+7 > len(s) {
+		return "", errors.New("lnurl: invalid bech32 string")
+	}
+	hrp, dataPart := s[:sep], s[sep+1:]
+	if hrp != "lnurl" {
+		return "", errors.New("lnurl: unexpected prefix " + hrp)
+	}
+
+	data := make([]byte, len(dataPart))
+	for i := range dataPart {
+		idx := strings.IndexByte(charset, dataPart[i])
+		if idx < 0 {
+			return "", errors.New("lnurl: invalid bech32 character")
+		}
+		data[i] = byte(idx)
+	}
+	if polymod(append(hrpExpand(hrp), data...)) != 1 {
+		return "", errors.New("lnurl: invalid bech32 checksum")
+	}
+
+	decoded := convertBits(data[:len(data)-6], 5, 8, false)
+	if decoded == nil {
+		return "", errors.New("lnurl: invalid bech32 padding")
+	}
+	return string(decoded), nil
+}
+
+func bech32Encode(hrp string, data []byte) string {
+	values := append(hrpExpand(hrp), data...)
+	values = append(values, 0, 0, 0, 0, 0, 0)
+	mod := polymod(values) ^ 1
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, b := range data {
+		sb.WriteByte(charset[b])
+	}
+	for i := range 6 {
+		sb.WriteByte(charset[(mod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+func polymod(values []byte) uint32 {
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := range 5 {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func hrpExpand(hrp string) []byte {
+	out := make([]byte, 0, len(hrp)*2+1)
+	for i := range len(hrp) {
+		out = append(out, hrp[i]>>5)
+	}
+	out = append(out, 0)
+	for i := range len(hrp) {
+		out = append(out, hrp[i]&31)
+	}
+	return out
+}
+
+// convertBits regroups data from fromBits-wide to toBits-wide values. It
+// returns nil if the input has invalid padding when pad is false.
+func convertBits(data []byte, fromBits, toBits uint, pad bool) []byte {
+	var acc, bits uint
+	maxv := uint(1)<<toBits - 1
+	out := make([]byte, 0, len(data)*int(fromBits)/int(toBits)+1)
+	for _, b := range data {
+		acc = acc<<fromBits | uint(b)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			out = append(out, byte((acc>>bits)&maxv))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			out = append(out, byte((acc<<(toBits-bits))&maxv))
+		}
+	} else if bits >= fromBits || (acc<<(toBits-bits))&maxv != 0 {
+		return nil
+	}
+	return out
+}
@@ -0,0 +1,80 @@
+// Package lnurl implements the parts of the LNURL protocol
+// (https://github.com/lnurl/luds) the server speaks: bech32 URL encoding
+// (LUD-01), withdraw requests (LUD-03) and pay requests (LUD-06).
+package lnurl
+
+import "encoding/json"
+
+// StatusResponse is the generic LNURL reply to a callback.
+type StatusResponse struct {
+	Status string `json:"status"`           // "OK" or "ERROR"
+	Reason string `json:"reason,omitempty"` // Set when Status is "ERROR"
+}
+
+// OK returns a successful status response.
+func OK() StatusResponse {
+	return StatusResponse{Status: "OK"}
+}
+
+// Error returns a failed status response with reason shown to the user.
+func Error(reason string) StatusResponse {
+	return StatusResponse{Status: "ERROR", Reason: reason}
+}
+
+// WithdrawRequest is the LUD-03 response that tells a wallet how much it may
+// pull and where to send its invoice.
+type WithdrawRequest struct {
+	Tag                string `json:"tag"` // Always "withdrawRequest"
+	Callback           string `json:"callback"`
+	K1                 string `json:"k1"`
+	DefaultDescription string `json:"defaultDescription"`
+	MinWithdrawable    int64  `json:"minWithdrawable"` // millisatoshis
+	MaxWithdrawable    int64  `json:"maxWithdrawable"` // millisatoshis
+}
+
+// NewWithdrawRequest builds a withdraw request for amounts between minSats
+// and maxSats.
+func NewWithdrawRequest(callback, k1, description string, minSats, maxSats int64) WithdrawRequest {
+	return WithdrawRequest{
+		Tag:                "withdrawRequest",
+		Callback:           callback,
+		K1:                 k1,
+		DefaultDescription: description,
+		MinWithdrawable:    minSats * 1000,
+		MaxWithdrawable:    maxSats * 1000,
+	}
+}
+
+// PayRequest is the LUD-06 response describing what a wallet may pay and
+// where to fetch the invoice.
+type PayRequest struct {
+	Tag         string `json:"tag"` // Always "payRequest"
+	Callback    string `json:"callback"`
+	MinSendable int64  `json:"minSendable"` // millisatoshis
+	MaxSendable int64  `json:"maxSendable"` // millisatoshis
+	Metadata    string `json:"metadata"`    // JSON array of [mime type, content] pairs
+}
+
+// NewPayRequest builds a pay request for amounts between minSats and maxSats,
+// described to the payer by description.
+func NewPayRequest(callback, description string, minSats, maxSats int64) PayRequest {
+	metadata, _ := json.Marshal([][2]string{{"text/plain", description}})
+	return PayRequest{
+		Tag:         "payRequest",
+		Callback:    callback,
+		MinSendable: minSats * 1000,
+		MaxSendable: maxSats * 1000,
+		Metadata:    string(metadata),
+	}
+}
+
+// PayResponse carries the invoice for an LNURL-pay callback.
+type PayResponse struct {
+	PR     string     `json:"pr"`
+	Routes []struct{} `json:"routes"` // Always empty; kept for older wallets
+}
+
+// NewPayResponse wraps a BOLT11 payment request.
+func NewPayResponse(paymentRequest string) PayResponse {
+	return PayResponse{PR: paymentRequest, Routes: []struct{}{}}
+}
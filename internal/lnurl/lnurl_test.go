@@ -0,0 +1,69 @@
+package lnurl
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	// Test vector from LUD-01
+	url := "https://service.com/api?q=3fc3645b439ce8e7f2553a69e5267081d96dcd340693afabe04be7b0ccd178df"
+	want := "LNURL1DP68GURN8GHJ7UM9WFMXJCM99E3K7MF0V9CXJ0M385EKVCENXC6R2C35XVUKXEFCV5MKVV34X5EKZD3EV56NYD3HXQURZEPEXEJXXEPNXSCRVWFNV9NXZCN9XQ6XYEFHVGCXXCMYXYMNSERXFQ5FNS"
+
+	if got := Encode(url); got != want {
+		t.Errorf("Encode = %s, want %s", got, want)
+	}
+
+	decoded, err := Decode(want)
+	if err != nil {
+		t.Fatalf("Decode failed: %v", err)
+	}
+	if decoded != url {
+		t.Errorf("Decode = %s, want %s", decoded, url)
+	}
+
+	if _, err := Decode("lightning:" + want); err != nil {
+		t.Errorf("expected lightning: prefix to be accepted, got %v", err)
+	}
+
+	corrupted := want[:len(want)-1] + "Q"
+	if _, err := Decode(corrupted); err == nil {
+		t.Error("expected checksum error for corrupted LNURL")
+	}
+}
+
+func TestNewWithdrawRequest(t *testing.T) {
+	req := NewWithdrawRequest("https://example.com/cb", "k1", "earnings", 1, 500)
+
+	data, _ := json.Marshal(req)
+	var got map[string]any
+	json.Unmarshal(data, &got)
+
+	if got["tag"] != "withdrawRequest" {
+		t.Errorf("unexpected tag %v", got["tag"])
+	}
+	if got["minWithdrawable"] != float64(1000) || got["maxWithdrawable"] != float64(500000) {
+		t.Errorf("expected msat bounds, got %v-%v", got["minWithdrawable"], got["maxWithdrawable"])
+	}
+}
+
+func TestNewPayRequest(t *testing.T) {
+	req := NewPayRequest("https://example.com/cb", `file "abc"`, 100, 100)
+
+	if req.Tag != "payRequest" || req.MinSendable != 100_000 || req.MaxSendable != 100_000 {
+		t.Errorf("unexpected pay request %+v", req)
+	}
+
+	var metadata [][]string
+	if err := json.Unmarshal([]byte(req.Metadata), &metadata); err != nil {
+		t.Fatalf("metadata is not valid JSON: %v", err)
+	}
+	if len(metadata) != 1 || metadata[0][0] != "text/plain" || metadata[0][1] != `file "abc"` {
+		t.Errorf("unexpected metadata %s", req.Metadata)
+	}
+
+	data, _ := json.Marshal(NewPayResponse("lnbc1"))
+	if string(data) != `{"pr":"lnbc1","routes":[]}` {
+		t.Errorf("unexpected pay response %s", data)
+	}
+}
@@ -6,8 +6,12 @@ import (
 )
 
 var (
-	B2       = log.New(os.Stdout, "[b2] ", log.LstdFlags)
+	S3       = log.New(os.Stdout, "[s3] ", log.LstdFlags)
 	Alby     = log.New(os.Stdout, "[alby] ", log.LstdFlags)
+	LND      = log.New(os.Stdout, "[lnd] ", log.LstdFlags)
+	CLN      = log.New(os.Stdout, "[cln] ", log.LstdFlags)
+	NWC      = log.New(os.Stdout, "[nwc] ", log.LstdFlags)
+	Cashu    = log.New(os.Stdout, "[cashu] ", log.LstdFlags)
 	Internal = log.New(os.Stdout, "[internal] ", log.LstdFlags)
 	HTTP     = log.New(os.Stdout, "[http] ", log.LstdFlags)
 )
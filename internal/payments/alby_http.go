@@ -7,28 +7,49 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"satoshisend/internal/logging"
+	"satoshisend/internal/store"
 )
 
 const albyAPIBase = "https://api.getalby.com"
 
+// albyInboxRetry is how often unprocessed inbox messages are retried.
+const albyInboxRetry = 30 * time.Second
+
+// ErrDuplicateWebhook is returned by HandleWebhook for a message ID that has
+// already been received. Senders should treat it as success.
+var ErrDuplicateWebhook = errors.New("duplicate webhook")
+
 // AlbyHTTPClient implements LNDClient using the Alby Wallet HTTP API.
 // This connects to Alby's custodial wallet service and uses webhooks for payment notifications.
 type AlbyHTTPClient struct {
 	accessToken   string
+	baseURL       string // albyAPIBase unless overridden in AlbyConfig
 	httpClient    *http.Client
 	webhookSecret string
+	inbox         store.WebhookInbox
+	inboxNotify   chan struct{}
+
+	// delivered records settled hashes already pushed to updates, so a
+	// webhook and a poll for the same invoice only settle it once
+	mu        sync.Mutex
+	delivered map[string]time.Time
 
 	updates chan InvoiceUpdate
 	done    chan struct{}
 }
 
+// albyDeliveredTTL is how long a delivered settlement is remembered for dedupe.
+const albyDeliveredTTL = time.Hour
+
 // Alby API request/response structures
 type albyCreateInvoiceRequest struct {
 	Amount      int64  `json:"amount"`
@@ -42,12 +63,20 @@ type albyInvoiceResponse struct {
 	Amount         int64  `json:"amount"`
 	Settled        bool   `json:"settled"`
 	SettledAt      string `json:"settled_at,omitempty"`
+	ExpiresAt      string `json:"expires_at,omitempty"`
 }
 
 // AlbyConfig holds configuration for the Alby HTTP client.
 type AlbyConfig struct {
 	AccessToken   string
-	WebhookSecret string // The SVIX webhook secret from your Alby webhook endpoint
+	WebhookSecret string            // The SVIX webhook secret from your Alby webhook endpoint
+	BaseURL       string            // API base URL; defaults to https://api.getalby.com
+	Timeout       time.Duration     // Per-request timeout; defaults to 30s
+	Transport     http.RoundTripper // Optional custom transport (proxies, test doubles)
+
+	// Inbox persists each verified webhook before it is acknowledged. If nil,
+	// webhooks are only delivered in memory and may be lost on overflow or crash.
+	Inbox store.WebhookInbox
 }
 
 // NewAlbyHTTPClient creates a new Alby HTTP API client with webhook support.
@@ -60,27 +89,53 @@ func NewAlbyHTTPClient(cfg AlbyConfig) (*AlbyHTTPClient, error) {
 		return nil, fmt.Errorf("webhook secret is required")
 	}
 
+	baseURL := strings.TrimRight(cfg.BaseURL, "/")
+	if baseURL == "" {
+		baseURL = albyAPIBase
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
 	c := &AlbyHTTPClient{
 		accessToken:   cfg.AccessToken,
+		baseURL:       baseURL,
 		webhookSecret: cfg.WebhookSecret,
+		inbox:         cfg.Inbox,
+		inboxNotify:   make(chan struct{}, 1),
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   timeout,
+			Transport: cfg.Transport,
 		},
-		updates: make(chan InvoiceUpdate, 1000),
-		done:    make(chan struct{}),
+		delivered: make(map[string]time.Time),
+		updates:   make(chan InvoiceUpdate, 1000),
+		done:      make(chan struct{}),
 	}
 
 	// Test the connection
 	if err := c.testConnection(); err != nil {
 		return nil, fmt.Errorf("failed to connect to Alby: %w", err)
 	}
-	logging.Alby.Println("connected")
+	if baseURL != albyAPIBase {
+		logging.Alby.Printf("connected to %s", baseURL)
+	} else {
+		logging.Alby.Println("connected")
+	}
 
 	return c, nil
 }
 
+// apiURL returns the full URL for an Alby API path.
+func (c *AlbyHTTPClient) apiURL(path string) string {
+	if c.baseURL == "" {
+		return albyAPIBase + path
+	}
+	return c.baseURL + path
+}
+
 func (c *AlbyHTTPClient) testConnection() error {
-	req, err := http.NewRequest("GET", albyAPIBase+"/balance", nil)
+	req, err := http.NewRequest("GET", c.apiURL("/balance"), nil)
 	if err != nil {
 		return err
 	}
@@ -111,7 +166,7 @@ func (c *AlbyHTTPClient) CreateInvoice(ctx context.Context, amountSats int64, me
 		return nil, fmt.Errorf("failed to marshal request: %w", err)
 	}
 
-	req, err := http.NewRequestWithContext(ctx, "POST", albyAPIBase+"/invoices", bytes.NewReader(jsonBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL("/invoices"), bytes.NewReader(jsonBody))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -139,16 +194,247 @@ func (c *AlbyHTTPClient) CreateInvoice(ctx context.Context, amountSats int64, me
 		PaymentRequest: albyResp.PaymentRequest,
 		AmountSats:     amountSats,
 	}
+	if albyResp.ExpiresAt != "" {
+		if expiresAt, err := time.Parse(time.RFC3339, albyResp.ExpiresAt); err == nil {
+			inv.ExpiresAt = expiresAt
+		}
+	}
 
-	logging.Alby.Printf("created invoice %s for %d sats", albyResp.PaymentHash[:16], amountSats)
+	logging.Alby.Printf("created invoice %s for %d sats", ShortHash(albyResp.PaymentHash), amountSats)
 
 	return inv, nil
 }
 
+type albyPayInvoiceRequest struct {
+	Invoice string `json:"invoice"`
+}
+
+type albyPaymentResponse struct {
+	Amount          int64  `json:"amount"`
+	Fee             int64  `json:"fee"`
+	PaymentHash     string `json:"payment_hash"`
+	PaymentPreimage string `json:"payment_preimage"`
+}
+
+// PayInvoice pays a BOLT11 invoice from the Alby account. The API applies its
+// own fee limit, so maxFeeSats is not sent.
+func (c *AlbyHTTPClient) PayInvoice(ctx context.Context, paymentRequest string, maxFeeSats int64) (*Payment, error) {
+	jsonBody, err := json.Marshal(albyPayInvoiceRequest{Invoice: paymentRequest})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.apiURL("/payments/bolt11"), bytes.NewReader(jsonBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var albyResp albyPaymentResponse
+	if err := json.NewDecoder(resp.Body).Decode(&albyResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	logging.Alby.Printf("paid invoice %s (%d sats, %d sats fees)", ShortHash(albyResp.PaymentHash), albyResp.Amount, albyResp.Fee)
+
+	return &Payment{
+		PaymentHash: albyResp.PaymentHash,
+		Preimage:    albyResp.PaymentPreimage,
+		AmountSats:  albyResp.Amount,
+		FeeSats:     albyResp.Fee,
+	}, nil
+}
+
+func (c *AlbyHTTPClient) LookupInvoice(ctx context.Context, paymentHash string) (*InvoiceUpdate, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.apiURL("/invoices/"+paymentHash), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrInvoiceNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var albyResp albyInvoiceResponse
+	if err := json.NewDecoder(resp.Body).Decode(&albyResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return &InvoiceUpdate{
+		PaymentHash: paymentHash,
+		Settled:     albyResp.Settled,
+	}, nil
+}
+
 func (c *AlbyHTTPClient) SubscribeInvoices(ctx context.Context) (<-chan InvoiceUpdate, error) {
+	if c.inbox != nil {
+		go c.inboxLoop(ctx)
+	}
 	return c.updates, nil
 }
 
+// StartPoller periodically looks up the invoices returned by pending (typically
+// payments.Service.PendingPaymentHashes) and pushes settled ones into the
+// updates channel. It is a fallback for when webhooks are not being delivered.
+func (c *AlbyHTTPClient) StartPoller(ctx context.Context, interval time.Duration, pending func() []string) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-c.done:
+				return
+			case <-ticker.C:
+				c.poll(ctx, pending())
+			}
+		}
+	}()
+}
+
+// poll looks up each hash once and emits settlements for paid invoices.
+func (c *AlbyHTTPClient) poll(ctx context.Context, hashes []string) {
+	c.pruneDelivered()
+
+	for _, hash := range hashes {
+		if ctx.Err() != nil {
+			return
+		}
+		if c.wasDelivered(hash) {
+			continue
+		}
+
+		update, err := c.LookupInvoice(ctx, hash)
+		if err != nil {
+			if ctx.Err() == nil {
+				logging.Alby.Printf("poll: failed to look up invoice %s: %v", ShortHash(hash), err)
+			}
+			continue
+		}
+		if update.Settled && c.emit(hash) {
+			logging.Alby.Printf("invoice %s settled (found by poll)", ShortHash(hash))
+		}
+	}
+}
+
+// emit pushes a settlement for hash into the updates channel unless one has
+// already been delivered. It reports whether an update was sent.
+func (c *AlbyHTTPClient) emit(hash string) bool {
+	if !c.claim(hash) {
+		return false
+	}
+
+	select {
+	case c.updates <- InvoiceUpdate{
+		PaymentHash: hash,
+		Settled:     true,
+	}:
+		return true
+	default:
+		// Forget the hash so a later webhook or poll can retry delivery
+		c.unclaim(hash)
+		logging.Alby.Printf("WARNING: update channel full, payment %s may be delayed", ShortHash(hash))
+		return false
+	}
+}
+
+// deliver is like emit but waits for room in the updates channel and then for
+// the consumer to acknowledge the update. It returns false only if it gave up
+// because the client or ctx was shut down.
+func (c *AlbyHTTPClient) deliver(ctx context.Context, hash string) bool {
+	if !c.claim(hash) {
+		return true
+	}
+
+	handled := make(chan struct{})
+	var once sync.Once
+	select {
+	case c.updates <- InvoiceUpdate{
+		PaymentHash: hash,
+		Settled:     true,
+		Ack:         func() { once.Do(func() { close(handled) }) },
+	}:
+		select {
+		case <-handled:
+			return true
+		case <-ctx.Done():
+		case <-c.done:
+		}
+		// Handed over but not confirmed; leave the message for a replay
+		return false
+	case <-ctx.Done():
+	case <-c.done:
+	}
+	c.unclaim(hash)
+	return false
+}
+
+// claim records hash as delivered, reporting false if it already was.
+func (c *AlbyHTTPClient) claim(hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.delivered == nil {
+		c.delivered = make(map[string]time.Time)
+	}
+	if _, ok := c.delivered[hash]; ok {
+		return false
+	}
+	c.delivered[hash] = time.Now()
+	return true
+}
+
+func (c *AlbyHTTPClient) unclaim(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.delivered, hash)
+}
+
+func (c *AlbyHTTPClient) wasDelivered(hash string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.delivered[hash]
+	return ok
+}
+
+func (c *AlbyHTTPClient) pruneDelivered() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cutoff := time.Now().Add(-albyDeliveredTTL)
+	for hash, at := range c.delivered {
+		if at.Before(cutoff) {
+			delete(c.delivered, hash)
+		}
+	}
+}
+
+func (c *AlbyHTTPClient) BackendName() string {
+	return "alby"
+}
+
 func (c *AlbyHTTPClient) Close() error {
 	close(c.done)
 	return nil
@@ -176,22 +462,82 @@ func (c *AlbyHTTPClient) HandleWebhook(body []byte, headers http.Header) error {
 		return fmt.Errorf("failed to parse payload: %w", err)
 	}
 
-	if payload.Settled && payload.PaymentHash != "" {
-		logging.Alby.Printf("invoice %s settled", payload.PaymentHash[:16])
-
+	if c.inbox != nil {
+		// Persist before acknowledging; the inbox worker delivers the update
+		msg := &store.WebhookMessage{
+			ID:         headers.Get("svix-id"),
+			Payload:    body,
+			ReceivedAt: time.Now(),
+		}
+		if err := c.inbox.SaveWebhook(context.Background(), msg); err != nil {
+			if errors.Is(err, store.ErrDuplicate) {
+				return ErrDuplicateWebhook
+			}
+			return fmt.Errorf("failed to persist webhook: %w", err)
+		}
 		select {
-		case c.updates <- InvoiceUpdate{
-			PaymentHash: payload.PaymentHash,
-			Settled:     true,
-		}:
+		case c.inboxNotify <- struct{}{}:
 		default:
-			logging.Alby.Printf("WARNING: update channel full, payment %s may be delayed", payload.PaymentHash[:16])
+		}
+		return nil
+	}
+
+	if payload.Settled && payload.PaymentHash != "" {
+		if c.emit(payload.PaymentHash) {
+			logging.Alby.Printf("invoice %s settled", ShortHash(payload.PaymentHash))
 		}
 	}
 
 	return nil
 }
 
+// inboxLoop delivers persisted webhooks, replaying any left unprocessed by a
+// previous run, and retries periodically in case a delivery was interrupted.
+func (c *AlbyHTTPClient) inboxLoop(ctx context.Context) {
+	ticker := time.NewTicker(albyInboxRetry)
+	defer ticker.Stop()
+
+	for {
+		c.processInbox(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.done:
+			return
+		case <-c.inboxNotify:
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *AlbyHTTPClient) processInbox(ctx context.Context) {
+	msgs, err := c.inbox.ListUnprocessedWebhooks(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			logging.Alby.Printf("failed to read webhook inbox: %v", err)
+		}
+		return
+	}
+
+	for _, msg := range msgs {
+		var payload AlbyWebhookPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			// Verified when received, so this can't be fixed by retrying
+			logging.Alby.Printf("dropping unparseable inbox message %s: %v", msg.ID, err)
+		} else if payload.Settled && payload.PaymentHash != "" {
+			if !c.deliver(ctx, payload.PaymentHash) {
+				return
+			}
+			logging.Alby.Printf("invoice %s settled", ShortHash(payload.PaymentHash))
+		}
+
+		if err := c.inbox.MarkWebhookProcessed(ctx, msg.ID); err != nil {
+			logging.Alby.Printf("failed to mark webhook %s processed: %v", msg.ID, err)
+		}
+	}
+}
+
 // verifyWebhookSignature verifies the SVIX signature on a webhook request.
 // SVIX signs webhooks using HMAC-SHA256.
 func (c *AlbyHTTPClient) verifyWebhookSignature(body []byte, headers http.Header) error {
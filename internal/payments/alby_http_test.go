@@ -1,13 +1,22 @@
 package payments
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"satoshisend/internal/payments/albyfake"
+	"satoshisend/internal/store"
 )
 
 func TestParseTimestamp(t *testing.T) {
@@ -251,3 +260,351 @@ func TestSubscribeInvoices(t *testing.T) {
 		t.Error("expected returned channel to be the updates channel")
 	}
 }
+
+func TestPoller(t *testing.T) {
+	const paidHash = "paid0000000000000000000000000000"
+	const openHash = "open0000000000000000000000000000"
+
+	var mu sync.Mutex
+	lookups := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hash := strings.TrimPrefix(r.URL.Path, "/invoices/")
+		mu.Lock()
+		lookups[hash]++
+		mu.Unlock()
+		switch hash {
+		case paidHash, openHash:
+			json.NewEncoder(w).Encode(albyInvoiceResponse{PaymentHash: hash, Settled: hash == paidHash})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	secret := base64.StdEncoding.EncodeToString([]byte("test-secret-key-1234"))
+	client := &AlbyHTTPClient{
+		baseURL:       srv.URL,
+		httpClient:    srv.Client(),
+		webhookSecret: "whsec_" + secret,
+		updates:       make(chan InvoiceUpdate, 10),
+		done:          make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client.StartPoller(ctx, 10*time.Millisecond, func() []string {
+		return []string{paidHash, openHash, "unknown000000000000000000000000"}
+	})
+
+	select {
+	case update := <-client.updates:
+		if update.PaymentHash != paidHash || !update.Settled {
+			t.Errorf("unexpected update %+v", update)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for polled settlement")
+	}
+
+	// A late webhook for the same invoice must not settle it twice
+	body := `{"payment_hash":"` + paidHash + `","settled":true}`
+	timestamp := fmt.Sprintf("%d", time.Now().Unix())
+	secretBytes, _ := base64.StdEncoding.DecodeString(secret)
+	mac := hmac.New(sha256.New, secretBytes)
+	mac.Write([]byte("msg_late." + timestamp + "." + body))
+	headers := http.Header{}
+	headers.Set("svix-id", "msg_late")
+	headers.Set("svix-timestamp", timestamp)
+	headers.Set("svix-signature", "v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	if err := client.HandleWebhook([]byte(body), headers); err != nil {
+		t.Fatalf("HandleWebhook failed: %v", err)
+	}
+
+	// Let a few more poll rounds run
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case update := <-client.updates:
+		t.Errorf("expected no duplicate update, got %+v", update)
+	default:
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if lookups[paidHash] != 1 {
+		t.Errorf("expected delivered invoice to be looked up once, got %d", lookups[paidHash])
+	}
+	if lookups[openHash] < 2 {
+		t.Errorf("expected open invoice to be polled repeatedly, got %d", lookups[openHash])
+	}
+}
+
+func TestPollerStopsOnClose(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	client := &AlbyHTTPClient{
+		updates: make(chan InvoiceUpdate, 10),
+		done:    make(chan struct{}),
+	}
+	client.StartPoller(context.Background(), 5*time.Millisecond, func() []string {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+		return nil
+	})
+
+	time.Sleep(30 * time.Millisecond)
+	client.Close()
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	after := calls
+	mu.Unlock()
+
+	time.Sleep(30 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != after {
+		t.Errorf("poller kept running after Close (%d -> %d calls)", after, calls)
+	}
+}
+
+// countingTransport counts requests passing through it.
+type countingTransport struct {
+	mu    sync.Mutex
+	count int
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	c.count++
+	c.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestAlbyHTTPClient_AgainstFake(t *testing.T) {
+	fake, err := albyfake.New(albyfake.Config{})
+	if err != nil {
+		t.Fatalf("albyfake.New failed: %v", err)
+	}
+	defer fake.Close()
+
+	transport := &countingTransport{}
+	client, err := NewAlbyHTTPClient(AlbyConfig{
+		AccessToken:   fake.Token(),
+		WebhookSecret: fake.WebhookSecret(),
+		BaseURL:       fake.URL + "/",
+		Timeout:       5 * time.Second,
+		Transport:     transport,
+	})
+	if err != nil {
+		t.Fatalf("NewAlbyHTTPClient failed: %v", err)
+	}
+	defer client.Close()
+
+	// Deliver the fake's webhooks straight to the client
+	webhooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if err := client.HandleWebhook(body, r.Header); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	}))
+	defer webhooks.Close()
+	fake.SetWebhookURL(webhooks.URL)
+
+	ctx := context.Background()
+	updates, _ := client.SubscribeInvoices(ctx)
+
+	inv, err := client.CreateInvoice(ctx, 2100, "test")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+	if got := fake.Invoices(); len(got) != 1 || got[0].Amount != 2100 {
+		t.Fatalf("unexpected invoices on fake: %+v", got)
+	}
+
+	lookup, err := client.LookupInvoice(ctx, inv.PaymentHash)
+	if err != nil {
+		t.Fatalf("LookupInvoice failed: %v", err)
+	}
+	if lookup.Settled {
+		t.Error("expected new invoice to be unsettled")
+	}
+
+	if err := fake.Settle(inv.PaymentHash); err != nil {
+		t.Fatalf("Settle failed: %v", err)
+	}
+
+	select {
+	case update := <-updates:
+		if update.PaymentHash != inv.PaymentHash || !update.Settled {
+			t.Errorf("unexpected update %+v", update)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for webhook settlement")
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	if transport.count < 3 {
+		t.Errorf("expected requests to go through custom transport, got %d", transport.count)
+	}
+}
+
+func TestNewAlbyHTTPClient_RejectedToken(t *testing.T) {
+	fake, err := albyfake.New(albyfake.Config{})
+	if err != nil {
+		t.Fatalf("albyfake.New failed: %v", err)
+	}
+	defer fake.Close()
+
+	_, err = NewAlbyHTTPClient(AlbyConfig{
+		AccessToken:   "wrong",
+		WebhookSecret: fake.WebhookSecret(),
+		BaseURL:       fake.URL,
+	})
+	if err == nil {
+		t.Error("expected error for rejected token")
+	}
+}
+
+// memInbox is an in-memory store.WebhookInbox.
+type memInbox struct {
+	mu   sync.Mutex
+	msgs []*store.WebhookMessage
+}
+
+func (m *memInbox) SaveWebhook(ctx context.Context, msg *store.WebhookMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, existing := range m.msgs {
+		if existing.ID == msg.ID {
+			return store.ErrDuplicate
+		}
+	}
+	copied := *msg
+	m.msgs = append(m.msgs, &copied)
+	return nil
+}
+
+func (m *memInbox) ListUnprocessedWebhooks(ctx context.Context) ([]*store.WebhookMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var result []*store.WebhookMessage
+	for _, msg := range m.msgs {
+		if !msg.Processed {
+			copied := *msg
+			result = append(result, &copied)
+		}
+	}
+	return result, nil
+}
+
+func (m *memInbox) MarkWebhookProcessed(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, msg := range m.msgs {
+		if msg.ID == id {
+			msg.Processed = true
+			return nil
+		}
+	}
+	return store.ErrNotFound
+}
+
+func (m *memInbox) unprocessed() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, msg := range m.msgs {
+		if !msg.Processed {
+			n++
+		}
+	}
+	return n
+}
+
+func TestHandleWebhook_Inbox(t *testing.T) {
+	secret := "whsec_" + base64.StdEncoding.EncodeToString([]byte("test-secret-key-1234"))
+	inbox := &memInbox{}
+	// A single-slot channel: without the inbox the second webhook would be dropped
+	client := &AlbyHTTPClient{
+		webhookSecret: secret,
+		inbox:         inbox,
+		inboxNotify:   make(chan struct{}, 1),
+		updates:       make(chan InvoiceUpdate, 1),
+		done:          make(chan struct{}),
+	}
+
+	send := func(id, hash string) error {
+		body := []byte(`{"payment_hash":"` + hash + `","settled":true}`)
+		ts := time.Now()
+		headers := http.Header{}
+		headers.Set("svix-id", id)
+		headers.Set("svix-timestamp", fmt.Sprintf("%d", ts.Unix()))
+		headers.Set("svix-signature", albyfake.Sign(secret, id, ts, body))
+		return client.HandleWebhook(body, headers)
+	}
+
+	// Received before the worker runs, e.g. just before a crash
+	if err := send("msg_1", "hash1000000000000000"); err != nil {
+		t.Fatalf("HandleWebhook failed: %v", err)
+	}
+	if err := send("msg_2", "hash2000000000000000"); err != nil {
+		t.Fatalf("HandleWebhook failed: %v", err)
+	}
+	if err := send("msg_1", "hash1000000000000000"); err != ErrDuplicateWebhook {
+		t.Errorf("expected ErrDuplicateWebhook for replayed svix-id, got %v", err)
+	}
+
+	select {
+	case update := <-client.updates:
+		t.Fatalf("expected no delivery before the worker starts, got %+v", update)
+	default:
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, _ := client.SubscribeInvoices(ctx)
+
+	var got []string
+	for len(got) < 2 {
+		select {
+		case update := <-updates:
+			got = append(got, update.PaymentHash)
+			// Nothing may be marked processed until the consumer has settled it
+			time.Sleep(20 * time.Millisecond)
+			if n := inbox.unprocessed(); n != 3-len(got) {
+				t.Errorf("expected %d unprocessed messages before ack, got %d", 3-len(got), n)
+			}
+			if update.Ack == nil {
+				t.Fatal("expected inbox updates to carry an Ack")
+			}
+			update.Ack()
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for inbox delivery, got %v", got)
+		}
+	}
+	if got[0] != "hash1000000000000000" || got[1] != "hash2000000000000000" {
+		t.Errorf("expected inbox order to be preserved, got %v", got)
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for inbox.unprocessed() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := inbox.unprocessed(); n != 0 {
+		t.Errorf("expected all messages processed, %d remain", n)
+	}
+
+	// New webhooks are picked up without waiting for the retry ticker
+	if err := send("msg_3", "hash3000000000000000"); err != nil {
+		t.Fatalf("HandleWebhook failed: %v", err)
+	}
+	select {
+	case update := <-updates:
+		if update.PaymentHash != "hash3000000000000000" {
+			t.Errorf("unexpected update %+v", update)
+		}
+		update.Ack()
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for live inbox delivery")
+	}
+}
@@ -0,0 +1,330 @@
+// Package albyfake provides an in-memory stand-in for the Alby Wallet API.
+//
+// It implements the endpoints AlbyHTTPClient uses (GET /balance, POST
+// /invoices, GET /invoices/{hash}) and delivers Svix-signed webhooks when an
+// invoice is settled, so the Alby backend can be exercised end to end in
+// tests and local development without a real wallet.
+package albyfake
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// InvoiceExpiry is the lifetime of invoices issued by the fake, matching Alby's default.
+const InvoiceExpiry = 24 * time.Hour
+
+// ErrUnknownInvoice is returned by Settle for hashes the fake never issued.
+var ErrUnknownInvoice = errors.New("unknown invoice")
+
+// Config configures a fake Alby server. All fields are optional.
+type Config struct {
+	Addr          string // Listen address, e.g. "127.0.0.1:8081"; a random port if empty
+	Token         string // Accepted bearer token; defaults to "test-token"
+	WebhookSecret string // Svix secret ("whsec_..."); generated if empty
+	WebhookURL    string // Where settlement webhooks are POSTed; none are sent if empty
+}
+
+// Invoice is an invoice issued by the fake.
+type Invoice struct {
+	PaymentHash    string
+	PaymentRequest string
+	Amount         int64
+	Description    string
+	Settled        bool
+	SettledAt      time.Time
+	ExpiresAt      time.Time
+}
+
+// Server is a running fake Alby API.
+type Server struct {
+	URL string
+
+	srv           *httptest.Server
+	token         string
+	webhookSecret string
+	client        *http.Client
+
+	mu         sync.Mutex
+	webhookURL string
+	invoices   map[string]*Invoice
+	order      []string
+	msgSeq     int
+}
+
+// New starts a fake Alby server.
+func New(cfg Config) (*Server, error) {
+	s := &Server{
+		token:         cfg.Token,
+		webhookSecret: cfg.WebhookSecret,
+		webhookURL:    cfg.WebhookURL,
+		client:        &http.Client{Timeout: 10 * time.Second},
+		invoices:      make(map[string]*Invoice),
+	}
+	if s.token == "" {
+		s.token = "test-token"
+	}
+	if s.webhookSecret == "" {
+		secret := make([]byte, 24)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		s.webhookSecret = "whsec_" + base64.StdEncoding.EncodeToString(secret)
+	}
+
+	s.srv = httptest.NewUnstartedServer(s.routes())
+	if cfg.Addr != "" {
+		l, err := net.Listen("tcp", cfg.Addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", cfg.Addr, err)
+		}
+		s.srv.Listener.Close()
+		s.srv.Listener = l
+	}
+	s.srv.Start()
+	s.URL = s.srv.URL
+
+	return s, nil
+}
+
+// Close shuts the server down.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Token returns the bearer token the fake accepts.
+func (s *Server) Token() string {
+	return s.token
+}
+
+// WebhookSecret returns the Svix secret used to sign webhooks.
+func (s *Server) WebhookSecret() string {
+	return s.webhookSecret
+}
+
+// SetWebhookURL changes where settlement webhooks are delivered.
+func (s *Server) SetWebhookURL(url string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.webhookURL = url
+}
+
+// Invoices returns a snapshot of all issued invoices in creation order.
+func (s *Server) Invoices() []Invoice {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := make([]Invoice, 0, len(s.order))
+	for _, hash := range s.order {
+		result = append(result, *s.invoices[hash])
+	}
+	return result
+}
+
+// Settle marks an invoice as paid and, if a webhook URL is configured,
+// delivers a signed settlement webhook to it.
+func (s *Server) Settle(paymentHash string) error {
+	s.mu.Lock()
+	inv, ok := s.invoices[paymentHash]
+	if !ok {
+		s.mu.Unlock()
+		return ErrUnknownInvoice
+	}
+	if !inv.Settled {
+		inv.Settled = true
+		inv.SettledAt = time.Now()
+	}
+	payload := webhookPayload{
+		Amount:      inv.Amount,
+		Settled:     true,
+		SettledAt:   inv.SettledAt.UTC().Format(time.RFC3339),
+		Type:        "incoming",
+		PaymentHash: inv.PaymentHash,
+	}
+	webhookURL := s.webhookURL
+	s.msgSeq++
+	msgID := fmt.Sprintf("msg_fake%d", s.msgSeq)
+	s.mu.Unlock()
+
+	if webhookURL == "" {
+		return nil
+	}
+	return s.sendWebhook(webhookURL, msgID, payload)
+}
+
+func (s *Server) sendWebhook(url, msgID string, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	ts := time.Now()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("svix-id", msgID)
+	req.Header.Set("svix-timestamp", fmt.Sprintf("%d", ts.Unix()))
+	req.Header.Set("svix-signature", Sign(s.webhookSecret, msgID, ts, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook delivery failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Sign returns the svix-signature header value for a webhook body.
+func Sign(secret, msgID string, ts time.Time, body []byte) string {
+	key, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%s.%d.%s", msgID, ts.Unix(), body)
+	return "v1," + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type webhookPayload struct {
+	Amount      int64  `json:"amount"`
+	Settled     bool   `json:"settled"`
+	SettledAt   string `json:"settled_at,omitempty"`
+	Type        string `json:"type"`
+	PaymentHash string `json:"payment_hash"`
+}
+
+type invoiceJSON struct {
+	PaymentHash    string `json:"payment_hash"`
+	PaymentRequest string `json:"payment_request"`
+	Amount         int64  `json:"amount"`
+	Settled        bool   `json:"settled"`
+	SettledAt      string `json:"settled_at,omitempty"`
+	ExpiresAt      string `json:"expires_at"`
+}
+
+func (inv *Invoice) toJSON() invoiceJSON {
+	out := invoiceJSON{
+		PaymentHash:    inv.PaymentHash,
+		PaymentRequest: inv.PaymentRequest,
+		Amount:         inv.Amount,
+		Settled:        inv.Settled,
+		ExpiresAt:      inv.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+	if inv.Settled {
+		out.SettledAt = inv.SettledAt.UTC().Format(time.RFC3339)
+	}
+	return out
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /balance", s.handleBalance)
+	mux.HandleFunc("POST /invoices", s.handleCreateInvoice)
+	mux.HandleFunc("GET /invoices/{hash}", s.handleGetInvoice)
+	// Not part of the Alby API: lets local dev trigger a payment with curl
+	mux.HandleFunc("POST /_fake/settle/{hash}", s.handleSettle)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, "/_fake/") && r.Header.Get("Authorization") != "Bearer "+s.token {
+			writeJSON(w, http.StatusUnauthorized, map[string]any{"error": true, "message": "invalid token"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) handleBalance(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"balance": 0, "currency": "BTC", "unit": "sat"})
+}
+
+func (s *Server) handleCreateInvoice(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Amount      int64  `json:"amount"`
+		Description string `json:"description"`
+		Memo        string `json:"memo"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": true, "message": "invalid body"})
+		return
+	}
+	if req.Amount <= 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": true, "message": "amount must be positive"})
+		return
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": true, "message": err.Error()})
+		return
+	}
+	hash := hex.EncodeToString(raw)
+	description := req.Description
+	if description == "" {
+		description = req.Memo
+	}
+	inv := &Invoice{
+		PaymentHash:    hash,
+		PaymentRequest: fmt.Sprintf("lnbc%dn1fake%s", req.Amount*10, hash[:20]),
+		Amount:         req.Amount,
+		Description:    description,
+		ExpiresAt:      time.Now().Add(InvoiceExpiry),
+	}
+
+	s.mu.Lock()
+	s.invoices[hash] = inv
+	s.order = append(s.order, hash)
+	out := inv.toJSON()
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusCreated, out)
+}
+
+func (s *Server) handleGetInvoice(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	inv, ok := s.invoices[r.PathValue("hash")]
+	var out invoiceJSON
+	if ok {
+		out = inv.toJSON()
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": true, "message": "invoice not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *Server) handleSettle(w http.ResponseWriter, r *http.Request) {
+	err := s.Settle(r.PathValue("hash"))
+	switch {
+	case errors.Is(err, ErrUnknownInvoice):
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": true, "message": err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadGateway, map[string]any{"error": true, "message": err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"settled": true})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,136 @@
+package payments
+
+import (
+	"encoding/hex"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrInvoiceNoAmount is returned for invoices that leave the amount to the payer.
+var ErrInvoiceNoAmount = errors.New("invoice has no amount")
+
+// bolt11Multipliers converts a BOLT11 amount multiplier to millisatoshis per unit.
+// The pico multiplier is handled separately since it is a tenth of a millisat.
+var bolt11Multipliers = map[byte]int64{
+	'm': 100_000_000,
+	'u': 100_000,
+	'n': 100,
+}
+
+// InvoiceAmountMsat returns the amount encoded in a BOLT11 payment request's
+// human-readable part, in millisatoshis. The signature is not checked; the
+// paying backend validates the invoice itself.
+func InvoiceAmountMsat(paymentRequest string) (int64, error) {
+	pr := strings.ToLower(strings.TrimSpace(paymentRequest))
+	pr = strings.TrimPrefix(pr, "lightning:")
+
+	sep := strings.LastIndexByte(pr, '1')
+	if !strings.HasPrefix(pr, "ln") || sep < 0 {
+		return 0, errors.New("not a BOLT11 invoice")
+	}
+	hrp := pr[2:sep]
+
+	// Skip the currency prefix (bc, tb, bcrt, ...) to reach the amount
+	start := strings.IndexAny(hrp, "0123456789")
+	if start < 0 {
+		return 0, ErrInvoiceNoAmount
+	}
+	amount := hrp[start:]
+
+	multiplier := amount[len(amount)-1]
+	if multiplier >= '0' && multiplier <= '9' {
+		multiplier = 0
+	} else {
+		amount = amount[:len(amount)-1]
+	}
+
+	value, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, errors.New("invalid invoice amount")
+	}
+
+	switch multiplier {
+	case 0:
+		if value > math.MaxInt64/100_000_000_000 {
+			return 0, errors.New("invalid invoice amount")
+		}
+		return value * 100_000_000_000, nil // whole bitcoin
+	case 'p':
+		if value%10 != 0 {
+			return 0, errors.New("invalid invoice amount")
+		}
+		return value / 10, nil
+	default:
+		perUnit, ok := bolt11Multipliers[multiplier]
+		if !ok {
+			return 0, errors.New("invalid invoice amount multiplier")
+		}
+		if value > math.MaxInt64/perUnit {
+			return 0, errors.New("invalid invoice amount")
+		}
+		return value * perUnit, nil
+	}
+}
+
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// BOLT11 data part layout, in 5-bit groups: a timestamp, tagged fields, then
+// the signature and bech32 checksum.
+const (
+	bolt11TimestampLen = 7
+	bolt11SignatureLen = 104
+	bech32ChecksumLen  = 6
+)
+
+// InvoicePaymentHash returns the hex payment hash from a BOLT11 payment
+// request's tagged fields. As with InvoiceAmountMsat, neither the signature
+// nor the checksum is checked.
+func InvoicePaymentHash(paymentRequest string) (string, error) {
+	pr := strings.ToLower(strings.TrimSpace(paymentRequest))
+	pr = strings.TrimPrefix(pr, "lightning:")
+
+	sep := strings.LastIndexByte(pr, '1')
+	if !strings.HasPrefix(pr, "ln") || sep < 0 {
+		return "", errors.New("not a BOLT11 invoice")
+	}
+	data := pr[sep+1:]
+	if len(data) < bolt11TimestampLen+bolt11SignatureLen+bech32ChecksumLen {
+		return "", errors.New("invoice too short")
+	}
+
+	values := make([]byte, len(data))
+	for i := range data {
+		idx := strings.IndexByte(bech32Charset, data[i])
+		if idx < 0 {
+			return "", errors.New("invalid bech32 character")
+		}
+		values[i] = byte(idx)
+	}
+
+	fields := values[bolt11TimestampLen : len(values)-bolt11SignatureLen-bech32ChecksumLen]
+	for len(fields) >= 3 {
+		tag, n := fields[0], int(fields[1])<<5|int(fields[2])
+		fields = fields[3:]
+		if n > len(fields) {
+			return "", errors.New("invalid invoice field")
+		}
+		// 'p' is 1 in the bech32 alphabet; 52 groups hold a 256-bit hash
+		if tag == 1 && n == 52 {
+			var acc, bits uint
+			hash := make([]byte, 0, 32)
+			for _, v := range fields[:n] {
+				acc = (acc<<5 | uint(v)) & 0xfff
+				bits += 5
+				if bits >= 8 && len(hash) < 32 {
+					bits -= 8
+					hash = append(hash, byte(acc>>bits))
+				}
+			}
+			return hex.EncodeToString(hash), nil
+		}
+		fields = fields[n:]
+	}
+	return "", errors.New("invoice has no payment hash")
+}
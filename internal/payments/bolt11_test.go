@@ -0,0 +1,80 @@
+package payments
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInvoiceAmountMsat(t *testing.T) {
+	tests := []struct {
+		pr      string
+		want    int64
+		wantErr bool
+	}{
+		{"lnbc2500u1pvjluezpp5qqqsyq", 250_000_000, false},
+		{"lnbc20m1pvjluezpp5qqqsyq", 2_000_000_000, false},
+		{"lnbcrt500n1pjq8x", 50_000, false},
+		{"lntb1500n1xyz", 150_000, false},
+		{"lnbc10p1abc", 1, false},
+		{"LIGHTNING:LNBC1U1ABC", 100_000, false},
+		{"lnbc11p1abc", 0, true},     // sub-millisat amount
+		{"lnbc1pvjluezpp5", 0, true}, // no amount
+		{"lnbc5x1abc", 0, true},      // unknown multiplier
+		{"bitcoin:abc", 0, true},
+	}
+
+	for _, tc := range tests {
+		got, err := InvoiceAmountMsat(tc.pr)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("InvoiceAmountMsat(%q) = %d, expected error", tc.pr, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("InvoiceAmountMsat(%q) failed: %v", tc.pr, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("InvoiceAmountMsat(%q) = %d, want %d", tc.pr, got, tc.want)
+		}
+	}
+
+	if _, err := InvoiceAmountMsat("lnbc1pvjluezpp5"); err != ErrInvoiceNoAmount {
+		t.Errorf("expected ErrInvoiceNoAmount, got %v", err)
+	}
+}
+
+func TestInvoiceAmountMsat_Overflow(t *testing.T) {
+	for _, pr := range []string{"lnbc922337211abc", "lnbc92233720369m1abc", "lnbc92233720368548u1abc", "lnbc92233720368547759n1abc"} {
+		if got, err := InvoiceAmountMsat(pr); err == nil {
+			t.Errorf("InvoiceAmountMsat(%q) = %d, expected overflow error", pr, got)
+		}
+	}
+}
+
+func TestInvoicePaymentHash(t *testing.T) {
+	// The donation example from BOLT 11
+	pr := "lnbc1pvjluezpp5qqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqqqsyqcyq5rqwzqfqypqdpl2pkx2ctnv5sxxmmwwd5kgetjypeh2ursdae8g6twvus8g6rfwvs8qun0dfjkxaq8rkx3yf5tcsyz3d73gafnh3cax9rn449d9p5uxz9ezhhypd0elx87sjle52x86fux2ypatgddc6k63n7erqz25le42c4u4ecky03ylcqca784w"
+	hash, err := InvoicePaymentHash(pr)
+	if err != nil {
+		t.Fatalf("InvoicePaymentHash failed: %v", err)
+	}
+	if want := "0001020304050607080900010203040506070809000102030405060708090102"; hash != want {
+		t.Errorf("InvoicePaymentHash = %s, want %s", hash, want)
+	}
+
+	mock, mockHash := MockPaymentRequest("5u")
+	if got, err := InvoicePaymentHash(mock); err != nil || got != mockHash {
+		t.Errorf("InvoicePaymentHash(mock) = %s, %v; want %s", got, err, mockHash)
+	}
+	if amount, err := InvoiceAmountMsat(mock); err != nil || amount != 500_000 {
+		t.Errorf("InvoiceAmountMsat(mock) = %d, %v; want 500000", amount, err)
+	}
+
+	for _, bad := range []string{"lnbcrt5u1withdraw", "garbage", "lnbc1" + strings.Repeat("q", 120)} {
+		if _, err := InvoicePaymentHash(bad); err == nil {
+			t.Errorf("InvoicePaymentHash(%q) expected error", bad)
+		}
+	}
+}
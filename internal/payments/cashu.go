@@ -0,0 +1,96 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/store"
+)
+
+// ErrCashuDisabled is returned when no Cashu mint is configured.
+var ErrCashuDisabled = errors.New("cashu payments are not enabled")
+
+// CashuReceiver redeems Cashu ecash tokens into the server's wallet.
+type CashuReceiver interface {
+	// Receive swaps the token's proofs at the mint and returns the amount
+	// received. It fails without spending the token if it is worth less than
+	// minSats after mint fees.
+	Receive(ctx context.Context, token string, minSats int64) (int64, error)
+	// MintURL returns the mint whose tokens are accepted.
+	MintURL() string
+}
+
+// SetCashuReceiver enables paying for uploads with Cashu tokens.
+func (s *Service) SetCashuReceiver(r CashuReceiver) {
+	s.cashu = r
+}
+
+// CashuMint returns the mint whose tokens are accepted for uploads, or ""
+// if Cashu payments are disabled.
+func (s *Service) CashuMint() string {
+	if s.cashu == nil {
+		return ""
+	}
+	return s.cashu.MintURL()
+}
+
+// PayWithCashu pays a file's pending upload invoice with a Cashu token
+// instead of Lightning, and returns the amount received. Tokens worth more
+// than the invoice are accepted in full. On success the file is marked paid
+// exactly as if the invoice had settled, and the invoice is cancelled if the
+// backend supports it. ErrInvoicePaid is returned, and the token left unspent,
+// if the invoice turns out to have been paid already.
+func (s *Service) PayWithCashu(ctx context.Context, fileID, token string) (int64, error) {
+	if s.cashu == nil {
+		return 0, ErrCashuDisabled
+	}
+
+	// One token per file at a time, so two can't both be spent on the same
+	// invoice, and the invoice can't be re-issued under us. Other files'
+	// payments don't wait on this one's swap.
+	defer s.lockFile(fileID)()
+
+	pending, err := s.GetInvoiceForFile(fileID)
+	if err != nil {
+		return 0, err
+	}
+
+	// Don't spend the token on an invoice that was paid but not yet settled
+	if update, err := s.lnd.LookupInvoice(ctx, pending.PaymentHash); err == nil && update.Settled {
+		s.handlePayment(ctx, pending.PaymentHash)
+		return 0, ErrInvoicePaid
+	}
+
+	received, err := s.cashu.Receive(ctx, token, pending.Invoice.AmountSats)
+	if err != nil {
+		return 0, err
+	}
+	logging.Internal.Printf("received %d sats in ecash for file %s (invoice %s)", received, fileID, ShortHash(pending.PaymentHash))
+
+	if !s.settle(ctx, pending.PaymentHash, "cashu") {
+		// The invoice settled over Lightning while the token was being
+		// swapped, so the ecash is owed back
+		logging.Internal.Printf("CRITICAL: file %s was also paid over Lightning, refund of %d sats of ecash due", fileID, received)
+		now := time.Now()
+		s.recordPayment(ctx, &store.Payment{
+			PaymentHash: "cashu:" + pending.PaymentHash,
+			FileID:      fileID,
+			Kind:        store.InvoiceKindUpload,
+			AmountSats:  received,
+			CreatedAt:   now,
+			SettledAt:   now,
+			Backend:     "cashu",
+			RefundDue:   "file already paid over Lightning",
+		})
+		return received, nil
+	}
+
+	if canceler, ok := s.lnd.(InvoiceCanceler); ok {
+		if err := canceler.CancelInvoice(ctx, pending.PaymentHash); err != nil {
+			logging.Internal.Printf("failed to cancel invoice %s paid with ecash: %v", ShortHash(pending.PaymentHash), err)
+		}
+	}
+	return received, nil
+}
@@ -0,0 +1,514 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/store"
+)
+
+// clnPayIndexKey is the settings key under which the waitanyinvoice cursor is persisted.
+const clnPayIndexKey = "cln.pay_index"
+
+// clnWaitTimeout is how long a single waitanyinvoice call blocks on the node.
+const clnWaitTimeout = 60 * time.Second
+
+// clnErrWaitTimeout is the error code CLN returns when waitanyinvoice times out.
+const clnErrWaitTimeout = 904
+
+// CLNRESTClient implements LNDClient for Core Lightning using the clnrest plugin.
+// Requests are authenticated with a rune (see `lightning-cli createrune`).
+// Settlements are observed with waitanyinvoice, and the pay_index cursor is
+// persisted so that invoices paid while the server was down are still picked up.
+type CLNRESTClient struct {
+	baseURL    string
+	rune       string
+	httpClient *http.Client // Used for regular requests (with timeout)
+	waitHTTP   *http.Client // Used for long-polling waitanyinvoice
+	state      store.SettingsStore
+
+	mu       sync.Mutex
+	payIndex uint64
+
+	updates chan InvoiceUpdate
+	done    chan struct{}
+	once    sync.Once
+}
+
+// CLNConfig holds configuration for the Core Lightning REST client.
+type CLNConfig struct {
+	URL         string              // clnrest endpoint, e.g. "https://localhost:3010"
+	Rune        string              // Rune allowing getinfo, invoice, waitanyinvoice, listinvoices, delinvoice (and pay for payouts)
+	TLSCertPath string              // Path to clnrest's certificate; system roots are used if empty
+	State       store.SettingsStore // Persists the pay_index cursor; in-memory only if nil
+}
+
+type clnInvoiceRequest struct {
+	AmountMsat   int64  `json:"amount_msat"`
+	Label        string `json:"label"`
+	Description  string `json:"description"`
+	Expiry       int64  `json:"expiry,omitempty"`       // seconds
+	DescHashOnly bool   `json:"deschashonly,omitempty"` // Commit to the description by its hash only
+}
+
+type clnInvoiceResponse struct {
+	PaymentHash string `json:"payment_hash"`
+	Bolt11      string `json:"bolt11"`
+	ExpiresAt   int64  `json:"expires_at"`
+}
+
+type clnWaitAnyInvoiceRequest struct {
+	LastPayIndex uint64 `json:"lastpay_index"`
+	Timeout      int    `json:"timeout"`
+}
+
+type clnWaitInvoice struct {
+	Label        string `json:"label"`
+	PaymentHash  string `json:"payment_hash"`
+	Status       string `json:"status"`
+	PayIndex     uint64 `json:"pay_index"`
+	LocalOfferID string `json:"local_offer_id,omitempty"`
+}
+
+type clnListInvoicesRequest struct {
+	PaymentHash string `json:"payment_hash"`
+}
+
+type clnListInvoicesResponse struct {
+	Invoices []clnWaitInvoice `json:"invoices"`
+}
+
+type clnDelInvoiceRequest struct {
+	Label  string `json:"label"`
+	Status string `json:"status"`
+}
+
+type clnOfferRequest struct {
+	Amount         string `json:"amount"` // e.g. "1000msat"
+	Description    string `json:"description"`
+	AbsoluteExpiry int64  `json:"absolute_expiry,omitempty"` // unix seconds
+}
+
+type clnOfferResponse struct {
+	OfferID string `json:"offer_id"`
+	Bolt12  string `json:"bolt12"`
+	Active  bool   `json:"active"`
+}
+
+type clnPayRequest struct {
+	Bolt11   string `json:"bolt11"`
+	MaxFee   int64  `json:"maxfee,omitempty"` // msat
+	RetryFor int    `json:"retry_for,omitempty"`
+}
+
+type clnPayResponse struct {
+	PaymentHash     string `json:"payment_hash"`
+	PaymentPreimage string `json:"payment_preimage"`
+	AmountMsat      int64  `json:"amount_msat"`
+	AmountSentMsat  int64  `json:"amount_sent_msat"`
+	Status          string `json:"status"`
+}
+
+type clnListPaysRequest struct {
+	PaymentHash string `json:"payment_hash"`
+}
+
+type clnListPaysResponse struct {
+	Pays []struct {
+		Status string `json:"status"` // "pending", "complete" or "failed"
+	} `json:"pays"`
+}
+
+type clnError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *clnError) Error() string {
+	return fmt.Sprintf("CLN error %d: %s", e.Code, e.Message)
+}
+
+// NewCLNRESTClient creates a new Core Lightning REST client and verifies the connection.
+func NewCLNRESTClient(cfg CLNConfig) (*CLNRESTClient, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("URL is required")
+	}
+	if cfg.Rune == "" {
+		return nil, fmt.Errorf("rune is required")
+	}
+
+	transport, err := newTLSTransport(cfg.TLSCertPath)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &CLNRESTClient{
+		baseURL: strings.TrimRight(cfg.URL, "/"),
+		rune:    cfg.Rune,
+		httpClient: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: transport,
+		},
+		waitHTTP: &http.Client{
+			Timeout:   clnWaitTimeout + 30*time.Second,
+			Transport: transport,
+		},
+		state:   cfg.State,
+		updates: make(chan InvoiceUpdate, 1000),
+		done:    make(chan struct{}),
+	}
+
+	if err := c.call(context.Background(), c.httpClient, "getinfo", struct{}{}, nil); err != nil {
+		return nil, fmt.Errorf("failed to connect to CLN: %w", err)
+	}
+	logging.CLN.Printf("connected to %s", c.baseURL)
+
+	return c, nil
+}
+
+// call invokes a CLN RPC method via clnrest and decodes the result into out.
+func (c *CLNRESTClient) call(ctx context.Context, client *http.Client, method string, params any, out any) error {
+	jsonBody, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", c.baseURL+"/v1/"+method, bytes.NewReader(jsonBody))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Rune", c.rune)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		var rpcErr clnError
+		if json.Unmarshal(body, &rpcErr) == nil && rpcErr.Code != 0 {
+			return &rpcErr
+		}
+		return fmt.Errorf("API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+func (c *CLNRESTClient) CreateInvoice(ctx context.Context, amountSats int64, memo string) (*Invoice, error) {
+	return c.createInvoice(ctx, amountSats, memo, false, DefaultInvoiceExpiry)
+}
+
+// CreateInvoiceWithExpiry creates an invoice that can be paid for expiry.
+func (c *CLNRESTClient) CreateInvoiceWithExpiry(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (*Invoice, error) {
+	return c.createInvoice(ctx, amountSats, memo, false, expiry)
+}
+
+// CreateInvoiceForMetadata creates an invoice committing to the SHA-256 of
+// metadata, for LNURL-pay. CLN hashes the description itself.
+func (c *CLNRESTClient) CreateInvoiceForMetadata(ctx context.Context, amountSats int64, metadata string) (*Invoice, error) {
+	return c.createInvoice(ctx, amountSats, metadata, true, DefaultInvoiceExpiry)
+}
+
+func (c *CLNRESTClient) createInvoice(ctx context.Context, amountSats int64, description string, hashOnly bool, expiry time.Duration) (*Invoice, error) {
+	label, err := newCLNLabel()
+	if err != nil {
+		return nil, err
+	}
+
+	var clnResp clnInvoiceResponse
+	err = c.call(ctx, c.httpClient, "invoice", clnInvoiceRequest{
+		AmountMsat:   amountSats * 1000,
+		Label:        label,
+		Description:  description,
+		Expiry:       int64(expiry / time.Second),
+		DescHashOnly: hashOnly,
+	}, &clnResp)
+	if err != nil {
+		return nil, err
+	}
+
+	logging.CLN.Printf("created invoice %s for %d sats (label %s)", ShortHash(clnResp.PaymentHash), amountSats, label)
+
+	inv := &Invoice{
+		PaymentHash:    clnResp.PaymentHash,
+		PaymentRequest: clnResp.Bolt11,
+		AmountSats:     amountSats,
+	}
+	if clnResp.ExpiresAt > 0 {
+		inv.ExpiresAt = time.Unix(clnResp.ExpiresAt, 0)
+	}
+	return inv, nil
+}
+
+// CreateOffer creates a BOLT12 offer with the offer plugin. Payments of it
+// arrive through waitanyinvoice like any other invoice, tagged with the
+// offer's ID.
+func (c *CLNRESTClient) CreateOffer(ctx context.Context, amountSats int64, description string, expiresAt time.Time) (*Offer, error) {
+	req := clnOfferRequest{
+		Amount:      strconv.FormatInt(amountSats*1000, 10) + "msat",
+		Description: description,
+	}
+	if !expiresAt.IsZero() {
+		req.AbsoluteExpiry = expiresAt.Unix()
+	}
+
+	var clnResp clnOfferResponse
+	if err := c.call(ctx, c.httpClient, "offer", req, &clnResp); err != nil {
+		return nil, err
+	}
+	if !clnResp.Active {
+		return nil, fmt.Errorf("offer %s is not active", clnResp.OfferID)
+	}
+
+	logging.CLN.Printf("created offer %s for %d sats", ShortHash(clnResp.OfferID), amountSats)
+	return &Offer{
+		OfferID:    clnResp.OfferID,
+		Bolt12:     clnResp.Bolt12,
+		AmountSats: amountSats,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
+
+// CancelInvoice deletes an unpaid invoice from the node. CLN identifies
+// invoices by label, so the label is looked up first.
+func (c *CLNRESTClient) CancelInvoice(ctx context.Context, paymentHash string) error {
+	var list clnListInvoicesResponse
+	err := c.call(ctx, c.httpClient, "listinvoices", clnListInvoicesRequest{PaymentHash: paymentHash}, &list)
+	if err != nil {
+		return err
+	}
+	if len(list.Invoices) == 0 {
+		return ErrInvoiceNotFound
+	}
+
+	inv := list.Invoices[0]
+	err = c.call(ctx, c.httpClient, "delinvoice", clnDelInvoiceRequest{Label: inv.Label, Status: inv.Status}, nil)
+	if err != nil {
+		return err
+	}
+
+	logging.CLN.Printf("deleted invoice %s (label %s)", ShortHash(paymentHash), inv.Label)
+	return nil
+}
+
+// PayInvoice pays a BOLT11 invoice with the node's pay plugin.
+func (c *CLNRESTClient) PayInvoice(ctx context.Context, paymentRequest string, maxFeeSats int64) (*Payment, error) {
+	var clnResp clnPayResponse
+	err := c.call(ctx, c.waitHTTP, "pay", clnPayRequest{
+		Bolt11:   paymentRequest,
+		MaxFee:   maxFeeSats * 1000,
+		RetryFor: 60,
+	}, &clnResp)
+	if err != nil {
+		return nil, err
+	}
+	if clnResp.Status != "complete" {
+		return nil, fmt.Errorf("payment %s", clnResp.Status)
+	}
+
+	payment := &Payment{
+		PaymentHash: clnResp.PaymentHash,
+		Preimage:    clnResp.PaymentPreimage,
+		AmountSats:  clnResp.AmountMsat / 1000,
+		FeeSats:     (clnResp.AmountSentMsat - clnResp.AmountMsat) / 1000,
+	}
+	logging.CLN.Printf("paid invoice %s (%d sats, %d sats fees)", ShortHash(payment.PaymentHash), payment.AmountSats, payment.FeeSats)
+	return payment, nil
+}
+
+// LookupPayment reports the state of an outgoing payment. A payment that was
+// retried has one entry per attempt; it failed only if all of them did.
+func (c *CLNRESTClient) LookupPayment(ctx context.Context, paymentHash string) (PaymentState, error) {
+	var clnResp clnListPaysResponse
+	err := c.call(ctx, c.httpClient, "listpays", clnListPaysRequest{PaymentHash: paymentHash}, &clnResp)
+	if err != nil {
+		return 0, err
+	}
+	if len(clnResp.Pays) == 0 {
+		return 0, ErrPaymentNotFound
+	}
+
+	state := PaymentFailed
+	for _, pay := range clnResp.Pays {
+		switch pay.Status {
+		case "complete":
+			return PaymentSucceeded, nil
+		case "failed":
+		default:
+			state = PaymentPending
+		}
+	}
+	return state, nil
+}
+
+func (c *CLNRESTClient) LookupInvoice(ctx context.Context, paymentHash string) (*InvoiceUpdate, error) {
+	var clnResp clnListInvoicesResponse
+	err := c.call(ctx, c.httpClient, "listinvoices", clnListInvoicesRequest{PaymentHash: paymentHash}, &clnResp)
+	if err != nil {
+		return nil, err
+	}
+	if len(clnResp.Invoices) == 0 {
+		return nil, ErrInvoiceNotFound
+	}
+
+	return &InvoiceUpdate{
+		PaymentHash: paymentHash,
+		Settled:     clnResp.Invoices[0].Status == "paid",
+	}, nil
+}
+
+// newCLNLabel returns a unique invoice label. CLN requires labels to be unique
+// across all invoices ever created on the node.
+func newCLNLabel() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "satoshisend-" + hex.EncodeToString(b), nil
+}
+
+// SubscribeInvoices starts a waitanyinvoice loop that resumes from the
+// persisted pay_index.
+func (c *CLNRESTClient) SubscribeInvoices(ctx context.Context) (<-chan InvoiceUpdate, error) {
+	if c.state != nil {
+		value, err := c.state.GetSetting(ctx, clnPayIndexKey)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return nil, fmt.Errorf("failed to load pay_index: %w", err)
+		}
+		if value != "" {
+			idx, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid stored pay_index %q: %w", value, err)
+			}
+			c.mu.Lock()
+			c.payIndex = idx
+			c.mu.Unlock()
+			logging.CLN.Printf("resuming invoice subscription from pay_index %d", idx)
+		}
+	}
+
+	go c.waitLoop(ctx)
+	return c.updates, nil
+}
+
+func (c *CLNRESTClient) waitLoop(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Abort the in-flight long poll when the client is closed
+	go func() {
+		select {
+		case <-c.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	backoff := time.Second
+	const maxBackoff = time.Minute
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := c.waitOnce(ctx)
+		if err == nil {
+			backoff = time.Second
+			continue
+		}
+
+		var rpcErr *clnError
+		if errors.As(err, &rpcErr) && rpcErr.Code == clnErrWaitTimeout {
+			// No invoice paid within the timeout; poll again
+			backoff = time.Second
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		logging.CLN.Printf("waitanyinvoice failed: %v (retrying in %s)", err, backoff)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (c *CLNRESTClient) waitOnce(ctx context.Context) error {
+	c.mu.Lock()
+	lastIndex := c.payIndex
+	c.mu.Unlock()
+
+	var inv clnWaitInvoice
+	err := c.call(ctx, c.waitHTTP, "waitanyinvoice", clnWaitAnyInvoiceRequest{
+		LastPayIndex: lastIndex,
+		Timeout:      int(clnWaitTimeout / time.Second),
+	}, &inv)
+	if err != nil {
+		return err
+	}
+
+	if inv.Status == "paid" && inv.PaymentHash != "" {
+		logging.CLN.Printf("invoice %s settled (pay_index %d)", ShortHash(inv.PaymentHash), inv.PayIndex)
+		select {
+		case c.updates <- InvoiceUpdate{PaymentHash: inv.PaymentHash, Settled: true, OfferID: inv.LocalOfferID}:
+		case <-ctx.Done():
+			// Don't advance the cursor for an update we couldn't deliver
+			return ctx.Err()
+		}
+	}
+
+	if inv.PayIndex > lastIndex {
+		c.mu.Lock()
+		c.payIndex = inv.PayIndex
+		c.mu.Unlock()
+
+		if c.state != nil {
+			if err := c.state.SetSetting(ctx, clnPayIndexKey, strconv.FormatUint(inv.PayIndex, 10)); err != nil {
+				logging.CLN.Printf("failed to persist pay_index %d: %v", inv.PayIndex, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (c *CLNRESTClient) BackendName() string {
+	return "cln"
+}
+
+func (c *CLNRESTClient) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+	})
+	return nil
+}
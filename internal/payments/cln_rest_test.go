@@ -0,0 +1,582 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"satoshisend/internal/store"
+)
+
+const testRune = "test-rune"
+
+// fakeCLN is a minimal stand-in for clnrest.
+type fakeCLN struct {
+	mu       sync.Mutex
+	labels   map[string]bool
+	invoices []*clnFakeInvoice
+	paid     []*clnFakeInvoice // in pay_index order
+	waits    []uint64          // lastpay_index values seen
+	changed  chan struct{}
+	maxFee   int64 // maxfee of the last pay call
+	offers   []clnOfferRequest
+	pays     map[string][]string // listpays statuses by payment hash
+}
+
+type clnFakeInvoice struct {
+	label      string
+	hash       string
+	amountMsat int64
+	payIndex   uint64
+	offerID    string
+}
+
+func newFakeCLN() *fakeCLN {
+	return &fakeCLN{
+		labels:  make(map[string]bool),
+		changed: make(chan struct{}),
+	}
+}
+
+// pay marks an invoice as paid, assigning the next pay_index.
+func (f *fakeCLN) pay(hash string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	inv := &clnFakeInvoice{hash: hash}
+	for _, i := range f.invoices {
+		if i.hash == hash {
+			inv = i
+		}
+	}
+	inv.payIndex = uint64(len(f.paid) + 1)
+	f.paid = append(f.paid, inv)
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// payOffer simulates a payer fetching and paying an invoice for an offer.
+func (f *fakeCLN) payOffer(offerID string) string {
+	hash, _ := generatePaymentHash()
+	f.mu.Lock()
+	f.invoices = append(f.invoices, &clnFakeInvoice{label: "offer-" + hash[:8], hash: hash, offerID: offerID})
+	f.mu.Unlock()
+	f.pay(hash)
+	return hash
+}
+
+func (f *fakeCLN) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Rune") != testRune {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(clnError{Code: 1501, Message: "Not authorized"})
+		return
+	}
+
+	switch r.URL.Path {
+	case "/v1/getinfo":
+		w.Write([]byte(`{"id":"02abc","alias":"fake"}`))
+
+	case "/v1/invoice":
+		var req clnInvoiceRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		f.mu.Lock()
+		if f.labels[req.Label] {
+			f.mu.Unlock()
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(clnError{Code: 900, Message: "Duplicate label"})
+			return
+		}
+		f.labels[req.Label] = true
+		hash, _ := generatePaymentHash()
+		f.invoices = append(f.invoices, &clnFakeInvoice{label: req.Label, hash: hash, amountMsat: req.AmountMsat})
+		f.mu.Unlock()
+
+		json.NewEncoder(w).Encode(clnInvoiceResponse{
+			PaymentHash: hash,
+			Bolt11:      "lnbcrt" + hash[:20],
+			ExpiresAt:   time.Now().Add(time.Hour).Unix(),
+		})
+
+	case "/v1/listinvoices":
+		var req clnListInvoicesRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		f.mu.Lock()
+		var resp clnListInvoicesResponse
+		resp.Invoices = []clnWaitInvoice{}
+		for _, inv := range f.invoices {
+			if inv.hash == req.PaymentHash {
+				status := "unpaid"
+				if inv.payIndex > 0 {
+					status = "paid"
+				}
+				resp.Invoices = append(resp.Invoices, clnWaitInvoice{
+					Label:       inv.label,
+					PaymentHash: inv.hash,
+					Status:      status,
+					PayIndex:    inv.payIndex,
+				})
+			}
+		}
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(resp)
+
+	case "/v1/delinvoice":
+		var req clnDelInvoiceRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		for i, inv := range f.invoices {
+			if inv.label == req.Label {
+				f.invoices = append(f.invoices[:i], f.invoices[i+1:]...)
+				json.NewEncoder(w).Encode(clnWaitInvoice{Label: inv.label, PaymentHash: inv.hash, Status: req.Status})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(clnError{Code: 905, Message: "Unknown invoice"})
+
+	case "/v1/offer":
+		var req clnOfferRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		f.offers = append(f.offers, req)
+		f.mu.Unlock()
+
+		id, _ := generatePaymentHash()
+		json.NewEncoder(w).Encode(clnOfferResponse{
+			OfferID: id,
+			Bolt12:  "lno1" + id[:20],
+			Active:  true,
+		})
+
+	case "/v1/pay":
+		var req clnPayRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		f.maxFee = req.MaxFee
+		f.mu.Unlock()
+
+		if strings.Contains(req.Bolt11, "noroute") {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(clnError{Code: 210, Message: "Ran out of routes to try"})
+			return
+		}
+		preimage, hash, _ := generatePreimage()
+		json.NewEncoder(w).Encode(clnPayResponse{
+			PaymentHash:     hash,
+			PaymentPreimage: preimage,
+			AmountMsat:      500_000,
+			AmountSentMsat:  501_000,
+			Status:          "complete",
+		})
+
+	case "/v1/listpays":
+		var req clnListPaysRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		f.mu.Lock()
+		var resp clnListPaysResponse
+		for _, status := range f.pays[req.PaymentHash] {
+			resp.Pays = append(resp.Pays, struct {
+				Status string `json:"status"`
+			}{status})
+		}
+		f.mu.Unlock()
+		json.NewEncoder(w).Encode(resp)
+
+	case "/v1/waitanyinvoice":
+		var req clnWaitAnyInvoiceRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		f.mu.Lock()
+		f.waits = append(f.waits, req.LastPayIndex)
+		f.mu.Unlock()
+
+		deadline := time.After(100 * time.Millisecond)
+		for {
+			f.mu.Lock()
+			if int(req.LastPayIndex) < len(f.paid) {
+				inv := f.paid[req.LastPayIndex]
+				f.mu.Unlock()
+				json.NewEncoder(w).Encode(clnWaitInvoice{
+					Label:        inv.label,
+					PaymentHash:  inv.hash,
+					Status:       "paid",
+					PayIndex:     inv.payIndex,
+					LocalOfferID: inv.offerID,
+				})
+				return
+			}
+			changed := f.changed
+			f.mu.Unlock()
+
+			select {
+			case <-changed:
+			case <-deadline:
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(clnError{Code: clnErrWaitTimeout, Message: "Timed out"})
+				return
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// memSettings is an in-memory store.SettingsStore.
+type memSettings struct {
+	mu     sync.Mutex
+	values map[string]string
+}
+
+func newMemSettings() *memSettings {
+	return &memSettings{values: make(map[string]string)}
+}
+
+func (m *memSettings) GetSetting(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v, ok := m.values[key]
+	if !ok {
+		return "", store.ErrNotFound
+	}
+	return v, nil
+}
+
+func (m *memSettings) SetSetting(ctx context.Context, key, value string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[key] = value
+	return nil
+}
+
+func (m *memSettings) get(key string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key]
+}
+
+func newTestCLNClient(t *testing.T, srv *httptest.Server, state store.SettingsStore) *CLNRESTClient {
+	t.Helper()
+	client, err := NewCLNRESTClient(CLNConfig{
+		URL:   srv.URL,
+		Rune:  testRune,
+		State: state,
+	})
+	if err != nil {
+		t.Fatalf("NewCLNRESTClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestNewCLNRESTClient_Validation(t *testing.T) {
+	if _, err := NewCLNRESTClient(CLNConfig{Rune: testRune}); err == nil {
+		t.Error("expected error for missing URL")
+	}
+	if _, err := NewCLNRESTClient(CLNConfig{URL: "https://localhost:3010"}); err == nil {
+		t.Error("expected error for missing rune")
+	}
+
+	srv := httptest.NewServer(newFakeCLN())
+	defer srv.Close()
+	if _, err := NewCLNRESTClient(CLNConfig{URL: srv.URL, Rune: "wrong"}); err == nil {
+		t.Error("expected error for rejected rune")
+	}
+}
+
+func TestCLNRESTClient_CreateInvoice(t *testing.T) {
+	fake := newFakeCLN()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client := newTestCLNClient(t, srv, nil)
+
+	ctx := context.Background()
+	inv1, err := client.CreateInvoice(ctx, 250, "memo")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+	inv2, err := client.CreateInvoice(ctx, 250, "memo")
+	if err != nil {
+		t.Fatalf("second CreateInvoice failed: %v", err)
+	}
+
+	if inv1.PaymentHash == inv2.PaymentHash {
+		t.Error("expected distinct invoices")
+	}
+	if inv1.AmountSats != 250 {
+		t.Errorf("expected 250 sats, got %d", inv1.AmountSats)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if len(fake.labels) != 2 {
+		t.Errorf("expected 2 unique labels, got %d", len(fake.labels))
+	}
+	if fake.invoices[0].amountMsat != 250000 {
+		t.Errorf("expected 250000 msat, got %d", fake.invoices[0].amountMsat)
+	}
+}
+
+func TestCLNRESTClient_LookupInvoice(t *testing.T) {
+	fake := newFakeCLN()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client := newTestCLNClient(t, srv, nil)
+
+	ctx := context.Background()
+	inv, err := client.CreateInvoice(ctx, 100, "")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+
+	update, err := client.LookupInvoice(ctx, inv.PaymentHash)
+	if err != nil {
+		t.Fatalf("LookupInvoice failed: %v", err)
+	}
+	if update.Settled {
+		t.Error("expected unpaid invoice to be unsettled")
+	}
+
+	fake.pay(inv.PaymentHash)
+
+	update, err = client.LookupInvoice(ctx, inv.PaymentHash)
+	if err != nil {
+		t.Fatalf("LookupInvoice failed: %v", err)
+	}
+	if !update.Settled {
+		t.Error("expected paid invoice to be settled")
+	}
+
+	missing, _ := generatePaymentHash()
+	if _, err := client.LookupInvoice(ctx, missing); err != ErrInvoiceNotFound {
+		t.Errorf("expected ErrInvoiceNotFound, got %v", err)
+	}
+}
+
+func TestCLNRESTClient_CancelInvoice(t *testing.T) {
+	fake := newFakeCLN()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client := newTestCLNClient(t, srv, nil)
+
+	ctx := context.Background()
+	inv, err := client.CreateInvoice(ctx, 100, "")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+	if inv.ExpiresAt.IsZero() {
+		t.Error("expected expiry from node")
+	}
+
+	if err := client.CancelInvoice(ctx, inv.PaymentHash); err != nil {
+		t.Fatalf("CancelInvoice failed: %v", err)
+	}
+	if _, err := client.LookupInvoice(ctx, inv.PaymentHash); err != ErrInvoiceNotFound {
+		t.Errorf("expected deleted invoice to be gone, got %v", err)
+	}
+	if err := client.CancelInvoice(ctx, inv.PaymentHash); err != ErrInvoiceNotFound {
+		t.Errorf("expected ErrInvoiceNotFound, got %v", err)
+	}
+}
+
+func TestCLNRESTClient_PayInvoice(t *testing.T) {
+	fake := newFakeCLN()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client := newTestCLNClient(t, srv, nil)
+
+	ctx := context.Background()
+	payment, err := client.PayInvoice(ctx, "lnbcrt5u1paytest", 15)
+	if err != nil {
+		t.Fatalf("PayInvoice failed: %v", err)
+	}
+	if payment.AmountSats != 500 || payment.FeeSats != 1 {
+		t.Errorf("expected 500 sats + 1 fee, got %d + %d", payment.AmountSats, payment.FeeSats)
+	}
+	fake.mu.Lock()
+	maxFee := fake.maxFee
+	fake.mu.Unlock()
+	if maxFee != 15_000 {
+		t.Errorf("expected maxfee 15000 msat, got %d", maxFee)
+	}
+
+	if _, err := client.PayInvoice(ctx, "lnbcrt5u1noroute", 15); err == nil {
+		t.Error("expected error when the payment fails")
+	}
+}
+
+func TestCLNRESTClient_LookupPayment(t *testing.T) {
+	fake := newFakeCLN()
+	fake.pays = map[string][]string{
+		"retried": {"failed", "complete"},
+		"routing": {"failed", "pending"},
+		"failed":  {"failed", "failed"},
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client := newTestCLNClient(t, srv, nil)
+
+	tests := []struct {
+		hash    string
+		want    PaymentState
+		wantErr error
+	}{
+		{"retried", PaymentSucceeded, nil},
+		{"routing", PaymentPending, nil},
+		{"failed", PaymentFailed, nil},
+		{"unknown", 0, ErrPaymentNotFound},
+	}
+	for _, tc := range tests {
+		state, err := client.LookupPayment(context.Background(), tc.hash)
+		if err != tc.wantErr || state != tc.want {
+			t.Errorf("LookupPayment(%s) = %v, %v; want %v, %v", tc.hash, state, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestCLNRESTClient_SubscribeInvoices(t *testing.T) {
+	fake := newFakeCLN()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	state := newMemSettings()
+	client := newTestCLNClient(t, srv, state)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := client.SubscribeInvoices(ctx)
+	if err != nil {
+		t.Fatalf("SubscribeInvoices failed: %v", err)
+	}
+
+	inv, _ := client.CreateInvoice(ctx, 100, "")
+
+	// Let at least one waitanyinvoice time out before paying
+	time.Sleep(150 * time.Millisecond)
+	fake.pay(inv.PaymentHash)
+
+	select {
+	case update := <-updates:
+		if update.PaymentHash != inv.PaymentHash || !update.Settled {
+			t.Errorf("unexpected update %+v", update)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for settlement")
+	}
+
+	// Cursor must be persisted once the update has been delivered
+	deadline := time.Now().Add(time.Second)
+	for state.get(clnPayIndexKey) != "1" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := state.get(clnPayIndexKey); got != "1" {
+		t.Errorf("expected persisted pay_index 1, got %q", got)
+	}
+}
+
+func TestCLNRESTClient_Offers(t *testing.T) {
+	fake := newFakeCLN()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	client := newTestCLNClient(t, srv, newMemSettings())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	expiresAt := time.Now().Add(24 * time.Hour)
+	offer, err := client.CreateOffer(ctx, 250, "renewal", expiresAt)
+	if err != nil {
+		t.Fatalf("CreateOffer failed: %v", err)
+	}
+	if !strings.HasPrefix(offer.Bolt12, "lno1") || offer.AmountSats != 250 {
+		t.Errorf("unexpected offer %+v", offer)
+	}
+	fake.mu.Lock()
+	req := fake.offers[0]
+	fake.mu.Unlock()
+	if req.Amount != "250000msat" || req.AbsoluteExpiry != expiresAt.Unix() {
+		t.Errorf("unexpected offer request %+v", req)
+	}
+
+	updates, err := client.SubscribeInvoices(ctx)
+	if err != nil {
+		t.Fatalf("SubscribeInvoices failed: %v", err)
+	}
+
+	// Each payment of the offer is reported with the offer's ID
+	for range 2 {
+		hash := fake.payOffer(offer.OfferID)
+		select {
+		case update := <-updates:
+			if update.PaymentHash != hash || update.OfferID != offer.OfferID {
+				t.Errorf("unexpected update %+v", update)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for offer payment")
+		}
+	}
+}
+
+func TestCLNRESTClient_ResumesFromPersistedCursor(t *testing.T) {
+	fake := newFakeCLN()
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	// Two invoices were paid; the first was already processed before a restart
+	hash1, _ := generatePaymentHash()
+	hash2, _ := generatePaymentHash()
+	fake.pay(hash1)
+	fake.pay(hash2)
+
+	state := newMemSettings()
+	state.SetSetting(context.Background(), clnPayIndexKey, "1")
+
+	client := newTestCLNClient(t, srv, state)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates, err := client.SubscribeInvoices(ctx)
+	if err != nil {
+		t.Fatalf("SubscribeInvoices failed: %v", err)
+	}
+
+	select {
+	case update := <-updates:
+		if update.PaymentHash != hash2 {
+			t.Errorf("expected only the unprocessed invoice %s, got %s", hash2, update.PaymentHash)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for replayed settlement")
+	}
+
+	fake.mu.Lock()
+	firstWait := fake.waits[0]
+	fake.mu.Unlock()
+	if firstWait != 1 {
+		t.Errorf("expected first waitanyinvoice with lastpay_index 1, got %d", firstWait)
+	}
+}
+
+func TestCLNRESTClient_InvalidPersistedCursor(t *testing.T) {
+	srv := httptest.NewServer(newFakeCLN())
+	defer srv.Close()
+
+	state := newMemSettings()
+	state.SetSetting(context.Background(), clnPayIndexKey, "not-a-number")
+	client := newTestCLNClient(t, srv, state)
+
+	if _, err := client.SubscribeInvoices(context.Background()); err == nil {
+		t.Error("expected error for corrupt pay_index")
+	}
+}
@@ -2,24 +2,122 @@ package payments
 
 import (
 	"context"
+	"time"
 )
 
+// DefaultInvoiceExpiry is the invoice lifetime requested from backends that
+// let the caller choose one.
+const DefaultInvoiceExpiry = time.Hour
+
 // Invoice represents a Lightning Network invoice.
 type Invoice struct {
 	PaymentHash    string
 	PaymentRequest string // BOLT11 encoded invoice
 	AmountSats     int64
+	ExpiresAt      time.Time // Zero if the backend did not report an expiry
+}
+
+// Expired reports whether the invoice can no longer be paid.
+func (inv *Invoice) Expired(now time.Time) bool {
+	return !inv.ExpiresAt.IsZero() && now.After(inv.ExpiresAt)
 }
 
 // InvoiceUpdate represents a payment status update.
 type InvoiceUpdate struct {
 	PaymentHash string
 	Settled     bool
+	OfferID     string // Set if the invoice was issued for one of our BOLT12 offers
+
+	// Ack, if set, is called once the update has been fully handled, so a
+	// backend can hold off acknowledging its source until the effect is durable.
+	Ack func()
 }
 
 // LNDClient defines the interface for Lightning Network operations.
 type LNDClient interface {
 	CreateInvoice(ctx context.Context, amountSats int64, memo string) (*Invoice, error)
 	SubscribeInvoices(ctx context.Context) (<-chan InvoiceUpdate, error)
+	// LookupInvoice queries the current state of an invoice from the wallet.
+	// It is used to reconcile invoices whose settlement notification was missed.
+	LookupInvoice(ctx context.Context, paymentHash string) (*InvoiceUpdate, error)
 	Close() error
 }
+
+// InvoiceCanceler is implemented by backends that can cancel an open invoice
+// so that it can no longer be paid.
+type InvoiceCanceler interface {
+	CancelInvoice(ctx context.Context, paymentHash string) error
+}
+
+// ExpiringInvoicer is implemented by backends that let the caller choose an
+// invoice's lifetime, so it can be made to expire before what it pays for.
+type ExpiringInvoicer interface {
+	// CreateInvoiceWithExpiry is CreateInvoice with an invoice that can be
+	// paid for expiry instead of DefaultInvoiceExpiry.
+	CreateInvoiceWithExpiry(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (*Invoice, error)
+}
+
+// DescriptionHashInvoicer is implemented by backends that can create
+// invoices committing to a description by its SHA-256 hash, as LNURL-pay
+// (LUD-06) requires.
+type DescriptionHashInvoicer interface {
+	// CreateInvoiceForMetadata creates an invoice whose description hash is
+	// the SHA-256 of metadata, instead of carrying a memo.
+	CreateInvoiceForMetadata(ctx context.Context, amountSats int64, metadata string) (*Invoice, error)
+}
+
+// Payment is the result of paying a Lightning invoice.
+type Payment struct {
+	PaymentHash string
+	Preimage    string // hex
+	AmountSats  int64
+	FeeSats     int64
+}
+
+// InvoicePayer is implemented by backends that can pay invoices, which is
+// needed to pay out uploader earnings.
+type InvoicePayer interface {
+	// PayInvoice pays a BOLT11 invoice, spending at most maxFeeSats on
+	// routing fees where the backend supports a limit.
+	PayInvoice(ctx context.Context, paymentRequest string, maxFeeSats int64) (*Payment, error)
+}
+
+// PaymentState is what a backend knows about an outgoing payment.
+type PaymentState int
+
+const (
+	PaymentPending   PaymentState = iota // Still in flight; may yet succeed
+	PaymentSucceeded                     // Delivered; the preimage is known
+	PaymentFailed                        // Definitively failed; no funds left the wallet
+)
+
+// PaymentLookup is implemented by backends that can report the state of an
+// outgoing payment, so a payment whose outcome PayInvoice couldn't report
+// (e.g. on a timeout) can be resolved later.
+type PaymentLookup interface {
+	// LookupPayment returns the state of the payment for paymentHash, or
+	// ErrPaymentNotFound if the backend never attempted it.
+	LookupPayment(ctx context.Context, paymentHash string) (PaymentState, error)
+}
+
+// BackendNamer is implemented by backends that identify themselves, so
+// payment records show which wallet received each payment.
+type BackendNamer interface {
+	BackendName() string
+}
+
+// Offer is a reusable BOLT12 offer. Every payment of it settles a new invoice
+// that SubscribeInvoices reports with the offer's ID.
+type Offer struct {
+	OfferID    string
+	Bolt12     string // lno... encoded offer
+	AmountSats int64
+	ExpiresAt  time.Time
+}
+
+// OfferProvider is implemented by backends that support BOLT12 offers.
+type OfferProvider interface {
+	// CreateOffer creates an offer for a fixed amount that can be paid
+	// repeatedly until expiresAt.
+	CreateOffer(ctx context.Context, amountSats int64, description string, expiresAt time.Time) (*Offer, error)
+}
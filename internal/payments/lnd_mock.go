@@ -3,32 +3,55 @@ package payments
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"log"
+	"strings"
 	"sync"
 	"time"
 )
 
 // MockLNDClient implements LNDClient for testing and development.
 type MockLNDClient struct {
-	mu       sync.Mutex
-	invoices map[string]*Invoice
-	updates  chan InvoiceUpdate
+	mu        sync.Mutex
+	invoices  map[string]*Invoice
+	preimages map[string]string
+	settled   map[string]bool
+	canceled  map[string]bool
+	deschash  map[string]string // hex description hash of LNURL-pay invoices
+	payments  []Payment
+	payErr    error
+	payState  PaymentState // reported by LookupPayment for payments that failed with payErr
+	attempts  map[string]PaymentState
+	offers    map[string]*Offer
+	updates   chan InvoiceUpdate
 }
 
 // NewMockLNDClient creates a new mock LND client.
 func NewMockLNDClient() *MockLNDClient {
 	return &MockLNDClient{
-		invoices: make(map[string]*Invoice),
-		updates:  make(chan InvoiceUpdate, 100),
+		invoices:  make(map[string]*Invoice),
+		preimages: make(map[string]string),
+		settled:   make(map[string]bool),
+		canceled:  make(map[string]bool),
+		deschash:  make(map[string]string),
+		attempts:  make(map[string]PaymentState),
+		offers:    make(map[string]*Offer),
+		updates:   make(chan InvoiceUpdate, 100),
 	}
 }
 
 func (m *MockLNDClient) CreateInvoice(ctx context.Context, amountSats int64, memo string) (*Invoice, error) {
+	return m.CreateInvoiceWithExpiry(ctx, amountSats, memo, DefaultInvoiceExpiry)
+}
+
+// CreateInvoiceWithExpiry creates a mock invoice that expires after expiry.
+func (m *MockLNDClient) CreateInvoiceWithExpiry(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (*Invoice, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	hash, err := generatePaymentHash()
+	preimage, hash, err := generatePreimage()
 	if err != nil {
 		return nil, err
 	}
@@ -37,12 +60,20 @@ func (m *MockLNDClient) CreateInvoice(ctx context.Context, amountSats int64, mem
 		PaymentHash:    hash,
 		PaymentRequest: "lnbc" + hash[:20], // Fake BOLT11
 		AmountSats:     amountSats,
+		ExpiresAt:      time.Now().Add(expiry),
 	}
 	m.invoices[hash] = inv
+	m.preimages[hash] = preimage
 
 	// Auto-settle after 20 seconds (for development/testing)
 	go func() {
 		time.Sleep(20 * time.Second)
+		m.mu.Lock()
+		canceled := m.canceled[hash]
+		m.mu.Unlock()
+		if canceled {
+			return
+		}
 		log.Printf("Mock: auto-settling invoice %s", hash[:8])
 		m.SimulatePayment(hash)
 	}()
@@ -50,23 +81,256 @@ func (m *MockLNDClient) CreateInvoice(ctx context.Context, amountSats int64, mem
 	return inv, nil
 }
 
+// CreateInvoiceForMetadata creates a mock invoice committing to the SHA-256
+// of metadata.
+func (m *MockLNDClient) CreateInvoiceForMetadata(ctx context.Context, amountSats int64, metadata string) (*Invoice, error) {
+	inv, err := m.CreateInvoice(ctx, amountSats, "")
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(metadata))
+	m.mu.Lock()
+	m.deschash[inv.PaymentHash] = hex.EncodeToString(hash[:])
+	m.mu.Unlock()
+	return inv, nil
+}
+
+// DescriptionHash returns the hex description hash an invoice was created
+// with, or "" if it carries a memo (for testing).
+func (m *MockLNDClient) DescriptionHash(paymentHash string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.deschash[paymentHash]
+}
+
 func (m *MockLNDClient) SubscribeInvoices(ctx context.Context) (<-chan InvoiceUpdate, error) {
 	return m.updates, nil
 }
 
+func (m *MockLNDClient) LookupInvoice(ctx context.Context, paymentHash string) (*InvoiceUpdate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.invoices[paymentHash]; !ok {
+		return nil, ErrInvoiceNotFound
+	}
+	return &InvoiceUpdate{
+		PaymentHash: paymentHash,
+		Settled:     m.settled[paymentHash],
+	}, nil
+}
+
 // SimulatePayment simulates a payment being received (for testing).
 func (m *MockLNDClient) SimulatePayment(paymentHash string) {
+	m.MarkSettled(paymentHash)
 	m.updates <- InvoiceUpdate{
 		PaymentHash: paymentHash,
 		Settled:     true,
 	}
 }
 
+// MarkSettled marks an invoice as paid without sending an update, simulating
+// a settlement notification that never arrived (for testing).
+func (m *MockLNDClient) MarkSettled(paymentHash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.settled[paymentHash] = true
+}
+
+// CancelInvoice forgets an invoice so it can no longer be paid.
+func (m *MockLNDClient) CancelInvoice(ctx context.Context, paymentHash string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.invoices[paymentHash]; !ok {
+		return ErrInvoiceNotFound
+	}
+	if m.settled[paymentHash] {
+		return errors.New("invoice already settled")
+	}
+	delete(m.invoices, paymentHash)
+	m.canceled[paymentHash] = true
+	return nil
+}
+
+// Canceled reports whether CancelInvoice was called for an invoice (for testing).
+func (m *MockLNDClient) Canceled(paymentHash string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.canceled[paymentHash]
+}
+
+func (m *MockLNDClient) BackendName() string {
+	return "mock"
+}
+
 func (m *MockLNDClient) Close() error {
 	close(m.updates)
 	return nil
 }
 
+// PayInvoice records the payment and reports success, unless SetPayError
+// was called.
+func (m *MockLNDClient) PayInvoice(ctx context.Context, paymentRequest string, maxFeeSats int64) (*Payment, error) {
+	amountMsat, err := InvoiceAmountMsat(paymentRequest)
+	if err != nil {
+		return nil, err
+	}
+	preimage, hash, err := generatePreimage()
+	if err != nil {
+		return nil, err
+	}
+	if invoiceHash, err := InvoicePaymentHash(paymentRequest); err == nil {
+		hash = invoiceHash
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.payErr != nil {
+		m.attempts[hash] = m.payState
+		return nil, m.payErr
+	}
+	m.attempts[hash] = PaymentSucceeded
+	payment := Payment{
+		PaymentHash: hash,
+		Preimage:    preimage,
+		AmountSats:  amountMsat / 1000,
+	}
+	m.payments = append(m.payments, payment)
+	log.Printf("Mock: paid invoice %s for %d sats", hash[:8], payment.AmountSats)
+	return &payment, nil
+}
+
+// SetPayError makes subsequent PayInvoice calls fail with err (nil to succeed).
+// LookupPayment reports the failed payments as PaymentFailed.
+func (m *MockLNDClient) SetPayError(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.payErr = err
+	m.payState = PaymentFailed
+}
+
+// SetPayInFlight makes subsequent PayInvoice calls fail with err, as on a
+// timeout, while LookupPayment reports the payments as still in flight.
+func (m *MockLNDClient) SetPayInFlight(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.payErr = err
+	m.payState = PaymentPending
+}
+
+// SetPaymentState changes what LookupPayment reports for a payment, e.g. to
+// resolve one left in flight (for testing).
+func (m *MockLNDClient) SetPaymentState(paymentHash string, state PaymentState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts[paymentHash] = state
+}
+
+// LookupPayment reports the state of a payment made with PayInvoice.
+func (m *MockLNDClient) LookupPayment(ctx context.Context, paymentHash string) (PaymentState, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state, ok := m.attempts[paymentHash]
+	if !ok {
+		return 0, ErrPaymentNotFound
+	}
+	return state, nil
+}
+
+// MockPaymentRequest returns a structurally valid BOLT11 payment request for
+// amount (e.g. "5u") with a random payment hash, and that hash. Its signature
+// and checksum are zeroes, so only this package's parsers accept it (for
+// testing).
+func MockPaymentRequest(amount string) (paymentRequest, paymentHash string) {
+	_, paymentHash, err := generatePreimage()
+	if err != nil {
+		panic(err)
+	}
+	raw, _ := hex.DecodeString(paymentHash)
+
+	var sb strings.Builder
+	sb.WriteString("lnbcrt" + amount + "1")
+	sb.WriteString(strings.Repeat("q", bolt11TimestampLen))
+	// Tag 'p' with a data length of 52 groups
+	sb.WriteString("p" + string(bech32Charset[52>>5]) + string(bech32Charset[52&31]))
+	var acc, bits uint
+	for _, b := range raw {
+		acc = acc<<8 | uint(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			sb.WriteByte(bech32Charset[(acc>>bits)&31])
+		}
+	}
+	sb.WriteByte(bech32Charset[(acc<<(5-bits))&31])
+	sb.WriteString(strings.Repeat("q", bolt11SignatureLen+bech32ChecksumLen))
+	return sb.String(), paymentHash
+}
+
+// Payments returns the invoices paid with PayInvoice (for testing).
+func (m *MockLNDClient) Payments() []Payment {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Payment(nil), m.payments...)
+}
+
+// Preimage returns the hex preimage a payer would learn by paying the invoice
+// (for testing L402 flows).
+func (m *MockLNDClient) Preimage(paymentHash string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	preimage, ok := m.preimages[paymentHash]
+	return preimage, ok
+}
+
+// CreateOffer creates a fake BOLT12 offer. Use PayOffer to simulate payers.
+func (m *MockLNDClient) CreateOffer(ctx context.Context, amountSats int64, description string, expiresAt time.Time) (*Offer, error) {
+	id, err := generatePaymentHash()
+	if err != nil {
+		return nil, err
+	}
+
+	offer := &Offer{
+		OfferID:    id,
+		Bolt12:     "lno1" + id[:20], // Fake BOLT12
+		AmountSats: amountSats,
+		ExpiresAt:  expiresAt,
+	}
+	m.mu.Lock()
+	m.offers[id] = offer
+	m.mu.Unlock()
+	return offer, nil
+}
+
+// PayOffer simulates someone paying an offer: a new invoice is created and
+// settled for it. It returns the invoice's payment hash and the preimage the
+// payer learns (for testing).
+func (m *MockLNDClient) PayOffer(offerID string) (paymentHash, preimage string, err error) {
+	m.mu.Lock()
+	offer, ok := m.offers[offerID]
+	if !ok {
+		m.mu.Unlock()
+		return "", "", ErrInvoiceNotFound
+	}
+	preimage, paymentHash, err = generatePreimage()
+	if err != nil {
+		m.mu.Unlock()
+		return "", "", err
+	}
+	m.invoices[paymentHash] = &Invoice{PaymentHash: paymentHash, AmountSats: offer.AmountSats}
+	m.preimages[paymentHash] = preimage
+	m.settled[paymentHash] = true
+	m.mu.Unlock()
+
+	m.updates <- InvoiceUpdate{
+		PaymentHash: paymentHash,
+		Settled:     true,
+		OfferID:     offerID,
+	}
+	return paymentHash, preimage, nil
+}
+
 func generatePaymentHash() (string, error) {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
@@ -74,3 +338,13 @@ func generatePaymentHash() (string, error) {
 	}
 	return hex.EncodeToString(bytes), nil
 }
+
+// generatePreimage returns a random hex preimage and its payment hash.
+func generatePreimage() (preimage, hash string, err error) {
+	bytes := make([]byte, 32)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", "", err
+	}
+	sum := sha256.Sum256(bytes)
+	return hex.EncodeToString(bytes), hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,554 @@
+package payments
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testMacaroonHex = "0201036c6e64"
+
+// fakeLND is a minimal stand-in for lnd's REST API.
+type fakeLND struct {
+	mu       sync.Mutex
+	invoices map[string]int64  // hex hash -> value
+	settled  map[string]bool   // hex hashes reported as SETTLED by lookups
+	events   chan string       // hex hashes to report as settled on the stream
+	streams  int               // number of subscribe connections seen
+	lastIdx  []string          // settle_index query values seen per connection
+	feeLimit string            // fee_limit.fixed of the last payment
+	payments map[string]string // hex hash -> status reported by track
+	descHash map[string][]byte // hex hash -> description_hash of the invoice
+}
+
+func newFakeLND() *fakeLND {
+	return &fakeLND{
+		invoices: make(map[string]int64),
+		settled:  make(map[string]bool),
+		events:   make(chan string, 10),
+		descHash: make(map[string][]byte),
+	}
+}
+
+func (f *fakeLND) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Grpc-Metadata-macaroon") != testMacaroonHex {
+		http.Error(w, `{"code":2,"message":"verification failed"}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/getinfo":
+		w.Write([]byte(`{"alias":"fake","synced_to_chain":true}`))
+
+	case r.Method == "POST" && r.URL.Path == "/v1/invoices":
+		var req struct {
+			Value           string `json:"value"`
+			Memo            string `json:"memo"`
+			DescriptionHash []byte `json:"description_hash"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		hash, _ := generatePaymentHash()
+		raw, _ := hex.DecodeString(hash)
+		var value int64
+		fmt.Sscanf(req.Value, "%d", &value)
+
+		f.mu.Lock()
+		f.invoices[hash] = value
+		f.descHash[hash] = req.DescriptionHash
+		f.mu.Unlock()
+
+		json.NewEncoder(w).Encode(map[string]string{
+			"r_hash":          base64.StdEncoding.EncodeToString(raw),
+			"payment_request": "lnbcrt" + hash[:20],
+			"add_index":       "1",
+		})
+
+	case r.Method == "POST" && r.URL.Path == "/v2/invoices/cancel":
+		var req lndCancelInvoiceRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		hash, _ := decodeLNDHash(req.PaymentHash)
+		f.mu.Lock()
+		_, ok := f.invoices[hash]
+		delete(f.invoices, hash)
+		f.mu.Unlock()
+		if !ok {
+			http.Error(w, `{"code":5,"message":"unable to locate invoice"}`, http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{}`))
+
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v1/invoice/"):
+		hash := strings.TrimPrefix(r.URL.Path, "/v1/invoice/")
+		f.mu.Lock()
+		_, ok := f.invoices[hash]
+		settled := f.settled[hash]
+		f.mu.Unlock()
+		if !ok {
+			http.Error(w, `{"code":5,"message":"unable to locate invoice"}`, http.StatusNotFound)
+			return
+		}
+		state := "OPEN"
+		if settled {
+			state = "SETTLED"
+		}
+		json.NewEncoder(w).Encode(lndInvoice{State: state})
+
+	case r.Method == "POST" && r.URL.Path == "/v1/channels/transactions":
+		var req struct {
+			PaymentRequest string `json:"payment_request"`
+			FeeLimit       struct {
+				Fixed string `json:"fixed"`
+			} `json:"fee_limit"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		f.mu.Lock()
+		f.feeLimit = req.FeeLimit.Fixed
+		f.mu.Unlock()
+
+		if strings.Contains(req.PaymentRequest, "noroute") {
+			json.NewEncoder(w).Encode(map[string]string{"payment_error": "unable to find a path to destination"})
+			return
+		}
+		preimage, hash, _ := generatePreimage()
+		rawPreimage, _ := hex.DecodeString(preimage)
+		rawHash, _ := hex.DecodeString(hash)
+		json.NewEncoder(w).Encode(map[string]any{
+			"payment_preimage": base64.StdEncoding.EncodeToString(rawPreimage),
+			"payment_hash":     base64.StdEncoding.EncodeToString(rawHash),
+			"payment_route": map[string]string{
+				"total_fees": "2",
+				"total_amt":  "502",
+			},
+		})
+
+	case r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/v2/router/track/"):
+		raw, err := base64.URLEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/v2/router/track/"))
+		if err != nil {
+			http.Error(w, `{"code":3,"message":"invalid hash"}`, http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		status, ok := f.payments[hex.EncodeToString(raw)]
+		f.mu.Unlock()
+		if !ok {
+			w.Write([]byte(`{"error":{"code":5,"message":"payment isn't initiated"}}` + "\n"))
+			return
+		}
+		fmt.Fprintf(w, `{"result":{"status":%q}}`+"\n", status)
+
+	case r.Method == "GET" && r.URL.Path == "/v1/invoices/subscribe":
+		f.mu.Lock()
+		f.streams++
+		f.lastIdx = append(f.lastIdx, r.URL.Query().Get("settle_index"))
+		events := f.events
+		f.mu.Unlock()
+
+		flusher := w.(http.Flusher)
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		idx := 0
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case hash, ok := <-events:
+				if !ok {
+					// Simulate lnd dropping the stream
+					return
+				}
+				idx++
+				raw, _ := hex.DecodeString(hash)
+				json.NewEncoder(w).Encode(map[string]any{
+					"result": map[string]string{
+						"r_hash":       base64.StdEncoding.EncodeToString(raw),
+						"state":        "SETTLED",
+						"settle_index": fmt.Sprintf("%d", idx),
+					},
+				})
+				flusher.Flush()
+			}
+		}
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// writeServerCert writes the httptest server's certificate to a temp file.
+func writeServerCert(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	certPath := filepath.Join(t.TempDir(), "tls.cert")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatalf("failed to write cert: %v", err)
+	}
+	return certPath
+}
+
+func newTestLNDClient(t *testing.T, fake *fakeLND) *LNDRESTClient {
+	t.Helper()
+	srv := httptest.NewTLSServer(fake)
+	t.Cleanup(srv.Close)
+
+	client, err := NewLNDRESTClient(LNDConfig{
+		Host:        srv.URL,
+		MacaroonHex: testMacaroonHex,
+		TLSCertPath: writeServerCert(t, srv),
+	})
+	if err != nil {
+		t.Fatalf("NewLNDRESTClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestNewLNDRESTClient_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  LNDConfig
+	}{
+		{"missing host", LNDConfig{MacaroonHex: testMacaroonHex}},
+		{"missing macaroon", LNDConfig{Host: "https://localhost:8080"}},
+		{"missing macaroon file", LNDConfig{Host: "https://localhost:8080", MacaroonPath: "/nonexistent/invoice.macaroon"}},
+		{"missing cert file", LNDConfig{Host: "https://localhost:8080", MacaroonHex: testMacaroonHex, TLSCertPath: "/nonexistent/tls.cert"}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewLNDRESTClient(tc.cfg); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestNewLNDRESTClient_MacaroonFile(t *testing.T) {
+	srv := httptest.NewTLSServer(newFakeLND())
+	defer srv.Close()
+
+	raw, _ := hex.DecodeString(testMacaroonHex)
+	macPath := filepath.Join(t.TempDir(), "invoice.macaroon")
+	os.WriteFile(macPath, raw, 0600)
+
+	client, err := NewLNDRESTClient(LNDConfig{
+		Host:         srv.URL,
+		MacaroonPath: macPath,
+		TLSCertPath:  writeServerCert(t, srv),
+	})
+	if err != nil {
+		t.Fatalf("expected connection with macaroon file, got: %v", err)
+	}
+	client.Close()
+}
+
+func TestNewLNDRESTClient_RejectsUntrustedCert(t *testing.T) {
+	srv := httptest.NewTLSServer(newFakeLND())
+	defer srv.Close()
+
+	// Without pinning the self-signed cert the TLS handshake must fail
+	_, err := NewLNDRESTClient(LNDConfig{
+		Host:        srv.URL,
+		MacaroonHex: testMacaroonHex,
+	})
+	if err == nil {
+		t.Error("expected TLS verification error")
+	}
+}
+
+func TestNewLNDRESTClient_BadMacaroon(t *testing.T) {
+	srv := httptest.NewTLSServer(newFakeLND())
+	defer srv.Close()
+
+	_, err := NewLNDRESTClient(LNDConfig{
+		Host:        srv.URL,
+		MacaroonHex: "deadbeef",
+		TLSCertPath: writeServerCert(t, srv),
+	})
+	if err == nil {
+		t.Error("expected error for rejected macaroon")
+	}
+}
+
+func TestLNDRESTClient_CreateInvoice(t *testing.T) {
+	fake := newFakeLND()
+	client := newTestLNDClient(t, fake)
+
+	inv, err := client.CreateInvoice(context.Background(), 1500, "test memo")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+
+	if len(inv.PaymentHash) != 64 {
+		t.Errorf("expected 64-char hex payment hash, got %q", inv.PaymentHash)
+	}
+	if inv.PaymentRequest == "" {
+		t.Error("expected payment request")
+	}
+	if inv.AmountSats != 1500 {
+		t.Errorf("expected 1500 sats, got %d", inv.AmountSats)
+	}
+
+	fake.mu.Lock()
+	value, ok := fake.invoices[inv.PaymentHash]
+	fake.mu.Unlock()
+	if !ok {
+		t.Fatal("invoice hash does not match what lnd returned")
+	}
+	if value != 1500 {
+		t.Errorf("lnd received value %d, want 1500", value)
+	}
+}
+
+func TestLNDRESTClient_CreateInvoiceForMetadata(t *testing.T) {
+	fake := newFakeLND()
+	client := newTestLNDClient(t, fake)
+
+	metadata := `[["text/plain","test"]]`
+	inv, err := client.CreateInvoiceForMetadata(context.Background(), 1500, metadata)
+	if err != nil {
+		t.Fatalf("CreateInvoiceForMetadata failed: %v", err)
+	}
+
+	want := sha256.Sum256([]byte(metadata))
+	fake.mu.Lock()
+	got := fake.descHash[inv.PaymentHash]
+	value := fake.invoices[inv.PaymentHash]
+	fake.mu.Unlock()
+	if !bytes.Equal(got, want[:]) {
+		t.Errorf("lnd received description_hash %x, want %x", got, want)
+	}
+	if value != 1500 || inv.AmountSats != 1500 {
+		t.Errorf("expected a 1500 sat invoice, lnd received %d", value)
+	}
+}
+
+func TestLNDRESTClient_LookupInvoice(t *testing.T) {
+	fake := newFakeLND()
+	client := newTestLNDClient(t, fake)
+	ctx := context.Background()
+
+	inv, err := client.CreateInvoice(ctx, 100, "")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+
+	update, err := client.LookupInvoice(ctx, inv.PaymentHash)
+	if err != nil {
+		t.Fatalf("LookupInvoice failed: %v", err)
+	}
+	if update.Settled {
+		t.Error("expected open invoice to be unsettled")
+	}
+
+	fake.mu.Lock()
+	fake.settled[inv.PaymentHash] = true
+	fake.mu.Unlock()
+
+	update, err = client.LookupInvoice(ctx, inv.PaymentHash)
+	if err != nil {
+		t.Fatalf("LookupInvoice failed: %v", err)
+	}
+	if !update.Settled || update.PaymentHash != inv.PaymentHash {
+		t.Errorf("unexpected update %+v", update)
+	}
+
+	missing, _ := generatePaymentHash()
+	if _, err := client.LookupInvoice(ctx, missing); err != ErrInvoiceNotFound {
+		t.Errorf("expected ErrInvoiceNotFound, got %v", err)
+	}
+}
+
+func TestLNDRESTClient_CancelInvoice(t *testing.T) {
+	fake := newFakeLND()
+	client := newTestLNDClient(t, fake)
+	ctx := context.Background()
+
+	inv, err := client.CreateInvoice(ctx, 100, "")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+	if inv.ExpiresAt.Before(time.Now().Add(DefaultInvoiceExpiry - time.Minute)) {
+		t.Errorf("unexpected expiry %v", inv.ExpiresAt)
+	}
+
+	if err := client.CancelInvoice(ctx, inv.PaymentHash); err != nil {
+		t.Fatalf("CancelInvoice failed: %v", err)
+	}
+	if _, err := client.LookupInvoice(ctx, inv.PaymentHash); err != ErrInvoiceNotFound {
+		t.Errorf("expected cancelled invoice to be gone, got %v", err)
+	}
+	if err := client.CancelInvoice(ctx, inv.PaymentHash); err == nil {
+		t.Error("expected error cancelling unknown invoice")
+	}
+}
+
+func TestLNDRESTClient_PayInvoice(t *testing.T) {
+	fake := newFakeLND()
+	client := newTestLNDClient(t, fake)
+	ctx := context.Background()
+
+	payment, err := client.PayInvoice(ctx, "lnbcrt5u1paytest", 15)
+	if err != nil {
+		t.Fatalf("PayInvoice failed: %v", err)
+	}
+	if len(payment.PaymentHash) != 64 || len(payment.Preimage) != 64 {
+		t.Errorf("expected hex hash and preimage, got %+v", payment)
+	}
+	if payment.AmountSats != 500 || payment.FeeSats != 2 {
+		t.Errorf("expected 500 sats + 2 fee, got %d + %d", payment.AmountSats, payment.FeeSats)
+	}
+	fake.mu.Lock()
+	feeLimit := fake.feeLimit
+	fake.mu.Unlock()
+	if feeLimit != "15" {
+		t.Errorf("expected fee limit 15, got %q", feeLimit)
+	}
+
+	if _, err := client.PayInvoice(ctx, "lnbcrt5u1noroute", 15); err == nil {
+		t.Error("expected error when lnd reports a payment error")
+	}
+}
+
+func TestLNDRESTClient_LookupPayment(t *testing.T) {
+	fake := newFakeLND()
+	succeeded, _ := generatePaymentHash()
+	inFlight, _ := generatePaymentHash()
+	failed, _ := generatePaymentHash()
+	unknown, _ := generatePaymentHash()
+	fake.payments = map[string]string{
+		succeeded: "SUCCEEDED",
+		inFlight:  "IN_FLIGHT",
+		failed:    "FAILED",
+	}
+	client := newTestLNDClient(t, fake)
+
+	tests := []struct {
+		hash    string
+		want    PaymentState
+		wantErr error
+	}{
+		{succeeded, PaymentSucceeded, nil},
+		{inFlight, PaymentPending, nil},
+		{failed, PaymentFailed, nil},
+		{unknown, 0, ErrPaymentNotFound},
+	}
+	for _, tc := range tests {
+		state, err := client.LookupPayment(context.Background(), tc.hash)
+		if err != tc.wantErr || state != tc.want {
+			t.Errorf("LookupPayment(%s) = %v, %v; want %v, %v", tc.hash[:8], state, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestLNDRESTClient_SubscribeInvoices(t *testing.T) {
+	fake := newFakeLND()
+	client := newTestLNDClient(t, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, err := client.SubscribeInvoices(ctx)
+	if err != nil {
+		t.Fatalf("SubscribeInvoices failed: %v", err)
+	}
+
+	inv, _ := client.CreateInvoice(ctx, 100, "")
+	fake.events <- inv.PaymentHash
+
+	select {
+	case update := <-updates:
+		if update.PaymentHash != inv.PaymentHash {
+			t.Errorf("expected hash %s, got %s", inv.PaymentHash, update.PaymentHash)
+		}
+		if !update.Settled {
+			t.Error("expected settled update")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for settlement update")
+	}
+}
+
+func TestLNDRESTClient_SubscribeReconnects(t *testing.T) {
+	fake := newFakeLND()
+	client := newTestLNDClient(t, fake)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates, _ := client.SubscribeInvoices(ctx)
+
+	hash, _ := generatePaymentHash()
+	fake.events <- hash
+	select {
+	case <-updates:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for first update")
+	}
+
+	// Drop the stream; the client should reconnect and resume from settle_index 1
+	oldEvents := fake.events
+	fake.mu.Lock()
+	fake.events = make(chan string, 10)
+	fake.mu.Unlock()
+	close(oldEvents)
+
+	hash2, _ := generatePaymentHash()
+	fake.mu.Lock()
+	fake.events <- hash2
+	fake.mu.Unlock()
+
+	select {
+	case update := <-updates:
+		if update.PaymentHash != hash2 {
+			t.Errorf("expected hash %s after reconnect, got %s", hash2, update.PaymentHash)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for update after reconnect")
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.streams < 2 {
+		t.Errorf("expected at least 2 subscribe connections, got %d", fake.streams)
+	}
+	if fake.lastIdx[len(fake.lastIdx)-1] != "1" {
+		t.Errorf("expected reconnect with settle_index=1, got %q", fake.lastIdx[len(fake.lastIdx)-1])
+	}
+}
+
+func TestDecodeLNDHash(t *testing.T) {
+	raw := make([]byte, 32)
+	raw[0] = 0xab
+	want := hex.EncodeToString(raw)
+
+	got, err := decodeLNDHash(base64.StdEncoding.EncodeToString(raw))
+	if err != nil || got != want {
+		t.Errorf("decodeLNDHash(std) = %q, %v; want %q", got, err, want)
+	}
+
+	got, err = decodeLNDHash(base64.URLEncoding.EncodeToString(raw))
+	if err != nil || got != want {
+		t.Errorf("decodeLNDHash(url) = %q, %v; want %q", got, err, want)
+	}
+
+	if _, err := decodeLNDHash(base64.StdEncoding.EncodeToString([]byte("short"))); err == nil {
+		t.Error("expected error for wrong-length hash")
+	}
+}
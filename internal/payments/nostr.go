@@ -0,0 +1,199 @@
+package payments
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+// nostrEvent is a NIP-01 event.
+type nostrEvent struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// nostrFilter is a NIP-01 subscription filter.
+type nostrFilter struct {
+	Kinds   []int    `json:"kinds,omitempty"`
+	Authors []string `json:"authors,omitempty"`
+	PTags   []string `json:"#p,omitempty"`
+	ETags   []string `json:"#e,omitempty"`
+	Since   int64    `json:"since,omitempty"`
+}
+
+// serializeForID returns the canonical serialization used to compute the event ID.
+func (e *nostrEvent) serializeForID() ([]byte, error) {
+	tags := e.Tags
+	if tags == nil {
+		tags = [][]string{}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false) // NIP-01 forbids escaping beyond the JSON minimum
+	if err := enc.Encode([]any{0, e.PubKey, e.CreatedAt, e.Kind, tags, e.Content}); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// sign sets the event's pubkey, ID and signature.
+func (e *nostrEvent) sign(key *btcec.PrivateKey) error {
+	e.PubKey = hex.EncodeToString(schnorr.SerializePubKey(key.PubKey()))
+	if e.Tags == nil {
+		e.Tags = [][]string{}
+	}
+
+	serialized, err := e.serializeForID()
+	if err != nil {
+		return err
+	}
+	id := sha256.Sum256(serialized)
+	sig, err := schnorr.Sign(key, id[:])
+	if err != nil {
+		return err
+	}
+
+	e.ID = hex.EncodeToString(id[:])
+	e.Sig = hex.EncodeToString(sig.Serialize())
+	return nil
+}
+
+// verify checks the event ID and signature.
+func (e *nostrEvent) verify() error {
+	serialized, err := e.serializeForID()
+	if err != nil {
+		return err
+	}
+	id := sha256.Sum256(serialized)
+	if hex.EncodeToString(id[:]) != e.ID {
+		return errors.New("event id mismatch")
+	}
+
+	pubBytes, err := hex.DecodeString(e.PubKey)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	pub, err := schnorr.ParsePubKey(pubBytes)
+	if err != nil {
+		return fmt.Errorf("invalid pubkey: %w", err)
+	}
+	sigBytes, err := hex.DecodeString(e.Sig)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	sig, err := schnorr.ParseSignature(sigBytes)
+	if err != nil {
+		return fmt.Errorf("invalid signature: %w", err)
+	}
+	if !sig.Verify(id[:], pub) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// tag returns the first value of the named tag, or "" if absent.
+func (e *nostrEvent) tag(name string) string {
+	for _, t := range e.Tags {
+		if len(t) >= 2 && t[0] == name {
+			return t[1]
+		}
+	}
+	return ""
+}
+
+// parseNostrPubKey parses a hex-encoded x-only public key.
+func parseNostrPubKey(hexKey string) (*btcec.PublicKey, error) {
+	raw, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.ParsePubKey(raw)
+}
+
+// parseNostrSecret parses a hex-encoded private key.
+func parseNostrSecret(hexKey string) (*btcec.PrivateKey, error) {
+	raw, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("expected 32-byte secret, got %d bytes", len(raw))
+	}
+	key, _ := btcec.PrivKeyFromBytes(raw)
+	return key, nil
+}
+
+// nip04Encrypt encrypts plaintext for the given recipient as described in NIP-04.
+func nip04Encrypt(key *btcec.PrivateKey, recipient *btcec.PublicKey, plaintext string) (string, error) {
+	block, err := aes.NewCipher(btcec.GenerateSharedSecret(key, recipient))
+	if err != nil {
+		return "", err
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+
+	// PKCS#7 padding
+	padLen := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := append([]byte(plaintext), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	return base64.StdEncoding.EncodeToString(ciphertext) + "?iv=" + base64.StdEncoding.EncodeToString(iv), nil
+}
+
+// nip04Decrypt decrypts a NIP-04 payload sent by the given sender.
+func nip04Decrypt(key *btcec.PrivateKey, sender *btcec.PublicKey, content string) (string, error) {
+	ctB64, ivB64, ok := strings.Cut(content, "?iv=")
+	if !ok {
+		return "", errors.New("missing iv")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(ctB64)
+	if err != nil {
+		return "", fmt.Errorf("invalid ciphertext: %w", err)
+	}
+	iv, err := base64.StdEncoding.DecodeString(ivB64)
+	if err != nil {
+		return "", fmt.Errorf("invalid iv: %w", err)
+	}
+	if len(iv) != aes.BlockSize || len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return "", errors.New("malformed payload")
+	}
+
+	block, err := aes.NewCipher(btcec.GenerateSharedSecret(key, sender))
+	if err != nil {
+		return "", err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+
+	padLen := int(plaintext[len(plaintext)-1])
+	if padLen == 0 || padLen > aes.BlockSize || padLen > len(plaintext) {
+		return "", errors.New("invalid padding")
+	}
+	for _, b := range plaintext[len(plaintext)-padLen:] {
+		if int(b) != padLen {
+			return "", errors.New("invalid padding")
+		}
+	}
+	return string(plaintext[:len(plaintext)-padLen]), nil
+}
@@ -0,0 +1,656 @@
+package payments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"golang.org/x/net/websocket"
+
+	"satoshisend/internal/logging"
+)
+
+// NIP-47 event kinds.
+const (
+	nwcKindRequest      = 23194
+	nwcKindResponse     = 23195
+	nwcKindNotification = 23196
+)
+
+// nwcRequestTimeout bounds how long we wait for the wallet to answer a request.
+const nwcRequestTimeout = 30 * time.Second
+
+// NWCClient implements LNDClient over Nostr Wallet Connect (NIP-47).
+// It keeps a single relay connection open, reconnecting with backoff, and
+// listens for payment_received notifications to report settlements.
+type NWCClient struct {
+	relayURL     string
+	walletPub    *btcec.PublicKey
+	walletPubHex string
+	secret       *btcec.PrivateKey
+	clientPubHex string
+
+	writeMu sync.Mutex // Serializes writes to the websocket
+
+	mu          sync.Mutex
+	conn        *websocket.Conn
+	connected   chan struct{}            // Closed while a connection is up
+	waiters     map[string]chan nwcReply // Request event ID -> reply
+	outstanding map[string]struct{}      // Hashes of invoices we created and haven't seen settled
+
+	updates chan InvoiceUpdate
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NWCConfig holds configuration for the Nostr Wallet Connect client.
+type NWCConfig struct {
+	URI string // nostr+walletconnect://<wallet-pubkey>?relay=wss://...&secret=<hex>
+}
+
+type nwcReply struct {
+	event *nostrEvent
+	err   error
+}
+
+type nwcRequest struct {
+	Method string `json:"method"`
+	Params any    `json:"params"`
+}
+
+type nwcResponse struct {
+	ResultType string          `json:"result_type"`
+	Error      *nwcError       `json:"error"`
+	Result     json.RawMessage `json:"result"`
+}
+
+type nwcError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *nwcError) Error() string {
+	return fmt.Sprintf("wallet error %s: %s", e.Code, e.Message)
+}
+
+type nwcMakeInvoiceParams struct {
+	Amount          int64  `json:"amount"` // msats
+	Description     string `json:"description,omitempty"`
+	DescriptionHash string `json:"description_hash,omitempty"` // hex
+	Expiry          int64  `json:"expiry,omitempty"`           // seconds
+}
+
+type nwcPayInvoiceParams struct {
+	Invoice string `json:"invoice"`
+}
+
+type nwcPayInvoiceResult struct {
+	Preimage string `json:"preimage"`
+	FeesPaid int64  `json:"fees_paid"` // msats
+}
+
+type nwcLookupInvoiceParams struct {
+	PaymentHash string `json:"payment_hash"`
+}
+
+// nwcTransaction is the transaction object returned by make_invoice,
+// lookup_invoice and payment notifications.
+type nwcTransaction struct {
+	Type        string `json:"type"`
+	State       string `json:"state,omitempty"`
+	Invoice     string `json:"invoice"`
+	PaymentHash string `json:"payment_hash"`
+	Amount      int64  `json:"amount"` // msats
+	CreatedAt   int64  `json:"created_at"`
+	ExpiresAt   int64  `json:"expires_at,omitempty"`
+	SettledAt   int64  `json:"settled_at,omitempty"`
+}
+
+func (t *nwcTransaction) settled() bool {
+	return t.SettledAt > 0 || t.State == "settled"
+}
+
+type nwcNotification struct {
+	NotificationType string         `json:"notification_type"`
+	Notification     nwcTransaction `json:"notification"`
+}
+
+// ParseNWCURI parses a nostr+walletconnect:// connection URI into its
+// wallet pubkey, relay URL and client secret.
+func ParseNWCURI(uri string) (walletPubHex, relayURL, secretHex string, err error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return "", "", "", err
+	}
+	if u.Scheme != "nostr+walletconnect" && u.Scheme != "nostrwalletconnect" {
+		return "", "", "", fmt.Errorf("unexpected scheme %q", u.Scheme)
+	}
+
+	// The pubkey is in the host part, or the opaque part for "scheme:pubkey?..." URIs
+	walletPubHex = u.Host
+	if walletPubHex == "" {
+		walletPubHex = strings.TrimPrefix(u.Opaque, "//")
+	}
+	relayURL = u.Query().Get("relay")
+	secretHex = u.Query().Get("secret")
+
+	if walletPubHex == "" {
+		return "", "", "", errors.New("missing wallet pubkey")
+	}
+	if relayURL == "" {
+		return "", "", "", errors.New("missing relay")
+	}
+	if secretHex == "" {
+		return "", "", "", errors.New("missing secret")
+	}
+	return walletPubHex, relayURL, secretHex, nil
+}
+
+// NewNWCClient creates a Nostr Wallet Connect client and connects to the relay.
+func NewNWCClient(cfg NWCConfig) (*NWCClient, error) {
+	walletPubHex, relayURL, secretHex, err := ParseNWCURI(cfg.URI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid connection URI: %w", err)
+	}
+	walletPub, err := parseNostrPubKey(walletPubHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid wallet pubkey: %w", err)
+	}
+	secret, err := parseNostrSecret(secretHex)
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %w", err)
+	}
+
+	probe := &nostrEvent{}
+	if err := probe.sign(secret); err != nil {
+		return nil, err
+	}
+
+	c := &NWCClient{
+		relayURL:     relayURL,
+		walletPub:    walletPub,
+		walletPubHex: strings.ToLower(walletPubHex),
+		secret:       secret,
+		clientPubHex: probe.PubKey,
+		connected:    make(chan struct{}),
+		waiters:      make(map[string]chan nwcReply),
+		outstanding:  make(map[string]struct{}),
+		updates:      make(chan InvoiceUpdate, 1000),
+		done:         make(chan struct{}),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to relay: %w", err)
+	}
+	logging.NWC.Printf("connected to relay %s", relayURL)
+
+	go c.run(conn)
+
+	return c, nil
+}
+
+// dial opens a websocket to the relay and subscribes to wallet responses
+// and notifications addressed to us.
+func (c *NWCClient) dial(ctx context.Context) (*websocket.Conn, error) {
+	origin := strings.Replace(strings.Replace(c.relayURL, "wss://", "https://", 1), "ws://", "http://", 1)
+	wsCfg, err := websocket.NewConfig(c.relayURL, origin)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := wsCfg.DialContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Look back a little so responses racing the (re)connect aren't lost
+	since := time.Now().Add(-time.Minute).Unix()
+	subs := []struct {
+		id     string
+		filter nostrFilter
+	}{
+		{"nwc-responses", nostrFilter{Kinds: []int{nwcKindResponse}, Authors: []string{c.walletPubHex}, PTags: []string{c.clientPubHex}, Since: since}},
+		{"nwc-notifications", nostrFilter{Kinds: []int{nwcKindNotification}, Authors: []string{c.walletPubHex}, PTags: []string{c.clientPubHex}, Since: since}},
+	}
+	for _, sub := range subs {
+		if err := c.writeJSON(conn, []any{"REQ", sub.id, sub.filter}); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	return conn, nil
+}
+
+func (c *NWCClient) writeJSON(conn *websocket.Conn, msg any) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	return websocket.Message.Send(conn, string(data))
+}
+
+// run services the connection and reconnects with backoff until Close is called.
+func (c *NWCClient) run(conn *websocket.Conn) {
+	backoff := time.Second
+	const maxBackoff = time.Minute
+	first := true
+
+	for {
+		c.mu.Lock()
+		c.conn = conn
+		close(c.connected)
+		c.mu.Unlock()
+
+		if !first {
+			logging.NWC.Printf("reconnected to relay %s", c.relayURL)
+			go c.recoverMissed()
+		}
+		first = false
+
+		start := time.Now()
+		err := c.readLoop(conn)
+		conn.Close()
+
+		c.mu.Lock()
+		c.conn = nil
+		c.connected = make(chan struct{})
+		c.mu.Unlock()
+
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		if time.Since(start) > maxBackoff {
+			backoff = time.Second
+		}
+		logging.NWC.Printf("relay connection lost: %v (reconnecting in %s)", err, backoff)
+
+		for {
+			select {
+			case <-c.done:
+				return
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			conn, err = c.dial(ctx)
+			cancel()
+			if err == nil {
+				break
+			}
+			logging.NWC.Printf("reconnect failed: %v (retrying in %s)", err, backoff)
+		}
+	}
+}
+
+func (c *NWCClient) readLoop(conn *websocket.Conn) error {
+	for {
+		var raw string
+		if err := websocket.Message.Receive(conn, &raw); err != nil {
+			return err
+		}
+
+		var msg []json.RawMessage
+		if err := json.Unmarshal([]byte(raw), &msg); err != nil || len(msg) == 0 {
+			continue
+		}
+		var typ string
+		json.Unmarshal(msg[0], &typ)
+
+		switch typ {
+		case "EVENT":
+			if len(msg) < 3 {
+				continue
+			}
+			var ev nostrEvent
+			if err := json.Unmarshal(msg[2], &ev); err != nil {
+				continue
+			}
+			c.handleEvent(&ev)
+		case "OK":
+			if len(msg) < 4 {
+				continue
+			}
+			var id, reason string
+			var accepted bool
+			json.Unmarshal(msg[1], &id)
+			json.Unmarshal(msg[2], &accepted)
+			json.Unmarshal(msg[3], &reason)
+			if !accepted {
+				c.deliver(id, nwcReply{err: fmt.Errorf("relay rejected request: %s", reason)})
+			}
+		case "NOTICE", "CLOSED":
+			logging.NWC.Printf("relay %s: %s", strings.ToLower(typ), raw)
+		}
+	}
+}
+
+func (c *NWCClient) handleEvent(ev *nostrEvent) {
+	if strings.ToLower(ev.PubKey) != c.walletPubHex {
+		return
+	}
+	if err := ev.verify(); err != nil {
+		logging.NWC.Printf("dropping event with invalid signature: %v", err)
+		return
+	}
+
+	switch ev.Kind {
+	case nwcKindResponse:
+		if reqID := ev.tag("e"); reqID != "" {
+			c.deliver(reqID, nwcReply{event: ev})
+		}
+	case nwcKindNotification:
+		plaintext, err := nip04Decrypt(c.secret, c.walletPub, ev.Content)
+		if err != nil {
+			logging.NWC.Printf("failed to decrypt notification: %v", err)
+			return
+		}
+		var n nwcNotification
+		if err := json.Unmarshal([]byte(plaintext), &n); err != nil {
+			logging.NWC.Printf("failed to parse notification: %v", err)
+			return
+		}
+		if n.NotificationType == "payment_received" && n.Notification.PaymentHash != "" {
+			c.emitSettled(n.Notification.PaymentHash)
+		}
+	}
+}
+
+func (c *NWCClient) deliver(requestID string, reply nwcReply) {
+	c.mu.Lock()
+	ch, ok := c.waiters[requestID]
+	if ok {
+		delete(c.waiters, requestID)
+	}
+	c.mu.Unlock()
+
+	if ok {
+		ch <- reply
+	}
+}
+
+func (c *NWCClient) emitSettled(paymentHash string) {
+	c.mu.Lock()
+	delete(c.outstanding, paymentHash)
+	c.mu.Unlock()
+
+	logging.NWC.Printf("invoice %s settled", ShortHash(paymentHash))
+
+	// Wait for room rather than drop the settlement; the relay won't resend it
+	select {
+	case c.updates <- InvoiceUpdate{PaymentHash: paymentHash, Settled: true}:
+	case <-c.done:
+	}
+}
+
+// recoverMissed looks up invoices created by this client that were still
+// unpaid when the relay connection dropped, since notifications sent during
+// the outage are not guaranteed to be replayed by the relay.
+func (c *NWCClient) recoverMissed() {
+	c.mu.Lock()
+	hashes := make([]string, 0, len(c.outstanding))
+	for h := range c.outstanding {
+		hashes = append(hashes, h)
+	}
+	c.mu.Unlock()
+
+	for _, hash := range hashes {
+		ctx, cancel := context.WithTimeout(context.Background(), nwcRequestTimeout)
+		tx, err := c.lookupInvoice(ctx, hash)
+		cancel()
+		if err != nil {
+			logging.NWC.Printf("lookup of invoice %s failed: %v", ShortHash(hash), err)
+			continue
+		}
+		if tx.settled() {
+			c.emitSettled(hash)
+		}
+	}
+}
+
+// request sends a NIP-47 request and waits for the wallet's response.
+func (c *NWCClient) request(ctx context.Context, method string, params any, out any) error {
+	plaintext, err := json.Marshal(nwcRequest{Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %w", err)
+	}
+	content, err := nip04Encrypt(c.secret, c.walletPub, string(plaintext))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt request: %w", err)
+	}
+
+	ev := &nostrEvent{
+		CreatedAt: time.Now().Unix(),
+		Kind:      nwcKindRequest,
+		Tags:      [][]string{{"p", c.walletPubHex}},
+		Content:   content,
+	}
+	if err := ev.sign(c.secret); err != nil {
+		return fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, nwcRequestTimeout)
+	defer cancel()
+
+	replyCh := make(chan nwcReply, 1)
+	c.mu.Lock()
+	c.waiters[ev.ID] = replyCh
+	connected := c.connected
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.waiters, ev.ID)
+		c.mu.Unlock()
+	}()
+
+	// Wait for the relay connection if we're between reconnects
+	select {
+	case <-connected:
+	case <-c.done:
+		return errors.New("client closed")
+	case <-ctx.Done():
+		return fmt.Errorf("relay unavailable: %w", ctx.Err())
+	}
+
+	c.mu.Lock()
+	conn := c.conn
+	c.mu.Unlock()
+	if conn == nil {
+		return errors.New("relay connection lost")
+	}
+	if err := c.writeJSON(conn, []any{"EVENT", ev}); err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var reply nwcReply
+	select {
+	case reply = <-replyCh:
+	case <-c.done:
+		return errors.New("client closed")
+	case <-ctx.Done():
+		return fmt.Errorf("no response from wallet: %w", ctx.Err())
+	}
+	if reply.err != nil {
+		return reply.err
+	}
+
+	respText, err := nip04Decrypt(c.secret, c.walletPub, reply.event.Content)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt response: %w", err)
+	}
+	var resp nwcResponse
+	if err := json.Unmarshal([]byte(respText), &resp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if out != nil {
+		if err := json.Unmarshal(resp.Result, out); err != nil {
+			return fmt.Errorf("failed to decode result: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *NWCClient) CreateInvoice(ctx context.Context, amountSats int64, memo string) (*Invoice, error) {
+	return c.CreateInvoiceWithExpiry(ctx, amountSats, memo, DefaultInvoiceExpiry)
+}
+
+// CreateInvoiceWithExpiry creates an invoice that can be paid for expiry.
+func (c *NWCClient) CreateInvoiceWithExpiry(ctx context.Context, amountSats int64, memo string, expiry time.Duration) (*Invoice, error) {
+	return c.makeInvoice(ctx, amountSats, nwcMakeInvoiceParams{Description: memo, Expiry: int64(expiry / time.Second)})
+}
+
+// CreateInvoiceForMetadata creates an invoice committing to the SHA-256 of
+// metadata, for LNURL-pay.
+func (c *NWCClient) CreateInvoiceForMetadata(ctx context.Context, amountSats int64, metadata string) (*Invoice, error) {
+	hash := sha256.Sum256([]byte(metadata))
+	return c.makeInvoice(ctx, amountSats, nwcMakeInvoiceParams{
+		DescriptionHash: hex.EncodeToString(hash[:]),
+		Expiry:          int64(DefaultInvoiceExpiry / time.Second),
+	})
+}
+
+func (c *NWCClient) makeInvoice(ctx context.Context, amountSats int64, params nwcMakeInvoiceParams) (*Invoice, error) {
+	params.Amount = amountSats * 1000
+
+	var tx nwcTransaction
+	err := c.request(ctx, "make_invoice", params, &tx)
+	if err != nil {
+		return nil, err
+	}
+	if tx.PaymentHash == "" || tx.Invoice == "" {
+		return nil, errors.New("wallet returned incomplete invoice")
+	}
+
+	c.mu.Lock()
+	c.outstanding[tx.PaymentHash] = struct{}{}
+	c.mu.Unlock()
+
+	logging.NWC.Printf("created invoice %s for %d sats", ShortHash(tx.PaymentHash), amountSats)
+
+	inv := &Invoice{
+		PaymentHash:    tx.PaymentHash,
+		PaymentRequest: tx.Invoice,
+		AmountSats:     amountSats,
+		ExpiresAt:      time.Now().Add(time.Duration(params.Expiry) * time.Second),
+	}
+	if tx.ExpiresAt > 0 {
+		inv.ExpiresAt = time.Unix(tx.ExpiresAt, 0)
+	}
+	return inv, nil
+}
+
+// PayInvoice pays a BOLT11 invoice from the connected wallet. NWC has no fee
+// limit parameter, so maxFeeSats is left to the wallet's own budget settings.
+func (c *NWCClient) PayInvoice(ctx context.Context, paymentRequest string, maxFeeSats int64) (*Payment, error) {
+	amountMsat, err := InvoiceAmountMsat(paymentRequest)
+	if err != nil {
+		return nil, err
+	}
+
+	var result nwcPayInvoiceResult
+	if err := c.request(ctx, "pay_invoice", nwcPayInvoiceParams{Invoice: paymentRequest}, &result); err != nil {
+		return nil, err
+	}
+
+	preimage, err := hex.DecodeString(result.Preimage)
+	if err != nil {
+		return nil, fmt.Errorf("invalid preimage from wallet: %w", err)
+	}
+	hash := sha256.Sum256(preimage)
+
+	payment := &Payment{
+		PaymentHash: hex.EncodeToString(hash[:]),
+		Preimage:    result.Preimage,
+		AmountSats:  amountMsat / 1000,
+		FeeSats:     result.FeesPaid / 1000,
+	}
+	logging.NWC.Printf("paid invoice %s (%d sats, %d sats fees)", ShortHash(payment.PaymentHash), payment.AmountSats, payment.FeeSats)
+	return payment, nil
+}
+
+func (c *NWCClient) lookupInvoice(ctx context.Context, paymentHash string) (*nwcTransaction, error) {
+	var tx nwcTransaction
+	if err := c.request(ctx, "lookup_invoice", nwcLookupInvoiceParams{PaymentHash: paymentHash}, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+func (c *NWCClient) LookupInvoice(ctx context.Context, paymentHash string) (*InvoiceUpdate, error) {
+	tx, err := c.lookupInvoice(ctx, paymentHash)
+	if err != nil {
+		var walletErr *nwcError
+		if errors.As(err, &walletErr) && walletErr.Code == "NOT_FOUND" {
+			return nil, ErrInvoiceNotFound
+		}
+		return nil, err
+	}
+	return &InvoiceUpdate{
+		PaymentHash: paymentHash,
+		Settled:     tx.settled(),
+	}, nil
+}
+
+// LookupPayment reports the state of an outgoing payment. NWC's
+// lookup_invoice covers payments as well as invoices.
+func (c *NWCClient) LookupPayment(ctx context.Context, paymentHash string) (PaymentState, error) {
+	tx, err := c.lookupInvoice(ctx, paymentHash)
+	if err != nil {
+		var walletErr *nwcError
+		if errors.As(err, &walletErr) && walletErr.Code == "NOT_FOUND" {
+			return 0, ErrPaymentNotFound
+		}
+		return 0, err
+	}
+	switch {
+	case tx.settled():
+		return PaymentSucceeded, nil
+	case tx.State == "failed":
+		return PaymentFailed, nil
+	default:
+		return PaymentPending, nil
+	}
+}
+
+func (c *NWCClient) SubscribeInvoices(ctx context.Context) (<-chan InvoiceUpdate, error) {
+	return c.updates, nil
+}
+
+func (c *NWCClient) BackendName() string {
+	return "nwc"
+}
+
+func (c *NWCClient) Close() error {
+	c.once.Do(func() {
+		close(c.done)
+		c.mu.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+		}
+		c.mu.Unlock()
+	})
+	return nil
+}
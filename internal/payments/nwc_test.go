@@ -0,0 +1,492 @@
+package payments
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+	"golang.org/x/net/websocket"
+)
+
+// fakeRelay is an in-process Nostr relay with a NIP-47 wallet attached.
+// Like real relays it does not store ephemeral events (kinds 20000-29999),
+// so notifications sent while a client is disconnected are lost.
+type fakeRelay struct {
+	srv *httptest.Server
+
+	walletKey *btcec.PrivateKey
+	walletPub string
+
+	mu       sync.Mutex
+	conns    map[*websocket.Conn]*relayConn
+	invoices map[string]*nwcTransaction
+	requests int
+}
+
+type relayConn struct {
+	mu   sync.Mutex
+	subs map[string]nostrFilter
+}
+
+func newFakeRelay(t *testing.T) *fakeRelay {
+	t.Helper()
+	key, _ := btcec.NewPrivateKey()
+	r := &fakeRelay{
+		walletKey: key,
+		walletPub: hex.EncodeToString(schnorr.SerializePubKey(key.PubKey())),
+		conns:     make(map[*websocket.Conn]*relayConn),
+		invoices:  make(map[string]*nwcTransaction),
+	}
+	r.srv = httptest.NewServer(websocket.Handler(r.serve))
+	t.Cleanup(func() {
+		r.dropConnections()
+		r.srv.Close()
+	})
+	return r
+}
+
+func (r *fakeRelay) url() string {
+	return "ws://" + strings.TrimPrefix(r.srv.URL, "http://")
+}
+
+// connectionURI returns a NWC URI for a fresh client key.
+func (r *fakeRelay) connectionURI() string {
+	secret, _ := btcec.NewPrivateKey()
+	return "nostr+walletconnect://" + r.walletPub + "?relay=" + r.url() + "&secret=" + hex.EncodeToString(secret.Serialize())
+}
+
+func (r *fakeRelay) serve(ws *websocket.Conn) {
+	rc := &relayConn{subs: make(map[string]nostrFilter)}
+	r.mu.Lock()
+	r.conns[ws] = rc
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.conns, ws)
+		r.mu.Unlock()
+		ws.Close()
+	}()
+
+	for {
+		var raw string
+		if err := websocket.Message.Receive(ws, &raw); err != nil {
+			return
+		}
+		var msg []json.RawMessage
+		if json.Unmarshal([]byte(raw), &msg) != nil || len(msg) < 2 {
+			continue
+		}
+		var typ string
+		json.Unmarshal(msg[0], &typ)
+
+		switch typ {
+		case "REQ":
+			var subID string
+			var filter nostrFilter
+			json.Unmarshal(msg[1], &subID)
+			json.Unmarshal(msg[2], &filter)
+			rc.mu.Lock()
+			rc.subs[subID] = filter
+			rc.mu.Unlock()
+			r.send(ws, rc, []any{"EOSE", subID})
+
+		case "EVENT":
+			var ev nostrEvent
+			json.Unmarshal(msg[1], &ev)
+			if err := ev.verify(); err != nil {
+				r.send(ws, rc, []any{"OK", ev.ID, false, "invalid: " + err.Error()})
+				continue
+			}
+			r.send(ws, rc, []any{"OK", ev.ID, true, ""})
+			r.broadcast(&ev)
+			if ev.Kind == nwcKindRequest && ev.tag("p") == r.walletPub {
+				go r.handleWalletRequest(&ev)
+			}
+		}
+	}
+}
+
+func (r *fakeRelay) send(ws *websocket.Conn, rc *relayConn, msg any) {
+	data, _ := json.Marshal(msg)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	websocket.Message.Send(ws, string(data))
+}
+
+func (r *fakeRelay) broadcast(ev *nostrEvent) {
+	r.mu.Lock()
+	conns := make(map[*websocket.Conn]*relayConn, len(r.conns))
+	for ws, rc := range r.conns {
+		conns[ws] = rc
+	}
+	r.mu.Unlock()
+
+	for ws, rc := range conns {
+		rc.mu.Lock()
+		var matched []string
+		for subID, f := range rc.subs {
+			if filterMatches(f, ev) {
+				matched = append(matched, subID)
+			}
+		}
+		rc.mu.Unlock()
+		for _, subID := range matched {
+			r.send(ws, rc, []any{"EVENT", subID, ev})
+		}
+	}
+}
+
+func filterMatches(f nostrFilter, ev *nostrEvent) bool {
+	contains := func(list []string, v string) bool {
+		for _, s := range list {
+			if s == v {
+				return true
+			}
+		}
+		return false
+	}
+	if len(f.Kinds) > 0 {
+		ok := false
+		for _, k := range f.Kinds {
+			if k == ev.Kind {
+				ok = true
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(f.Authors) > 0 && !contains(f.Authors, ev.PubKey) {
+		return false
+	}
+	if len(f.PTags) > 0 && !contains(f.PTags, ev.tag("p")) {
+		return false
+	}
+	return ev.CreatedAt >= f.Since
+}
+
+func (r *fakeRelay) handleWalletRequest(req *nostrEvent) {
+	clientPub, _ := parseNostrPubKey(req.PubKey)
+	plaintext, err := nip04Decrypt(r.walletKey, clientPub, req.Content)
+	if err != nil {
+		return
+	}
+	var call struct {
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	json.Unmarshal([]byte(plaintext), &call)
+
+	r.mu.Lock()
+	r.requests++
+	r.mu.Unlock()
+
+	resp := map[string]any{"result_type": call.Method}
+	switch call.Method {
+	case "make_invoice":
+		var p nwcMakeInvoiceParams
+		json.Unmarshal(call.Params, &p)
+		hash, _ := generatePaymentHash()
+		tx := &nwcTransaction{
+			Type:        "incoming",
+			Invoice:     "lnbc" + hash[:20],
+			PaymentHash: hash,
+			Amount:      p.Amount,
+			CreatedAt:   time.Now().Unix(),
+		}
+		r.mu.Lock()
+		r.invoices[hash] = tx
+		r.mu.Unlock()
+		resp["result"] = tx
+	case "lookup_invoice":
+		var p nwcLookupInvoiceParams
+		json.Unmarshal(call.Params, &p)
+		r.mu.Lock()
+		tx, ok := r.invoices[p.PaymentHash]
+		r.mu.Unlock()
+		if !ok {
+			resp["error"] = nwcError{Code: "NOT_FOUND", Message: "invoice not found"}
+		} else {
+			resp["result"] = tx
+		}
+	default:
+		resp["error"] = nwcError{Code: "NOT_IMPLEMENTED", Message: call.Method}
+	}
+
+	r.publishEncrypted(clientPub, req.PubKey, nwcKindResponse, resp, [][]string{{"p", req.PubKey}, {"e", req.ID}})
+}
+
+func (r *fakeRelay) publishEncrypted(to *btcec.PublicKey, toHex string, kind int, payload any, tags [][]string) {
+	data, _ := json.Marshal(payload)
+	content, _ := nip04Encrypt(r.walletKey, to, string(data))
+	ev := &nostrEvent{CreatedAt: time.Now().Unix(), Kind: kind, Tags: tags, Content: content}
+	ev.sign(r.walletKey)
+	r.broadcast(ev)
+}
+
+// pay settles an invoice and notifies the given client.
+func (r *fakeRelay) pay(hash string, client *NWCClient) {
+	r.mu.Lock()
+	tx := r.invoices[hash]
+	tx.SettledAt = time.Now().Unix()
+	tx.State = "settled"
+	r.mu.Unlock()
+
+	clientPub, _ := parseNostrPubKey(client.clientPubHex)
+	r.publishEncrypted(clientPub, client.clientPubHex, nwcKindNotification, nwcNotification{
+		NotificationType: "payment_received",
+		Notification:     *tx,
+	}, [][]string{{"p", client.clientPubHex}})
+}
+
+func (r *fakeRelay) dropConnections() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for ws := range r.conns {
+		ws.Close()
+	}
+}
+
+func (r *fakeRelay) connectionCount() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.conns)
+}
+
+func newTestNWCClient(t *testing.T, relay *fakeRelay) *NWCClient {
+	t.Helper()
+	client, err := NewNWCClient(NWCConfig{URI: relay.connectionURI()})
+	if err != nil {
+		t.Fatalf("NewNWCClient failed: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func waitForUpdate(t *testing.T, updates <-chan InvoiceUpdate, hash string, timeout time.Duration) {
+	t.Helper()
+	select {
+	case update := <-updates:
+		if update.PaymentHash != hash || !update.Settled {
+			t.Errorf("unexpected update %+v, want settled %s", update, hash)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("timed out waiting for settlement of %s", hash[:16])
+	}
+}
+
+func TestParseNWCURI(t *testing.T) {
+	pub := strings.Repeat("ab", 32)
+	secret := strings.Repeat("cd", 32)
+
+	tests := []struct {
+		name    string
+		uri     string
+		wantErr bool
+	}{
+		{"valid", "nostr+walletconnect://" + pub + "?relay=wss%3A%2F%2Frelay.example.com&secret=" + secret, false},
+		{"unescaped relay", "nostr+walletconnect://" + pub + "?relay=wss://relay.example.com&secret=" + secret, false},
+		{"wrong scheme", "https://" + pub + "?relay=wss://r&secret=" + secret, true},
+		{"missing relay", "nostr+walletconnect://" + pub + "?secret=" + secret, true},
+		{"missing secret", "nostr+walletconnect://" + pub + "?relay=wss://r", true},
+		{"missing pubkey", "nostr+walletconnect://?relay=wss://r&secret=" + secret, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			gotPub, gotRelay, gotSecret, err := ParseNWCURI(tc.uri)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("ParseNWCURI error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.wantErr {
+				return
+			}
+			if gotPub != pub || gotSecret != secret || gotRelay != "wss://relay.example.com" {
+				t.Errorf("got (%s, %s, %s)", gotPub, gotRelay, gotSecret)
+			}
+		})
+	}
+}
+
+func TestNIP04RoundTrip(t *testing.T) {
+	alice, _ := btcec.NewPrivateKey()
+	bob, _ := btcec.NewPrivateKey()
+
+	for _, msg := range []string{"", "hello", strings.Repeat("x", 16), `{"method":"make_invoice"}`} {
+		ct, err := nip04Encrypt(alice, bob.PubKey(), msg)
+		if err != nil {
+			t.Fatalf("encrypt failed: %v", err)
+		}
+		pt, err := nip04Decrypt(bob, alice.PubKey(), ct)
+		if err != nil {
+			t.Fatalf("decrypt failed: %v", err)
+		}
+		if pt != msg {
+			t.Errorf("round trip = %q, want %q", pt, msg)
+		}
+	}
+
+	if _, err := nip04Decrypt(bob, alice.PubKey(), "no-iv-here"); err == nil {
+		t.Error("expected error for payload without iv")
+	}
+}
+
+func TestNostrEventSignVerify(t *testing.T) {
+	key, _ := btcec.NewPrivateKey()
+	ev := &nostrEvent{CreatedAt: 1700000000, Kind: 1, Content: "<tags & stuff>"}
+	if err := ev.sign(key); err != nil {
+		t.Fatalf("sign failed: %v", err)
+	}
+	if err := ev.verify(); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+
+	ev.Content = "tampered"
+	if err := ev.verify(); err == nil {
+		t.Error("expected verification failure for tampered event")
+	}
+}
+
+func TestNWCClient_CreateInvoice(t *testing.T) {
+	relay := newFakeRelay(t)
+	client := newTestNWCClient(t, relay)
+
+	inv, err := client.CreateInvoice(context.Background(), 2100, "test")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+	if inv.PaymentRequest == "" || inv.PaymentHash == "" {
+		t.Errorf("incomplete invoice %+v", inv)
+	}
+
+	relay.mu.Lock()
+	tx := relay.invoices[inv.PaymentHash]
+	relay.mu.Unlock()
+	if tx == nil || tx.Amount != 2100000 {
+		t.Errorf("wallet should have received make_invoice for 2100000 msat, got %+v", tx)
+	}
+}
+
+func TestNWCClient_WalletError(t *testing.T) {
+	relay := newFakeRelay(t)
+	client := newTestNWCClient(t, relay)
+
+	_, err := client.lookupInvoice(context.Background(), strings.Repeat("00", 32))
+	if err == nil || !strings.Contains(err.Error(), "NOT_FOUND") {
+		t.Errorf("expected NOT_FOUND wallet error, got %v", err)
+	}
+}
+
+func TestNWCClient_LookupPayment(t *testing.T) {
+	relay := newFakeRelay(t)
+	client := newTestNWCClient(t, relay)
+
+	hashes := map[string]*nwcTransaction{
+		"succeeded": {Type: "outgoing", SettledAt: time.Now().Unix()},
+		"pending":   {Type: "outgoing", State: "pending"},
+		"failed":    {Type: "outgoing", State: "failed"},
+	}
+	relay.mu.Lock()
+	for name, tx := range hashes {
+		tx.PaymentHash = name
+		relay.invoices[name] = tx
+	}
+	relay.mu.Unlock()
+
+	tests := []struct {
+		hash    string
+		want    PaymentState
+		wantErr error
+	}{
+		{"succeeded", PaymentSucceeded, nil},
+		{"pending", PaymentPending, nil},
+		{"failed", PaymentFailed, nil},
+		{"unknown", 0, ErrPaymentNotFound},
+	}
+	for _, tc := range tests {
+		state, err := client.LookupPayment(context.Background(), tc.hash)
+		if err != tc.wantErr || state != tc.want {
+			t.Errorf("LookupPayment(%s) = %v, %v; want %v, %v", tc.hash, state, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestNWCClient_PaymentNotification(t *testing.T) {
+	relay := newFakeRelay(t)
+	client := newTestNWCClient(t, relay)
+
+	updates, _ := client.SubscribeInvoices(context.Background())
+
+	inv, err := client.CreateInvoice(context.Background(), 100, "")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+	relay.pay(inv.PaymentHash, client)
+
+	waitForUpdate(t, updates, inv.PaymentHash, 2*time.Second)
+}
+
+func TestNWCClient_ReconnectRecoversMissedPayment(t *testing.T) {
+	relay := newFakeRelay(t)
+	client := newTestNWCClient(t, relay)
+	updates, _ := client.SubscribeInvoices(context.Background())
+
+	inv, err := client.CreateInvoice(context.Background(), 100, "")
+	if err != nil {
+		t.Fatalf("CreateInvoice failed: %v", err)
+	}
+
+	// Drop the connection and pay while the client is offline; the
+	// notification is lost because the relay doesn't store ephemeral events.
+	relay.dropConnections()
+	deadline := time.Now().Add(time.Second)
+	for relay.connectionCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	relay.pay(inv.PaymentHash, client)
+
+	// After reconnecting the client looks up outstanding invoices
+	waitForUpdate(t, updates, inv.PaymentHash, 5*time.Second)
+
+	// Requests work again over the new connection
+	if _, err := client.CreateInvoice(context.Background(), 100, ""); err != nil {
+		t.Errorf("CreateInvoice after reconnect failed: %v", err)
+	}
+}
+
+func TestNWCClient_SettlementWaitsForRoom(t *testing.T) {
+	c := &NWCClient{
+		outstanding: make(map[string]struct{}),
+		updates:     make(chan InvoiceUpdate, 1),
+		done:        make(chan struct{}),
+	}
+	c.updates <- InvoiceUpdate{PaymentHash: "first"}
+
+	sent := make(chan struct{})
+	go func() {
+		c.emitSettled(strings.Repeat("ab", 32))
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+		t.Fatal("settlement should wait while the channel is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+	<-c.updates
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("settlement was not delivered once there was room")
+	}
+	if u := <-c.updates; u.PaymentHash != strings.Repeat("ab", 32) || !u.Settled {
+		t.Errorf("unexpected update %+v", u)
+	}
+}
@@ -0,0 +1,165 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/store"
+)
+
+// ErrOffersUnsupported is returned when the Lightning backend can't create
+// BOLT12 offers.
+var ErrOffersUnsupported = errors.New("backend does not support BOLT12 offers")
+
+// OfferPaymentCallback is called for every payment of a download offer.
+type OfferPaymentCallback func(ctx context.Context, offer *store.Offer, paymentHash string)
+
+// offerReuseMargin is how long an existing offer must remain valid to be
+// handed out again instead of creating a new one.
+const offerReuseMargin = 10 * time.Minute
+
+// OffersSupported reports whether the backend can create BOLT12 offers.
+func (s *Service) OffersSupported() bool {
+	_, ok := s.lnd.(OfferProvider)
+	return ok
+}
+
+// CreateExtensionOffer returns a BOLT12 offer that extends a paid file's
+// hosting by duration each time it is paid. The offer is valid until
+// expiresAt, normally the file's current expiry, so it can't be paid after
+// the file is gone. An existing matching offer is reused.
+func (s *Service) CreateExtensionOffer(ctx context.Context, fileID string, amountSats int64, duration time.Duration, expiresAt time.Time) (*Offer, error) {
+	return s.offerFor(ctx, &store.Offer{
+		FileID:     fileID,
+		Kind:       store.InvoiceKindExtension,
+		AmountSats: amountSats,
+		Duration:   duration,
+		ExpiresAt:  expiresAt,
+	}, "SatoshiSend hosting extension: "+fileID[:8])
+}
+
+// CreateDownloadOffer returns a BOLT12 offer that sells an L402 download
+// token each time it is paid. An existing matching offer is reused.
+func (s *Service) CreateDownloadOffer(ctx context.Context, fileID string, amountSats int64, expiresAt time.Time) (*Offer, error) {
+	return s.offerFor(ctx, &store.Offer{
+		FileID:     fileID,
+		Kind:       store.InvoiceKindDownload,
+		AmountSats: amountSats,
+		ExpiresAt:  expiresAt,
+	}, "SatoshiSend download: "+fileID[:8])
+}
+
+// offerFor returns a tracked offer matching want, creating one if none is
+// valid for long enough. A reused offer never outlives want.ExpiresAt.
+func (s *Service) offerFor(ctx context.Context, want *store.Offer, description string) (*Offer, error) {
+	provider, ok := s.lnd.(OfferProvider)
+	if !ok {
+		return nil, ErrOffersUnsupported
+	}
+
+	cutoff := time.Now().Add(offerReuseMargin)
+	s.mu.RLock()
+	for _, offer := range s.offers {
+		if offer.FileID == want.FileID && offer.Kind == want.Kind &&
+			offer.AmountSats == want.AmountSats && offer.Duration == want.Duration &&
+			offer.ExpiresAt.After(cutoff) && !offer.ExpiresAt.After(want.ExpiresAt) {
+			s.mu.RUnlock()
+			return &Offer{OfferID: offer.OfferID, Bolt12: offer.Bolt12, AmountSats: offer.AmountSats, ExpiresAt: offer.ExpiresAt}, nil
+		}
+	}
+	s.mu.RUnlock()
+
+	if !want.ExpiresAt.After(cutoff) {
+		return nil, errors.New("file expires too soon for an offer")
+	}
+
+	offer, err := provider.CreateOffer(ctx, want.AmountSats, description, want.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	want.OfferID = offer.OfferID
+	want.Bolt12 = offer.Bolt12
+	want.CreatedAt = time.Now()
+	if err := s.store.SaveOffer(ctx, want); err != nil {
+		logging.Internal.Printf("failed to persist offer %s: %v", ShortHash(offer.OfferID), err)
+		// Continue anyway - in-memory tracking still works
+	}
+
+	s.mu.Lock()
+	for id, o := range s.offers {
+		if time.Now().After(o.ExpiresAt) {
+			delete(s.offers, id)
+		}
+	}
+	s.offers[offer.OfferID] = want
+	s.mu.Unlock()
+	return offer, nil
+}
+
+// SetOfferPaymentCallback sets the callback invoked when a download offer is
+// paid (e.g. to record the L402 token it bought).
+func (s *Service) SetOfferPaymentCallback(cb OfferPaymentCallback) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onOfferPayment = cb
+}
+
+func (s *Service) handleOfferPayment(ctx context.Context, update InvoiceUpdate) {
+	s.mu.RLock()
+	offer, ok := s.offers[update.OfferID]
+	cb := s.onOfferPayment
+	s.mu.RUnlock()
+	if !ok {
+		// Not one of ours, or it expired and was forgotten
+		logging.Internal.Printf("ignoring payment %s for unknown offer %s", ShortHash(update.PaymentHash), ShortHash(update.OfferID))
+		return
+	}
+
+	switch offer.Kind {
+	case store.InvoiceKindExtension:
+		var refundDue string
+		if err := s.store.ExtendExpiry(ctx, offer.FileID, offer.Duration); err != nil {
+			logging.Internal.Printf("CRITICAL: failed to extend file %s by %s after offer payment, refund due: %v", offer.FileID, offer.Duration, err)
+			refundDue = "extension of a missing file: " + err.Error()
+		} else {
+			logging.Internal.Printf("extended file %s by %s via offer", offer.FileID, offer.Duration)
+		}
+		// Download payments belong to the uploader and are tracked in the
+		// earnings ledger instead
+		now := time.Now()
+		s.recordPayment(ctx, &store.Payment{
+			PaymentHash: update.PaymentHash,
+			FileID:      offer.FileID,
+			Kind:        offer.Kind,
+			AmountSats:  offer.AmountSats,
+			CreatedAt:   now,
+			SettledAt:   now,
+			Backend:     s.backendName(),
+			RefundDue:   refundDue,
+		})
+	case store.InvoiceKindDownload:
+		if cb != nil {
+			cb(ctx, offer, update.PaymentHash)
+		}
+	}
+}
+
+// LoadOffers loads unexpired offers from the database into memory so their
+// payments are recognized after a restart.
+func (s *Service) LoadOffers(ctx context.Context) error {
+	offers, err := s.store.ListOffers(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, offer := range offers {
+		s.offers[offer.OfferID] = offer
+	}
+	logging.Internal.Printf("loaded %d offers from database", len(offers))
+	return nil
+}
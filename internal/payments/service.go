@@ -2,6 +2,8 @@ package payments
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"sync"
 	"time"
@@ -12,16 +14,60 @@ import (
 
 var (
 	ErrInvoiceNotFound = errors.New("invoice not found")
+	ErrInvoicePaid     = errors.New("invoice already paid")
+	ErrPaymentNotFound = errors.New("payment not found")
+	ErrExpiresTooSoon  = errors.New("file expires too soon to be extended")
 )
 
-// PaymentCallback is called when a payment is received for a file.
+// minExtensionInvoiceExpiry is the shortest time an extension invoice is
+// issued for. Files closer to their expiry than this can't be extended.
+const minExtensionInvoiceExpiry = 10 * time.Minute
+
+// UnknownInvoiceExpiry is how long an invoice whose backend did not report an
+// expiry is assumed to stay payable.
+const UnknownInvoiceExpiry = 24 * time.Hour
+
+// PaymentCallback is called when a file's upload invoice is paid.
 type PaymentCallback func(fileID string)
 
+// DownloadCallback is called when an L402 download invoice is paid.
+type DownloadCallback func(ctx context.Context, fileID string, amountSats int64, paymentHash string)
+
+// TopUpCallback is called when an account top-up invoice is paid.
+type TopUpCallback func(ctx context.Context, accountID string, amountSats int64, paymentHash string)
+
+// ExchangeRate is the fiat price of bitcoin an invoice amount was converted
+// at. The zero value means the amount was priced in sats.
+type ExchangeRate struct {
+	Currency string  // ISO 4217 code, e.g. "USD"
+	BTCPrice float64 // Price of 1 BTC in Currency
+}
+
 // PendingInvoice tracks an invoice waiting for payment.
 type PendingInvoice struct {
 	FileID      string
 	PaymentHash string
 	Invoice     *Invoice
+	Kind        store.InvoiceKind // Empty is treated as store.InvoiceKindUpload
+	Duration    time.Duration     // Hosting time added on settlement (extensions only)
+	AccountID   string            // Account credited on settlement (top-ups only)
+	Rate        ExchangeRate      // Rate a fiat price was converted at, for accounting
+	CreatedAt   time.Time
+
+	// DescriptionHash is the hex SHA-256 of the LNURL-pay metadata the
+	// invoice commits to, if it was issued for LNURL-pay. It is only kept
+	// in memory; after a restart the next LNURL-pay request re-issues.
+	DescriptionHash string
+}
+
+func (p *PendingInvoice) isExtension() bool {
+	return p.Kind == store.InvoiceKindExtension
+}
+
+// isUpload reports whether settling the invoice pays for a new upload, which
+// makes it the file's current invoice.
+func (p *PendingInvoice) isUpload() bool {
+	return p.Kind == "" || p.Kind == store.InvoiceKindUpload
 }
 
 // Service handles payment operations.
@@ -29,56 +75,195 @@ type Service struct {
 	lnd   LNDClient
 	store store.Store
 
-	mu        sync.RWMutex
-	pending   map[string]*PendingInvoice // keyed by payment hash
-	byFileID  map[string]*PendingInvoice // keyed by file ID
-	onPayment PaymentCallback            // optional callback when payment received
+	locksMu   sync.Mutex
+	fileLocks map[string]*fileLock // serialize invoice re-issue and Cashu payments per file
+	cashu     CashuReceiver        // optional; enables Cashu payments
+	history   store.PaymentHistory // optional; records settled payments
+
+	mu             sync.RWMutex
+	pending        map[string]*PendingInvoice // keyed by payment hash
+	byFileID       map[string]*PendingInvoice // upload invoices keyed by file ID
+	offers         map[string]*store.Offer    // BOLT12 offers keyed by offer ID
+	onPayment      PaymentCallback            // optional callback when payment received
+	onOfferPayment OfferPaymentCallback       // optional callback when a download offer is paid
+	onDownload     DownloadCallback           // optional callback when a download invoice is paid
+	onTopUp        TopUpCallback              // optional callback when an account top-up is paid
 }
 
 // NewService creates a new payment service.
 func NewService(lnd LNDClient, st store.Store) *Service {
 	return &Service{
-		lnd:      lnd,
-		store:    st,
-		pending:  make(map[string]*PendingInvoice),
-		byFileID: make(map[string]*PendingInvoice),
+		lnd:       lnd,
+		store:     st,
+		pending:   make(map[string]*PendingInvoice),
+		byFileID:  make(map[string]*PendingInvoice),
+		offers:    make(map[string]*store.Offer),
+		fileLocks: make(map[string]*fileLock),
+	}
+}
+
+// fileLock is held while a file's upload invoice is replaced or paid with
+// ecash, which both involve calls to the backend or the mint.
+type fileLock struct {
+	mu   sync.Mutex
+	refs int // holders and waiters; the lock is dropped at zero
+}
+
+// lockFile locks fileID's invoice without blocking other files, and returns
+// the function that unlocks it.
+func (s *Service) lockFile(fileID string) (unlock func()) {
+	s.locksMu.Lock()
+	l := s.fileLocks[fileID]
+	if l == nil {
+		l = &fileLock{}
+		s.fileLocks[fileID] = l
+	}
+	l.refs++
+	s.locksMu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		s.locksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(s.fileLocks, fileID)
+		}
+		s.locksMu.Unlock()
+	}
+}
+
+// CreateInvoiceForFile creates a Lightning invoice for hosting a file. rate
+// records the exchange rate a fiat price was converted at, if any.
+func (s *Service) CreateInvoiceForFile(ctx context.Context, fileID string, amountSats int64, rate ExchangeRate) (*Invoice, error) {
+	inv, err := s.lnd.CreateInvoice(ctx, amountSats, invoiceMemo(fileID))
+	if err != nil {
+		return nil, err
 	}
+
+	s.track(ctx, &PendingInvoice{
+		FileID:      fileID,
+		PaymentHash: inv.PaymentHash,
+		Invoice:     inv,
+		Kind:        store.InvoiceKindUpload,
+		Rate:        rate,
+	})
+	return inv, nil
 }
 
-// CreateInvoiceForFile creates a Lightning invoice for hosting a file.
-func (s *Service) CreateInvoiceForFile(ctx context.Context, fileID string, amountSats int64) (*Invoice, error) {
-	memo := "SatoshiSend file hosting: " + fileID[:8]
+// CreateExtensionInvoice creates a Lightning invoice that, once settled,
+// extends an already-paid file's hosting by duration from its current expiry.
+// Extension invoices don't replace the file's upload invoice. The invoice
+// expires no later than the file does (fileExpiresAt), and ErrExpiresTooSoon
+// is returned if that leaves too little time to pay it.
+func (s *Service) CreateExtensionInvoice(ctx context.Context, fileID string, amountSats int64, duration time.Duration, fileExpiresAt time.Time, rate ExchangeRate) (*Invoice, error) {
+	memo := "SatoshiSend hosting extension: " + fileID[:8]
+	// Leave a margin for the backend's clock and the time it takes to
+	// create the invoice
+	remaining := time.Until(fileExpiresAt) - time.Minute
+
+	var inv *Invoice
+	var err error
+	if invoicer, ok := s.lnd.(ExpiringInvoicer); ok {
+		if remaining < minExtensionInvoiceExpiry {
+			return nil, ErrExpiresTooSoon
+		}
+		inv, err = invoicer.CreateInvoiceWithExpiry(ctx, amountSats, memo, min(DefaultInvoiceExpiry, remaining))
+	} else {
+		// The backend picks the lifetime, normally DefaultInvoiceExpiry
+		if remaining < DefaultInvoiceExpiry {
+			return nil, ErrExpiresTooSoon
+		}
+		inv, err = s.lnd.CreateInvoice(ctx, amountSats, memo)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.track(ctx, &PendingInvoice{
+		FileID:      fileID,
+		PaymentHash: inv.PaymentHash,
+		Invoice:     inv,
+		Kind:        store.InvoiceKindExtension,
+		Duration:    duration,
+		Rate:        rate,
+	})
+	return inv, nil
+}
 
-	inv, err := s.lnd.CreateInvoice(ctx, amountSats, memo)
+// CreateDownloadInvoice creates a Lightning invoice for an L402 download
+// token. The payer proves settlement by presenting the invoice's preimage,
+// but the invoice is tracked as pending too, so the uploader is credited via
+// the download callback once it is paid whether or not the token is used.
+func (s *Service) CreateDownloadInvoice(ctx context.Context, fileID string, amountSats int64) (*Invoice, error) {
+	inv, err := s.lnd.CreateInvoice(ctx, amountSats, "SatoshiSend download: "+fileID[:8])
 	if err != nil {
 		return nil, err
 	}
 
-	pending := &PendingInvoice{
+	s.track(ctx, &PendingInvoice{
 		FileID:      fileID,
 		PaymentHash: inv.PaymentHash,
 		Invoice:     inv,
+		Kind:        store.InvoiceKindDownload,
+	})
+	return inv, nil
+}
+
+// CreateTopUpInvoice creates a Lightning invoice that, once settled, credits
+// amountSats to a prepaid account via the top-up callback.
+func (s *Service) CreateTopUpInvoice(ctx context.Context, accountID string, amountSats int64) (*Invoice, error) {
+	inv, err := s.lnd.CreateInvoice(ctx, amountSats, "SatoshiSend account top-up: "+accountID[:min(8, len(accountID))])
+	if err != nil {
+		return nil, err
 	}
 
+	s.track(ctx, &PendingInvoice{
+		PaymentHash: inv.PaymentHash,
+		Invoice:     inv,
+		Kind:        store.InvoiceKindTopUp,
+		AccountID:   accountID,
+	})
+	return inv, nil
+}
+
+func invoiceMemo(fileID string) string {
+	return "SatoshiSend file hosting: " + fileID[:8]
+}
+
+// track records pending as an invoice awaiting payment, both in memory and in
+// the database. Upload invoices also become the file's current invoice.
+func (s *Service) track(ctx context.Context, pending *PendingInvoice) *PendingInvoice {
+	inv := pending.Invoice
+	pending.CreatedAt = time.Now()
+
 	// Persist to database for restart recovery
 	storeInv := &store.PendingInvoice{
 		PaymentHash:    inv.PaymentHash,
-		FileID:         fileID,
+		FileID:         pending.FileID,
 		PaymentRequest: inv.PaymentRequest,
-		AmountSats:     amountSats,
-		CreatedAt:      time.Now(),
+		AmountSats:     inv.AmountSats,
+		CreatedAt:      pending.CreatedAt,
+		ExpiresAt:      inv.ExpiresAt,
+		Kind:           pending.Kind,
+		Duration:       pending.Duration,
+		AccountID:      pending.AccountID,
+		Currency:       pending.Rate.Currency,
+		BTCPrice:       pending.Rate.BTCPrice,
 	}
 	if err := s.store.SavePendingInvoice(ctx, storeInv); err != nil {
-		logging.Internal.Printf("failed to persist invoice %s: %v", inv.PaymentHash[:16], err)
+		logging.Internal.Printf("failed to persist invoice %s: %v", ShortHash(inv.PaymentHash), err)
 		// Continue anyway - in-memory tracking still works
 	}
 
 	s.mu.Lock()
 	s.pending[inv.PaymentHash] = pending
-	s.byFileID[fileID] = pending
+	if pending.isUpload() {
+		s.byFileID[pending.FileID] = pending
+	}
 	s.mu.Unlock()
 
-	return inv, nil
+	return pending
 }
 
 // GetInvoiceForFile returns the pending invoice for a file.
@@ -93,6 +278,135 @@ func (s *Service) GetInvoiceForFile(fileID string) (*PendingInvoice, error) {
 	return pending, nil
 }
 
+// RefreshInvoiceForFile returns the pending invoice for a file, first
+// replacing it with a fresh invoice for the same amount if it has expired
+// while the file is still awaiting payment. The superseded invoice is
+// cancelled if the backend supports it, and otherwise still settles the file
+// if it is paid. If it turns out to have been paid, the file is settled and
+// ErrInvoicePaid is returned.
+func (s *Service) RefreshInvoiceForFile(ctx context.Context, fileID string) (*PendingInvoice, error) {
+	defer s.lockFile(fileID)()
+
+	pending, err := s.GetInvoiceForFile(fileID)
+	if err != nil {
+		return nil, err
+	}
+	if !pending.Invoice.Expired(time.Now()) {
+		return pending, nil
+	}
+	return s.reissue(ctx, pending, "", func() (*Invoice, error) {
+		return s.lnd.CreateInvoice(ctx, pending.Invoice.AmountSats, invoiceMemo(fileID))
+	})
+}
+
+// InvoiceForLNURLPay returns the invoice to hand an LNURL-pay wallet for a
+// file awaiting payment. LUD-06 requires the invoice to commit to the pay
+// request's metadata by its hash, so unless the file's current invoice
+// already does and is unexpired, it is replaced by one that does, and the
+// superseded invoice is handled as in RefreshInvoiceForFile. Backends that
+// can't create such invoices get the current invoice, refreshed if expired.
+func (s *Service) InvoiceForLNURLPay(ctx context.Context, fileID, metadata string) (*PendingInvoice, error) {
+	defer s.lockFile(fileID)()
+
+	pending, err := s.GetInvoiceForFile(fileID)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(metadata))
+	descHash := hex.EncodeToString(sum[:])
+	expired := pending.Invoice.Expired(time.Now())
+
+	invoicer, ok := s.lnd.(DescriptionHashInvoicer)
+	if !ok {
+		if !expired {
+			return pending, nil
+		}
+		return s.reissue(ctx, pending, "", func() (*Invoice, error) {
+			return s.lnd.CreateInvoice(ctx, pending.Invoice.AmountSats, invoiceMemo(fileID))
+		})
+	}
+	if pending.DescriptionHash == descHash && !expired {
+		return pending, nil
+	}
+	return s.reissue(ctx, pending, descHash, func() (*Invoice, error) {
+		return invoicer.CreateInvoiceForMetadata(ctx, pending.Invoice.AmountSats, metadata)
+	})
+}
+
+// reissue replaces a file's upload invoice with one made by create, then
+// cancels and forgets the old one. An old invoice that can't be cancelled
+// stays tracked against the file until it expires, so paying it still marks
+// the file paid. It must be called with the file locked. The old invoice is
+// returned unchanged if the file can no longer be paid for, and
+// ErrInvoicePaid if the old invoice turns out to have been paid.
+func (s *Service) reissue(ctx context.Context, pending *PendingInvoice, descHash string, create func() (*Invoice, error)) (*PendingInvoice, error) {
+	fileID := pending.FileID
+	now := time.Now()
+
+	// Only re-issue for files that can still be paid for
+	meta, err := s.store.GetFileMetadata(ctx, fileID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrInvoiceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if meta.Paid || now.After(meta.ExpiresAt) {
+		return pending, nil
+	}
+
+	// The old invoice may have been paid just before it expired
+	oldHash := pending.PaymentHash
+	if update, err := s.lnd.LookupInvoice(ctx, oldHash); err == nil && update.Settled {
+		s.handlePayment(ctx, oldHash)
+		return nil, ErrInvoicePaid
+	}
+
+	inv, err := create()
+	if err != nil {
+		return nil, err
+	}
+	refreshed := s.track(ctx, &PendingInvoice{
+		FileID:          fileID,
+		PaymentHash:     inv.PaymentHash,
+		Invoice:         inv,
+		Kind:            store.InvoiceKindUpload,
+		Rate:            pending.Rate,
+		DescriptionHash: descHash,
+	})
+
+	// Until it is cancelled the old invoice can still be paid, so it is only
+	// forgotten once the backend confirms it can't be
+	if canceler, ok := s.lnd.(InvoiceCanceler); ok {
+		if err := canceler.CancelInvoice(ctx, oldHash); err != nil && !errors.Is(err, ErrInvoiceNotFound) {
+			logging.Internal.Printf("failed to cancel superseded invoice %s, keeping it until it expires: %v", ShortHash(oldHash), err)
+		} else {
+			s.mu.Lock()
+			delete(s.pending, oldHash)
+			s.mu.Unlock()
+			if err := s.store.DeletePendingInvoice(ctx, oldHash); err != nil {
+				logging.Internal.Printf("failed to delete superseded invoice %s: %v", ShortHash(oldHash), err)
+			}
+		}
+	}
+
+	logging.Internal.Printf("re-issued invoice for file %s (%s -> %s)", fileID, ShortHash(oldHash), ShortHash(inv.PaymentHash))
+	return refreshed, nil
+}
+
+// PendingPaymentHashes returns the payment hashes of all invoices still
+// awaiting payment.
+func (s *Service) PendingPaymentHashes() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	hashes := make([]string, 0, len(s.pending))
+	for hash := range s.pending {
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
 // SetPaymentCallback sets a callback function that will be called when a
 // payment is received. This allows external components (like rate limiters)
 // to be notified of payments.
@@ -102,8 +416,49 @@ func (s *Service) SetPaymentCallback(cb PaymentCallback) {
 	s.onPayment = cb
 }
 
+// SetDownloadCallback sets the callback invoked when a download invoice is
+// paid (e.g. to credit the uploader's earnings).
+func (s *Service) SetDownloadCallback(cb DownloadCallback) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onDownload = cb
+}
+
+// SetTopUpCallback sets the callback invoked when an account top-up invoice
+// is paid.
+func (s *Service) SetTopUpCallback(cb TopUpCallback) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onTopUp = cb
+}
+
+// SetHistory enables keeping a permanent record of every settled payment,
+// which the revenue statistics are computed from.
+func (s *Service) SetHistory(h store.PaymentHistory) {
+	s.history = h
+}
+
+// backendName returns the name of the Lightning backend for payment records.
+func (s *Service) backendName() string {
+	if namer, ok := s.lnd.(BackendNamer); ok {
+		return namer.BackendName()
+	}
+	return "unknown"
+}
+
+// recordPayment adds a settled payment to the payment history, if enabled.
+func (s *Service) recordPayment(ctx context.Context, p *store.Payment) {
+	if s.history == nil {
+		return
+	}
+	if err := s.history.RecordPayment(ctx, p); err != nil {
+		logging.Internal.Printf("failed to record payment %s: %v", ShortHash(p.PaymentHash), err)
+	}
+}
+
 // StartPaymentWatcher starts watching for invoice payments.
-// It marks files as paid when their invoices are settled.
+// It marks files as paid when their upload invoices are settled, and extends
+// their expiry when extension invoices or offers are paid.
 func (s *Service) StartPaymentWatcher(ctx context.Context) error {
 	updates, err := s.lnd.SubscribeInvoices(ctx)
 	if err != nil {
@@ -119,9 +474,14 @@ func (s *Service) StartPaymentWatcher(ctx context.Context) error {
 				if !ok {
 					return
 				}
-				if update.Settled {
+				if update.Settled && update.OfferID != "" {
+					s.handleOfferPayment(ctx, update)
+				} else if update.Settled {
 					s.handlePayment(ctx, update.PaymentHash)
 				}
+				if update.Ack != nil {
+					update.Ack()
+				}
 			}
 		}
 	}()
@@ -130,19 +490,90 @@ func (s *Service) StartPaymentWatcher(ctx context.Context) error {
 }
 
 func (s *Service) handlePayment(ctx context.Context, paymentHash string) {
+	s.settle(ctx, paymentHash, s.backendName())
+}
+
+// settle applies the effect of a pending invoice that was paid through
+// backend, and records the payment. It reports whether the invoice was still
+// pending, i.e. whether this call settled it.
+func (s *Service) settle(ctx context.Context, paymentHash, backend string) bool {
 	s.mu.Lock()
 	pending, ok := s.pending[paymentHash]
 	cb := s.onPayment
+	onTopUp := s.onTopUp
+	onDownload := s.onDownload
 	if ok {
 		delete(s.pending, paymentHash)
-		delete(s.byFileID, pending.FileID)
+		if s.byFileID[pending.FileID] == pending {
+			delete(s.byFileID, pending.FileID)
+		}
 	}
 	s.mu.Unlock()
 
 	if ok {
-		// Remove from persistent storage
-		if err := s.store.DeletePendingInvoice(ctx, paymentHash); err != nil {
-			logging.Internal.Printf("failed to delete pending invoice %s: %v", paymentHash[:16], err)
+		kind := pending.Kind
+		if kind == "" {
+			kind = store.InvoiceKindUpload
+		}
+		payment := &store.Payment{
+			PaymentHash: paymentHash,
+			FileID:      pending.FileID,
+			Kind:        kind,
+			AmountSats:  pending.Invoice.AmountSats,
+			CreatedAt:   pending.CreatedAt,
+			SettledAt:   time.Now(),
+			Backend:     backend,
+			AccountID:   pending.AccountID,
+			Currency:    pending.Rate.Currency,
+			BTCPrice:    pending.Rate.BTCPrice,
+		}
+
+		if pending.isExtension() {
+			// The pending row keeps the file from being cleaned up, so it
+			// is only removed once the file has been extended
+			if err := s.store.ExtendExpiry(ctx, pending.FileID, pending.Duration); err != nil {
+				logging.Internal.Printf("CRITICAL: failed to extend file %s by %s after receiving payment, refund due: %v", pending.FileID, pending.Duration, err)
+				payment.RefundDue = "extension of a missing file: " + err.Error()
+			} else {
+				logging.Internal.Printf("extended file %s by %s", pending.FileID, pending.Duration)
+			}
+			s.deletePending(ctx, paymentHash)
+			s.recordPayment(ctx, payment)
+			return true
+		}
+
+		// Download payments belong to the uploader and are tracked in the
+		// earnings ledger instead of the payment history
+		if pending.Kind == store.InvoiceKindDownload {
+			s.deletePending(ctx, paymentHash)
+			if onDownload != nil {
+				onDownload(ctx, pending.FileID, pending.Invoice.AmountSats, paymentHash)
+			}
+			return true
+		}
+
+		// A superseded invoice that couldn't be cancelled may be paid
+		// after the file already was
+		if pending.isUpload() {
+			if meta, err := s.store.GetFileMetadata(ctx, pending.FileID); err == nil && meta.Paid {
+				logging.Internal.Printf("CRITICAL: invoice %s paid for file %s that was already paid, refund due", ShortHash(paymentHash), pending.FileID)
+				payment.RefundDue = "file already paid"
+				s.deletePending(ctx, paymentHash)
+				s.recordPayment(ctx, payment)
+				return true
+			}
+		}
+
+		s.deletePending(ctx, paymentHash)
+		s.recordPayment(ctx, payment)
+
+		if pending.Kind == store.InvoiceKindTopUp {
+			if onTopUp == nil {
+				logging.Internal.Printf("CRITICAL: top-up %s for account %s paid but accounts are not enabled", ShortHash(paymentHash), pending.AccountID)
+				return true
+			}
+			onTopUp(ctx, pending.AccountID, pending.Invoice.AmountSats, paymentHash)
+			return true
 		}
 
 		if err := s.store.UpdatePaymentStatus(ctx, pending.FileID, true); err != nil {
@@ -161,6 +592,14 @@ func (s *Service) handlePayment(ctx context.Context, paymentHash string) {
 			}()
 		}
 	}
+	return ok
+}
+
+// deletePending removes a settled invoice from persistent storage.
+func (s *Service) deletePending(ctx context.Context, paymentHash string) {
+	if err := s.store.DeletePendingInvoice(ctx, paymentHash); err != nil {
+		logging.Internal.Printf("failed to delete pending invoice %s: %v", ShortHash(paymentHash), err)
+	}
 }
 
 // LoadPendingInvoices loads pending invoices from the database into memory.
@@ -182,10 +621,20 @@ func (s *Service) LoadPendingInvoices(ctx context.Context) error {
 				PaymentHash:    inv.PaymentHash,
 				PaymentRequest: inv.PaymentRequest,
 				AmountSats:     inv.AmountSats,
+				ExpiresAt:      inv.ExpiresAt,
 			},
+			Kind:      inv.Kind,
+			Duration:  inv.Duration,
+			AccountID: inv.AccountID,
+			Rate:      ExchangeRate{Currency: inv.Currency, BTCPrice: inv.BTCPrice},
+			CreatedAt: inv.CreatedAt,
 		}
 		s.pending[inv.PaymentHash] = pending
-		s.byFileID[inv.FileID] = pending
+		// A file may still have invoices that were superseded but not
+		// cancelled; the newest one is its current invoice
+		if current, ok := s.byFileID[inv.FileID]; pending.isUpload() && (!ok || current.CreatedAt.Before(pending.CreatedAt)) {
+			s.byFileID[inv.FileID] = pending
+		}
 	}
 
 	if len(invoices) > 0 {
@@ -194,3 +643,137 @@ func (s *Service) LoadPendingInvoices(ctx context.Context) error {
 
 	return nil
 }
+
+// ReconcilePendingInvoices asks the wallet for the state of every tracked
+// invoice and settles any that were paid without us hearing about it (e.g. a
+// webhook delivered while the server was down). Unpaid invoices that have
+// expired are dropped. Returns the number settled.
+func (s *Service) ReconcilePendingInvoices(ctx context.Context) (int, error) {
+	hashes := s.PendingPaymentHashes()
+
+	settled, pruned := 0, 0
+	var firstErr error
+	for _, hash := range hashes {
+		if ctx.Err() != nil {
+			return settled, ctx.Err()
+		}
+
+		update, err := s.lnd.LookupInvoice(ctx, hash)
+		if err != nil {
+			notFound := errors.Is(err, ErrInvoiceNotFound)
+			if !notFound && firstErr == nil {
+				firstErr = err
+			}
+			logging.Internal.Printf("failed to look up invoice %s: %v", ShortHash(hash), err)
+			if notFound && s.expired(ctx, hash, time.Now()) {
+				s.drop(ctx, hash)
+				pruned++
+			}
+			continue
+		}
+		if update.Settled {
+			logging.Internal.Printf("invoice %s was paid while unobserved, settling", ShortHash(hash))
+			s.handlePayment(ctx, hash)
+			settled++
+		} else if s.expired(ctx, hash, time.Now()) {
+			// The lookup above was the last chance for it to have been paid
+			s.drop(ctx, hash)
+			pruned++
+		}
+	}
+
+	if pruned > 0 {
+		logging.Internal.Printf("dropped %d expired pending invoices", pruned)
+	}
+	return settled, firstErr
+}
+
+// expired reports whether the pending invoice for hash can no longer be paid
+// and is of no further use. A file's current upload invoice is kept while the
+// file is still awaiting payment, since RefreshInvoiceForFile re-issues it
+// from there.
+func (s *Service) expired(ctx context.Context, hash string, now time.Time) bool {
+	s.mu.RLock()
+	pending, ok := s.pending[hash]
+	current := ok && s.byFileID[pending.FileID] == pending
+	s.mu.RUnlock()
+	if !ok {
+		return false
+	}
+
+	expiresAt := pending.Invoice.ExpiresAt
+	if expiresAt.IsZero() {
+		if pending.CreatedAt.IsZero() {
+			return false
+		}
+		expiresAt = pending.CreatedAt.Add(UnknownInvoiceExpiry)
+	}
+	if !now.After(expiresAt) {
+		return false
+	}
+
+	if pending.isUpload() && current {
+		meta, err := s.store.GetFileMetadata(ctx, pending.FileID)
+		if errors.Is(err, store.ErrNotFound) {
+			return true
+		}
+		if err != nil {
+			return false
+		}
+		return meta.Paid || now.After(meta.ExpiresAt)
+	}
+	return true
+}
+
+// drop stops tracking the pending invoice for hash.
+func (s *Service) drop(ctx context.Context, hash string) {
+	s.mu.Lock()
+	if pending, ok := s.pending[hash]; ok {
+		delete(s.pending, hash)
+		if s.byFileID[pending.FileID] == pending {
+			delete(s.byFileID, pending.FileID)
+		}
+	}
+	s.mu.Unlock()
+
+	if err := s.store.DeletePendingInvoice(ctx, hash); err != nil {
+		logging.Internal.Printf("failed to delete expired invoice %s: %v", ShortHash(hash), err)
+	}
+}
+
+// StartReconciler reconciles pending invoices immediately and then every
+// interval until ctx is cancelled.
+func (s *Service) StartReconciler(ctx context.Context, interval time.Duration) {
+	reconcile := func() {
+		count, err := s.ReconcilePendingInvoices(ctx)
+		if err != nil && ctx.Err() == nil {
+			logging.Internal.Printf("invoice reconciliation error: %v", err)
+		}
+		if count > 0 {
+			logging.Internal.Printf("reconciled %d paid invoices", count)
+		}
+	}
+
+	go func() {
+		reconcile()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reconcile()
+			}
+		}
+	}()
+}
+
+// ShortHash truncates a payment hash or offer ID for logging.
+func ShortHash(hash string) string {
+	if len(hash) > 16 {
+		return hash[:16]
+	}
+	return hash
+}
@@ -2,6 +2,11 @@ package payments
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -12,12 +17,14 @@ import (
 type mockStore struct {
 	files    map[string]*store.FileMeta
 	invoices map[string]*store.PendingInvoice
+	offers   map[string]*store.Offer
 }
 
 func newMockStore() *mockStore {
 	return &mockStore{
 		files:    make(map[string]*store.FileMeta),
 		invoices: make(map[string]*store.PendingInvoice),
+		offers:   make(map[string]*store.Offer),
 	}
 }
 
@@ -43,6 +50,19 @@ func (m *mockStore) UpdatePaymentStatus(ctx context.Context, fileID string, paid
 	return nil
 }
 
+func (m *mockStore) ExtendExpiry(ctx context.Context, fileID string, d time.Duration) error {
+	meta, ok := m.files[fileID]
+	if !ok || !meta.Paid {
+		return store.ErrNotFound
+	}
+	base := time.Now()
+	if meta.ExpiresAt.After(base) {
+		base = meta.ExpiresAt
+	}
+	meta.ExpiresAt = base.Add(d)
+	return nil
+}
+
 func (m *mockStore) DeleteFileMetadata(ctx context.Context, id string) error {
 	delete(m.files, id)
 	return nil
@@ -78,6 +98,21 @@ func (m *mockStore) ListPendingInvoices(ctx context.Context) ([]*store.PendingIn
 	return result, nil
 }
 
+func (m *mockStore) SaveOffer(ctx context.Context, offer *store.Offer) error {
+	m.offers[offer.OfferID] = offer
+	return nil
+}
+
+func (m *mockStore) ListOffers(ctx context.Context) ([]*store.Offer, error) {
+	var result []*store.Offer
+	for _, offer := range m.offers {
+		if offer.ExpiresAt.After(time.Now()) {
+			result = append(result, offer)
+		}
+	}
+	return result, nil
+}
+
 func TestService_CreateInvoice(t *testing.T) {
 	lnd := NewMockLNDClient()
 	st := newMockStore()
@@ -85,7 +120,7 @@ func TestService_CreateInvoice(t *testing.T) {
 
 	ctx := context.Background()
 
-	inv, err := svc.CreateInvoiceForFile(ctx, "test-file-id-1234", 1000)
+	inv, err := svc.CreateInvoiceForFile(ctx, "test-file-id-1234", 1000, ExchangeRate{})
 	if err != nil {
 		t.Fatalf("create invoice failed: %v", err)
 	}
@@ -115,7 +150,8 @@ func TestService_InvoicePersistence(t *testing.T) {
 	ctx := context.Background()
 
 	// Create an invoice - should be persisted
-	inv, err := svc.CreateInvoiceForFile(ctx, "test-persist-file", 500)
+	rate := ExchangeRate{Currency: "USD", BTCPrice: 64250.5}
+	inv, err := svc.CreateInvoiceForFile(ctx, "test-persist-file", 500, rate)
 	if err != nil {
 		t.Fatalf("create invoice failed: %v", err)
 	}
@@ -135,6 +171,18 @@ func TestService_InvoicePersistence(t *testing.T) {
 	if stored.AmountSats != 500 {
 		t.Errorf("stored AmountSats = %d, want 500", stored.AmountSats)
 	}
+	if stored.Currency != rate.Currency || stored.BTCPrice != rate.BTCPrice {
+		t.Errorf("stored rate = %s %v, want %s %v", stored.Currency, stored.BTCPrice, rate.Currency, rate.BTCPrice)
+	}
+
+	// The rate is restored after a restart
+	svc = NewService(lnd, st)
+	if err := svc.LoadPendingInvoices(ctx); err != nil {
+		t.Fatalf("LoadPendingInvoices failed: %v", err)
+	}
+	if pending, _ := svc.GetInvoiceForFile("test-persist-file"); pending == nil || pending.Rate != rate {
+		t.Errorf("expected loaded invoice to keep rate %+v", rate)
+	}
 }
 
 func TestService_LoadPendingInvoices(t *testing.T) {
@@ -196,7 +244,7 @@ func TestService_PaymentDeletesInvoice(t *testing.T) {
 	}
 
 	// Create invoice
-	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 500)
+	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 500, ExchangeRate{})
 
 	// Verify invoice is in store
 	if len(st.invoices) != 1 {
@@ -239,7 +287,7 @@ func TestService_PaymentWatcher(t *testing.T) {
 	}
 
 	// Create invoice
-	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 500)
+	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 500, ExchangeRate{})
 
 	// Simulate payment
 	lnd.SimulatePayment(inv.PaymentHash)
@@ -259,3 +307,929 @@ func TestService_PaymentWatcher(t *testing.T) {
 		t.Error("expected pending invoice to be cleared")
 	}
 }
+
+func TestService_PaymentWatcherAcksAfterSettling(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	fileID := "test-file-id-ack"
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:        fileID,
+		Size:      1024,
+		ExpiresAt: time.Now().Add(24 * time.Hour),
+		CreatedAt: time.Now(),
+	})
+	if err := svc.StartPaymentWatcher(ctx); err != nil {
+		t.Fatalf("start watcher failed: %v", err)
+	}
+	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 500, ExchangeRate{})
+	lnd.MarkSettled(inv.PaymentHash)
+
+	paidAtAck := make(chan bool, 1)
+	lnd.updates <- InvoiceUpdate{
+		PaymentHash: inv.PaymentHash,
+		Settled:     true,
+		Ack: func() {
+			meta, _ := st.GetFileMetadata(ctx, fileID)
+			paidAtAck <- meta != nil && meta.Paid
+		},
+	}
+
+	select {
+	case paid := <-paidAtAck:
+		if !paid {
+			t.Error("expected the file to be paid before the update was acknowledged")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("update was never acknowledged")
+	}
+}
+
+func TestService_ReconcilePendingInvoices(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	ctx := context.Background()
+
+	// Two invoices were created before a restart; one was paid during downtime
+	for _, id := range []string{"reconcile-paid", "reconcile-open"} {
+		st.SaveFileMetadata(ctx, &store.FileMeta{
+			ID:        id,
+			Size:      1024,
+			ExpiresAt: time.Now().Add(24 * time.Hour),
+			CreatedAt: time.Now(),
+		})
+	}
+	before := NewService(lnd, st)
+	paidInv, _ := before.CreateInvoiceForFile(ctx, "reconcile-paid", 500, ExchangeRate{})
+	before.CreateInvoiceForFile(ctx, "reconcile-open", 500, ExchangeRate{})
+	lnd.MarkSettled(paidInv.PaymentHash)
+
+	// Orphaned row the wallet has never heard of
+	st.invoices["unknown-hash-0000"] = &store.PendingInvoice{
+		PaymentHash: "unknown-hash-0000",
+		FileID:      "reconcile-unknown",
+		AmountSats:  500,
+		CreatedAt:   time.Now(),
+	}
+
+	svc := NewService(lnd, st)
+	if err := svc.LoadPendingInvoices(ctx); err != nil {
+		t.Fatalf("LoadPendingInvoices failed: %v", err)
+	}
+
+	var notified []string
+	svc.SetPaymentCallback(func(fileID string) { notified = append(notified, fileID) })
+
+	count, err := svc.ReconcilePendingInvoices(ctx)
+	if err != nil {
+		t.Fatalf("ReconcilePendingInvoices failed: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 reconciled invoice, got %d", count)
+	}
+
+	meta, _ := st.GetFileMetadata(ctx, "reconcile-paid")
+	if !meta.Paid {
+		t.Error("expected paid file to be marked as paid")
+	}
+	meta, _ = st.GetFileMetadata(ctx, "reconcile-open")
+	if meta.Paid {
+		t.Error("expected unpaid file to remain unpaid")
+	}
+	if len(notified) != 1 || notified[0] != "reconcile-paid" {
+		t.Errorf("expected callback for reconcile-paid, got %v", notified)
+	}
+	if _, ok := st.invoices[paidInv.PaymentHash]; ok {
+		t.Error("expected settled invoice to be removed from store")
+	}
+	if _, err := svc.GetInvoiceForFile("reconcile-open"); err != nil {
+		t.Error("expected open invoice to still be tracked")
+	}
+
+	// A second pass must not settle anything again
+	count, _ = svc.ReconcilePendingInvoices(ctx)
+	if count != 0 {
+		t.Errorf("expected 0 reconciled invoices on second pass, got %d", count)
+	}
+}
+
+func TestService_ReconcileDropsExpiredInvoices(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	ctx := context.Background()
+
+	awaiting := "awaiting-file-0001"
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: awaiting, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+	extended := "extended-file-0001"
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: extended, Paid: true, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+
+	upload, _ := svc.CreateInvoiceForFile(ctx, awaiting, 100, ExchangeRate{})
+	ext, _ := svc.CreateExtensionInvoice(ctx, extended, 100, 24*time.Hour, time.Now().Add(time.Hour), ExchangeRate{})
+	topUp, _ := svc.CreateTopUpInvoice(ctx, "account-0001", 100)
+	live, _ := svc.CreateTopUpInvoice(ctx, "account-0002", 100)
+	for _, hash := range []string{upload.PaymentHash, ext.PaymentHash, topUp.PaymentHash} {
+		svc.pending[hash].Invoice.ExpiresAt = time.Now().Add(-time.Second)
+	}
+
+	if _, err := svc.ReconcilePendingInvoices(ctx); err != nil {
+		t.Fatalf("ReconcilePendingInvoices failed: %v", err)
+	}
+
+	for _, hash := range []string{ext.PaymentHash, topUp.PaymentHash} {
+		if _, ok := svc.pending[hash]; ok {
+			t.Errorf("expected expired invoice %s to be dropped", hash[:8])
+		}
+		if _, ok := st.invoices[hash]; ok {
+			t.Errorf("expected expired invoice %s to be removed from store", hash[:8])
+		}
+	}
+	// Kept so it can be re-issued while the file awaits payment
+	if _, err := svc.GetInvoiceForFile(awaiting); err != nil {
+		t.Errorf("expected upload invoice of an unpaid file to be kept, got %v", err)
+	}
+	if _, ok := svc.pending[live.PaymentHash]; !ok {
+		t.Error("expected unexpired invoice to be kept")
+	}
+}
+
+func TestService_RefreshInvoiceForFile(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	ctx := context.Background()
+
+	fileID := "refresh-file-0001"
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:        fileID,
+		Size:      1024,
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+		CreatedAt: time.Now(),
+	})
+
+	rate := ExchangeRate{Currency: "EUR", BTCPrice: 59000}
+	inv, err := svc.CreateInvoiceForFile(ctx, fileID, 300, rate)
+	if err != nil {
+		t.Fatalf("create invoice failed: %v", err)
+	}
+	if inv.ExpiresAt.IsZero() {
+		t.Fatal("expected mock invoice to have an expiry")
+	}
+	if !st.invoices[inv.PaymentHash].ExpiresAt.Equal(inv.ExpiresAt) {
+		t.Error("expected expiry to be persisted")
+	}
+
+	// A live invoice is returned unchanged
+	pending, err := svc.RefreshInvoiceForFile(ctx, fileID)
+	if err != nil {
+		t.Fatalf("RefreshInvoiceForFile failed: %v", err)
+	}
+	if pending.PaymentHash != inv.PaymentHash {
+		t.Error("expected unexpired invoice to be kept")
+	}
+
+	// Once the wallet has expired it, a new one is issued and the old one cancelled
+	pending.Invoice.ExpiresAt = time.Now().Add(-time.Second)
+	refreshed, err := svc.RefreshInvoiceForFile(ctx, fileID)
+	if err != nil {
+		t.Fatalf("RefreshInvoiceForFile failed: %v", err)
+	}
+	if refreshed.PaymentHash == inv.PaymentHash {
+		t.Fatal("expected a new invoice")
+	}
+	if refreshed.Invoice.AmountSats != 300 {
+		t.Errorf("expected 300 sats, got %d", refreshed.Invoice.AmountSats)
+	}
+	if refreshed.Rate != rate {
+		t.Errorf("expected re-issued invoice to keep the original rate, got %+v", refreshed.Rate)
+	}
+	if !lnd.Canceled(inv.PaymentHash) {
+		t.Error("expected superseded invoice to be cancelled")
+	}
+	if _, ok := st.invoices[inv.PaymentHash]; ok {
+		t.Error("expected superseded invoice to be removed from store")
+	}
+	if _, ok := st.invoices[refreshed.PaymentHash]; !ok {
+		t.Error("expected new invoice to be persisted")
+	}
+
+	// Paying the new invoice settles the file
+	svc.handlePayment(ctx, refreshed.PaymentHash)
+	meta, _ := st.GetFileMetadata(ctx, fileID)
+	if !meta.Paid {
+		t.Error("expected file to be paid via the re-issued invoice")
+	}
+}
+
+func TestService_RefreshInvoiceForFile_ExpiredFile(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	ctx := context.Background()
+
+	fileID := "refresh-file-0002"
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:        fileID,
+		Size:      1024,
+		ExpiresAt: time.Now().Add(-time.Minute), // pending window has passed
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 300, ExchangeRate{})
+	pending, _ := svc.GetInvoiceForFile(fileID)
+	pending.Invoice.ExpiresAt = time.Now().Add(-time.Second)
+
+	got, err := svc.RefreshInvoiceForFile(ctx, fileID)
+	if err != nil {
+		t.Fatalf("RefreshInvoiceForFile failed: %v", err)
+	}
+	if got.PaymentHash != inv.PaymentHash {
+		t.Error("expected no new invoice for a file that can no longer be paid")
+	}
+}
+
+func TestService_RefreshInvoiceForFile_PaidBeforeExpiry(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	ctx := context.Background()
+
+	fileID := "refresh-file-0003"
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:        fileID,
+		Size:      1024,
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+		CreatedAt: time.Now(),
+	})
+	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 300, ExchangeRate{})
+	pending, _ := svc.GetInvoiceForFile(fileID)
+	pending.Invoice.ExpiresAt = time.Now().Add(-time.Second)
+	// Paid, but the notification hasn't arrived yet
+	lnd.MarkSettled(inv.PaymentHash)
+
+	if _, err := svc.RefreshInvoiceForFile(ctx, fileID); err != ErrInvoicePaid {
+		t.Fatalf("expected ErrInvoicePaid, got %v", err)
+	}
+	meta, _ := st.GetFileMetadata(ctx, fileID)
+	if !meta.Paid {
+		t.Error("expected file to be settled by the lookup")
+	}
+}
+
+func TestService_InvoiceForLNURLPay(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	ctx := context.Background()
+
+	fileID := "lnurlpay-file-0001"
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:        fileID,
+		Size:      1024,
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+		CreatedAt: time.Now(),
+	})
+	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 300, ExchangeRate{})
+
+	metadata := `[["text/plain","SatoshiSend file hosting: lnurlpay"]]`
+	sum := sha256.Sum256([]byte(metadata))
+
+	// The memo invoice is replaced by one committing to the metadata
+	first, err := svc.InvoiceForLNURLPay(ctx, fileID, metadata)
+	if err != nil {
+		t.Fatalf("InvoiceForLNURLPay failed: %v", err)
+	}
+	if first.PaymentHash == inv.PaymentHash || first.Invoice.AmountSats != 300 {
+		t.Fatalf("expected a new 300 sat invoice, got %+v", first.Invoice)
+	}
+	if got := lnd.DescriptionHash(first.PaymentHash); got != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected description hash of the metadata, got %q", got)
+	}
+	if !lnd.Canceled(inv.PaymentHash) {
+		t.Error("expected superseded invoice to be cancelled")
+	}
+
+	// Later callbacks reuse it while it is payable
+	again, _ := svc.InvoiceForLNURLPay(ctx, fileID, metadata)
+	if again.PaymentHash != first.PaymentHash {
+		t.Error("expected the unexpired invoice to be reused")
+	}
+
+	first.Invoice.ExpiresAt = time.Now().Add(-time.Second)
+	renewed, _ := svc.InvoiceForLNURLPay(ctx, fileID, metadata)
+	if renewed.PaymentHash == first.PaymentHash || !lnd.Canceled(first.PaymentHash) {
+		t.Error("expected an expired invoice to be replaced and cancelled")
+	}
+	if len(svc.PendingPaymentHashes()) != 1 {
+		t.Errorf("expected only the current invoice to be pending, got %d", len(svc.PendingPaymentHashes()))
+	}
+}
+
+// memoOnlyLND hides the mock's optional interfaces.
+type memoOnlyLND struct{ LNDClient }
+
+func TestService_InvoiceForLNURLPay_MemoOnlyBackend(t *testing.T) {
+	st := newMockStore()
+	svc := NewService(memoOnlyLND{NewMockLNDClient()}, st)
+	ctx := context.Background()
+
+	fileID := "lnurlpay-file-0002"
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:        fileID,
+		Size:      1024,
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+		CreatedAt: time.Now(),
+	})
+	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 300, ExchangeRate{})
+
+	pending, err := svc.InvoiceForLNURLPay(ctx, fileID, `[["text/plain","x"]]`)
+	if err != nil {
+		t.Fatalf("InvoiceForLNURLPay failed: %v", err)
+	}
+	if pending.PaymentHash != inv.PaymentHash {
+		t.Error("expected the current invoice to be reused")
+	}
+}
+
+// uncancelableLND hides the mock's CancelInvoice, like NWC.
+type uncancelableLND struct {
+	LNDClient
+	DescriptionHashInvoicer
+}
+
+func TestService_InvoiceForLNURLPay_UncancelableBackend(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(uncancelableLND{lnd, lnd}, st)
+	history := &memHistory{}
+	svc.SetHistory(history)
+	ctx := context.Background()
+
+	fileID := "lnurlpay-file-0003"
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:        fileID,
+		Size:      1024,
+		ExpiresAt: time.Now().Add(10 * time.Minute),
+		CreatedAt: time.Now(),
+	})
+	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 300, ExchangeRate{})
+
+	first, err := svc.InvoiceForLNURLPay(ctx, fileID, `[["text/plain","x"]]`)
+	if err != nil {
+		t.Fatalf("InvoiceForLNURLPay failed: %v", err)
+	}
+	if first.PaymentHash == inv.PaymentHash {
+		t.Fatal("expected a new invoice")
+	}
+	// The old invoice is still payable, so it stays tracked
+	if len(svc.PendingPaymentHashes()) != 2 {
+		t.Errorf("expected both invoices to be pending, got %d", len(svc.PendingPaymentHashes()))
+	}
+	if _, ok := st.invoices[inv.PaymentHash]; !ok {
+		t.Error("expected superseded invoice to stay persisted")
+	}
+
+	// After a restart the newer invoice is still the file's current one
+	restarted := NewService(lnd, st)
+	if err := restarted.LoadPendingInvoices(ctx); err != nil {
+		t.Fatalf("LoadPendingInvoices failed: %v", err)
+	}
+	if current, _ := restarted.GetInvoiceForFile(fileID); current.PaymentHash != first.PaymentHash {
+		t.Error("expected the re-issued invoice to be current after a restart")
+	}
+
+	// The payer scanned the old invoice before the LNURL callback
+	lnd.MarkSettled(inv.PaymentHash)
+	if n, err := svc.ReconcilePendingInvoices(ctx); err != nil || n != 1 {
+		t.Fatalf("expected 1 settled invoice, got %d (%v)", n, err)
+	}
+	meta, _ := st.GetFileMetadata(ctx, fileID)
+	if !meta.Paid {
+		t.Error("expected paying the superseded invoice to mark the file paid")
+	}
+
+	// Paying the other one too is owed back
+	svc.handlePayment(ctx, first.PaymentHash)
+	if len(history.payments) != 2 {
+		t.Fatalf("expected 2 recorded payments, got %d", len(history.payments))
+	}
+	if history.payments[0].RefundDue != "" {
+		t.Errorf("expected first payment to be kept, got refund %q", history.payments[0].RefundDue)
+	}
+	if history.payments[1].RefundDue == "" {
+		t.Error("expected second payment for the same file to be marked for refund")
+	}
+}
+
+func TestService_ExtensionInvoice(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	ctx := context.Background()
+
+	fileID := "extend-file-1234"
+	expiresAt := time.Now().Add(48 * time.Hour)
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:        fileID,
+		Size:      1024,
+		ExpiresAt: expiresAt,
+		Paid:      true,
+		CreatedAt: time.Now(),
+	})
+
+	var notified []string
+	svc.SetPaymentCallback(func(fileID string) { notified = append(notified, fileID) })
+
+	inv, err := svc.CreateExtensionInvoice(ctx, fileID, 300, 30*24*time.Hour, expiresAt, ExchangeRate{})
+	if err != nil {
+		t.Fatalf("CreateExtensionInvoice failed: %v", err)
+	}
+
+	// Persisted as an extension, but not the file's upload invoice
+	stored, ok := st.invoices[inv.PaymentHash]
+	if !ok {
+		t.Fatal("expected extension invoice to be persisted")
+	}
+	if stored.Kind != store.InvoiceKindExtension || stored.Duration != 30*24*time.Hour {
+		t.Errorf("unexpected persisted invoice kind=%q duration=%v", stored.Kind, stored.Duration)
+	}
+	if _, err := svc.GetInvoiceForFile(fileID); err != ErrInvoiceNotFound {
+		t.Error("extension invoice should not be returned as the file's upload invoice")
+	}
+
+	// Survives a restart
+	svc = NewService(lnd, st)
+	svc.SetPaymentCallback(func(fileID string) { notified = append(notified, fileID) })
+	if err := svc.LoadPendingInvoices(ctx); err != nil {
+		t.Fatalf("LoadPendingInvoices failed: %v", err)
+	}
+
+	lnd.MarkSettled(inv.PaymentHash)
+	if count, err := svc.ReconcilePendingInvoices(ctx); err != nil || count != 1 {
+		t.Fatalf("expected 1 reconciled invoice, got %d (err=%v)", count, err)
+	}
+
+	meta, _ := st.GetFileMetadata(ctx, fileID)
+	want := expiresAt.Add(30 * 24 * time.Hour)
+	if !meta.ExpiresAt.Equal(want) {
+		t.Errorf("expected expiry extended from current expiry to %v, got %v", want, meta.ExpiresAt)
+	}
+	if len(notified) != 0 {
+		t.Errorf("payment callback should only fire for upload invoices, got %v", notified)
+	}
+	if _, ok := st.invoices[inv.PaymentHash]; ok {
+		t.Error("expected settled extension invoice to be removed from store")
+	}
+}
+
+func TestService_ExtensionInvoiceExpiry(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	history := &memHistory{}
+	svc.SetHistory(history)
+	ctx := context.Background()
+
+	fileID := "expiring-file-12"
+	fileExpiry := time.Now().Add(30 * time.Minute)
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: fileID, Paid: true, ExpiresAt: fileExpiry, CreatedAt: time.Now()})
+
+	// Capped at the file's expiry
+	inv, err := svc.CreateExtensionInvoice(ctx, fileID, 300, 24*time.Hour, fileExpiry, ExchangeRate{})
+	if err != nil {
+		t.Fatalf("CreateExtensionInvoice failed: %v", err)
+	}
+	if inv.ExpiresAt.After(fileExpiry) {
+		t.Errorf("invoice expires at %v, after the file at %v", inv.ExpiresAt, fileExpiry)
+	}
+
+	if _, err := svc.CreateExtensionInvoice(ctx, fileID, 300, 24*time.Hour, time.Now().Add(time.Minute), ExchangeRate{}); !errors.Is(err, ErrExpiresTooSoon) {
+		t.Errorf("expected ErrExpiresTooSoon, got %v", err)
+	}
+	// A backend that picks the lifetime itself needs the default to fit
+	memoOnly := NewService(memoOnlyLND{lnd}, st)
+	if _, err := memoOnly.CreateExtensionInvoice(ctx, fileID, 300, 24*time.Hour, fileExpiry, ExchangeRate{}); !errors.Is(err, ErrExpiresTooSoon) {
+		t.Errorf("expected ErrExpiresTooSoon without a choice of expiry, got %v", err)
+	}
+
+	// Paid after the file is gone: owed back, not counted as applied
+	delete(st.files, fileID)
+	svc.handlePayment(ctx, inv.PaymentHash)
+	if len(history.payments) != 1 || history.payments[0].RefundDue == "" {
+		t.Fatalf("expected the payment to be recorded as a refund, got %+v", history.payments)
+	}
+	if _, ok := st.invoices[inv.PaymentHash]; ok {
+		t.Error("expected the settled invoice to be removed from store")
+	}
+}
+
+func TestService_TopUpInvoice(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	ctx := context.Background()
+
+	inv, err := svc.CreateTopUpInvoice(ctx, "account-1234", 5000)
+	if err != nil {
+		t.Fatalf("CreateTopUpInvoice failed: %v", err)
+	}
+	stored, ok := st.invoices[inv.PaymentHash]
+	if !ok {
+		t.Fatal("expected top-up invoice to be persisted")
+	}
+	if stored.Kind != store.InvoiceKindTopUp || stored.AccountID != "account-1234" {
+		t.Errorf("unexpected persisted invoice kind=%q account=%q", stored.Kind, stored.AccountID)
+	}
+
+	// Survives a restart and credits the account on settlement
+	type topUp struct {
+		accountID   string
+		amount      int64
+		paymentHash string
+	}
+	var credited []topUp
+	var notified []string
+	svc = NewService(lnd, st)
+	svc.SetPaymentCallback(func(fileID string) { notified = append(notified, fileID) })
+	svc.SetTopUpCallback(func(ctx context.Context, accountID string, amountSats int64, paymentHash string) {
+		credited = append(credited, topUp{accountID, amountSats, paymentHash})
+	})
+	if err := svc.LoadPendingInvoices(ctx); err != nil {
+		t.Fatalf("LoadPendingInvoices failed: %v", err)
+	}
+
+	lnd.MarkSettled(inv.PaymentHash)
+	if count, err := svc.ReconcilePendingInvoices(ctx); err != nil || count != 1 {
+		t.Fatalf("expected 1 reconciled invoice, got %d (err=%v)", count, err)
+	}
+
+	want := topUp{"account-1234", 5000, inv.PaymentHash}
+	if len(credited) != 1 || credited[0] != want {
+		t.Errorf("expected top-up callback %+v, got %+v", want, credited)
+	}
+	if len(notified) != 0 {
+		t.Errorf("payment callback should only fire for upload invoices, got %v", notified)
+	}
+	if _, ok := st.invoices[inv.PaymentHash]; ok {
+		t.Error("expected settled top-up invoice to be removed from store")
+	}
+}
+
+// memHistory is an in-memory store.PaymentHistory.
+type memHistory struct {
+	payments []*store.Payment
+}
+
+func (h *memHistory) RecordPayment(ctx context.Context, p *store.Payment) error {
+	h.payments = append(h.payments, p)
+	return nil
+}
+
+func (h *memHistory) ListPayments(ctx context.Context) ([]*store.Payment, error) {
+	return h.payments, nil
+}
+
+func TestService_PaymentHistory(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	history := &memHistory{}
+	svc.SetHistory(history)
+	svc.SetTopUpCallback(func(ctx context.Context, accountID string, amountSats int64, paymentHash string) {})
+	svc.SetCashuReceiver(&stubCashu{})
+	ctx := context.Background()
+
+	fileID := "history-file-123"
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: fileID, Size: 1024, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+	upload, _ := svc.CreateInvoiceForFile(ctx, fileID, 1500, ExchangeRate{Currency: "EUR", BTCPrice: 60000})
+	extension, _ := svc.CreateExtensionInvoice(ctx, fileID, 700, time.Hour, time.Now().Add(time.Hour), ExchangeRate{})
+	topUp, _ := svc.CreateTopUpInvoice(ctx, "account-1234", 5000)
+
+	lnd.MarkSettled(upload.PaymentHash)
+	lnd.MarkSettled(extension.PaymentHash)
+	lnd.MarkSettled(topUp.PaymentHash)
+	if count, err := svc.ReconcilePendingInvoices(ctx); err != nil || count != 3 {
+		t.Fatalf("expected 3 reconciled invoices, got %d (err=%v)", count, err)
+	}
+
+	byHash := make(map[string]*store.Payment)
+	for _, p := range history.payments {
+		byHash[p.PaymentHash] = p
+	}
+	if len(byHash) != 3 {
+		t.Fatalf("expected 3 recorded payments, got %d", len(history.payments))
+	}
+	got := byHash[upload.PaymentHash]
+	if got.Kind != store.InvoiceKindUpload || got.FileID != fileID || got.AmountSats != 1500 || got.Backend != "mock" {
+		t.Errorf("unexpected upload payment %+v", got)
+	}
+	if got.Currency != "EUR" || got.BTCPrice != 60000 {
+		t.Errorf("expected the exchange rate to be recorded, got %s %v", got.Currency, got.BTCPrice)
+	}
+	if got.CreatedAt.IsZero() || got.SettledAt.Before(got.CreatedAt) {
+		t.Errorf("unexpected timestamps created=%v settled=%v", got.CreatedAt, got.SettledAt)
+	}
+	if got := byHash[extension.PaymentHash]; got.Kind != store.InvoiceKindExtension || got.AmountSats != 700 {
+		t.Errorf("unexpected extension payment %+v", got)
+	}
+	if got := byHash[topUp.PaymentHash]; got.Kind != store.InvoiceKindTopUp || got.AccountID != "account-1234" || got.AmountSats != 5000 {
+		t.Errorf("unexpected top-up payment %+v", got)
+	}
+
+	// Ecash payments are recorded with their own backend
+	cashuFile := "history-cashu-12"
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: cashuFile, Size: 1024, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+	cashuInv, _ := svc.CreateInvoiceForFile(ctx, cashuFile, 500, ExchangeRate{})
+	if _, err := svc.PayWithCashu(ctx, cashuFile, "good-500"); err != nil {
+		t.Fatalf("PayWithCashu failed: %v", err)
+	}
+	last := history.payments[len(history.payments)-1]
+	if last.PaymentHash != cashuInv.PaymentHash || last.Backend != "cashu" {
+		t.Errorf("expected the ecash payment to be recorded with backend cashu, got %+v", last)
+	}
+
+	// Backends that don't name themselves are still recorded
+	svc = NewService(offerlessLND{lnd}, st)
+	if name := svc.backendName(); name != "unknown" {
+		t.Errorf("expected unknown backend, got %q", name)
+	}
+}
+
+// offerlessLND hides the mock's offer support.
+type offerlessLND struct{ LNDClient }
+
+func TestService_Offers(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	ctx := context.Background()
+
+	fileID := "offer-file-12345"
+	expiresAt := time.Now().Add(48 * time.Hour)
+	st.SaveFileMetadata(ctx, &store.FileMeta{
+		ID:        fileID,
+		Size:      1024,
+		ExpiresAt: expiresAt,
+		Paid:      true,
+		CreatedAt: time.Now(),
+	})
+
+	if !svc.OffersSupported() {
+		t.Fatal("expected the mock to support offers")
+	}
+	offer, err := svc.CreateExtensionOffer(ctx, fileID, 300, 24*time.Hour, expiresAt)
+	if err != nil {
+		t.Fatalf("CreateExtensionOffer failed: %v", err)
+	}
+	again, _ := svc.CreateExtensionOffer(ctx, fileID, 300, 24*time.Hour, expiresAt)
+	if again.OfferID != offer.OfferID {
+		t.Error("expected a matching offer to be reused")
+	}
+	other, _ := svc.CreateExtensionOffer(ctx, fileID, 900, 72*time.Hour, expiresAt)
+	if other.OfferID == offer.OfferID {
+		t.Error("expected a new offer for a different duration")
+	}
+	if _, err := svc.CreateExtensionOffer(ctx, fileID, 300, 24*time.Hour, time.Now().Add(time.Minute)); err == nil {
+		t.Error("expected no offer for a file about to expire")
+	}
+
+	// Survives a restart, and every payment extends the file
+	svc = NewService(lnd, st)
+	if err := svc.LoadOffers(ctx); err != nil {
+		t.Fatalf("LoadOffers failed: %v", err)
+	}
+	for range 2 {
+		if _, _, err := lnd.PayOffer(offer.OfferID); err != nil {
+			t.Fatalf("PayOffer failed: %v", err)
+		}
+		svc.handleOfferPayment(ctx, <-lnd.updates)
+	}
+	meta, _ := st.GetFileMetadata(ctx, fileID)
+	if want := expiresAt.Add(48 * time.Hour); !meta.ExpiresAt.Equal(want) {
+		t.Errorf("expected expiry %v after two payments, got %v", want, meta.ExpiresAt)
+	}
+
+	t.Run("download offers notify the callback", func(t *testing.T) {
+		var paid []string
+		svc.SetOfferPaymentCallback(func(ctx context.Context, o *store.Offer, paymentHash string) {
+			if o.FileID == fileID && o.Kind == store.InvoiceKindDownload {
+				paid = append(paid, paymentHash)
+			}
+		})
+
+		offer, err := svc.CreateDownloadOffer(ctx, fileID, 50, expiresAt)
+		if err != nil {
+			t.Fatalf("CreateDownloadOffer failed: %v", err)
+		}
+		hash, _, _ := lnd.PayOffer(offer.OfferID)
+		svc.handleOfferPayment(ctx, <-lnd.updates)
+
+		if len(paid) != 1 || paid[0] != hash {
+			t.Errorf("expected callback for %s, got %v", hash, paid)
+		}
+	})
+
+	t.Run("unsupported backend", func(t *testing.T) {
+		svc := NewService(offerlessLND{lnd}, st)
+		if svc.OffersSupported() {
+			t.Error("expected offers to be unsupported")
+		}
+		if _, err := svc.CreateDownloadOffer(ctx, fileID, 50, expiresAt); err != ErrOffersUnsupported {
+			t.Errorf("expected ErrOffersUnsupported, got %v", err)
+		}
+	})
+}
+
+// stubCashu implements CashuReceiver, accepting tokens named "good-<amount>".
+type stubCashu struct {
+	received []string
+}
+
+func (s *stubCashu) Receive(ctx context.Context, token string, minSats int64) (int64, error) {
+	amount, err := strconv.ParseInt(strings.TrimPrefix(token, "good-"), 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid token")
+	}
+	if amount < minSats {
+		return 0, errors.New("token amount too low")
+	}
+	s.received = append(s.received, token)
+	return amount, nil
+}
+
+func (s *stubCashu) MintURL() string { return "https://mint.example" }
+
+func TestService_PayWithCashu(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	ctx := context.Background()
+
+	if _, err := svc.PayWithCashu(ctx, "cashu-file-12345", "good-500"); !errors.Is(err, ErrCashuDisabled) {
+		t.Fatalf("expected ErrCashuDisabled, got %v", err)
+	}
+	receiver := &stubCashu{}
+	svc.SetCashuReceiver(receiver)
+	if svc.CashuMint() != "https://mint.example" {
+		t.Errorf("unexpected mint %q", svc.CashuMint())
+	}
+
+	fileID := "cashu-file-12345"
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: fileID, Size: 1024, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+	inv, _ := svc.CreateInvoiceForFile(ctx, fileID, 500, ExchangeRate{})
+
+	var notified string
+	svc.SetPaymentCallback(func(id string) { notified = id })
+
+	if _, err := svc.PayWithCashu(ctx, fileID, "good-100"); err == nil {
+		t.Fatal("expected a token worth less than the invoice to be refused")
+	}
+	if meta, _ := st.GetFileMetadata(ctx, fileID); meta.Paid {
+		t.Fatal("file marked paid after a refused token")
+	}
+
+	received, err := svc.PayWithCashu(ctx, fileID, "good-600")
+	if err != nil {
+		t.Fatalf("PayWithCashu failed: %v", err)
+	}
+	if received != 600 {
+		t.Errorf("expected 600 sats received, got %d", received)
+	}
+	if meta, _ := st.GetFileMetadata(ctx, fileID); !meta.Paid {
+		t.Error("expected file to be marked paid")
+	}
+	if notified != fileID {
+		t.Errorf("expected payment callback for %s, got %q", fileID, notified)
+	}
+	if _, err := svc.GetInvoiceForFile(fileID); err != ErrInvoiceNotFound {
+		t.Errorf("expected the invoice to be settled, got %v", err)
+	}
+	if !lnd.Canceled(inv.PaymentHash) {
+		t.Error("expected the Lightning invoice to be cancelled")
+	}
+
+	if _, err := svc.PayWithCashu(ctx, fileID, "good-600"); err != ErrInvoiceNotFound {
+		t.Errorf("expected a second payment to be refused, got %v", err)
+	}
+	if len(receiver.received) != 1 {
+		t.Errorf("expected exactly one token redeemed, got %d", len(receiver.received))
+	}
+}
+
+// blockingCashu implements CashuReceiver, holding tokens named "slow" until
+// release is closed.
+type blockingCashu struct {
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingCashu) Receive(ctx context.Context, token string, minSats int64) (int64, error) {
+	if token == "slow" {
+		close(b.entered)
+		<-b.release
+	}
+	return minSats, nil
+}
+
+func (b *blockingCashu) MintURL() string { return "https://mint.example" }
+
+func TestService_PayWithCashuLocksPerFile(t *testing.T) {
+	st := newMockStore()
+	svc := NewService(NewMockLNDClient(), st)
+	ctx := context.Background()
+	receiver := &blockingCashu{entered: make(chan struct{}), release: make(chan struct{})}
+	svc.SetCashuReceiver(receiver)
+
+	for _, id := range []string{"cashu-slow-00001", "cashu-fast-00001"} {
+		st.SaveFileMetadata(ctx, &store.FileMeta{ID: id, Size: 1024, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+		svc.CreateInvoiceForFile(ctx, id, 500, ExchangeRate{})
+	}
+
+	slow := make(chan error, 1)
+	go func() {
+		_, err := svc.PayWithCashu(ctx, "cashu-slow-00001", "slow")
+		slow <- err
+	}()
+	<-receiver.entered
+
+	// A swap in progress for one file doesn't hold up another
+	fast := make(chan error, 1)
+	go func() {
+		_, err := svc.PayWithCashu(ctx, "cashu-fast-00001", "fast")
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Fatalf("PayWithCashu failed: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("payment for another file waited on the slow swap")
+	}
+
+	close(receiver.release)
+	if err := <-slow; err != nil {
+		t.Fatalf("PayWithCashu failed: %v", err)
+	}
+	svc.locksMu.Lock()
+	defer svc.locksMu.Unlock()
+	if len(svc.fileLocks) != 0 {
+		t.Errorf("expected file locks to be released, %d left", len(svc.fileLocks))
+	}
+}
+
+func TestService_PayWithCashuAfterLightningPayment(t *testing.T) {
+	lnd := NewMockLNDClient()
+	st := newMockStore()
+	svc := NewService(lnd, st)
+	history := &memHistory{}
+	svc.SetHistory(history)
+	ctx := context.Background()
+	receiver := &blockingCashu{entered: make(chan struct{}), release: make(chan struct{})}
+	svc.SetCashuReceiver(receiver)
+
+	// Paid over Lightning before the token arrived: the token isn't spent
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: "cashu-late-00001", Size: 1024, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+	inv, _ := svc.CreateInvoiceForFile(ctx, "cashu-late-00001", 500, ExchangeRate{})
+	lnd.MarkSettled(inv.PaymentHash)
+	if _, err := svc.PayWithCashu(ctx, "cashu-late-00001", "slow"); err != ErrInvoicePaid {
+		t.Fatalf("expected ErrInvoicePaid, got %v", err)
+	}
+	select {
+	case <-receiver.entered:
+		t.Fatal("expected the token not to be swapped")
+	default:
+	}
+	if meta, _ := st.GetFileMetadata(ctx, "cashu-late-00001"); !meta.Paid {
+		t.Error("expected the Lightning payment to be settled")
+	}
+
+	// Paid over Lightning during the swap: the ecash is owed back
+	st.SaveFileMetadata(ctx, &store.FileMeta{ID: "cashu-race-00001", Size: 1024, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+	inv, _ = svc.CreateInvoiceForFile(ctx, "cashu-race-00001", 500, ExchangeRate{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := svc.PayWithCashu(ctx, "cashu-race-00001", "slow")
+		done <- err
+	}()
+	<-receiver.entered
+	svc.handlePayment(ctx, inv.PaymentHash)
+	close(receiver.release)
+	if err := <-done; err != nil {
+		t.Fatalf("PayWithCashu failed: %v", err)
+	}
+
+	if len(history.payments) != 3 {
+		t.Fatalf("expected 3 recorded payments, got %d", len(history.payments))
+	}
+	refund := history.payments[2]
+	if refund.Backend != "cashu" || refund.AmountSats != 500 || refund.RefundDue == "" {
+		t.Errorf("expected a refund of the ecash, got %+v", refund)
+	}
+	if refund.PaymentHash == inv.PaymentHash {
+		t.Error("expected the refund not to reuse the Lightning payment's hash")
+	}
+}
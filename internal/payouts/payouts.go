@@ -0,0 +1,326 @@
+// Package payouts pays uploaders the earnings their files accrue from paid
+// downloads. Earnings are tracked in the store's ledger; the uploader's wallet
+// pulls them with LNURL-withdraw, using a one-time k1 handed out to the
+// authenticated owner.
+package payouts
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/payments"
+	"satoshisend/internal/store"
+)
+
+var (
+	ErrUnknownWithdrawal   = errors.New("unknown or expired withdrawal")
+	ErrInsufficientBalance = errors.New("amount exceeds available balance")
+	ErrInvalidInvoice      = errors.New("invoice must specify a positive amount and a payment hash")
+)
+
+// WithdrawalTTL is how long a withdrawal link stays usable.
+const WithdrawalTTL = 10 * time.Minute
+
+// payTimeout bounds how long a single payout attempt may take.
+const payTimeout = 2 * time.Minute
+
+// withdrawal is an outstanding LNURL-withdraw link for a file's earnings.
+type withdrawal struct {
+	fileID    string
+	expiresAt time.Time
+}
+
+// Service hands out withdrawal links and pays the invoices wallets submit.
+type Service struct {
+	ledger store.Ledger
+	payer  payments.InvoicePayer
+
+	mu          sync.Mutex
+	withdrawals map[string]*withdrawal // keyed by k1
+	inflight    map[string]bool        // payouts being paid or resolved, keyed by k1
+
+	wg sync.WaitGroup // in-flight payouts
+}
+
+// NewService creates a payout service that pays from payer.
+func NewService(ledger store.Ledger, payer payments.InvoicePayer) *Service {
+	return &Service{
+		ledger:      ledger,
+		payer:       payer,
+		withdrawals: make(map[string]*withdrawal),
+		inflight:    make(map[string]bool),
+	}
+}
+
+// Balance returns a file's unpaid earnings in sats.
+func (s *Service) Balance(ctx context.Context, fileID string) (int64, error) {
+	return s.ledger.LedgerBalance(ctx, fileID)
+}
+
+// Entries returns every credit and payout recorded for a file.
+func (s *Service) Entries(ctx context.Context, fileID string) ([]*store.LedgerEntry, error) {
+	return s.ledger.ListLedgerEntries(ctx, fileID)
+}
+
+// NewWithdrawal creates a one-time withdrawal link for fileID's earnings and
+// returns its k1.
+func (s *Service) NewWithdrawal(fileID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	k1 := hex.EncodeToString(b)
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, w := range s.withdrawals {
+		if now.After(w.expiresAt) {
+			delete(s.withdrawals, key)
+		}
+	}
+	s.withdrawals[k1] = &withdrawal{fileID: fileID, expiresAt: now.Add(WithdrawalTTL)}
+	return k1, nil
+}
+
+// Withdrawable returns the file a withdrawal link pays out for and its
+// current balance.
+func (s *Service) Withdrawable(ctx context.Context, k1 string) (fileID string, balanceSats int64, err error) {
+	s.mu.Lock()
+	w, ok := s.withdrawals[k1]
+	s.mu.Unlock()
+	if !ok || time.Now().After(w.expiresAt) {
+		return "", 0, ErrUnknownWithdrawal
+	}
+
+	balance, err := s.ledger.LedgerBalance(ctx, w.fileID)
+	if err != nil {
+		return "", 0, err
+	}
+	return w.fileID, balance, nil
+}
+
+// Withdraw redeems a withdrawal link by paying paymentRequest from the file's
+// balance. The balance is debited before returning; the payment itself is
+// made in the background as LNURL-withdraw expects. The debit is reversed
+// only once the wallet reports the payment as failed. Each k1 can be used
+// once.
+func (s *Service) Withdraw(ctx context.Context, k1, paymentRequest string) error {
+	s.mu.Lock()
+	w, ok := s.withdrawals[k1]
+	delete(s.withdrawals, k1)
+	s.mu.Unlock()
+	if !ok || time.Now().After(w.expiresAt) {
+		return ErrUnknownWithdrawal
+	}
+
+	amountMsat, err := payments.InvoiceAmountMsat(paymentRequest)
+	if err != nil {
+		return ErrInvalidInvoice
+	}
+	// Round up so a sub-sat remainder can never be paid out uncovered
+	amountSats := (amountMsat + 999) / 1000
+	if amountSats <= 0 {
+		return ErrInvalidInvoice
+	}
+	// Needed to find out what became of the payment if paying it errors
+	paymentHash, err := payments.InvoicePaymentHash(paymentRequest)
+	if err != nil {
+		return ErrInvalidInvoice
+	}
+
+	err = s.ledger.AddLedgerEntry(ctx, &store.LedgerEntry{
+		FileID:     w.fileID,
+		Kind:       store.LedgerPayout,
+		AmountSats: -amountSats,
+		Reference:  k1,
+		CreatedAt:  time.Now(),
+	})
+	if errors.Is(err, store.ErrInsufficientBalance) {
+		return ErrInsufficientBalance
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record payout: %w", err)
+	}
+
+	payout := &store.PendingPayout{
+		Reference:      k1,
+		FileID:         w.fileID,
+		PaymentHash:    paymentHash,
+		PaymentRequest: paymentRequest,
+		AmountSats:     amountSats,
+		CreatedAt:      time.Now(),
+	}
+	s.mu.Lock()
+	s.inflight[k1] = true
+	s.mu.Unlock()
+	if err := s.ledger.SavePendingPayout(ctx, payout); err != nil {
+		// Nothing has been paid, so the debit can safely be undone
+		s.reverse(payout)
+		s.release(k1)
+		return fmt.Errorf("failed to record payout: %w", err)
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.release(k1)
+		s.pay(payout)
+	}()
+	return nil
+}
+
+func (s *Service) pay(payout *store.PendingPayout) {
+	ctx, cancel := context.WithTimeout(context.Background(), payTimeout)
+	defer cancel()
+
+	payment, err := s.payer.PayInvoice(ctx, payout.PaymentRequest, maxFeeSats(payout.AmountSats))
+	if err == nil {
+		logging.Internal.Printf("payout sent: file_id=%s, amount=%d sats, fee=%d sats, payment_hash=%s",
+			payout.FileID, payout.AmountSats, payment.FeeSats, payment.PaymentHash)
+		s.settled(payout)
+		return
+	}
+
+	// An error doesn't mean no money left: the payment may still be in
+	// flight, e.g. after a timeout. Ask the wallet before reversing.
+	logging.Internal.Printf("payout for %s (%d sats) returned an error, checking its state: %v", payout.FileID, payout.AmountSats, err)
+	s.resolve(payout)
+}
+
+// resolve looks up a pending payout's payment and settles or reverses it if
+// the outcome is final. Otherwise it is left for a later attempt.
+func (s *Service) resolve(payout *store.PendingPayout) {
+	lookup, ok := s.payer.(payments.PaymentLookup)
+	if !ok {
+		logging.Internal.Printf("CRITICAL: payout %s for %s (%d sats) has an unknown outcome and the wallet can't look up payments; resolve it manually",
+			payout.Reference, payout.FileID, payout.AmountSats)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	state, err := lookup.LookupPayment(ctx, payout.PaymentHash)
+	if errors.Is(err, payments.ErrPaymentNotFound) && time.Since(payout.CreatedAt) > payTimeout {
+		// The attempt was abandoned long ago without reaching the wallet
+		state, err = payments.PaymentFailed, nil
+	}
+	if err != nil {
+		logging.Internal.Printf("failed to look up payout %s for %s, will retry: %v", payout.Reference, payout.FileID, err)
+		return
+	}
+
+	switch state {
+	case payments.PaymentSucceeded:
+		logging.Internal.Printf("payout sent: file_id=%s, amount=%d sats, payment_hash=%s", payout.FileID, payout.AmountSats, payout.PaymentHash)
+		s.settled(payout)
+	case payments.PaymentFailed:
+		logging.Internal.Printf("payout failed for %s (%d sats), reversing", payout.FileID, payout.AmountSats)
+		if s.reverse(payout) {
+			s.settled(payout)
+		}
+	default:
+		logging.Internal.Printf("payout %s for %s is still in flight", payout.Reference, payout.FileID)
+	}
+}
+
+// reverse credits back a payout whose payment failed, reporting whether the
+// reversal is recorded.
+func (s *Service) reverse(payout *store.PendingPayout) bool {
+	// Use a fresh context: the reversal must be recorded even if the
+	// payment attempt ran out of time.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := s.ledger.AddLedgerEntry(ctx, &store.LedgerEntry{
+		FileID:     payout.FileID,
+		Kind:       store.LedgerPayoutReversal,
+		AmountSats: payout.AmountSats,
+		Reference:  payout.Reference,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil && !errors.Is(err, store.ErrDuplicate) {
+		logging.Internal.Printf("failed to reverse payout %s for %s: %v", payout.Reference, payout.FileID, err)
+		return false
+	}
+	return true
+}
+
+// settled stops tracking a payout whose outcome is final.
+func (s *Service) settled(payout *store.PendingPayout) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := s.ledger.DeletePendingPayout(ctx, payout.Reference); err != nil {
+		logging.Internal.Printf("failed to clear pending payout %s: %v", payout.Reference, err)
+	}
+}
+
+func (s *Service) release(k1 string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inflight, k1)
+}
+
+// ResolvePending looks up every payout whose outcome is still unknown,
+// including ones left over from a previous run, and settles or reverses
+// those the wallet has an answer for.
+func (s *Service) ResolvePending(ctx context.Context) error {
+	pending, err := s.ledger.ListPendingPayouts(ctx)
+	if err != nil {
+		return err
+	}
+	for _, payout := range pending {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		s.mu.Lock()
+		busy := s.inflight[payout.Reference]
+		s.mu.Unlock()
+		if !busy {
+			s.resolve(payout)
+		}
+	}
+	return nil
+}
+
+// StartResolver resolves pending payouts immediately and then every interval
+// until ctx is cancelled.
+func (s *Service) StartResolver(ctx context.Context, interval time.Duration) {
+	resolve := func() {
+		if err := s.ResolvePending(ctx); err != nil && ctx.Err() == nil {
+			logging.Internal.Printf("payout resolution error: %v", err)
+		}
+	}
+
+	go func() {
+		resolve()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				resolve()
+			}
+		}
+	}()
+}
+
+// Wait blocks until in-flight payouts have finished. Call it during graceful
+// shutdown so a payout isn't abandoned between debit and payment.
+func (s *Service) Wait() {
+	s.wg.Wait()
+}
+
+// maxFeeSats caps routing fees at 1% of the payout plus a 10 sat floor. The
+// operator absorbs the fee; uploaders receive the full amount withdrawn.
+func maxFeeSats(amountSats int64) int64 {
+	return 10 + amountSats/100
+}
@@ -0,0 +1,205 @@
+package payouts
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"satoshisend/internal/payments"
+	"satoshisend/internal/store"
+)
+
+func newTestService(t *testing.T) (*Service, *store.SQLiteStore, *payments.MockLNDClient) {
+	t.Helper()
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+
+	lnd := payments.NewMockLNDClient()
+	return NewService(st, lnd), st, lnd
+}
+
+func credit(t *testing.T, st *store.SQLiteStore, fileID string, amount int64, ref string) {
+	t.Helper()
+	err := st.AddLedgerEntry(context.Background(), &store.LedgerEntry{
+		FileID:     fileID,
+		Kind:       store.LedgerDownload,
+		AmountSats: amount,
+		Reference:  ref,
+		CreatedAt:  time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("failed to credit: %v", err)
+	}
+}
+
+func TestService_Withdraw(t *testing.T) {
+	svc, st, lnd := newTestService(t)
+	ctx := context.Background()
+	credit(t, st, "file1", 800, "hash1")
+
+	k1, err := svc.NewWithdrawal("file1")
+	if err != nil {
+		t.Fatalf("NewWithdrawal failed: %v", err)
+	}
+	fileID, balance, err := svc.Withdrawable(ctx, k1)
+	if err != nil {
+		t.Fatalf("Withdrawable failed: %v", err)
+	}
+	if fileID != "file1" || balance != 800 {
+		t.Errorf("Withdrawable = %s, %d; want file1, 800", fileID, balance)
+	}
+
+	pr, _ := payments.MockPaymentRequest("5u")
+	if err := svc.Withdraw(ctx, k1, pr); err != nil {
+		t.Fatalf("Withdraw failed: %v", err)
+	}
+	svc.Wait()
+
+	paid := lnd.Payments()
+	if len(paid) != 1 || paid[0].AmountSats != 500 {
+		t.Fatalf("expected one 500 sat payment, got %+v", paid)
+	}
+	if balance, _ := svc.Balance(ctx, "file1"); balance != 300 {
+		t.Errorf("expected 300 sats left, got %d", balance)
+	}
+
+	if pending, _ := st.ListPendingPayouts(ctx); len(pending) != 0 {
+		t.Errorf("expected the sent payout to be resolved, got %+v", pending)
+	}
+
+	// Each link can only be redeemed once
+	again, _ := payments.MockPaymentRequest("1u")
+	if err := svc.Withdraw(ctx, k1, again); !errors.Is(err, ErrUnknownWithdrawal) {
+		t.Errorf("expected ErrUnknownWithdrawal on reuse, got %v", err)
+	}
+}
+
+func TestService_WithdrawRejected(t *testing.T) {
+	svc, st, lnd := newTestService(t)
+	ctx := context.Background()
+	credit(t, st, "file1", 100, "hash1")
+
+	tooMuch, _ := payments.MockPaymentRequest("2u")
+	tests := []struct {
+		name    string
+		pr      string
+		wantErr error
+	}{
+		{"over balance", tooMuch, ErrInsufficientBalance},
+		{"no amount", "lnbcrt1pnoamount", ErrInvalidInvoice},
+		{"no payment hash", "lnbcrt1u1nohash", ErrInvalidInvoice},
+		{"amount overflows", "lnbcrt92233720368548u1overflow", ErrInvalidInvoice},
+		{"not an invoice", "garbage", ErrInvalidInvoice},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			k1, _ := svc.NewWithdrawal("file1")
+			if err := svc.Withdraw(ctx, k1, tc.pr); !errors.Is(err, tc.wantErr) {
+				t.Errorf("expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+
+	if err := svc.Withdraw(ctx, "unknown", tooMuch); !errors.Is(err, ErrUnknownWithdrawal) {
+		t.Errorf("expected ErrUnknownWithdrawal, got %v", err)
+	}
+
+	svc.Wait()
+	if len(lnd.Payments()) != 0 {
+		t.Error("expected no payments for rejected withdrawals")
+	}
+	if balance, _ := svc.Balance(ctx, "file1"); balance != 100 {
+		t.Errorf("expected balance untouched, got %d", balance)
+	}
+}
+
+func TestService_WithdrawReversedOnFailure(t *testing.T) {
+	svc, st, lnd := newTestService(t)
+	ctx := context.Background()
+	credit(t, st, "file1", 500, "hash1")
+	lnd.SetPayError(errors.New("no route"))
+
+	k1, _ := svc.NewWithdrawal("file1")
+	pr, _ := payments.MockPaymentRequest("5u")
+	if err := svc.Withdraw(ctx, k1, pr); err != nil {
+		t.Fatalf("Withdraw failed: %v", err)
+	}
+	svc.Wait()
+
+	if balance, _ := svc.Balance(ctx, "file1"); balance != 500 {
+		t.Errorf("expected the failed payout to be reversed, balance %d", balance)
+	}
+	entries, _ := svc.Entries(ctx, "file1")
+	if len(entries) != 3 || entries[2].Kind != store.LedgerPayoutReversal {
+		t.Errorf("expected credit, payout and reversal entries, got %d", len(entries))
+	}
+	if pending, _ := st.ListPendingPayouts(ctx); len(pending) != 0 {
+		t.Errorf("expected the reversed payout to be resolved, got %+v", pending)
+	}
+}
+
+func TestService_WithdrawInFlightStaysDebited(t *testing.T) {
+	svc, st, lnd := newTestService(t)
+	ctx := context.Background()
+	credit(t, st, "file1", 500, "hash1")
+	lnd.SetPayInFlight(context.DeadlineExceeded)
+
+	k1, _ := svc.NewWithdrawal("file1")
+	pr, hash := payments.MockPaymentRequest("5u")
+	if err := svc.Withdraw(ctx, k1, pr); err != nil {
+		t.Fatalf("Withdraw failed: %v", err)
+	}
+	svc.Wait()
+
+	// A timeout says nothing about whether the payment went through
+	if balance, _ := svc.Balance(ctx, "file1"); balance != 0 {
+		t.Errorf("expected the payout to stay debited while in flight, balance %d", balance)
+	}
+	if err := svc.ResolvePending(ctx); err != nil {
+		t.Fatalf("ResolvePending failed: %v", err)
+	}
+	if pending, _ := st.ListPendingPayouts(ctx); len(pending) != 1 || pending[0].PaymentHash != hash {
+		t.Fatalf("expected the payout to stay pending, got %+v", pending)
+	}
+
+	// Once the wallet reports the payment failed, it is reversed
+	lnd.SetPaymentState(hash, payments.PaymentFailed)
+	if err := svc.ResolvePending(ctx); err != nil {
+		t.Fatalf("ResolvePending failed: %v", err)
+	}
+	if balance, _ := svc.Balance(ctx, "file1"); balance != 500 {
+		t.Errorf("expected the failed payout to be reversed, balance %d", balance)
+	}
+	if pending, _ := st.ListPendingPayouts(ctx); len(pending) != 0 {
+		t.Errorf("expected no pending payouts, got %+v", pending)
+	}
+}
+
+func TestService_ResolvePendingSucceeded(t *testing.T) {
+	svc, st, lnd := newTestService(t)
+	ctx := context.Background()
+	credit(t, st, "file1", 500, "hash1")
+	lnd.SetPayInFlight(errors.New("connection reset"))
+
+	k1, _ := svc.NewWithdrawal("file1")
+	pr, hash := payments.MockPaymentRequest("5u")
+	if err := svc.Withdraw(ctx, k1, pr); err != nil {
+		t.Fatalf("Withdraw failed: %v", err)
+	}
+	svc.Wait()
+
+	lnd.SetPaymentState(hash, payments.PaymentSucceeded)
+	if err := svc.ResolvePending(ctx); err != nil {
+		t.Fatalf("ResolvePending failed: %v", err)
+	}
+	if balance, _ := svc.Balance(ctx, "file1"); balance != 0 {
+		t.Errorf("expected a delivered payout to stay debited, balance %d", balance)
+	}
+	if pending, _ := st.ListPendingPayouts(ctx); len(pending) != 0 {
+		t.Errorf("expected no pending payouts, got %+v", pending)
+	}
+}
@@ -0,0 +1,258 @@
+// Package pricing computes how many sats to charge for hosting a file.
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	bytesPerGB = 1 << 30
+	day        = 24 * time.Hour
+)
+
+// DefaultTier is the tier used when a request doesn't name one.
+const DefaultTier = "standard"
+
+var (
+	ErrUnknownTier     = errors.New("unknown pricing tier")
+	ErrInvalidSize     = errors.New("size must be positive")
+	ErrInvalidDuration = errors.New("duration must be positive")
+)
+
+// Quote is the price for hosting a file of a given size for a given duration.
+type Quote struct {
+	SizeBytes       int64   `json:"size_bytes"`
+	DurationSeconds int64   `json:"duration_seconds"`
+	Tier            string  `json:"tier"`
+	SatsPerGBDay    float64 `json:"sats_per_gb_day"` // Rate after size bands
+	Multiplier      float64 `json:"multiplier"`      // Tier and promotion multipliers combined
+	Promotion       string  `json:"promotion,omitempty"`
+	MinimumApplied  bool    `json:"minimum_applied"`
+	AmountSats      int64   `json:"amount_sats"`
+
+	// Set for fiat-priced rate cards
+	Currency   string  `json:"currency,omitempty"`
+	BTCPrice   float64 `json:"btc_price,omitempty"`   // Exchange rate the amount was converted at
+	FiatAmount float64 `json:"fiat_amount,omitempty"` // Value of AmountSats in Currency
+}
+
+// Pricer turns an upload's size, hosting duration and tier into a price.
+// ctx bounds any exchange rate lookup.
+type Pricer interface {
+	Quote(ctx context.Context, size int64, duration time.Duration, tier string) (*Quote, error)
+}
+
+// Band overrides the base rate for files up to MaxBytes in size.
+type Band struct {
+	MaxBytes      int64   `json:"max_bytes"` // 0 means no upper bound
+	SatsPerGBDay  float64 `json:"sats_per_gb_day,omitempty"`
+	PricePerGBDay float64 `json:"price_per_gb_day,omitempty"` // In Config.Currency
+}
+
+// Promotion scales every price while it is active.
+type Promotion struct {
+	Label      string    `json:"label"`
+	Multiplier float64   `json:"multiplier"`
+	Starts     time.Time `json:"starts,omitzero"`
+	Ends       time.Time `json:"ends,omitzero"`
+}
+
+// Config describes a rate card. Bands are checked in order and the first
+// one that fits the file's size wins; otherwise SatsPerGBDay applies.
+//
+// If Currency is set, rates are given in that fiat currency instead: the
+// PricePerGBDay fields replace SatsPerGBDay, and prices are converted to sats
+// at quote time using the RateCard's RateProvider. MinimumPrice and
+// MinimumSats both apply.
+type Config struct {
+	SatsPerGBDay float64            `json:"sats_per_gb_day,omitempty"`
+	MinimumSats  int64              `json:"minimum_sats"`
+	Bands        []Band             `json:"bands,omitempty"`
+	Tiers        map[string]float64 `json:"tiers,omitempty"` // Tier name -> multiplier
+	Promotions   []Promotion        `json:"promotions,omitempty"`
+
+	Currency      string  `json:"currency,omitempty"` // ISO 4217 code, e.g. "USD"
+	PricePerGBDay float64 `json:"price_per_gb_day,omitempty"`
+	MinimumPrice  float64 `json:"minimum_price,omitempty"`
+}
+
+// DefaultConfig reproduces the original pricing: 1 sat per MB for 7 days of
+// hosting, with a 100 sat minimum.
+func DefaultConfig() Config {
+	return Config{
+		SatsPerGBDay: 1024.0 / 7,
+		MinimumSats:  100,
+	}
+}
+
+// LoadConfig reads a JSON rate card from path.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate checks that the rate card is usable.
+func (c Config) Validate() error {
+	if c.SatsPerGBDay < 0 || c.MinimumSats < 0 || c.PricePerGBDay < 0 || c.MinimumPrice < 0 {
+		return errors.New("rates must not be negative")
+	}
+	fiat := c.Currency != ""
+	if fiat && c.SatsPerGBDay != 0 {
+		return errors.New("use price_per_gb_day instead of sats_per_gb_day with a currency")
+	}
+	if !fiat && (c.PricePerGBDay != 0 || c.MinimumPrice != 0) {
+		return errors.New("price_per_gb_day and minimum_price require a currency")
+	}
+	for i, b := range c.Bands {
+		if b.SatsPerGBDay < 0 || b.PricePerGBDay < 0 || b.MaxBytes < 0 {
+			return fmt.Errorf("band %d: values must not be negative", i)
+		}
+		if fiat && b.SatsPerGBDay != 0 {
+			return fmt.Errorf("band %d: use price_per_gb_day with a currency", i)
+		}
+		if !fiat && b.PricePerGBDay != 0 {
+			return fmt.Errorf("band %d: price_per_gb_day requires a currency", i)
+		}
+	}
+	for name, m := range c.Tiers {
+		if m <= 0 {
+			return fmt.Errorf("tier %q: multiplier must be positive", name)
+		}
+	}
+	for _, p := range c.Promotions {
+		if p.Multiplier <= 0 {
+			return fmt.Errorf("promotion %q: multiplier must be positive", p.Label)
+		}
+	}
+	return nil
+}
+
+// RateCard is a Pricer backed by a Config.
+type RateCard struct {
+	cfg   Config
+	now   func() time.Time
+	rates RateProvider // required for fiat-priced configs
+}
+
+// NewRateCard creates a Pricer from cfg. Fiat-priced configs also need a
+// RateProvider; see SetRateProvider.
+func NewRateCard(cfg Config) (*RateCard, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	cfg.Currency = strings.ToUpper(cfg.Currency)
+	return &RateCard{cfg: cfg, now: time.Now}, nil
+}
+
+// SetRateProvider sets where a fiat-priced rate card gets exchange rates.
+func (r *RateCard) SetRateProvider(p RateProvider) {
+	r.rates = p
+}
+
+// Default returns a Pricer using DefaultConfig.
+func Default() *RateCard {
+	p, _ := NewRateCard(DefaultConfig())
+	return p
+}
+
+// Config returns the rate card's configuration.
+func (r *RateCard) Config() Config {
+	return r.cfg
+}
+
+func (r *RateCard) Quote(ctx context.Context, size int64, duration time.Duration, tier string) (*Quote, error) {
+	if size <= 0 {
+		return nil, ErrInvalidSize
+	}
+	if duration <= 0 {
+		return nil, ErrInvalidDuration
+	}
+	if tier == "" {
+		tier = DefaultTier
+	}
+
+	multiplier := 1.0
+	if m, ok := r.cfg.Tiers[tier]; ok {
+		multiplier = m
+	} else if tier != DefaultTier {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownTier, tier)
+	}
+
+	fiat := r.cfg.Currency != ""
+	rate := r.cfg.SatsPerGBDay
+	if fiat {
+		rate = r.cfg.PricePerGBDay
+	}
+	for _, b := range r.cfg.Bands {
+		if b.MaxBytes == 0 || size <= b.MaxBytes {
+			rate = b.SatsPerGBDay
+			if fiat {
+				rate = b.PricePerGBDay
+			}
+			break
+		}
+	}
+
+	// Fiat rates are converted to sats at the current exchange rate
+	minimum := r.cfg.MinimumSats
+	var exchange *Rate
+	if fiat {
+		if r.rates == nil {
+			return nil, fmt.Errorf("%w: no rate provider for %s", ErrRateUnavailable, r.cfg.Currency)
+		}
+		var err error
+		exchange, err = r.rates.Rate(ctx, r.cfg.Currency)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRateUnavailable, err)
+		}
+		rate *= exchange.SatsPerUnit()
+		minimum = max(minimum, int64(math.Ceil(r.cfg.MinimumPrice*exchange.SatsPerUnit()-1e-9)))
+	}
+
+	q := &Quote{
+		SizeBytes:       size,
+		DurationSeconds: int64(duration / time.Second),
+		Tier:            tier,
+		SatsPerGBDay:    rate,
+	}
+
+	now := r.now()
+	for _, p := range r.cfg.Promotions {
+		if (p.Starts.IsZero() || !now.Before(p.Starts)) && (p.Ends.IsZero() || now.Before(p.Ends)) {
+			multiplier *= p.Multiplier
+			q.Promotion = p.Label
+			break
+		}
+	}
+	q.Multiplier = multiplier
+
+	exact := float64(size) / bytesPerGB * rate * (float64(duration) / float64(day)) * multiplier
+	// The epsilon absorbs float error so that e.g. exactly 5 MB is 5 sats, not 4
+	q.AmountSats = int64(math.Floor(exact + 1e-9))
+	if q.AmountSats < minimum {
+		q.AmountSats = minimum
+		q.MinimumApplied = true
+	}
+
+	if exchange != nil {
+		q.Currency = exchange.Currency
+		q.BTCPrice = exchange.BTCPrice
+		q.FiatAmount = float64(q.AmountSats) / exchange.SatsPerUnit()
+	}
+
+	return q, nil
+}
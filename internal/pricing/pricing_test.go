@@ -0,0 +1,233 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	mb   = 1 << 20
+	week = 7 * 24 * time.Hour
+)
+
+func TestDefault_MatchesLegacyRule(t *testing.T) {
+	p := Default()
+
+	// Legacy rule: size / 1 MiB sats, minimum 100
+	sizes := []int64{1, mb, 99 * mb, 100 * mb, 100*mb + 1, 101*mb - 1, 512 * mb, 1 << 30, 5 << 30}
+	for _, size := range sizes {
+		want := size / mb
+		if want < 100 {
+			want = 100
+		}
+		q, err := p.Quote(context.Background(), size, week, "")
+		if err != nil {
+			t.Fatalf("Quote(%d) failed: %v", size, err)
+		}
+		if q.AmountSats != want {
+			t.Errorf("Quote(%d).AmountSats = %d, want %d", size, q.AmountSats, want)
+		}
+		if q.Tier != DefaultTier {
+			t.Errorf("Quote(%d).Tier = %q, want %q", size, q.Tier, DefaultTier)
+		}
+	}
+}
+
+func TestRateCard_Quote(t *testing.T) {
+	now := time.Date(2026, 6, 15, 12, 0, 0, 0, time.UTC)
+	cfg := Config{
+		SatsPerGBDay: 100,
+		MinimumSats:  10,
+		Bands: []Band{
+			{MaxBytes: 100 * mb, SatsPerGBDay: 200},
+			{MaxBytes: 0, SatsPerGBDay: 50},
+		},
+		Tiers: map[string]float64{"priority": 2},
+		Promotions: []Promotion{
+			{Label: "expired", Multiplier: 0.1, Ends: now.Add(-time.Hour)},
+			{Label: "summer", Multiplier: 0.5, Starts: now.Add(-time.Hour), Ends: now.Add(time.Hour)},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		size      int64
+		duration  time.Duration
+		tier      string
+		want      int64
+		wantMin   bool
+		wantPromo string
+	}{
+		// 1 GB, large band: 50 * 1 * 10 days * 0.5 promo
+		{"large band", 1 << 30, 10 * 24 * time.Hour, "", 250, false, "summer"},
+		// 100 MB, small band: 200 * (100/1024) * 30 days * 0.5 = 292.96
+		{"small band", 100 * mb, 30 * 24 * time.Hour, "", 292, false, "summer"},
+		{"tier multiplier", 1 << 30, 10 * 24 * time.Hour, "priority", 500, false, "summer"},
+		{"minimum", mb, 24 * time.Hour, "", 10, true, "summer"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := NewRateCard(cfg)
+			if err != nil {
+				t.Fatalf("NewRateCard failed: %v", err)
+			}
+			p.now = func() time.Time { return now }
+
+			q, err := p.Quote(context.Background(), tc.size, tc.duration, tc.tier)
+			if err != nil {
+				t.Fatalf("Quote failed: %v", err)
+			}
+			if q.AmountSats != tc.want {
+				t.Errorf("AmountSats = %d, want %d", q.AmountSats, tc.want)
+			}
+			if q.MinimumApplied != tc.wantMin {
+				t.Errorf("MinimumApplied = %v, want %v", q.MinimumApplied, tc.wantMin)
+			}
+			if q.Promotion != tc.wantPromo {
+				t.Errorf("Promotion = %q, want %q", q.Promotion, tc.wantPromo)
+			}
+		})
+	}
+}
+
+func TestRateCard_QuoteErrors(t *testing.T) {
+	p := Default()
+
+	if _, err := p.Quote(context.Background(), 0, week, ""); !errors.Is(err, ErrInvalidSize) {
+		t.Errorf("expected ErrInvalidSize, got %v", err)
+	}
+	if _, err := p.Quote(context.Background(), mb, 0, ""); !errors.Is(err, ErrInvalidDuration) {
+		t.Errorf("expected ErrInvalidDuration, got %v", err)
+	}
+	if _, err := p.Quote(context.Background(), mb, week, "gold"); !errors.Is(err, ErrUnknownTier) {
+		t.Errorf("expected ErrUnknownTier, got %v", err)
+	}
+}
+
+func TestRateCard_QuoteFiat(t *testing.T) {
+	// At $50,000 per BTC, one dollar buys 2000 sats
+	p, err := NewRateCard(Config{
+		Currency:      "usd",
+		PricePerGBDay: 0.01,
+		MinimumPrice:  0.05,
+		MinimumSats:   50,
+		Bands:         []Band{{MaxBytes: mb, PricePerGBDay: 0.1}, {MaxBytes: 0, PricePerGBDay: 0.01}},
+	})
+	if err != nil {
+		t.Fatalf("NewRateCard failed: %v", err)
+	}
+
+	if _, err := p.Quote(context.Background(), mb, week, ""); !errors.Is(err, ErrRateUnavailable) {
+		t.Errorf("expected ErrRateUnavailable without a rate provider, got %v", err)
+	}
+	p.SetRateProvider(StaticRates{"EUR": 45000})
+	if _, err := p.Quote(context.Background(), mb, week, ""); !errors.Is(err, ErrRateUnavailable) {
+		t.Errorf("expected ErrRateUnavailable without a USD rate, got %v", err)
+	}
+	p.SetRateProvider(StaticRates{"USD": 50000})
+
+	tests := []struct {
+		name       string
+		size       int64
+		duration   time.Duration
+		want       int64
+		wantMin    bool
+		wantPerDay float64
+	}{
+		// $0.01/GB/day * 100 GB-days = $1
+		{"base rate", 1 << 30, 100 * 24 * time.Hour, 2000, false, 20},
+		// $0.10/GB/day for a 1 MB file for a week is about one sat; the
+		// $0.05 minimum (100 sats) beats minimum_sats
+		{"fiat minimum", mb, week, 100, true, 200},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q, err := p.Quote(context.Background(), tc.size, tc.duration, "")
+			if err != nil {
+				t.Fatalf("Quote failed: %v", err)
+			}
+			if q.AmountSats != tc.want || q.MinimumApplied != tc.wantMin {
+				t.Errorf("AmountSats = %d (minimum %v), want %d (minimum %v)", q.AmountSats, q.MinimumApplied, tc.want, tc.wantMin)
+			}
+			if math.Abs(q.SatsPerGBDay-tc.wantPerDay) > 1e-9 {
+				t.Errorf("SatsPerGBDay = %v, want %v", q.SatsPerGBDay, tc.wantPerDay)
+			}
+			if q.Currency != "USD" || q.BTCPrice != 50000 {
+				t.Errorf("expected USD rate to be recorded, got %q %v", q.Currency, q.BTCPrice)
+			}
+			if want := float64(tc.want) / 2000; math.Abs(q.FiatAmount-want) > 1e-9 {
+				t.Errorf("FiatAmount = %v, want %v", q.FiatAmount, want)
+			}
+		})
+	}
+}
+
+func TestNewRateCard_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"negative rate", Config{SatsPerGBDay: -1}},
+		{"negative minimum", Config{MinimumSats: -1}},
+		{"negative band", Config{Bands: []Band{{MaxBytes: mb, SatsPerGBDay: -5}}}},
+		{"zero tier multiplier", Config{Tiers: map[string]float64{"free": 0}}},
+		{"zero promotion multiplier", Config{Promotions: []Promotion{{Label: "x"}}}},
+		{"sats rate with currency", Config{Currency: "USD", SatsPerGBDay: 10}},
+		{"sats band with currency", Config{Currency: "USD", Bands: []Band{{MaxBytes: mb, SatsPerGBDay: 5}}}},
+		{"fiat rate without currency", Config{PricePerGBDay: 0.01}},
+		{"fiat band without currency", Config{Bands: []Band{{MaxBytes: mb, PricePerGBDay: 0.01}}}},
+		{"negative fiat minimum", Config{Currency: "USD", MinimumPrice: -1}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := NewRateCard(tc.cfg); err == nil {
+				t.Error("expected validation error")
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	data := `{
+		"sats_per_gb_day": 20,
+		"minimum_sats": 50,
+		"bands": [{"max_bytes": 1048576, "sats_per_gb_day": 40}],
+		"tiers": {"priority": 1.5},
+		"promotions": [{"label": "launch", "multiplier": 0.8, "ends": "2030-01-01T00:00:00Z"}]
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	if cfg.SatsPerGBDay != 20 || cfg.MinimumSats != 50 {
+		t.Errorf("unexpected base rates: %+v", cfg)
+	}
+	if len(cfg.Bands) != 1 || cfg.Bands[0].SatsPerGBDay != 40 {
+		t.Errorf("unexpected bands: %+v", cfg.Bands)
+	}
+	if cfg.Tiers["priority"] != 1.5 {
+		t.Errorf("unexpected tiers: %+v", cfg.Tiers)
+	}
+	if len(cfg.Promotions) != 1 || !cfg.Promotions[0].Ends.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected promotions: %+v", cfg.Promotions)
+	}
+
+	if err := os.WriteFile(path, []byte(`{not json`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected error for malformed config")
+	}
+}
@@ -0,0 +1,220 @@
+package pricing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"satoshisend/internal/logging"
+)
+
+const satsPerBTC = 100_000_000
+
+// ErrRateUnavailable is returned when no exchange rate can be obtained for a
+// fiat-priced quote.
+var ErrRateUnavailable = errors.New("exchange rate unavailable")
+
+// Rate is the price of one bitcoin in a fiat currency.
+type Rate struct {
+	Currency  string    // ISO 4217 code, e.g. "USD"
+	BTCPrice  float64   // Price of 1 BTC in Currency
+	FetchedAt time.Time // When the provider reported the rate
+}
+
+// SatsPerUnit returns how many sats one unit of the currency buys.
+func (r *Rate) SatsPerUnit() float64 {
+	return satsPerBTC / r.BTCPrice
+}
+
+// RateProvider reports the current price of bitcoin in a fiat currency.
+type RateProvider interface {
+	Rate(ctx context.Context, currency string) (*Rate, error)
+}
+
+// StaticRates is a RateProvider with fixed prices of one bitcoin, keyed by
+// currency code. It suits operators who prefer to adjust prices by hand.
+type StaticRates map[string]float64
+
+func (s StaticRates) Rate(ctx context.Context, currency string) (*Rate, error) {
+	price, ok := s[strings.ToUpper(currency)]
+	if !ok || price <= 0 {
+		return nil, fmt.Errorf("no rate configured for %s", currency)
+	}
+	return &Rate{Currency: strings.ToUpper(currency), BTCPrice: price, FetchedAt: time.Now()}, nil
+}
+
+// HTTPRateConfig configures an HTTPRateProvider.
+type HTTPRateConfig struct {
+	// URL of a JSON price endpoint. "{currency}" is replaced with the
+	// currency code, e.g. "https://api.coinbase.com/v2/prices/BTC-{currency}/spot".
+	URL string
+	// Field is the dot-separated path to the price in the response, e.g.
+	// "data.amount". The price may be a JSON number or a numeric string.
+	Field   string
+	Timeout time.Duration // Defaults to 10 seconds
+}
+
+// HTTPRateProvider fetches rates from a JSON HTTP API.
+type HTTPRateProvider struct {
+	url        string
+	field      []string
+	httpClient *http.Client
+}
+
+// NewHTTPRateProvider creates a RateProvider that queries cfg.URL.
+func NewHTTPRateProvider(cfg HTTPRateConfig) (*HTTPRateProvider, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("rate URL is required")
+	}
+	if cfg.Field == "" {
+		return nil, errors.New("rate field is required")
+	}
+	timeout := cfg.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return &HTTPRateProvider{
+		url:        cfg.URL,
+		field:      strings.Split(cfg.Field, "."),
+		httpClient: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (p *HTTPRateProvider) Rate(ctx context.Context, currency string) (*Rate, error) {
+	currency = strings.ToUpper(currency)
+	url := strings.ReplaceAll(p.url, "{currency}", currency)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("rate request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("rate API returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var doc any
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("failed to decode rate response: %w", err)
+	}
+	for _, key := range p.field {
+		obj, ok := doc.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("rate response has no field %q", strings.Join(p.field, "."))
+		}
+		doc = obj[key]
+	}
+
+	var price float64
+	switch v := doc.(type) {
+	case float64:
+		price = v
+	case string:
+		price, err = strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid price %q in rate response", v)
+		}
+	default:
+		return nil, fmt.Errorf("rate response has no field %q", strings.Join(p.field, "."))
+	}
+	if price <= 0 {
+		return nil, fmt.Errorf("invalid price %v in rate response", price)
+	}
+	return &Rate{Currency: currency, BTCPrice: price, FetchedAt: time.Now()}, nil
+}
+
+// RateCache wraps a RateProvider, reusing each currency's rate for a TTL so
+// quotes don't hit the upstream API on every request. If a refresh fails,
+// the last rate is used for up to MaxStale after it was fetched. Concurrent
+// requests for a currency share one refresh, which runs without holding the
+// cache's lock, so a slow upstream doesn't hold up other currencies.
+type RateCache struct {
+	provider RateProvider
+	ttl      time.Duration
+	maxStale time.Duration
+
+	mu       sync.Mutex
+	rates    map[string]*Rate
+	fetching map[string]*rateFetch // refreshes in progress, by currency
+}
+
+// rateFetch is a refresh in progress. done is closed once rate or err is set.
+type rateFetch struct {
+	done chan struct{}
+	rate *Rate
+	err  error
+}
+
+// NewRateCache creates a cache that refreshes rates after ttl and falls back
+// to a stale rate for up to maxStale when the provider fails.
+func NewRateCache(provider RateProvider, ttl, maxStale time.Duration) *RateCache {
+	return &RateCache{
+		provider: provider,
+		ttl:      ttl,
+		maxStale: maxStale,
+		rates:    make(map[string]*Rate),
+		fetching: make(map[string]*rateFetch),
+	}
+}
+
+func (c *RateCache) Rate(ctx context.Context, currency string) (*Rate, error) {
+	currency = strings.ToUpper(currency)
+	c.mu.Lock()
+	cached := c.rates[currency]
+	if cached != nil && time.Since(cached.FetchedAt) < c.ttl {
+		c.mu.Unlock()
+		return cached, nil
+	}
+	f := c.fetching[currency]
+	if f == nil {
+		f = &rateFetch{done: make(chan struct{})}
+		c.fetching[currency] = f
+		// The refresh serves every waiter, so it outlives this caller
+		go c.refresh(context.WithoutCancel(ctx), currency, f)
+	}
+	c.mu.Unlock()
+
+	var err error
+	select {
+	case <-f.done:
+		if f.err == nil {
+			return f.rate, nil
+		}
+		err = f.err
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if cached != nil && time.Since(cached.FetchedAt) < c.maxStale {
+		logging.Internal.Printf("failed to refresh %s rate, using rate from %s: %v", currency, cached.FetchedAt.Format(time.RFC3339), err)
+		return cached, nil
+	}
+	return nil, err
+}
+
+// refresh fetches a currency's rate for f and caches it on success.
+func (c *RateCache) refresh(ctx context.Context, currency string, f *rateFetch) {
+	rate, err := c.provider.Rate(ctx, currency)
+
+	c.mu.Lock()
+	if err == nil {
+		c.rates[currency] = rate
+	}
+	delete(c.fetching, currency)
+	f.rate, f.err = rate, err
+	c.mu.Unlock()
+	close(f.done)
+}
@@ -0,0 +1,176 @@
+package pricing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPRateProvider(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/prices/BTC-USD/spot":
+			w.Write([]byte(`{"data": {"base": "BTC", "currency": "USD", "amount": "64250.50"}}`))
+		case "/prices/BTC-EUR/spot":
+			w.Write([]byte(`{"data": {"base": "BTC", "currency": "EUR", "amount": 59000}}`))
+		case "/prices/BTC-XXX/spot":
+			w.Write([]byte(`{"data": {"amount": "n/a"}}`))
+		case "/prices/BTC-YYY/spot":
+			w.Write([]byte(`{"data": "missing"}`))
+		default:
+			http.Error(w, "unknown currency", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	p, err := NewHTTPRateProvider(HTTPRateConfig{URL: srv.URL + "/prices/BTC-{currency}/spot", Field: "data.amount"})
+	if err != nil {
+		t.Fatalf("NewHTTPRateProvider failed: %v", err)
+	}
+	ctx := context.Background()
+
+	rate, err := p.Rate(ctx, "usd")
+	if err != nil {
+		t.Fatalf("Rate failed: %v", err)
+	}
+	if rate.Currency != "USD" || rate.BTCPrice != 64250.50 || rate.FetchedAt.IsZero() {
+		t.Errorf("unexpected rate %+v", rate)
+	}
+	if rate, err := p.Rate(ctx, "EUR"); err != nil || rate.BTCPrice != 59000 {
+		t.Errorf("expected numeric price 59000, got %+v (err=%v)", rate, err)
+	}
+
+	for _, currency := range []string{"XXX", "YYY", "ZZZ"} {
+		if _, err := p.Rate(ctx, currency); err == nil {
+			t.Errorf("expected an error for %s", currency)
+		}
+	}
+
+	if _, err := NewHTTPRateProvider(HTTPRateConfig{URL: srv.URL}); err == nil {
+		t.Error("expected an error without a field")
+	}
+}
+
+// countingRates serves a price that can be changed or failed, counting calls.
+type countingRates struct {
+	price atomic.Int64
+	fail  atomic.Bool
+	calls atomic.Int32
+}
+
+func (c *countingRates) Rate(ctx context.Context, currency string) (*Rate, error) {
+	c.calls.Add(1)
+	if c.fail.Load() {
+		return nil, errors.New("upstream down")
+	}
+	return &Rate{Currency: currency, BTCPrice: float64(c.price.Load()), FetchedAt: time.Now()}, nil
+}
+
+func TestRateCache(t *testing.T) {
+	upstream := &countingRates{}
+	upstream.price.Store(50000)
+	ctx := context.Background()
+
+	cache := NewRateCache(upstream, time.Hour, 2*time.Hour)
+	for i := 0; i < 3; i++ {
+		rate, err := cache.Rate(ctx, "usd")
+		if err != nil || rate.BTCPrice != 50000 {
+			t.Fatalf("unexpected rate %+v (err=%v)", rate, err)
+		}
+	}
+	if calls := upstream.calls.Load(); calls != 1 {
+		t.Errorf("expected 1 upstream call within the TTL, got %d", calls)
+	}
+
+	// Expired entries are refreshed
+	cache = NewRateCache(upstream, 0, time.Hour)
+	cache.Rate(ctx, "USD")
+	upstream.price.Store(60000)
+	if rate, _ := cache.Rate(ctx, "USD"); rate.BTCPrice != 60000 {
+		t.Errorf("expected refreshed rate 60000, got %v", rate.BTCPrice)
+	}
+
+	// A failed refresh falls back to the last rate while it isn't too old
+	upstream.fail.Store(true)
+	if rate, err := cache.Rate(ctx, "USD"); err != nil || rate.BTCPrice != 60000 {
+		t.Errorf("expected stale rate 60000, got %+v (err=%v)", rate, err)
+	}
+	if _, err := cache.Rate(ctx, "EUR"); err == nil {
+		t.Error("expected an error without any cached rate")
+	}
+
+	cache = NewRateCache(upstream, 0, 0)
+	upstream.fail.Store(false)
+	cache.Rate(ctx, "USD")
+	upstream.fail.Store(true)
+	if _, err := cache.Rate(ctx, "USD"); err == nil {
+		t.Error("expected an error once the cached rate is too old")
+	}
+}
+
+// slowRates blocks USD lookups until release is closed, counting calls.
+type slowRates struct {
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (s *slowRates) Rate(ctx context.Context, currency string) (*Rate, error) {
+	s.calls.Add(1)
+	if currency == "USD" {
+		<-s.release
+	}
+	return &Rate{Currency: currency, BTCPrice: 50000, FetchedAt: time.Now()}, nil
+}
+
+func TestRateCache_ConcurrentRefresh(t *testing.T) {
+	upstream := &slowRates{release: make(chan struct{})}
+	cache := NewRateCache(upstream, time.Hour, time.Hour)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := cache.Rate(context.Background(), "USD")
+			errs <- err
+		}()
+	}
+
+	// A slow refresh of one currency doesn't hold up another
+	done := make(chan struct{})
+	go func() {
+		cache.Rate(context.Background(), "EUR")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("EUR lookup waited on the USD refresh")
+	}
+
+	// A caller that gives up returns without waiting for the refresh
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := cache.Rate(ctx, "USD"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the caller's deadline to end the wait, got %v", err)
+	}
+
+	close(upstream.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Rate failed: %v", err)
+		}
+	}
+	// One call per currency, shared by every waiter
+	if calls := upstream.calls.Load(); calls != 2 {
+		t.Errorf("expected 2 upstream calls, got %d", calls)
+	}
+}
@@ -4,12 +4,21 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"math"
+	"slices"
+	"sort"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var ErrNotFound = errors.New("not found")
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrDuplicate           = errors.New("duplicate")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrExhausted           = errors.New("exhausted")
+	ErrInvalidAmount       = errors.New("invalid amount")
+)
 
 // SQLiteStore implements Store using SQLite.
 type SQLiteStore struct {
@@ -42,15 +51,19 @@ func migrate(db *sql.DB) error {
 			expires_at DATETIME NOT NULL,
 			host_duration_ns INTEGER NOT NULL DEFAULT 0,
 			paid INTEGER NOT NULL DEFAULT 0,
-			created_at DATETIME NOT NULL
+			created_at DATETIME NOT NULL,
+			download_price_sats INTEGER NOT NULL DEFAULT 0,
+			owner_token_hash TEXT NOT NULL DEFAULT ''
 		)
 	`)
 	if err != nil {
 		return err
 	}
 
-	// Add host_duration_ns column if it doesn't exist (migration for existing DBs)
+	// Add columns that don't exist yet (migration for existing DBs)
 	_, _ = db.Exec(`ALTER TABLE files ADD COLUMN host_duration_ns INTEGER NOT NULL DEFAULT 0`)
+	_, _ = db.Exec(`ALTER TABLE files ADD COLUMN download_price_sats INTEGER NOT NULL DEFAULT 0`)
+	_, _ = db.Exec(`ALTER TABLE files ADD COLUMN owner_token_hash TEXT NOT NULL DEFAULT ''`)
 
 	// Create pending_invoices table for restart recovery
 	_, err = db.Exec(`
@@ -59,6 +72,223 @@ func migrate(db *sql.DB) error {
 			file_id TEXT NOT NULL,
 			payment_request TEXT NOT NULL,
 			amount_sats INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME,
+			kind TEXT NOT NULL DEFAULT 'upload',
+			duration_ns INTEGER NOT NULL DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Add expires_at, kind and duration_ns columns if they don't exist (migration for existing DBs)
+	_, _ = db.Exec(`ALTER TABLE pending_invoices ADD COLUMN expires_at DATETIME`)
+	_, _ = db.Exec(`ALTER TABLE pending_invoices ADD COLUMN kind TEXT NOT NULL DEFAULT 'upload'`)
+	_, _ = db.Exec(`ALTER TABLE pending_invoices ADD COLUMN duration_ns INTEGER NOT NULL DEFAULT 0`)
+	_, _ = db.Exec(`ALTER TABLE pending_invoices ADD COLUMN account_id TEXT NOT NULL DEFAULT ''`)
+	_, _ = db.Exec(`ALTER TABLE pending_invoices ADD COLUMN currency TEXT NOT NULL DEFAULT ''`)
+	_, _ = db.Exec(`ALTER TABLE pending_invoices ADD COLUMN btc_price REAL NOT NULL DEFAULT 0`)
+
+	// Create settings table for small key/value state (e.g. backend cursors)
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL,
+			updated_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create webhook_inbox table so verified webhooks survive until processed
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS webhook_inbox (
+			id TEXT PRIMARY KEY,
+			payload BLOB NOT NULL,
+			received_at DATETIME NOT NULL,
+			processed INTEGER NOT NULL DEFAULT 0
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create l402_tokens table recording download tokens issued for paywalled files
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS l402_tokens (
+			payment_hash TEXT PRIMARY KEY,
+			file_id TEXT NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Offer-bought tokens aren't bound to the challenge's payment hash
+	_, _ = db.Exec(`ALTER TABLE l402_tokens ADD COLUMN offer_id TEXT NOT NULL DEFAULT ''`)
+
+	// Create offers table recording BOLT12 offers issued for files
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS offers (
+			offer_id TEXT PRIMARY KEY,
+			file_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			bolt12 TEXT NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			duration_ns INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create ledger table recording every earnings credit and payout
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS ledger (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			file_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			reference TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			UNIQUE (kind, reference)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_ledger_file_id ON ledger (file_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create pending_payouts table tracking payouts whose outcome isn't known yet
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS pending_payouts (
+			reference TEXT PRIMARY KEY,
+			file_id TEXT NOT NULL,
+			payment_hash TEXT NOT NULL,
+			payment_request TEXT NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create accounts and account_ledger tables for prepaid credit accounts
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS accounts (
+			id TEXT PRIMARY KEY,
+			api_key_hash TEXT NOT NULL UNIQUE,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS account_ledger (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			account_id TEXT NOT NULL,
+			kind TEXT NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			reference TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			UNIQUE (kind, reference)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_account_ledger_account_id ON account_ledger (account_id)`)
+	if err != nil {
+		return err
+	}
+
+	// Create vouchers and voucher_redemptions tables for discount codes
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS vouchers (
+			code TEXT PRIMARY KEY,
+			amount_off_sats INTEGER NOT NULL DEFAULT 0,
+			percent_off INTEGER NOT NULL DEFAULT 0,
+			max_uses INTEGER NOT NULL DEFAULT 0,
+			expires_at DATETIME,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS voucher_redemptions (
+			code TEXT NOT NULL,
+			file_id TEXT NOT NULL,
+			discount_sats INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			PRIMARY KEY (code, file_id)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create payments table keeping a record of every settled payment
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS payments (
+			payment_hash TEXT PRIMARY KEY,
+			file_id TEXT NOT NULL DEFAULT '',
+			kind TEXT NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			settled_at DATETIME NOT NULL,
+			backend TEXT NOT NULL DEFAULT '',
+			account_id TEXT NOT NULL DEFAULT '',
+			currency TEXT NOT NULL DEFAULT '',
+			btc_price REAL NOT NULL DEFAULT 0,
+			refund_due TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return err
+	}
+	_, _ = db.Exec(`ALTER TABLE payments ADD COLUMN refund_due TEXT NOT NULL DEFAULT ''`)
+	_, err = db.Exec(`CREATE INDEX IF NOT EXISTS idx_payments_settled_at ON payments (settled_at)`)
+	if err != nil {
+		return err
+	}
+
+	// Create partial_uploads table tracking resumable uploads in progress
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS partial_uploads (
+			id TEXT PRIMARY KEY,
+			length INTEGER NOT NULL,
+			upload_offset INTEGER NOT NULL DEFAULT 0,
+			chunks INTEGER NOT NULL DEFAULT 0,
+			metadata TEXT NOT NULL DEFAULT '',
+			created_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create cashu_proofs table holding ecash received for Cashu payments
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS cashu_proofs (
+			secret TEXT PRIMARY KEY,
+			mint TEXT NOT NULL,
+			keyset_id TEXT NOT NULL,
+			amount_sats INTEGER NOT NULL,
+			c TEXT NOT NULL,
 			created_at DATETIME NOT NULL
 		)
 	`)
@@ -71,22 +301,22 @@ func migrate(db *sql.DB) error {
 
 func (s *SQLiteStore) SaveFileMetadata(ctx context.Context, meta *FileMeta) error {
 	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO files (id, size, expires_at, host_duration_ns, paid, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, meta.ID, meta.Size, meta.ExpiresAt, int64(meta.HostDuration), meta.Paid, meta.CreatedAt)
+		INSERT INTO files (id, size, expires_at, host_duration_ns, paid, created_at, download_price_sats, owner_token_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, meta.ID, meta.Size, meta.ExpiresAt, int64(meta.HostDuration), meta.Paid, meta.CreatedAt, meta.DownloadPriceSats, meta.OwnerTokenHash)
 	return err
 }
 
 func (s *SQLiteStore) GetFileMetadata(ctx context.Context, id string) (*FileMeta, error) {
 	row := s.db.QueryRowContext(ctx, `
-		SELECT id, size, expires_at, host_duration_ns, paid, created_at
+		SELECT id, size, expires_at, host_duration_ns, paid, created_at, download_price_sats, owner_token_hash
 		FROM files WHERE id = ?
 	`, id)
 
 	var meta FileMeta
 	var hostDurationNs int64
 	var paid int
-	err := row.Scan(&meta.ID, &meta.Size, &meta.ExpiresAt, &hostDurationNs, &paid, &meta.CreatedAt)
+	err := row.Scan(&meta.ID, &meta.Size, &meta.ExpiresAt, &hostDurationNs, &paid, &meta.CreatedAt, &meta.DownloadPriceSats, &meta.OwnerTokenHash)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, ErrNotFound
 	}
@@ -133,6 +363,26 @@ func (s *SQLiteStore) UpdatePaymentStatus(ctx context.Context, fileID string, pa
 	return nil
 }
 
+func (s *SQLiteStore) ExtendExpiry(ctx context.Context, fileID string, d time.Duration) error {
+	// Extend from whichever is later: the current expiry or now
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE files
+		SET expires_at = datetime(max(julianday(expires_at), julianday('now')) + (? / 86400.0))
+		WHERE id = ? AND paid = 1
+	`, d.Seconds(), fileID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 func (s *SQLiteStore) DeleteFileMetadata(ctx context.Context, id string) error {
 	result, err := s.db.ExecContext(ctx, `DELETE FROM files WHERE id = ?`, id)
 	if err != nil {
@@ -150,9 +400,10 @@ func (s *SQLiteStore) DeleteFileMetadata(ctx context.Context, id string) error {
 
 func (s *SQLiteStore) ListExpiredFiles(ctx context.Context) ([]*FileMeta, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT id, size, expires_at, host_duration_ns, paid, created_at
+		SELECT id, size, expires_at, host_duration_ns, paid, created_at, download_price_sats, owner_token_hash
 		FROM files WHERE expires_at < ?
-	`, time.Now())
+		AND id NOT IN (SELECT file_id FROM pending_invoices WHERE kind = ?)
+	`, time.Now(), string(InvoiceKindExtension))
 	if err != nil {
 		return nil, err
 	}
@@ -163,7 +414,7 @@ func (s *SQLiteStore) ListExpiredFiles(ctx context.Context) ([]*FileMeta, error)
 		var meta FileMeta
 		var hostDurationNs int64
 		var paid int
-		if err := rows.Scan(&meta.ID, &meta.Size, &meta.ExpiresAt, &hostDurationNs, &paid, &meta.CreatedAt); err != nil {
+		if err := rows.Scan(&meta.ID, &meta.Size, &meta.ExpiresAt, &hostDurationNs, &paid, &meta.CreatedAt, &meta.DownloadPriceSats, &meta.OwnerTokenHash); err != nil {
 			return nil, err
 		}
 		meta.HostDuration = time.Duration(hostDurationNs)
@@ -244,15 +495,73 @@ func (s *SQLiteStore) GetStats(ctx context.Context) (*Stats, error) {
 		}
 		stats.DailyStats = append(stats.DailyStats, ds)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
+	if err := s.revenueStats(ctx, stats); err != nil {
+		return nil, err
+	}
 	return stats, nil
 }
 
+// revenueStats fills in the revenue figures of stats from the payments table.
+func (s *SQLiteStore) revenueStats(ctx context.Context, stats *Stats) error {
+	var avgPrice, avgSettleSecs float64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(*),
+			COALESCE(SUM(CASE WHEN refund_due = '' THEN amount_sats ELSE 0 END), 0),
+			COALESCE(AVG(CASE WHEN kind = ? AND refund_due = '' THEN amount_sats END), 0),
+			COALESCE(AVG(CASE WHEN kind = ? THEN (julianday(settled_at) - julianday(created_at)) * 86400 END), 0),
+			COALESCE(SUM(CASE WHEN refund_due != '' THEN 1 ELSE 0 END), 0),
+			COALESCE(SUM(CASE WHEN refund_due != '' THEN amount_sats ELSE 0 END), 0)
+		FROM payments
+	`, string(InvoiceKindUpload), string(InvoiceKindUpload)).Scan(&stats.Payments, &stats.RevenueSats, &avgPrice, &avgSettleSecs, &stats.Refunds, &stats.RefundSats)
+	if err != nil {
+		return err
+	}
+	stats.AvgPriceSats = int64(math.Round(avgPrice))
+	stats.AvgSettleTime = time.Duration(avgSettleSecs * float64(time.Second)).Round(time.Second)
+
+	// Daily revenue for the last 14 days, merged into the paid file stats
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT date(settled_at) as day, SUM(amount_sats)
+		FROM payments
+		WHERE settled_at >= date('now', '-14 days') AND refund_due = ''
+		GROUP BY date(settled_at)
+	`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var day string
+		var revenue int64
+		if err := rows.Scan(&day, &revenue); err != nil {
+			return err
+		}
+		i := sort.Search(len(stats.DailyStats), func(i int) bool { return stats.DailyStats[i].Date <= day })
+		if i < len(stats.DailyStats) && stats.DailyStats[i].Date == day {
+			stats.DailyStats[i].RevenueSats = revenue
+		} else {
+			stats.DailyStats = slices.Insert(stats.DailyStats, i, DailyStat{Date: day, RevenueSats: revenue})
+		}
+	}
+	return rows.Err()
+}
+
 func (s *SQLiteStore) SavePendingInvoice(ctx context.Context, inv *PendingInvoice) error {
+	expiresAt := sql.NullTime{Time: inv.ExpiresAt, Valid: !inv.ExpiresAt.IsZero()}
+	kind := inv.Kind
+	if kind == "" {
+		kind = InvoiceKindUpload
+	}
 	_, err := s.db.ExecContext(ctx, `
-		INSERT OR REPLACE INTO pending_invoices (payment_hash, file_id, payment_request, amount_sats, created_at)
-		VALUES (?, ?, ?, ?, ?)
-	`, inv.PaymentHash, inv.FileID, inv.PaymentRequest, inv.AmountSats, inv.CreatedAt)
+		INSERT OR REPLACE INTO pending_invoices (payment_hash, file_id, payment_request, amount_sats, created_at, expires_at, kind, duration_ns, account_id, currency, btc_price)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, inv.PaymentHash, inv.FileID, inv.PaymentRequest, inv.AmountSats, inv.CreatedAt, expiresAt, string(kind), int64(inv.Duration), inv.AccountID, inv.Currency, inv.BTCPrice)
 	return err
 }
 
@@ -263,7 +572,7 @@ func (s *SQLiteStore) DeletePendingInvoice(ctx context.Context, paymentHash stri
 
 func (s *SQLiteStore) ListPendingInvoices(ctx context.Context) ([]*PendingInvoice, error) {
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT payment_hash, file_id, payment_request, amount_sats, created_at
+		SELECT payment_hash, file_id, payment_request, amount_sats, created_at, expires_at, kind, duration_ns, account_id, currency, btc_price
 		FROM pending_invoices
 	`)
 	if err != nil {
@@ -274,14 +583,593 @@ func (s *SQLiteStore) ListPendingInvoices(ctx context.Context) ([]*PendingInvoic
 	var invoices []*PendingInvoice
 	for rows.Next() {
 		var inv PendingInvoice
-		if err := rows.Scan(&inv.PaymentHash, &inv.FileID, &inv.PaymentRequest, &inv.AmountSats, &inv.CreatedAt); err != nil {
+		var expiresAt sql.NullTime
+		var kind string
+		var durationNs int64
+		if err := rows.Scan(&inv.PaymentHash, &inv.FileID, &inv.PaymentRequest, &inv.AmountSats, &inv.CreatedAt, &expiresAt, &kind, &durationNs, &inv.AccountID, &inv.Currency, &inv.BTCPrice); err != nil {
 			return nil, err
 		}
+		inv.ExpiresAt = expiresAt.Time
+		inv.Kind = InvoiceKind(kind)
+		inv.Duration = time.Duration(durationNs)
 		invoices = append(invoices, &inv)
 	}
 	return invoices, rows.Err()
 }
 
+func (s *SQLiteStore) SaveOffer(ctx context.Context, offer *Offer) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO offers (offer_id, file_id, kind, bolt12, amount_sats, duration_ns, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, offer.OfferID, offer.FileID, string(offer.Kind), offer.Bolt12, offer.AmountSats, int64(offer.Duration), offer.ExpiresAt, offer.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) ListOffers(ctx context.Context) ([]*Offer, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT offer_id, file_id, kind, bolt12, amount_sats, duration_ns, expires_at, created_at
+		FROM offers WHERE expires_at > ?
+	`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offers []*Offer
+	for rows.Next() {
+		var offer Offer
+		var kind string
+		var durationNs int64
+		if err := rows.Scan(&offer.OfferID, &offer.FileID, &kind, &offer.Bolt12, &offer.AmountSats, &durationNs, &offer.ExpiresAt, &offer.CreatedAt); err != nil {
+			return nil, err
+		}
+		offer.Kind = InvoiceKind(kind)
+		offer.Duration = time.Duration(durationNs)
+		offers = append(offers, &offer)
+	}
+	return offers, rows.Err()
+}
+
+func (s *SQLiteStore) GetSetting(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+func (s *SQLiteStore) SetSetting(ctx context.Context, key, value string) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO settings (key, value, updated_at)
+		VALUES (?, ?, ?)
+	`, key, value, time.Now())
+	return err
+}
+
+func (s *SQLiteStore) SaveWebhook(ctx context.Context, msg *WebhookMessage) error {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO webhook_inbox (id, payload, received_at, processed)
+		VALUES (?, ?, ?, ?)
+	`, msg.ID, msg.Payload, msg.ReceivedAt, msg.Processed)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListUnprocessedWebhooks(ctx context.Context) ([]*WebhookMessage, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, payload, received_at, processed
+		FROM webhook_inbox
+		WHERE processed = 0
+		ORDER BY received_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []*WebhookMessage
+	for rows.Next() {
+		var msg WebhookMessage
+		if err := rows.Scan(&msg.ID, &msg.Payload, &msg.ReceivedAt, &msg.Processed); err != nil {
+			return nil, err
+		}
+		messages = append(messages, &msg)
+	}
+	return messages, rows.Err()
+}
+
+func (s *SQLiteStore) MarkWebhookProcessed(ctx context.Context, id string) error {
+	result, err := s.db.ExecContext(ctx, `UPDATE webhook_inbox SET processed = 1 WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *SQLiteStore) SaveL402Token(ctx context.Context, token *L402Token) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO l402_tokens (payment_hash, file_id, amount_sats, created_at, offer_id)
+		VALUES (?, ?, ?, ?, ?)
+	`, token.PaymentHash, token.FileID, token.AmountSats, token.CreatedAt, token.OfferID)
+	return err
+}
+
+func (s *SQLiteStore) GetL402Token(ctx context.Context, paymentHash string) (*L402Token, error) {
+	var token L402Token
+	err := s.db.QueryRowContext(ctx, `
+		SELECT payment_hash, file_id, amount_sats, created_at, offer_id
+		FROM l402_tokens WHERE payment_hash = ?
+	`, paymentHash).Scan(&token.PaymentHash, &token.FileID, &token.AmountSats, &token.CreatedAt, &token.OfferID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+func (s *SQLiteStore) AddLedgerEntry(ctx context.Context, entry *LedgerEntry) error {
+	// Payouts debit; everything else credits
+	if entry.AmountSats == 0 || (entry.AmountSats < 0) != (entry.Kind == LedgerPayout) {
+		return ErrInvalidAmount
+	}
+
+	// Debits are only inserted while the balance covers them; the check and
+	// insert run as one statement so concurrent payouts can't overdraw.
+	result, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO ledger (file_id, kind, amount_sats, reference, created_at)
+		SELECT ?, ?, ?, ?, ?
+		WHERE ? >= 0 OR (SELECT COALESCE(SUM(amount_sats), 0) FROM ledger WHERE file_id = ?) + ? >= 0
+	`, entry.FileID, string(entry.Kind), entry.AmountSats, entry.Reference, entry.CreatedAt,
+		entry.AmountSats, entry.FileID, entry.AmountSats)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		var exists int
+		err := s.db.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM ledger WHERE kind = ? AND reference = ?
+		`, string(entry.Kind), entry.Reference).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrDuplicate
+		}
+		return ErrInsufficientBalance
+	}
+
+	entry.ID, _ = result.LastInsertId()
+	return nil
+}
+
+func (s *SQLiteStore) LedgerBalance(ctx context.Context, fileID string) (int64, error) {
+	var balance int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount_sats), 0) FROM ledger WHERE file_id = ?
+	`, fileID).Scan(&balance)
+	return balance, err
+}
+
+func (s *SQLiteStore) ListLedgerEntries(ctx context.Context, fileID string) ([]*LedgerEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, file_id, kind, amount_sats, reference, created_at
+		FROM ledger WHERE file_id = ?
+		ORDER BY id
+	`, fileID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*LedgerEntry
+	for rows.Next() {
+		var entry LedgerEntry
+		var kind string
+		if err := rows.Scan(&entry.ID, &entry.FileID, &kind, &entry.AmountSats, &entry.Reference, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.Kind = LedgerKind(kind)
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLiteStore) SavePendingPayout(ctx context.Context, payout *PendingPayout) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO pending_payouts (reference, file_id, payment_hash, payment_request, amount_sats, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, payout.Reference, payout.FileID, payout.PaymentHash, payout.PaymentRequest, payout.AmountSats, payout.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) DeletePendingPayout(ctx context.Context, reference string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM pending_payouts WHERE reference = ?`, reference)
+	return err
+}
+
+func (s *SQLiteStore) ListPendingPayouts(ctx context.Context) ([]*PendingPayout, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT reference, file_id, payment_hash, payment_request, amount_sats, created_at
+		FROM pending_payouts ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payouts []*PendingPayout
+	for rows.Next() {
+		var p PendingPayout
+		if err := rows.Scan(&p.Reference, &p.FileID, &p.PaymentHash, &p.PaymentRequest, &p.AmountSats, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		payouts = append(payouts, &p)
+	}
+	return payouts, rows.Err()
+}
+
+func (s *SQLiteStore) CreateAccount(ctx context.Context, account *Account) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO accounts (id, api_key_hash, created_at) VALUES (?, ?, ?)
+	`, account.ID, account.APIKeyHash, account.CreatedAt)
+	return err
+}
+
+func (s *SQLiteStore) GetAccountByKeyHash(ctx context.Context, keyHash string) (*Account, error) {
+	var account Account
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, api_key_hash, created_at FROM accounts WHERE api_key_hash = ?
+	`, keyHash).Scan(&account.ID, &account.APIKeyHash, &account.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (s *SQLiteStore) AddAccountEntry(ctx context.Context, entry *AccountEntry) error {
+	// As with the earnings ledger, the balance check and insert are one
+	// statement so concurrent uploads can't overdraw the account.
+	result, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO account_ledger (account_id, kind, amount_sats, reference, created_at)
+		SELECT ?, ?, ?, ?, ?
+		WHERE ? >= 0 OR (SELECT COALESCE(SUM(amount_sats), 0) FROM account_ledger WHERE account_id = ?) + ? >= 0
+	`, entry.AccountID, string(entry.Kind), entry.AmountSats, entry.Reference, entry.CreatedAt,
+		entry.AmountSats, entry.AccountID, entry.AmountSats)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		var exists int
+		err := s.db.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM account_ledger WHERE kind = ? AND reference = ?
+		`, string(entry.Kind), entry.Reference).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if exists > 0 {
+			return ErrDuplicate
+		}
+		return ErrInsufficientBalance
+	}
+
+	entry.ID, _ = result.LastInsertId()
+	return nil
+}
+
+func (s *SQLiteStore) AccountBalance(ctx context.Context, accountID string) (int64, error) {
+	var balance int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COALESCE(SUM(amount_sats), 0) FROM account_ledger WHERE account_id = ?
+	`, accountID).Scan(&balance)
+	return balance, err
+}
+
+func (s *SQLiteStore) ListAccountEntries(ctx context.Context, accountID string) ([]*AccountEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, account_id, kind, amount_sats, reference, created_at
+		FROM account_ledger WHERE account_id = ?
+		ORDER BY id
+	`, accountID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*AccountEntry
+	for rows.Next() {
+		var entry AccountEntry
+		var kind string
+		if err := rows.Scan(&entry.ID, &entry.AccountID, &kind, &entry.AmountSats, &entry.Reference, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entry.Kind = AccountEntryKind(kind)
+		entries = append(entries, &entry)
+	}
+	return entries, rows.Err()
+}
+
+func (s *SQLiteStore) DeleteUnfundedAccounts(ctx context.Context, createdBefore time.Time) (int64, error) {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM accounts
+		WHERE created_at < ?
+		AND NOT EXISTS (SELECT 1 FROM account_ledger WHERE account_id = accounts.id)
+	`, createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+func (s *SQLiteStore) CreateVoucher(ctx context.Context, v *Voucher) error {
+	var expiresAt sql.NullTime
+	if !v.ExpiresAt.IsZero() {
+		expiresAt = sql.NullTime{Time: v.ExpiresAt, Valid: true}
+	}
+	result, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO vouchers (code, amount_off_sats, percent_off, max_uses, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, v.Code, v.AmountOffSats, v.PercentOff, v.MaxUses, expiresAt, v.CreatedAt)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrDuplicate
+	}
+	return nil
+}
+
+const voucherColumns = `
+	v.code, v.amount_off_sats, v.percent_off, v.max_uses, v.expires_at, v.created_at,
+	(SELECT COUNT(*) FROM voucher_redemptions r WHERE r.code = v.code)
+`
+
+func scanVoucher(row interface{ Scan(...any) error }) (*Voucher, error) {
+	var v Voucher
+	var expiresAt sql.NullTime
+	if err := row.Scan(&v.Code, &v.AmountOffSats, &v.PercentOff, &v.MaxUses, &expiresAt, &v.CreatedAt, &v.Uses); err != nil {
+		return nil, err
+	}
+	v.ExpiresAt = expiresAt.Time
+	return &v, nil
+}
+
+func (s *SQLiteStore) GetVoucher(ctx context.Context, code string) (*Voucher, error) {
+	v, err := scanVoucher(s.db.QueryRowContext(ctx, `SELECT `+voucherColumns+` FROM vouchers v WHERE v.code = ?`, code))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	return v, err
+}
+
+func (s *SQLiteStore) ListVouchers(ctx context.Context) ([]*Voucher, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+voucherColumns+` FROM vouchers v ORDER BY v.created_at, v.code`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var vouchers []*Voucher
+	for rows.Next() {
+		v, err := scanVoucher(rows)
+		if err != nil {
+			return nil, err
+		}
+		vouchers = append(vouchers, v)
+	}
+	return vouchers, rows.Err()
+}
+
+func (s *SQLiteStore) RedeemVoucher(ctx context.Context, r *VoucherRedemption) error {
+	// Uses are counted from the redemptions themselves, so checking the
+	// limit and recording the use in one statement can't oversubscribe a
+	// voucher under concurrent uploads.
+	result, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO voucher_redemptions (code, file_id, discount_sats, created_at)
+		SELECT v.code, ?, ?, ?
+		FROM vouchers v
+		WHERE v.code = ?
+			AND (v.expires_at IS NULL OR v.expires_at > ?)
+			AND (v.max_uses = 0 OR (SELECT COUNT(*) FROM voucher_redemptions r WHERE r.code = v.code) < v.max_uses)
+	`, r.FileID, r.DiscountSats, r.CreatedAt, r.Code, r.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return nil
+	}
+
+	var redeemed, exists int
+	err = s.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM voucher_redemptions WHERE code = ? AND file_id = ?),
+			(SELECT COUNT(*) FROM vouchers WHERE code = ?)
+	`, r.Code, r.FileID, r.Code).Scan(&redeemed, &exists)
+	if err != nil {
+		return err
+	}
+	switch {
+	case redeemed > 0:
+		return ErrDuplicate
+	case exists == 0:
+		return ErrNotFound
+	default:
+		return ErrExhausted
+	}
+}
+
+func (s *SQLiteStore) ReleaseVoucherRedemption(ctx context.Context, code, fileID string) error {
+	_, err := s.db.ExecContext(ctx, `
+		DELETE FROM voucher_redemptions WHERE code = ? AND file_id = ?
+	`, code, fileID)
+	return err
+}
+
+func (s *SQLiteStore) RecordPayment(ctx context.Context, p *Payment) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT OR IGNORE INTO payments (payment_hash, file_id, kind, amount_sats, created_at, settled_at, backend, account_id, currency, btc_price, refund_due)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, p.PaymentHash, p.FileID, string(p.Kind), p.AmountSats, p.CreatedAt, p.SettledAt, p.Backend, p.AccountID, p.Currency, p.BTCPrice, p.RefundDue)
+	return err
+}
+
+func (s *SQLiteStore) ListPayments(ctx context.Context) ([]*Payment, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT payment_hash, file_id, kind, amount_sats, created_at, settled_at, backend, account_id, currency, btc_price, refund_due
+		FROM payments
+		ORDER BY settled_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []*Payment
+	for rows.Next() {
+		var p Payment
+		var kind string
+		if err := rows.Scan(&p.PaymentHash, &p.FileID, &kind, &p.AmountSats, &p.CreatedAt, &p.SettledAt, &p.Backend, &p.AccountID, &p.Currency, &p.BTCPrice, &p.RefundDue); err != nil {
+			return nil, err
+		}
+		p.Kind = InvoiceKind(kind)
+		payments = append(payments, &p)
+	}
+	return payments, rows.Err()
+}
+
+func (s *SQLiteStore) SavePartialUpload(ctx context.Context, u *PartialUpload) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT OR REPLACE INTO partial_uploads (id, length, upload_offset, chunks, metadata, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, u.ID, u.Length, u.Offset, u.Chunks, u.Metadata, u.CreatedAt, u.ExpiresAt)
+	return err
+}
+
+func (s *SQLiteStore) GetPartialUpload(ctx context.Context, id string) (*PartialUpload, error) {
+	var u PartialUpload
+	err := s.db.QueryRowContext(ctx, `
+		SELECT id, length, upload_offset, chunks, metadata, created_at, expires_at
+		FROM partial_uploads WHERE id = ?
+	`, id).Scan(&u.ID, &u.Length, &u.Offset, &u.Chunks, &u.Metadata, &u.CreatedAt, &u.ExpiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+func (s *SQLiteStore) DeletePartialUpload(ctx context.Context, id string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM partial_uploads WHERE id = ?`, id)
+	return err
+}
+
+func (s *SQLiteStore) ListExpiredPartialUploads(ctx context.Context) ([]*PartialUpload, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, length, upload_offset, chunks, metadata, created_at, expires_at
+		FROM partial_uploads WHERE expires_at < ?
+	`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []*PartialUpload
+	for rows.Next() {
+		var u PartialUpload
+		if err := rows.Scan(&u.ID, &u.Length, &u.Offset, &u.Chunks, &u.Metadata, &u.CreatedAt, &u.ExpiresAt); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, &u)
+	}
+	return uploads, rows.Err()
+}
+
+func (s *SQLiteStore) SaveCashuProofs(ctx context.Context, proofs []*CashuProof) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, proof := range proofs {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO cashu_proofs (secret, mint, keyset_id, amount_sats, c, created_at)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, proof.Secret, proof.Mint, proof.KeysetID, proof.AmountSats, proof.C, proof.CreatedAt); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) ListCashuProofs(ctx context.Context) ([]*CashuProof, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT secret, mint, keyset_id, amount_sats, c, created_at
+		FROM cashu_proofs ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var proofs []*CashuProof
+	for rows.Next() {
+		var proof CashuProof
+		if err := rows.Scan(&proof.Secret, &proof.Mint, &proof.KeysetID, &proof.AmountSats, &proof.C, &proof.CreatedAt); err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, &proof)
+	}
+	return proofs, rows.Err()
+}
+
 func (s *SQLiteStore) Close() error {
 	return s.db.Close()
 }
@@ -17,12 +17,14 @@ func TestSQLiteStore(t *testing.T) {
 
 	t.Run("SaveAndGet", func(t *testing.T) {
 		meta := &FileMeta{
-			ID:           "test-file-1",
-			Size:         1024,
-			ExpiresAt:    time.Now().Add(1 * time.Hour),
-			HostDuration: 24 * time.Hour,
-			Paid:         false,
-			CreatedAt:    time.Now(),
+			ID:                "test-file-1",
+			Size:              1024,
+			ExpiresAt:         time.Now().Add(1 * time.Hour),
+			HostDuration:      24 * time.Hour,
+			Paid:              false,
+			CreatedAt:         time.Now(),
+			DownloadPriceSats: 500,
+			OwnerTokenHash:    "ownerhash",
 		}
 
 		if err := store.SaveFileMetadata(ctx, meta); err != nil {
@@ -40,6 +42,12 @@ func TestSQLiteStore(t *testing.T) {
 		if got.HostDuration != meta.HostDuration {
 			t.Errorf("got HostDuration %v, want %v", got.HostDuration, meta.HostDuration)
 		}
+		if got.DownloadPriceSats != meta.DownloadPriceSats {
+			t.Errorf("got DownloadPriceSats %d, want %d", got.DownloadPriceSats, meta.DownloadPriceSats)
+		}
+		if got.OwnerTokenHash != meta.OwnerTokenHash {
+			t.Errorf("got OwnerTokenHash %q, want %q", got.OwnerTokenHash, meta.OwnerTokenHash)
+		}
 	})
 
 	t.Run("GetNotFound", func(t *testing.T) {
@@ -80,6 +88,40 @@ func TestSQLiteStore(t *testing.T) {
 		}
 	})
 
+	t.Run("ExtendExpiry", func(t *testing.T) {
+		currentExpiry := time.Now().Add(48 * time.Hour)
+		store.SaveFileMetadata(ctx, &FileMeta{ID: "extend-future", Size: 1, ExpiresAt: currentExpiry, Paid: true, CreatedAt: time.Now()})
+		store.SaveFileMetadata(ctx, &FileMeta{ID: "extend-lapsed", Size: 1, ExpiresAt: time.Now().Add(-time.Hour), Paid: true, CreatedAt: time.Now()})
+		store.SaveFileMetadata(ctx, &FileMeta{ID: "extend-unpaid", Size: 1, ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now()})
+
+		// Extends from the current expiry, not from now
+		if err := store.ExtendExpiry(ctx, "extend-future", 24*time.Hour); err != nil {
+			t.Fatalf("ExtendExpiry failed: %v", err)
+		}
+		got, _ := store.GetFileMetadata(ctx, "extend-future")
+		want := currentExpiry.Add(24 * time.Hour)
+		if got.ExpiresAt.Sub(want).Abs() > time.Minute {
+			t.Errorf("expected ExpiresAt around %v, got %v", want, got.ExpiresAt)
+		}
+
+		// A lapsed expiry is extended from now
+		if err := store.ExtendExpiry(ctx, "extend-lapsed", 24*time.Hour); err != nil {
+			t.Fatalf("ExtendExpiry failed: %v", err)
+		}
+		got, _ = store.GetFileMetadata(ctx, "extend-lapsed")
+		want = time.Now().Add(24 * time.Hour)
+		if got.ExpiresAt.Sub(want).Abs() > time.Minute {
+			t.Errorf("expected ExpiresAt around %v, got %v", want, got.ExpiresAt)
+		}
+
+		if err := store.ExtendExpiry(ctx, "extend-unpaid", 24*time.Hour); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound for unpaid file, got %v", err)
+		}
+		if err := store.ExtendExpiry(ctx, "nonexistent", 24*time.Hour); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
 	t.Run("Delete", func(t *testing.T) {
 		meta := &FileMeta{
 			ID:           "test-file-3",
@@ -141,6 +183,18 @@ func TestSQLiteStore(t *testing.T) {
 		if !found {
 			t.Error("expired-file should be in expired list")
 		}
+
+		// Kept while an extension of it may still be paid
+		store.SavePendingInvoice(ctx, &PendingInvoice{PaymentHash: "ext-hash", FileID: "expired-file", PaymentRequest: "lnbc1", AmountSats: 100, CreatedAt: time.Now(), Kind: InvoiceKindExtension})
+		files, err = store.ListExpiredFiles(ctx)
+		if err != nil {
+			t.Fatalf("failed to list expired: %v", err)
+		}
+		for _, f := range files {
+			if f.ID == "expired-file" {
+				t.Error("expired-file with a pending extension should not be in expired list")
+			}
+		}
 	})
 }
 
@@ -160,6 +214,7 @@ func TestSQLiteStore_PendingInvoices(t *testing.T) {
 			PaymentRequest: "lnbc1000...",
 			AmountSats:     1000,
 			CreatedAt:      time.Now(),
+			ExpiresAt:      time.Now().Add(time.Hour).Truncate(time.Second),
 		}
 
 		if err := store.SavePendingInvoice(ctx, inv); err != nil {
@@ -188,6 +243,98 @@ func TestSQLiteStore_PendingInvoices(t *testing.T) {
 		if got.AmountSats != inv.AmountSats {
 			t.Errorf("AmountSats = %d, want %d", got.AmountSats, inv.AmountSats)
 		}
+		if !got.ExpiresAt.Equal(inv.ExpiresAt) {
+			t.Errorf("ExpiresAt = %v, want %v", got.ExpiresAt, inv.ExpiresAt)
+		}
+		if got.Kind != InvoiceKindUpload {
+			t.Errorf("Kind = %q, want %q", got.Kind, InvoiceKindUpload)
+		}
+	})
+
+	t.Run("Extension", func(t *testing.T) {
+		inv := &PendingInvoice{
+			PaymentHash:    "extensionhash",
+			FileID:         "file-extend",
+			PaymentRequest: "lnbc500...",
+			AmountSats:     500,
+			CreatedAt:      time.Now(),
+			Kind:           InvoiceKindExtension,
+			Duration:       30 * 24 * time.Hour,
+		}
+		if err := store.SavePendingInvoice(ctx, inv); err != nil {
+			t.Fatalf("failed to save invoice: %v", err)
+		}
+		defer store.DeletePendingInvoice(ctx, "extensionhash")
+
+		invoices, _ := store.ListPendingInvoices(ctx)
+		found := false
+		for _, got := range invoices {
+			if got.PaymentHash != "extensionhash" {
+				continue
+			}
+			found = true
+			if got.Kind != InvoiceKindExtension {
+				t.Errorf("Kind = %q, want %q", got.Kind, InvoiceKindExtension)
+			}
+			if got.Duration != inv.Duration {
+				t.Errorf("Duration = %v, want %v", got.Duration, inv.Duration)
+			}
+		}
+		if !found {
+			t.Error("extension invoice not listed")
+		}
+	})
+
+	t.Run("ExchangeRate", func(t *testing.T) {
+		inv := &PendingInvoice{
+			PaymentHash:    "fiathash",
+			FileID:         "file-fiat",
+			PaymentRequest: "lnbc2000...",
+			AmountSats:     2000,
+			CreatedAt:      time.Now(),
+			Currency:       "USD",
+			BTCPrice:       64250.5,
+		}
+		if err := store.SavePendingInvoice(ctx, inv); err != nil {
+			t.Fatalf("failed to save invoice: %v", err)
+		}
+		defer store.DeletePendingInvoice(ctx, "fiathash")
+
+		invoices, _ := store.ListPendingInvoices(ctx)
+		found := false
+		for _, got := range invoices {
+			if got.PaymentHash != "fiathash" {
+				continue
+			}
+			found = true
+			if got.Currency != "USD" || got.BTCPrice != 64250.5 {
+				t.Errorf("rate = %s %v, want USD 64250.5", got.Currency, got.BTCPrice)
+			}
+		}
+		if !found {
+			t.Error("fiat-priced invoice not listed")
+		}
+	})
+
+	t.Run("UnknownExpiry", func(t *testing.T) {
+		inv := &PendingInvoice{
+			PaymentHash:    "noexpiryhash",
+			FileID:         "file-noexpiry",
+			PaymentRequest: "lnbc1000...",
+			AmountSats:     1000,
+			CreatedAt:      time.Now(),
+		}
+		if err := store.SavePendingInvoice(ctx, inv); err != nil {
+			t.Fatalf("failed to save invoice: %v", err)
+		}
+		defer store.DeletePendingInvoice(ctx, "noexpiryhash")
+
+		invoices, _ := store.ListPendingInvoices(ctx)
+		for _, got := range invoices {
+			if got.PaymentHash == "noexpiryhash" && !got.ExpiresAt.IsZero() {
+				t.Errorf("expected zero ExpiresAt, got %v", got.ExpiresAt)
+			}
+		}
 	})
 
 	t.Run("Delete", func(t *testing.T) {
@@ -321,3 +468,627 @@ func TestSQLiteStore_GetStats(t *testing.T) {
 		}
 	})
 }
+
+func TestSQLiteStore_Payments(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	yesterday := now.Add(-24 * time.Hour)
+
+	store.SaveFileMetadata(ctx, &FileMeta{ID: "file-a", Size: 1024, ExpiresAt: now.Add(time.Hour), Paid: true, CreatedAt: now})
+
+	payments := []*Payment{
+		{PaymentHash: "hash-1", FileID: "file-a", Kind: InvoiceKindUpload, AmountSats: 1000, CreatedAt: now.Add(-30 * time.Second), SettledAt: now, Backend: "lnd"},
+		{PaymentHash: "hash-2", FileID: "file-b", Kind: InvoiceKindUpload, AmountSats: 3000, CreatedAt: yesterday.Add(-90 * time.Second), SettledAt: yesterday, Backend: "cashu", Currency: "USD", BTCPrice: 50000},
+		{PaymentHash: "hash-3", FileID: "file-a", Kind: InvoiceKindExtension, AmountSats: 500, CreatedAt: now.Add(-time.Hour), SettledAt: now, Backend: "lnd"},
+		{PaymentHash: "hash-4", Kind: InvoiceKindTopUp, AmountSats: 10000, CreatedAt: now, SettledAt: now, Backend: "lnd", AccountID: "acct-1"},
+		{PaymentHash: "hash-5", FileID: "file-gone", Kind: InvoiceKindExtension, AmountSats: 700, CreatedAt: now, SettledAt: now, Backend: "lnd", RefundDue: "extension of a missing file"},
+	}
+	for _, p := range payments {
+		if err := store.RecordPayment(ctx, p); err != nil {
+			t.Fatalf("RecordPayment failed: %v", err)
+		}
+	}
+	// Settling the same invoice twice only counts once
+	if err := store.RecordPayment(ctx, payments[0]); err != nil {
+		t.Fatalf("RecordPayment of a duplicate failed: %v", err)
+	}
+
+	list, err := store.ListPayments(ctx)
+	if err != nil {
+		t.Fatalf("ListPayments failed: %v", err)
+	}
+	if len(list) != 5 {
+		t.Fatalf("expected 5 payments, got %d", len(list))
+	}
+	if last := list[len(list)-1]; last.PaymentHash != "hash-2" || last.Currency != "USD" || last.BTCPrice != 50000 || last.Backend != "cashu" {
+		t.Errorf("unexpected oldest payment %+v", last)
+	}
+
+	stats, err := store.GetStats(ctx)
+	if err != nil {
+		t.Fatalf("GetStats failed: %v", err)
+	}
+	if stats.Payments != 5 {
+		t.Errorf("expected 5 payments, got %d", stats.Payments)
+	}
+	// Refunds due aren't revenue
+	if stats.RevenueSats != 14500 {
+		t.Errorf("expected 14500 sats revenue, got %d", stats.RevenueSats)
+	}
+	if stats.Refunds != 1 || stats.RefundSats != 700 {
+		t.Errorf("expected 1 refund of 700 sats, got %d of %d sats", stats.Refunds, stats.RefundSats)
+	}
+	if stats.AvgPriceSats != 2000 {
+		t.Errorf("expected an average upload price of 2000 sats, got %d", stats.AvgPriceSats)
+	}
+	if stats.AvgSettleTime != time.Minute {
+		t.Errorf("expected an average settlement time of 1m, got %s", stats.AvgSettleTime)
+	}
+
+	if len(stats.DailyStats) != 2 {
+		t.Fatalf("expected 2 days of stats, got %+v", stats.DailyStats)
+	}
+	today, earlier := stats.DailyStats[0], stats.DailyStats[1]
+	if today.Date != now.Format("2006-01-02") || today.PaidFiles != 1 || today.RevenueSats != 11500 {
+		t.Errorf("unexpected stats for today %+v", today)
+	}
+	if earlier.Date != yesterday.Format("2006-01-02") || earlier.PaidFiles != 0 || earlier.RevenueSats != 3000 {
+		t.Errorf("unexpected stats for yesterday %+v", earlier)
+	}
+}
+
+func TestSQLiteStore_Settings(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	if _, err := store.GetSetting(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for unset key, got %v", err)
+	}
+
+	if err := store.SetSetting(ctx, "cln.pay_index", "41"); err != nil {
+		t.Fatalf("SetSetting failed: %v", err)
+	}
+	if err := store.SetSetting(ctx, "cln.pay_index", "42"); err != nil {
+		t.Fatalf("SetSetting overwrite failed: %v", err)
+	}
+
+	got, err := store.GetSetting(ctx, "cln.pay_index")
+	if err != nil {
+		t.Fatalf("GetSetting failed: %v", err)
+	}
+	if got != "42" {
+		t.Errorf("GetSetting = %q, want %q", got, "42")
+	}
+}
+
+func TestSQLiteStore_L402Tokens(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+
+	if _, err := store.GetL402Token(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for unknown token, got %v", err)
+	}
+
+	token := &L402Token{
+		PaymentHash: "tokenhash",
+		FileID:      "paywalled-file",
+		AmountSats:  250,
+		CreatedAt:   time.Now(),
+	}
+	if err := store.SaveL402Token(ctx, token); err != nil {
+		t.Fatalf("SaveL402Token failed: %v", err)
+	}
+
+	got, err := store.GetL402Token(ctx, "tokenhash")
+	if err != nil {
+		t.Fatalf("GetL402Token failed: %v", err)
+	}
+	if got.FileID != token.FileID || got.AmountSats != token.AmountSats || got.OfferID != "" {
+		t.Errorf("GetL402Token = %+v, want %+v", got, token)
+	}
+
+	offerToken := &L402Token{PaymentHash: "offerhash", FileID: "paywalled-file", AmountSats: 250, CreatedAt: time.Now(), OfferID: "offer1"}
+	if err := store.SaveL402Token(ctx, offerToken); err != nil {
+		t.Fatalf("SaveL402Token failed: %v", err)
+	}
+	if got, _ := store.GetL402Token(ctx, "offerhash"); got == nil || got.OfferID != "offer1" {
+		t.Errorf("expected offer ID to round-trip, got %+v", got)
+	}
+}
+
+func TestSQLiteStore_Offers(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	active := &Offer{
+		OfferID:    "offer-active",
+		FileID:     "file1",
+		Kind:       InvoiceKindExtension,
+		Bolt12:     "lno1active",
+		AmountSats: 300,
+		Duration:   24 * time.Hour,
+		ExpiresAt:  time.Now().Add(time.Hour),
+		CreatedAt:  time.Now(),
+	}
+	expired := &Offer{
+		OfferID:    "offer-expired",
+		FileID:     "file1",
+		Kind:       InvoiceKindDownload,
+		Bolt12:     "lno1expired",
+		AmountSats: 50,
+		ExpiresAt:  time.Now().Add(-time.Minute),
+		CreatedAt:  time.Now().Add(-time.Hour),
+	}
+	for _, offer := range []*Offer{active, expired} {
+		if err := store.SaveOffer(ctx, offer); err != nil {
+			t.Fatalf("SaveOffer failed: %v", err)
+		}
+	}
+
+	offers, err := store.ListOffers(ctx)
+	if err != nil {
+		t.Fatalf("ListOffers failed: %v", err)
+	}
+	if len(offers) != 1 {
+		t.Fatalf("expected only the unexpired offer, got %d", len(offers))
+	}
+	got := offers[0]
+	if got.OfferID != active.OfferID || got.Kind != active.Kind || got.Duration != active.Duration || got.Bolt12 != active.Bolt12 {
+		t.Errorf("ListOffers = %+v, want %+v", got, active)
+	}
+}
+
+func TestSQLiteStore_Ledger(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	add := func(kind LedgerKind, amount int64, ref string) error {
+		return store.AddLedgerEntry(ctx, &LedgerEntry{
+			FileID:     "earning-file",
+			Kind:       kind,
+			AmountSats: amount,
+			Reference:  ref,
+			CreatedAt:  time.Now(),
+		})
+	}
+
+	if err := add(LedgerDownload, 250, "hash1"); err != nil {
+		t.Fatalf("AddLedgerEntry failed: %v", err)
+	}
+	if err := add(LedgerDownload, 250, "hash2"); err != nil {
+		t.Fatalf("AddLedgerEntry failed: %v", err)
+	}
+	if err := add(LedgerDownload, 250, "hash1"); err != ErrDuplicate {
+		t.Errorf("expected ErrDuplicate for a repeated credit, got %v", err)
+	}
+
+	for _, bad := range []struct {
+		kind   LedgerKind
+		amount int64
+	}{{LedgerDownload, -250}, {LedgerPayout, 100}, {LedgerPayoutReversal, -100}, {LedgerDownload, 0}} {
+		if err := add(bad.kind, bad.amount, "bad"); err != ErrInvalidAmount {
+			t.Errorf("expected ErrInvalidAmount for %s of %d, got %v", bad.kind, bad.amount, err)
+		}
+	}
+
+	if err := add(LedgerPayout, -600, "k1-a"); err != ErrInsufficientBalance {
+		t.Errorf("expected ErrInsufficientBalance for an overdraw, got %v", err)
+	}
+	if err := add(LedgerPayout, -400, "k1-b"); err != nil {
+		t.Fatalf("payout failed: %v", err)
+	}
+	if err := add(LedgerPayoutReversal, 400, "k1-b"); err != nil {
+		t.Fatalf("reversal failed: %v", err)
+	}
+
+	balance, err := store.LedgerBalance(ctx, "earning-file")
+	if err != nil {
+		t.Fatalf("LedgerBalance failed: %v", err)
+	}
+	if balance != 500 {
+		t.Errorf("balance = %d, want 500", balance)
+	}
+	if other, _ := store.LedgerBalance(ctx, "other-file"); other != 0 {
+		t.Errorf("expected zero balance for a file without entries, got %d", other)
+	}
+
+	entries, err := store.ListLedgerEntries(ctx, "earning-file")
+	if err != nil {
+		t.Fatalf("ListLedgerEntries failed: %v", err)
+	}
+	wantKinds := []LedgerKind{LedgerDownload, LedgerDownload, LedgerPayout, LedgerPayoutReversal}
+	if len(entries) != len(wantKinds) {
+		t.Fatalf("expected %d entries, got %d", len(wantKinds), len(entries))
+	}
+	for i, kind := range wantKinds {
+		if entries[i].Kind != kind {
+			t.Errorf("entry %d kind = %s, want %s", i, entries[i].Kind, kind)
+		}
+	}
+	if entries[2].AmountSats != -400 || entries[2].Reference != "k1-b" {
+		t.Errorf("unexpected payout entry %+v", entries[2])
+	}
+}
+
+func TestSQLiteStore_PendingPayouts(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	for i, ref := range []string{"k1-a", "k1-b"} {
+		err := store.SavePendingPayout(ctx, &PendingPayout{
+			Reference:      ref,
+			FileID:         "earning-file",
+			PaymentHash:    "hash-" + ref,
+			PaymentRequest: "lnbc1" + ref,
+			AmountSats:     100,
+			CreatedAt:      time.Now().Add(time.Duration(i) * time.Second),
+		})
+		if err != nil {
+			t.Fatalf("SavePendingPayout failed: %v", err)
+		}
+	}
+
+	if err := store.DeletePendingPayout(ctx, "k1-a"); err != nil {
+		t.Fatalf("DeletePendingPayout failed: %v", err)
+	}
+	payouts, err := store.ListPendingPayouts(ctx)
+	if err != nil {
+		t.Fatalf("ListPendingPayouts failed: %v", err)
+	}
+	if len(payouts) != 1 || payouts[0].Reference != "k1-b" || payouts[0].PaymentHash != "hash-k1-b" || payouts[0].AmountSats != 100 {
+		t.Errorf("unexpected pending payouts %+v", payouts)
+	}
+}
+
+func TestSQLiteStore_Accounts(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	account := &Account{ID: "acct-1", APIKeyHash: "keyhash-1", CreatedAt: time.Now()}
+	if err := store.CreateAccount(ctx, account); err != nil {
+		t.Fatalf("CreateAccount failed: %v", err)
+	}
+	if err := store.CreateAccount(ctx, &Account{ID: "acct-2", APIKeyHash: "keyhash-1", CreatedAt: time.Now()}); err == nil {
+		t.Error("expected an error for a reused API key hash")
+	}
+
+	got, err := store.GetAccountByKeyHash(ctx, "keyhash-1")
+	if err != nil {
+		t.Fatalf("GetAccountByKeyHash failed: %v", err)
+	}
+	if got.ID != "acct-1" {
+		t.Errorf("account ID = %s, want acct-1", got.ID)
+	}
+	if _, err := store.GetAccountByKeyHash(ctx, "unknown"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for an unknown key hash, got %v", err)
+	}
+
+	add := func(kind AccountEntryKind, amount int64, ref string) error {
+		return store.AddAccountEntry(ctx, &AccountEntry{
+			AccountID:  "acct-1",
+			Kind:       kind,
+			AmountSats: amount,
+			Reference:  ref,
+			CreatedAt:  time.Now(),
+		})
+	}
+
+	if err := add(AccountTopUp, 1000, "hash1"); err != nil {
+		t.Fatalf("AddAccountEntry failed: %v", err)
+	}
+	if err := add(AccountTopUp, 1000, "hash1"); err != ErrDuplicate {
+		t.Errorf("expected ErrDuplicate for a repeated top-up, got %v", err)
+	}
+	if err := add(AccountUpload, -1500, "file-a"); err != ErrInsufficientBalance {
+		t.Errorf("expected ErrInsufficientBalance for an overdraw, got %v", err)
+	}
+	if err := add(AccountUpload, -600, "file-a"); err != nil {
+		t.Fatalf("upload charge failed: %v", err)
+	}
+	if err := add(AccountUpload, -100, "file-a"); err != ErrDuplicate {
+		t.Errorf("expected ErrDuplicate for charging a file twice, got %v", err)
+	}
+	if err := add(AccountUploadReversal, 600, "file-a"); err != nil {
+		t.Fatalf("reversal failed: %v", err)
+	}
+
+	balance, err := store.AccountBalance(ctx, "acct-1")
+	if err != nil {
+		t.Fatalf("AccountBalance failed: %v", err)
+	}
+	if balance != 1000 {
+		t.Errorf("balance = %d, want 1000", balance)
+	}
+
+	entries, err := store.ListAccountEntries(ctx, "acct-1")
+	if err != nil {
+		t.Fatalf("ListAccountEntries failed: %v", err)
+	}
+	wantKinds := []AccountEntryKind{AccountTopUp, AccountUpload, AccountUploadReversal}
+	if len(entries) != len(wantKinds) {
+		t.Fatalf("expected %d entries, got %d", len(wantKinds), len(entries))
+	}
+	for i, kind := range wantKinds {
+		if entries[i].Kind != kind {
+			t.Errorf("entry %d kind = %s, want %s", i, entries[i].Kind, kind)
+		}
+	}
+	if entries[1].AmountSats != -600 || entries[1].Reference != "file-a" {
+		t.Errorf("unexpected upload entry %+v", entries[1])
+	}
+
+	// Top-up invoices remember the account they credit
+	if err := store.SavePendingInvoice(ctx, &PendingInvoice{
+		PaymentHash:    "topup-hash",
+		PaymentRequest: "lnbc...",
+		AmountSats:     5000,
+		CreatedAt:      time.Now(),
+		Kind:           InvoiceKindTopUp,
+		AccountID:      "acct-1",
+	}); err != nil {
+		t.Fatalf("SavePendingInvoice failed: %v", err)
+	}
+	invoices, err := store.ListPendingInvoices(ctx)
+	if err != nil {
+		t.Fatalf("ListPendingInvoices failed: %v", err)
+	}
+	if len(invoices) != 1 || invoices[0].Kind != InvoiceKindTopUp || invoices[0].AccountID != "acct-1" {
+		t.Errorf("unexpected pending invoices %+v", invoices)
+	}
+}
+
+func TestSQLiteStore_Vouchers(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	for _, v := range []*Voucher{
+		{Code: "FREE", PercentOff: 100, MaxUses: 2, CreatedAt: now},
+		{Code: "FIVEHUNDRED", AmountOffSats: 500, CreatedAt: now.Add(time.Second)},
+		{Code: "OLD", PercentOff: 50, ExpiresAt: now.Add(-time.Hour), CreatedAt: now.Add(2 * time.Second)},
+	} {
+		if err := store.CreateVoucher(ctx, v); err != nil {
+			t.Fatalf("CreateVoucher(%s) failed: %v", v.Code, err)
+		}
+	}
+	if err := store.CreateVoucher(ctx, &Voucher{Code: "FREE", PercentOff: 10, CreatedAt: now}); err != ErrDuplicate {
+		t.Errorf("expected ErrDuplicate for a reused code, got %v", err)
+	}
+
+	redeem := func(code, fileID string) error {
+		return store.RedeemVoucher(ctx, &VoucherRedemption{Code: code, FileID: fileID, DiscountSats: 100, CreatedAt: time.Now()})
+	}
+	if err := redeem("FREE", "file-a"); err != nil {
+		t.Fatalf("RedeemVoucher failed: %v", err)
+	}
+	if err := redeem("FREE", "file-a"); err != ErrDuplicate {
+		t.Errorf("expected ErrDuplicate for redeeming twice on a file, got %v", err)
+	}
+	if err := redeem("FREE", "file-b"); err != nil {
+		t.Fatalf("RedeemVoucher failed: %v", err)
+	}
+	if err := redeem("FREE", "file-c"); err != ErrExhausted {
+		t.Errorf("expected ErrExhausted past max uses, got %v", err)
+	}
+	if err := redeem("OLD", "file-a"); err != ErrExhausted {
+		t.Errorf("expected ErrExhausted for an expired voucher, got %v", err)
+	}
+	if err := redeem("MISSING", "file-a"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for an unknown code, got %v", err)
+	}
+	// Releasing a redemption gives the use back
+	if err := store.ReleaseVoucherRedemption(ctx, "FREE", "file-b"); err != nil {
+		t.Fatalf("ReleaseVoucherRedemption failed: %v", err)
+	}
+	if err := store.ReleaseVoucherRedemption(ctx, "FREE", "file-b"); err != nil {
+		t.Errorf("expected releasing twice to be a no-op, got %v", err)
+	}
+	if err := redeem("FREE", "file-c"); err != nil {
+		t.Fatalf("expected the released use to be redeemable, got %v", err)
+	}
+	if err := redeem("FREE", "file-d"); err != ErrExhausted {
+		t.Errorf("expected ErrExhausted past max uses, got %v", err)
+	}
+	for _, fileID := range []string{"file-x", "file-y", "file-z"} {
+		if err := redeem("FIVEHUNDRED", fileID); err != nil {
+			t.Fatalf("unlimited voucher redemption for %s failed: %v", fileID, err)
+		}
+	}
+
+	got, err := store.GetVoucher(ctx, "FREE")
+	if err != nil {
+		t.Fatalf("GetVoucher failed: %v", err)
+	}
+	if got.PercentOff != 100 || got.MaxUses != 2 || got.Uses != 2 || !got.ExpiresAt.IsZero() {
+		t.Errorf("unexpected voucher %+v", got)
+	}
+	if _, err := store.GetVoucher(ctx, "MISSING"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	list, err := store.ListVouchers(ctx)
+	if err != nil {
+		t.Fatalf("ListVouchers failed: %v", err)
+	}
+	if len(list) != 3 || list[0].Code != "FREE" || list[1].Code != "FIVEHUNDRED" || list[2].Code != "OLD" {
+		t.Fatalf("unexpected vouchers %+v", list)
+	}
+	if list[1].Uses != 3 || list[1].AmountOffSats != 500 {
+		t.Errorf("unexpected voucher %+v", list[1])
+	}
+	if list[2].ExpiresAt.IsZero() {
+		t.Error("expected expiry to round-trip")
+	}
+}
+
+func TestSQLiteStore_PartialUploads(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	active := &PartialUpload{ID: "active", Length: 1000, Metadata: "filename Zm9v", CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	stale := &PartialUpload{ID: "stale", Length: 500, Offset: 100, Chunks: 1, CreatedAt: now.Add(-2 * time.Hour), ExpiresAt: now.Add(-time.Hour)}
+	for _, u := range []*PartialUpload{active, stale} {
+		if err := store.SavePartialUpload(ctx, u); err != nil {
+			t.Fatalf("SavePartialUpload(%s) failed: %v", u.ID, err)
+		}
+	}
+
+	active.Offset, active.Chunks = 400, 2
+	if err := store.SavePartialUpload(ctx, active); err != nil {
+		t.Fatalf("SavePartialUpload update failed: %v", err)
+	}
+	got, err := store.GetPartialUpload(ctx, "active")
+	if err != nil {
+		t.Fatalf("GetPartialUpload failed: %v", err)
+	}
+	if got.Length != 1000 || got.Offset != 400 || got.Chunks != 2 || got.Metadata != "filename Zm9v" {
+		t.Errorf("unexpected partial upload %+v", got)
+	}
+	if _, err := store.GetPartialUpload(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+
+	expired, err := store.ListExpiredPartialUploads(ctx)
+	if err != nil {
+		t.Fatalf("ListExpiredPartialUploads failed: %v", err)
+	}
+	if len(expired) != 1 || expired[0].ID != "stale" || expired[0].Offset != 100 {
+		t.Fatalf("expected only the stale upload to be expired, got %+v", expired)
+	}
+
+	if err := store.DeletePartialUpload(ctx, "stale"); err != nil {
+		t.Fatalf("DeletePartialUpload failed: %v", err)
+	}
+	if _, err := store.GetPartialUpload(ctx, "stale"); err != ErrNotFound {
+		t.Errorf("expected deleted upload to be gone, got %v", err)
+	}
+}
+
+func TestSQLiteStore_WebhookInbox(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+
+	first := &WebhookMessage{ID: "msg_1", Payload: []byte(`{"a":1}`), ReceivedAt: now.Add(-time.Minute)}
+	second := &WebhookMessage{ID: "msg_2", Payload: []byte(`{"b":2}`), ReceivedAt: now}
+	for _, msg := range []*WebhookMessage{second, first} {
+		if err := store.SaveWebhook(ctx, msg); err != nil {
+			t.Fatalf("SaveWebhook(%s) failed: %v", msg.ID, err)
+		}
+	}
+
+	if err := store.SaveWebhook(ctx, &WebhookMessage{ID: "msg_1", Payload: []byte(`{}`), ReceivedAt: now}); err != ErrDuplicate {
+		t.Errorf("expected ErrDuplicate for repeated ID, got %v", err)
+	}
+
+	msgs, err := store.ListUnprocessedWebhooks(ctx)
+	if err != nil {
+		t.Fatalf("ListUnprocessedWebhooks failed: %v", err)
+	}
+	if len(msgs) != 2 || msgs[0].ID != "msg_1" || msgs[1].ID != "msg_2" {
+		t.Fatalf("expected msg_1, msg_2 oldest first, got %+v", msgs)
+	}
+	if string(msgs[0].Payload) != `{"a":1}` {
+		t.Errorf("payload = %s, want original payload", msgs[0].Payload)
+	}
+
+	if err := store.MarkWebhookProcessed(ctx, "msg_1"); err != nil {
+		t.Fatalf("MarkWebhookProcessed failed: %v", err)
+	}
+	if err := store.MarkWebhookProcessed(ctx, "missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound for unknown ID, got %v", err)
+	}
+
+	msgs, _ = store.ListUnprocessedWebhooks(ctx)
+	if len(msgs) != 1 || msgs[0].ID != "msg_2" {
+		t.Errorf("expected only msg_2 unprocessed, got %+v", msgs)
+	}
+
+	// Processed messages still count as duplicates
+	if err := store.SaveWebhook(ctx, first); err != ErrDuplicate {
+		t.Errorf("expected ErrDuplicate for processed ID, got %v", err)
+	}
+}
+
+func TestSQLiteStore_CashuProofs(t *testing.T) {
+	store, err := NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	proofs := []*CashuProof{
+		{Secret: "secret1", Mint: "https://mint.example", KeysetID: "009a1f293253e41e", AmountSats: 256, C: "02aa", CreatedAt: time.Now()},
+		{Secret: "secret2", Mint: "https://mint.example", KeysetID: "009a1f293253e41e", AmountSats: 4, C: "02bb", CreatedAt: time.Now()},
+	}
+	if err := store.SaveCashuProofs(ctx, proofs); err != nil {
+		t.Fatalf("SaveCashuProofs failed: %v", err)
+	}
+
+	// Saving is all or nothing
+	if err := store.SaveCashuProofs(ctx, []*CashuProof{
+		{Secret: "secret3", Mint: "https://mint.example", KeysetID: "009a1f293253e41e", AmountSats: 8, C: "02cc", CreatedAt: time.Now()},
+		proofs[0],
+	}); err == nil {
+		t.Error("expected saving a duplicate proof to fail")
+	}
+
+	got, err := store.ListCashuProofs(ctx)
+	if err != nil {
+		t.Fatalf("ListCashuProofs failed: %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 proofs, got %d", len(got))
+	}
+	if got[0].Secret != "secret1" || got[0].AmountSats != 256 || got[0].C != "02aa" || got[0].KeysetID != proofs[0].KeysetID {
+		t.Errorf("unexpected proof %+v", got[0])
+	}
+}
@@ -13,13 +13,21 @@ type FileMeta struct {
 	HostDuration time.Duration // Intended hosting duration after payment
 	Paid         bool
 	CreatedAt    time.Time
+
+	// DownloadPriceSats is what recipients must pay (via L402) per download
+	// token. Zero means downloads are free.
+	DownloadPriceSats int64
+	// OwnerTokenHash is the hex SHA-256 of the secret token returned to the
+	// uploader, which authenticates them to manage the file.
+	OwnerTokenHash string
 }
 
 // DailyStat contains statistics for a single day.
 type DailyStat struct {
-	Date      string
-	PaidFiles int
-	PaidBytes int64
+	Date        string
+	PaidFiles   int
+	PaidBytes   int64
+	RevenueSats int64 // Sats received from payments settled that day
 }
 
 // Stats contains aggregate statistics about stored files.
@@ -34,8 +42,30 @@ type Stats struct {
 	OldestFile   time.Time
 	NewestFile   time.Time
 	DailyStats   []DailyStat
+
+	// Revenue from the payment history
+	Payments      int           // Settled payments of any kind
+	RevenueSats   int64         // Sum of all settled payments
+	AvgPriceSats  int64         // Mean amount paid for an upload
+	AvgSettleTime time.Duration // Mean time from upload invoice to settlement
+	Refunds       int           // Payments owed back to their payers
+	RefundSats    int64         // Sum of the payments owed back, not counted as revenue
 }
 
+// InvoiceKind identifies what settling an invoice pays for.
+type InvoiceKind string
+
+const (
+	// InvoiceKindUpload pays for the initial hosting period of a new upload.
+	InvoiceKindUpload InvoiceKind = "upload"
+	// InvoiceKindExtension extends the hosting period of an already-paid file.
+	InvoiceKindExtension InvoiceKind = "extension"
+	// InvoiceKindDownload buys an L402 download token for a paywalled file.
+	InvoiceKindDownload InvoiceKind = "download"
+	// InvoiceKindTopUp credits a prepaid account's balance.
+	InvoiceKindTopUp InvoiceKind = "topup"
+)
+
 // PendingInvoice represents an invoice awaiting payment.
 type PendingInvoice struct {
 	PaymentHash    string
@@ -43,6 +73,43 @@ type PendingInvoice struct {
 	PaymentRequest string
 	AmountSats     int64
 	CreatedAt      time.Time
+	ExpiresAt      time.Time     // Zero if the backend did not report an expiry
+	Kind           InvoiceKind   // Empty is treated as InvoiceKindUpload
+	Duration       time.Duration // Hosting time added on settlement (extensions only)
+	AccountID      string        // Account credited on settlement (top-ups only)
+	Currency       string        // Fiat currency the amount was priced in; empty if priced in sats
+	BTCPrice       float64       // Price of 1 BTC in Currency when the invoice was created
+}
+
+// Payment is the permanent record of a settled invoice or offer payment.
+type Payment struct {
+	PaymentHash string
+	FileID      string // Empty for account top-ups
+	Kind        InvoiceKind
+	AmountSats  int64
+	CreatedAt   time.Time // When the invoice was issued
+	SettledAt   time.Time
+	Backend     string  // Wallet that received the payment, e.g. "lnd" or "cashu"
+	AccountID   string  // Account credited (top-ups only)
+	Currency    string  // Fiat currency the amount was priced in; empty if priced in sats
+	BTCPrice    float64 // Price of 1 BTC in Currency when the invoice was created
+
+	// RefundDue says why the payment bought nothing and is owed back to the
+	// payer (e.g. the file it extends is gone). Empty if it was applied.
+	RefundDue string
+}
+
+// Offer is a reusable BOLT12 offer issued for a file. Every payment of it
+// has the effect of a settled invoice of the same kind.
+type Offer struct {
+	OfferID    string
+	FileID     string
+	Kind       InvoiceKind // InvoiceKindExtension or InvoiceKindDownload
+	Bolt12     string
+	AmountSats int64
+	Duration   time.Duration // Hosting time added per payment (extensions only)
+	ExpiresAt  time.Time
+	CreatedAt  time.Time
 }
 
 // Store defines the interface for metadata persistence.
@@ -50,7 +117,13 @@ type Store interface {
 	SaveFileMetadata(ctx context.Context, meta *FileMeta) error
 	GetFileMetadata(ctx context.Context, id string) (*FileMeta, error)
 	UpdatePaymentStatus(ctx context.Context, fileID string, paid bool) error
+	// ExtendExpiry pushes a paid file's expiry back by d, counting from its
+	// current expiry (or from now if that has already passed).
+	ExtendExpiry(ctx context.Context, fileID string, d time.Duration) error
 	DeleteFileMetadata(ctx context.Context, id string) error
+	// ListExpiredFiles returns files past their expiry, except those with a
+	// pending extension invoice, which are kept until it is settled or dropped
+	// so a late payment still finds its file.
 	ListExpiredFiles(ctx context.Context) ([]*FileMeta, error)
 	GetStats(ctx context.Context) (*Stats, error)
 
@@ -59,5 +132,248 @@ type Store interface {
 	DeletePendingInvoice(ctx context.Context, paymentHash string) error
 	ListPendingInvoices(ctx context.Context) ([]*PendingInvoice, error)
 
+	// BOLT12 offer persistence, so payments are recognized after a restart
+	SaveOffer(ctx context.Context, offer *Offer) error
+	// ListOffers returns the offers that haven't expired yet.
+	ListOffers(ctx context.Context) ([]*Offer, error)
+
 	Close() error
 }
+
+// SettingsStore persists small pieces of key/value state, such as the
+// position of a Lightning backend's invoice cursor, across restarts.
+type SettingsStore interface {
+	// GetSetting returns the stored value, or ErrNotFound if the key is unset.
+	GetSetting(ctx context.Context, key string) (string, error)
+	SetSetting(ctx context.Context, key, value string) error
+}
+
+// WebhookMessage is a verified webhook persisted before it is acknowledged.
+type WebhookMessage struct {
+	ID         string // Sender's message ID (e.g. the svix-id header)
+	Payload    []byte
+	ReceivedAt time.Time
+	Processed  bool
+}
+
+// WebhookInbox durably records incoming webhooks so none are lost between
+// acknowledging the sender and acting on the payload.
+type WebhookInbox interface {
+	// SaveWebhook stores a new message, or returns ErrDuplicate if a message
+	// with the same ID was already received.
+	SaveWebhook(ctx context.Context, msg *WebhookMessage) error
+	// ListUnprocessedWebhooks returns messages not yet marked processed,
+	// oldest first.
+	ListUnprocessedWebhooks(ctx context.Context) ([]*WebhookMessage, error)
+	MarkWebhookProcessed(ctx context.Context, id string) error
+}
+
+// L402Token records a download token issued for a paywalled file. The
+// token's macaroon commits to PaymentHash; presenting the matching preimage
+// proves the invoice was paid.
+type L402Token struct {
+	PaymentHash string
+	FileID      string
+	AmountSats  int64
+	CreatedAt   time.Time
+	OfferID     string // Set for tokens bought by paying a BOLT12 offer
+}
+
+// L402TokenStore persists issued L402 download tokens.
+type L402TokenStore interface {
+	SaveL402Token(ctx context.Context, token *L402Token) error
+	// GetL402Token returns the token issued for paymentHash, or ErrNotFound.
+	GetL402Token(ctx context.Context, paymentHash string) (*L402Token, error)
+}
+
+// LedgerKind identifies what a ledger entry records.
+type LedgerKind string
+
+const (
+	// LedgerDownload credits a file's owner for a paid download.
+	LedgerDownload LedgerKind = "download"
+	// LedgerPayout debits a withdrawal to the owner's wallet.
+	LedgerPayout LedgerKind = "payout"
+	// LedgerPayoutReversal credits back a payout whose payment failed.
+	LedgerPayoutReversal LedgerKind = "payout_reversal"
+)
+
+// LedgerEntry is a single credit (positive) or debit (negative) against a
+// file's earnings.
+type LedgerEntry struct {
+	ID         int64
+	FileID     string
+	Kind       LedgerKind
+	AmountSats int64
+	Reference  string // e.g. the payment hash; unique per kind
+	CreatedAt  time.Time
+}
+
+// PendingPayout is a payout that was debited but whose payment hasn't been
+// confirmed or found to have failed.
+type PendingPayout struct {
+	Reference      string // The payout's ledger reference
+	FileID         string
+	PaymentHash    string
+	PaymentRequest string
+	AmountSats     int64
+	CreatedAt      time.Time
+}
+
+// Ledger records uploader earnings and payouts. A file's balance is the sum
+// of its entries.
+type Ledger interface {
+	// AddLedgerEntry appends an entry. It returns ErrDuplicate if an entry
+	// with the same kind and reference exists, ErrInsufficientBalance if a
+	// debit would take the balance below zero, and ErrInvalidAmount if the
+	// amount's sign doesn't match the kind (payouts debit, the rest credit).
+	AddLedgerEntry(ctx context.Context, entry *LedgerEntry) error
+	LedgerBalance(ctx context.Context, fileID string) (int64, error)
+	// ListLedgerEntries returns a file's entries, oldest first.
+	ListLedgerEntries(ctx context.Context, fileID string) ([]*LedgerEntry, error)
+
+	SavePendingPayout(ctx context.Context, payout *PendingPayout) error
+	DeletePendingPayout(ctx context.Context, reference string) error
+	// ListPendingPayouts returns payouts awaiting resolution, oldest first.
+	ListPendingPayouts(ctx context.Context) ([]*PendingPayout, error)
+}
+
+// Account is a prepaid credit account. Uploads completed with the account's
+// API key are paid from its balance instead of with an invoice.
+type Account struct {
+	ID         string
+	APIKeyHash string // hex SHA-256 of the API key
+	CreatedAt  time.Time
+}
+
+// AccountEntryKind identifies what an account ledger entry records.
+type AccountEntryKind string
+
+const (
+	// AccountTopUp credits a paid top-up invoice.
+	AccountTopUp AccountEntryKind = "topup"
+	// AccountUpload debits the hosting fee of an upload.
+	AccountUpload AccountEntryKind = "upload"
+	// AccountUploadReversal credits back an upload debit that didn't go through.
+	AccountUploadReversal AccountEntryKind = "upload_reversal"
+)
+
+// AccountEntry is a single credit (positive) or debit (negative) against an
+// account's balance.
+type AccountEntry struct {
+	ID         int64
+	AccountID  string
+	Kind       AccountEntryKind
+	AmountSats int64
+	Reference  string // Payment hash for top-ups, file ID for uploads; unique per kind
+	CreatedAt  time.Time
+}
+
+// AccountStore persists prepaid accounts and their ledgers. An account's
+// balance is the sum of its entries.
+type AccountStore interface {
+	CreateAccount(ctx context.Context, account *Account) error
+	// GetAccountByKeyHash returns the account with the API key hash, or ErrNotFound.
+	GetAccountByKeyHash(ctx context.Context, keyHash string) (*Account, error)
+	// AddAccountEntry appends an entry. It returns ErrDuplicate if an entry
+	// with the same kind and reference exists, and ErrInsufficientBalance if
+	// a debit would take the balance below zero.
+	AddAccountEntry(ctx context.Context, entry *AccountEntry) error
+	AccountBalance(ctx context.Context, accountID string) (int64, error)
+	// ListAccountEntries returns an account's entries, oldest first.
+	ListAccountEntries(ctx context.Context, accountID string) ([]*AccountEntry, error)
+	// DeleteUnfundedAccounts deletes accounts created before createdBefore
+	// that have no ledger entries, and returns how many it deleted.
+	DeleteUnfundedAccounts(ctx context.Context, createdBefore time.Time) (int64, error)
+}
+
+// Voucher is an operator-issued code that takes a fixed amount or a
+// percentage off an upload's hosting fee.
+type Voucher struct {
+	Code          string
+	AmountOffSats int64     // Fixed discount; zero for percentage vouchers
+	PercentOff    int       // 1-100; 100 makes uploads free
+	MaxUses       int       // Zero means unlimited
+	Uses          int       // Number of redemptions so far (read-only)
+	ExpiresAt     time.Time // Zero means it never expires
+	CreatedAt     time.Time
+}
+
+// VoucherRedemption records a voucher applied to an upload.
+type VoucherRedemption struct {
+	Code         string
+	FileID       string
+	DiscountSats int64
+	CreatedAt    time.Time
+}
+
+// VoucherStore persists vouchers and their redemptions.
+type VoucherStore interface {
+	// CreateVoucher stores a new voucher, or returns ErrDuplicate if the code
+	// is taken.
+	CreateVoucher(ctx context.Context, v *Voucher) error
+	// GetVoucher returns the voucher with the code, or ErrNotFound.
+	GetVoucher(ctx context.Context, code string) (*Voucher, error)
+	// ListVouchers returns all vouchers, oldest first.
+	ListVouchers(ctx context.Context) ([]*Voucher, error)
+	// RedeemVoucher records a redemption if the voucher is still valid. It
+	// returns ErrNotFound for an unknown code, ErrDuplicate if the voucher was
+	// already applied to the file, and ErrExhausted if it has expired or
+	// reached its maximum uses.
+	RedeemVoucher(ctx context.Context, r *VoucherRedemption) error
+	// ReleaseVoucherRedemption deletes the voucher's redemption for the
+	// file, giving the use back. It is not an error if there is none.
+	ReleaseVoucherRedemption(ctx context.Context, code, fileID string) error
+}
+
+// PaymentHistory keeps a permanent record of settled payments for revenue
+// accounting.
+type PaymentHistory interface {
+	// RecordPayment stores a settled payment, including one that is owed
+	// back (see Payment.RefundDue). Recording the same payment hash again is
+	// a no-op.
+	RecordPayment(ctx context.Context, p *Payment) error
+	// ListPayments returns all recorded payments, most recently settled first.
+	ListPayments(ctx context.Context) ([]*Payment, error)
+}
+
+// PartialUpload is a resumable upload whose data is still arriving. It is
+// removed once the upload has been completed.
+type PartialUpload struct {
+	ID        string
+	Length    int64  // Total size declared by the client
+	Offset    int64  // Bytes received so far; equal to Length once finished
+	Chunks    int    // Chunk blobs written, for storage that can't append
+	Metadata  string // Upload-Metadata sent at creation, returned verbatim
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// PartialUploadStore persists the progress of resumable uploads, so they can
+// be resumed after a dropped connection or a server restart.
+type PartialUploadStore interface {
+	// SavePartialUpload creates or updates a partial upload.
+	SavePartialUpload(ctx context.Context, u *PartialUpload) error
+	// GetPartialUpload returns the partial upload with the ID, or ErrNotFound.
+	GetPartialUpload(ctx context.Context, id string) (*PartialUpload, error)
+	DeletePartialUpload(ctx context.Context, id string) error
+	// ListExpiredPartialUploads returns partial uploads past their expiry.
+	ListExpiredPartialUploads(ctx context.Context) ([]*PartialUpload, error)
+}
+
+// CashuProof is an ecash proof held by the server, received when a Cashu
+// token was swapped at the mint. Whoever knows Secret and C can spend it.
+type CashuProof struct {
+	Secret     string
+	Mint       string
+	KeysetID   string
+	AmountSats int64
+	C          string // hex unblinded signature
+	CreatedAt  time.Time
+}
+
+// CashuProofStore keeps the ecash received for Cashu payments.
+type CashuProofStore interface {
+	SaveCashuProofs(ctx context.Context, proofs []*CashuProof) error
+	ListCashuProofs(ctx context.Context) ([]*CashuProof, error)
+}
@@ -0,0 +1,153 @@
+// Package vouchers manages operator-issued discount codes. A voucher takes a
+// fixed amount or a percentage off an upload's hosting fee; a 100% voucher
+// makes the upload free, so it is marked paid without an invoice.
+package vouchers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"satoshisend/internal/logging"
+	"satoshisend/internal/store"
+)
+
+var (
+	ErrNotFound = errors.New("unknown voucher code")
+	ErrExpired  = errors.New("voucher has expired")
+	ErrUsedUp   = errors.New("voucher has been used up")
+)
+
+// Service creates, checks and redeems vouchers.
+type Service struct {
+	store store.VoucherStore
+}
+
+// NewService creates a voucher service.
+func NewService(st store.VoucherStore) *Service {
+	return &Service{store: st}
+}
+
+// Create stores a new voucher. Exactly one of AmountOffSats and PercentOff
+// must be set. If Code is empty, a random code is generated.
+func (s *Service) Create(ctx context.Context, v *store.Voucher) error {
+	switch {
+	case v.AmountOffSats < 0 || v.PercentOff < 0 || v.PercentOff > 100:
+		return errors.New("discount out of range")
+	case (v.AmountOffSats > 0) == (v.PercentOff > 0):
+		return errors.New("set either an amount or a percentage off")
+	case v.MaxUses < 0:
+		return errors.New("max uses must not be negative")
+	}
+
+	if v.Code == "" {
+		code, err := newCode()
+		if err != nil {
+			return err
+		}
+		v.Code = code
+	}
+	v.Code = normalize(v.Code)
+	if v.CreatedAt.IsZero() {
+		v.CreatedAt = time.Now()
+	}
+
+	if err := s.store.CreateVoucher(ctx, v); err != nil {
+		if errors.Is(err, store.ErrDuplicate) {
+			return fmt.Errorf("voucher %s already exists", v.Code)
+		}
+		return err
+	}
+	logging.Internal.Printf("created voucher %s", v.Code)
+	return nil
+}
+
+// List returns all vouchers with their use counts.
+func (s *Service) List(ctx context.Context) ([]*store.Voucher, error) {
+	return s.store.ListVouchers(ctx)
+}
+
+// Check returns the voucher with the code if it can still be redeemed.
+func (s *Service) Check(ctx context.Context, code string) (*store.Voucher, error) {
+	v, err := s.store.GetVoucher(ctx, normalize(code))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !v.ExpiresAt.IsZero() && !time.Now().Before(v.ExpiresAt) {
+		return nil, ErrExpired
+	}
+	if v.MaxUses > 0 && v.Uses >= v.MaxUses {
+		return nil, ErrUsedUp
+	}
+	return v, nil
+}
+
+// Redeem applies the voucher to a file's hosting fee of amountSats, using
+// up one of its uses, and returns the discount. The use is taken before the
+// file is paid for or invoiced, so concurrent uploads can't oversubscribe
+// the voucher; call Release if neither happens.
+func (s *Service) Redeem(ctx context.Context, code, fileID string, amountSats int64) (int64, error) {
+	v, err := s.Check(ctx, code)
+	if err != nil {
+		return 0, err
+	}
+	discount := Discount(v, amountSats)
+
+	err = s.store.RedeemVoucher(ctx, &store.VoucherRedemption{
+		Code:         v.Code,
+		FileID:       fileID,
+		DiscountSats: discount,
+		CreatedAt:    time.Now(),
+	})
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return 0, ErrNotFound
+	case errors.Is(err, store.ErrExhausted):
+		// Expired or ran out since the check above
+		return 0, ErrUsedUp
+	case err != nil:
+		return 0, err
+	}
+	logging.Internal.Printf("redeemed voucher %s for %s: %d of %d sats off", v.Code, fileID, discount, amountSats)
+	return discount, nil
+}
+
+// Release gives back the use Redeem took for a file whose upload then
+// failed before it was paid for or invoiced.
+func (s *Service) Release(ctx context.Context, code, fileID string) error {
+	if err := s.store.ReleaseVoucherRedemption(ctx, normalize(code), fileID); err != nil {
+		return err
+	}
+	logging.Internal.Printf("released voucher %s for %s", normalize(code), fileID)
+	return nil
+}
+
+// Discount returns how many sats the voucher takes off a fee of amountSats.
+func Discount(v *store.Voucher, amountSats int64) int64 {
+	if v.PercentOff > 0 {
+		return amountSats * int64(v.PercentOff) / 100
+	}
+	return min(v.AmountOffSats, amountSats)
+}
+
+// normalize makes codes case-insensitive, since people type them in.
+func normalize(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// newCode returns a random code like "K7QD-M2XA-PW4R-HTZB".
+func newCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := base32.StdEncoding.EncodeToString(b)
+	return raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16], nil
+}
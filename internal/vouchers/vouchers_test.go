@@ -0,0 +1,115 @@
+package vouchers
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"satoshisend/internal/store"
+)
+
+func newTestService(t *testing.T) *Service {
+	t.Helper()
+	st, err := store.NewSQLiteStore(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	t.Cleanup(func() { st.Close() })
+	return NewService(st)
+}
+
+func TestService_Create(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+
+	v := &store.Voucher{PercentOff: 100, MaxUses: 1}
+	if err := svc.Create(ctx, v); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if !regexp.MustCompile(`^[A-Z2-7]{4}(-[A-Z2-7]{4}){3}$`).MatchString(v.Code) {
+		t.Errorf("unexpected generated code %q", v.Code)
+	}
+
+	custom := &store.Voucher{Code: " event2026 ", AmountOffSats: 500}
+	if err := svc.Create(ctx, custom); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if custom.Code != "EVENT2026" {
+		t.Errorf("code = %q, want EVENT2026", custom.Code)
+	}
+	if err := svc.Create(ctx, &store.Voucher{Code: "Event2026", AmountOffSats: 100}); err == nil {
+		t.Error("expected an error for a code that differs only in case")
+	}
+
+	invalid := []*store.Voucher{
+		{},
+		{AmountOffSats: 100, PercentOff: 10},
+		{PercentOff: 101},
+		{AmountOffSats: -1},
+		{PercentOff: 50, MaxUses: -1},
+	}
+	for _, v := range invalid {
+		if err := svc.Create(ctx, v); err == nil {
+			t.Errorf("expected an error for %+v", v)
+		}
+	}
+
+	list, err := svc.List(ctx)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(list) != 2 {
+		t.Errorf("expected 2 vouchers, got %d", len(list))
+	}
+}
+
+func TestService_Redeem(t *testing.T) {
+	svc := newTestService(t)
+	ctx := context.Background()
+
+	svc.Create(ctx, &store.Voucher{Code: "HALF", PercentOff: 50, MaxUses: 2})
+	svc.Create(ctx, &store.Voucher{Code: "FIXED", AmountOffSats: 500})
+	svc.Create(ctx, &store.Voucher{Code: "GONE", PercentOff: 100, ExpiresAt: time.Now().Add(-time.Minute)})
+
+	tests := []struct {
+		code         string
+		fileID       string
+		amount       int64
+		wantDiscount int64
+		wantErr      error
+	}{
+		{"half", "file-1", 301, 150, nil},
+		{"HALF", "file-2", 1000, 500, nil},
+		{"HALF", "file-3", 1000, 0, ErrUsedUp},
+		{"FIXED", "file-1", 2000, 500, nil},
+		{"FIXED", "file-2", 200, 200, nil},
+		{"GONE", "file-1", 1000, 0, ErrExpired},
+		{"NOPE", "file-1", 1000, 0, ErrNotFound},
+	}
+	for _, tc := range tests {
+		discount, err := svc.Redeem(ctx, tc.code, tc.fileID, tc.amount)
+		if !errors.Is(err, tc.wantErr) {
+			t.Errorf("Redeem(%s, %s) error = %v, want %v", tc.code, tc.fileID, err, tc.wantErr)
+		}
+		if discount != tc.wantDiscount {
+			t.Errorf("Redeem(%s, %s) = %d, want %d", tc.code, tc.fileID, discount, tc.wantDiscount)
+		}
+	}
+
+	if _, err := svc.Check(ctx, "HALF"); !errors.Is(err, ErrUsedUp) {
+		t.Errorf("expected used-up voucher to fail the check, got %v", err)
+	}
+	if _, err := svc.Check(ctx, "fixed"); err != nil {
+		t.Errorf("expected unlimited voucher to pass the check, got %v", err)
+	}
+
+	// A released use can be redeemed again
+	if err := svc.Release(ctx, "half", "file-2"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if discount, err := svc.Redeem(ctx, "HALF", "file-3", 1000); err != nil || discount != 500 {
+		t.Errorf("expected the released use to be redeemable, got %d, %v", discount, err)
+	}
+}
@@ -61,7 +61,11 @@ const copyInvoiceBtn = document.getElementById('copy-invoice');
 const amountSats = document.getElementById('amount-sats');
 const paymentStatus = document.getElementById('payment-status');
 const qrContainer = document.getElementById('qr-container');
+const qrToggleBtn = document.getElementById('qr-toggle');
 const weblnPayBtn = document.getElementById('webln-pay');
+const cashuPay = document.getElementById('cashu-pay');
+const cashuTokenInput = document.getElementById('cashu-token');
+const cashuPayBtn = document.getElementById('cashu-pay-btn');
 
 const shareUrl = document.getElementById('share-url');
 const copyLinkBtn = document.getElementById('copy-link');
@@ -239,11 +243,27 @@ async function initPending(fileId, key) {
         }
 
         const invoice = await invoiceResp.json();
+        if (invoice.paid) {
+            // Paid just as the invoice expired
+            showSharePage(fileId, key);
+            return;
+        }
         invoiceCode.textContent = invoice.payment_request;
         amountSats.textContent = invoice.amount_sats;
 
-        // Generate QR code
+        // Generate QR code for the invoice, which every wallet can pay. Wallets
+        // that speak LNURL can scan that instead: it stays valid when the
+        // invoice expires.
         await generateQRCode(invoice.payment_request);
+        if (invoice.lnurl) {
+            let showingLNURL = false;
+            qrToggleBtn.classList.remove('hidden');
+            qrToggleBtn.addEventListener('click', async () => {
+                showingLNURL = !showingLNURL;
+                qrToggleBtn.textContent = showingLNURL ? 'Show invoice' : 'Show LNURL';
+                await generateQRCode(showingLNURL ? invoice.lnurl : invoice.payment_request);
+            });
+        }
 
         // WebLN: offer one-click payment if wallet is available
         if (window.webln) {
@@ -262,6 +282,31 @@ async function initPending(fileId, key) {
             }, { once: true });
         }
 
+        // Cashu: accept a pasted ecash token if the server has a mint configured
+        if (invoice.cashu_mint) {
+            cashuPay.classList.remove('hidden');
+            cashuPayBtn.addEventListener('click', async () => {
+                const token = cashuTokenInput.value.trim();
+                if (!token) return;
+                cashuPayBtn.disabled = true;
+                try {
+                    const resp = await fetch(`/api/file/${fileId}/cashu`, {
+                        method: 'POST',
+                        headers: { 'Content-Type': 'application/json' },
+                        body: JSON.stringify({ token }),
+                    });
+                    if (!resp.ok) {
+                        throw new Error((await resp.text()).trim() || 'Token was not accepted');
+                    }
+                    cashuPay.classList.add('hidden');
+                } catch (err) {
+                    paymentStatus.innerHTML = `<span style="color: var(--error-red)">Error: ${err.message}</span>`;
+                } finally {
+                    cashuPayBtn.disabled = false;
+                }
+            });
+        }
+
         // Setup copy button
         copyInvoiceBtn.addEventListener('click', () => copyToClipboard(copyInvoiceBtn, invoiceCode.textContent));
 
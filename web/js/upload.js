@@ -52,43 +52,23 @@ export async function uploadFile(file, onProgress) {
 }
 
 /**
- * Upload blob via streaming proxy.
- * 1. Call /api/upload/init to get file ID
- * 2. PUT to /api/upload/{id} (streams through server to storage)
- * 3. Call /api/upload/complete to finalize
+ * PUT a blob, reporting bytes sent. Resolves with the XHR so callers can read
+ * response headers.
  */
-async function uploadDirectToStorage(blob, onProgress) {
-    // Step 1: Get file ID from server
-    onProgress(1, 0, 'Preparing upload...');
-
-    const initResponse = await fetch('/api/upload/init', {
-        method: 'POST',
-        headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ size: blob.size })
-    });
-
-    if (!initResponse.ok) {
-        const errorText = await initResponse.text();
-        throw new Error(errorText || 'Failed to initialize upload');
-    }
-
-    const { file_id } = await initResponse.json();
-
-    // Step 2: Stream upload through server proxy
-    await new Promise((resolve, reject) => {
+function putBlob(url, blob, onLoaded) {
+    return new Promise((resolve, reject) => {
         const xhr = new XMLHttpRequest();
-        xhr.open('PUT', `/api/upload/${file_id}`);
+        xhr.open('PUT', url);
 
         xhr.upload.onprogress = (e) => {
             if (e.lengthComputable) {
-                const progress = e.loaded / e.total;
-                onProgress(1, progress * 0.95, `Uploading... ${Math.round(progress * 100)}% (${formatSize(e.loaded)} / ${formatSize(e.total)})`);
+                onLoaded(e.loaded);
             }
         };
 
         xhr.onload = () => {
             if (xhr.status >= 200 && xhr.status < 300) {
-                resolve();
+                resolve(xhr);
             } else {
                 reject(new Error(`Upload failed: ${xhr.status} ${xhr.statusText}`));
             }
@@ -100,6 +80,71 @@ async function uploadDirectToStorage(blob, onProgress) {
 
         xhr.send(blob);
     });
+}
+
+/**
+ * Upload blob to storage.
+ * 1. Call /api/upload/init to get file ID (and presigned URLs if the storage
+ *    backend supports them)
+ * 2. PUT straight to the bucket, or to /api/upload/{id} which streams through
+ *    the server to storage
+ * 3. Call /api/upload/complete to finalize
+ */
+async function uploadDirectToStorage(blob, onProgress) {
+    // Step 1: Get file ID from server
+    onProgress(1, 0, 'Preparing upload...');
+
+    const initResponse = await fetch('/api/upload/init', {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ size: blob.size })
+    });
+
+    if (!initResponse.ok) {
+        const errorText = await initResponse.text();
+        throw new Error(errorText || 'Failed to initialize upload');
+    }
+
+    const init = await initResponse.json();
+    const file_id = init.file_id;
+
+    const reportProgress = (loaded) => {
+        const progress = loaded / blob.size;
+        onProgress(1, progress * 0.95, `Uploading... ${Math.round(progress * 100)}% (${formatSize(loaded)} / ${formatSize(blob.size)})`);
+    };
+
+    // Step 2: Upload the data
+    const complete = { file_id, size: blob.size };
+    let uploaded = false;
+    if (init.upload_url || init.upload_id) {
+        try {
+            if (init.upload_url) {
+                await putBlob(init.upload_url, blob, reportProgress);
+            } else {
+                complete.upload_id = init.upload_id;
+                complete.part_etags = [];
+                for (let i = 0; i < init.part_urls.length; i++) {
+                    const start = i * init.part_size;
+                    const part = blob.slice(start, start + init.part_size);
+                    const xhr = await putBlob(init.part_urls[i], part, (loaded) => reportProgress(start + loaded));
+                    const etag = xhr.getResponseHeader('ETag');
+                    if (!etag) {
+                        throw new Error('Storage did not return an ETag (check the bucket CORS rules)');
+                    }
+                    complete.part_etags.push(etag);
+                }
+            }
+            uploaded = true;
+        } catch (e) {
+            // Usually the bucket's CORS rules; send the data through the server instead
+            console.warn('Direct upload failed, falling back to proxy upload:', e);
+            delete complete.upload_id;
+            delete complete.part_etags;
+        }
+    }
+    if (!uploaded) {
+        await putBlob(`/api/upload/${file_id}`, blob, reportProgress);
+    }
 
     // Step 3: Complete the upload on server
     onProgress(1, 0.98, 'Finalizing...');
@@ -107,7 +152,7 @@ async function uploadDirectToStorage(blob, onProgress) {
     const completeResponse = await fetch('/api/upload/complete', {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ file_id, size: blob.size })
+        body: JSON.stringify(complete)
     });
 
     if (!completeResponse.ok) {